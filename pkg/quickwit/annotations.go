@@ -0,0 +1,186 @@
+package quickwit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
+	"github.com/quickwit-oss/quickwit-datasource/pkg/utils"
+)
+
+// Queries of this type are annotation queries, the documents matching the
+// query are returned as annotations
+const annotationsType = "annotations"
+
+const defaultAnnotationsSize = 10000
+
+// AnnotationQuery holds the field mappings of an annotations query
+type AnnotationQuery struct {
+	// TimeField is the time of the annotations, the time field of the index by default
+	TimeField string
+	// TimeEndField is the end time of region annotations
+	TimeEndField string
+	// TextField is the text of the annotations, the log message field by default
+	TextField string
+	// TagsField holds the tags of the annotations, as an array or a comma separated string
+	TagsField string
+	Size      int
+}
+
+func isAnnotationsQuery(query *Query) bool {
+	return query.QueryType == annotationsType
+}
+
+func parseAnnotationQuery(model *simplejson.Json) *AnnotationQuery {
+	return &AnnotationQuery{
+		TimeField:    strings.TrimSpace(model.Get("annotationTimeField").MustString()),
+		TimeEndField: strings.TrimSpace(model.Get("timeEndField").MustString()),
+		TextField:    strings.TrimSpace(model.Get("textField").MustString()),
+		TagsField:    strings.TrimSpace(model.Get("tagsField").MustString()),
+		Size:         model.Get("size").MustInt(defaultAnnotationsSize),
+	}
+}
+
+// annotationTimeField returns the field the annotations are sorted and
+// filtered on
+func annotationTimeField(q *Query, defaultTimeField string) string {
+	if q.Annotation != nil && q.Annotation.TimeField != "" {
+		return q.Annotation.TimeField
+	}
+	return defaultTimeField
+}
+
+func processAnnotationsQuery(q *Query, b *es.SearchRequestBuilder, defaultTimeField string) {
+	size := defaultAnnotationsSize
+	if q.Annotation != nil && q.Annotation.Size > 0 {
+		size = q.Annotation.Size
+	}
+	b.Sort(es.SortOrderDesc, annotationTimeField(q, defaultTimeField), "epoch_nanos_int")
+	b.Size(size)
+}
+
+// processAnnotationsResponse returns the hits as a frame of annotations with
+// time, timeEnd, text and tags columns
+func processAnnotationsResponse(res *es.SearchResponse, target *Query, configuredFields es.ConfiguredFields, queryRes *backend.DataResponse) error {
+	annotation := target.Annotation
+	if annotation == nil {
+		annotation = &AnnotationQuery{}
+	}
+	textField := annotation.TextField
+	if textField == "" {
+		textField = configuredFields.LogMessageField
+	}
+
+	var hits []map[string]interface{}
+	if res.Hits != nil {
+		hits = res.Hits.Hits
+	}
+	times := make([]time.Time, 0, len(hits))
+	timeEnds := make([]*time.Time, 0, len(hits))
+	texts := make([]string, 0, len(hits))
+	tags := make([]json.RawMessage, 0, len(hits))
+
+	for _, hit := range hits {
+		source := map[string]interface{}{}
+		if hitSource, ok := hit["_source"].(map[string]interface{}); ok {
+			source = flatten(hitSource)
+		}
+
+		// The hits are sorted on the time field as nanosecond timestamps
+		var hitTime time.Time
+		if timestamp, ok := hitSortTimestamp(hit); ok {
+			hitTime = time.Unix(0, timestamp).UTC()
+		} else {
+			t, err := parseAnnotationTime(source[annotationTimeField(target, configuredFields.TimeField)], configuredFields.TimeOutputFormat)
+			if err != nil || t == nil {
+				return fmt.Errorf("annotation without a valid time: %v", err)
+			}
+			hitTime = *t
+		}
+		times = append(times, hitTime)
+
+		var timeEnd *time.Time
+		if annotation.TimeEndField != "" {
+			t, err := parseAnnotationTime(source[annotation.TimeEndField], configuredFields.TimeOutputFormat)
+			if err != nil {
+				return fmt.Errorf("annotation with an invalid end time: %w", err)
+			}
+			timeEnd = t
+		}
+		timeEnds = append(timeEnds, timeEnd)
+
+		text := ""
+		if value, ok := source[textField]; ok && value != nil {
+			text = fmt.Sprint(value)
+		}
+		texts = append(texts, text)
+
+		hitTags, err := json.Marshal(annotationTags(source[annotation.TagsField]))
+		if err != nil {
+			return err
+		}
+		tags = append(tags, hitTags)
+	}
+
+	frame := data.NewFrame("",
+		data.NewField("time", nil, times),
+		data.NewField("timeEnd", nil, timeEnds),
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+	)
+	frame.RefID = target.RefID
+	queryRes.Frames = data.Frames{frame}
+	return nil
+}
+
+// parseAnnotationTime parses the value of a time field, string values that
+// do not match the output format are parsed as RFC 3339
+func parseAnnotationTime(value interface{}, timeOutputFormat string) (*time.Time, error) {
+	if value == nil {
+		return nil, nil
+	}
+	t, err := utils.ParseTime(value, timeOutputFormat)
+	if err != nil {
+		s, ok := value.(string)
+		if !ok {
+			return nil, err
+		}
+		if t, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return nil, err
+		}
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// annotationTags returns the tags of a tags field value, comma separated
+// strings are split into several tags
+func annotationTags(value interface{}) []string {
+	tags := []string{}
+	var values []interface{}
+	switch v := value.(type) {
+	case nil:
+		return tags
+	case []interface{}:
+		values = v
+	default:
+		values = []interface{}{v}
+	}
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		for _, tag := range strings.Split(fmt.Sprint(v), ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
@@ -0,0 +1,116 @@
+package quickwit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+)
+
+func TestAnnotationsQuery(t *testing.T) {
+	from := time.Date(2018, 5, 15, 17, 50, 0, 0, time.UTC)
+	to := time.Date(2018, 5, 15, 17, 55, 0, 0, time.UTC)
+
+	t.Run("Documents are searched on the annotation time field", func(t *testing.T) {
+		c := newFakeClient()
+		_, err := executeElasticsearchDataQuery(c, `{
+			"queryType": "annotations",
+			"query": "kind:deploy",
+			"annotationTimeField": "started_at",
+			"textField": "title",
+			"metrics": [{ "type": "count", "id": "1" }]
+		}`, from, to)
+		require.NoError(t, err)
+
+		sr := c.multisearchRequests[0][0]
+		assert.Equal(t, defaultAnnotationsSize, sr.Size)
+		assert.Equal(t, "started_at", sr.Query.Bool.Filters[0].(*es.DateRangeFilter).Key)
+		assert.Equal(t, "kind:deploy", sr.Query.Bool.Filters[1].(*es.QueryStringFilter).Query)
+		assert.Equal(t, map[string]interface{}{"order": "desc", "format": "epoch_nanos_int"}, sr.Sort[0]["started_at"])
+		assert.Empty(t, sr.Aggs)
+	})
+
+	t.Run("The legacy time field of the queries is ignored", func(t *testing.T) {
+		c := newFakeClient()
+		_, err := executeElasticsearchDataQuery(c, `{
+			"queryType": "annotations",
+			"timeField": "started_at"
+		}`, from, to)
+		require.NoError(t, err)
+
+		sr := c.multisearchRequests[0][0]
+		assert.Equal(t, "@timestamp", sr.Query.Bool.Filters[0].(*es.DateRangeFilter).Key)
+	})
+
+	t.Run("Hits are returned as region annotations with tags", func(t *testing.T) {
+		targets := map[string]string{
+			"A": `{
+				"queryType": "annotations",
+				"query": "*",
+				"timeEndField": "ended_at",
+				"tagsField": "tags"
+			}`,
+		}
+		response := `{
+			"responses": [{
+				"hits": { "hits": [
+					{ "_source": { "@timestamp": "2023-11-14T22:13:20Z", "ended_at": "2023-11-14T22:30:00Z", "line": "deploy", "tags": "web, prod,," }, "sort": [1700000000000000000] },
+					{ "_source": { "@timestamp": "2023-11-14T21:00:00Z", "line": "restart", "tags": ["web", "staging,canary"] }, "sort": [1699995600000000000] },
+					{ "_source": { "@timestamp": "2023-11-14T20:00:00Z" }, "sort": [1699992000000000000] }
+				] }
+			}]
+		}`
+
+		result, err := parseTestResponse(targets, response)
+		require.NoError(t, err)
+		res := result.Responses["A"]
+		require.NoError(t, res.Error)
+		require.Len(t, res.Frames, 1)
+		frame := res.Frames[0]
+
+		names := []string{}
+		for _, field := range frame.Fields {
+			names = append(names, field.Name)
+		}
+		assert.Equal(t, []string{"time", "timeEnd", "text", "tags"}, names)
+		require.Equal(t, 3, frame.Rows())
+
+		assert.Equal(t, time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC), frame.Fields[0].At(0))
+		timeEnd := frame.Fields[1].At(0).(*time.Time)
+		require.NotNil(t, timeEnd)
+		assert.Equal(t, time.Date(2023, 11, 14, 22, 30, 0, 0, time.UTC), *timeEnd)
+		assert.Nil(t, frame.Fields[1].At(1))
+
+		assert.Equal(t, "deploy", frame.Fields[2].At(0))
+		assert.Equal(t, "", frame.Fields[2].At(2))
+
+		assert.JSONEq(t, `["web", "prod"]`, string(frame.Fields[3].At(0).(json.RawMessage)))
+		assert.JSONEq(t, `["web", "staging", "canary"]`, string(frame.Fields[3].At(1).(json.RawMessage)))
+		assert.JSONEq(t, `[]`, string(frame.Fields[3].At(2).(json.RawMessage)))
+	})
+
+	t.Run("Annotation times fall back to the source when the hits are not sorted", func(t *testing.T) {
+		targets := map[string]string{
+			"A": `{ "queryType": "annotations", "timeEndField": "ended_at" }`,
+		}
+		response := `{
+			"responses": [{
+				"hits": { "hits": [
+					{ "_source": { "@timestamp": "2023-11-14T22:13:20Z", "ended_at": "2023-11-14T22:30:00Z" } }
+				] }
+			}]
+		}`
+
+		result, err := parseTestResponse(targets, response)
+		require.NoError(t, err)
+		frame := result.Responses["A"].Frames[0]
+		assert.Equal(t, time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC), frame.Fields[0].At(0))
+		assert.Equal(t, time.Date(2023, 11, 14, 22, 30, 0, 0, time.UTC), *frame.Fields[1].At(0).(*time.Time))
+		assert.Equal(t, data.FieldTypeNullableTime, frame.Fields[1].Type())
+	})
+}
@@ -0,0 +1,242 @@
+package es
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// DefaultResponseCacheMaxBytes is the size of the response cache when the
+// datasource does not configure it
+const DefaultResponseCacheMaxBytes = 64 << 20
+
+// ResponseCache is an in-memory LRU cache of the search responses, shared by
+// the clients of a datasource. The entries expire after ttl and the least
+// recently used ones are evicted once the responses exceed maxBytes.
+type ResponseCache struct {
+	ttl      time.Duration
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type responseCacheEntry struct {
+	key             string
+	response        json.RawMessage
+	executedRequest string
+	expiresAt       time.Time
+}
+
+func (e *responseCacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.response) + len(e.executedRequest))
+}
+
+// NewResponseCache creates a response cache, it returns nil when ttl is not
+// positive, which disables the cache
+func NewResponseCache(ttl time.Duration, maxBytes int64) *ResponseCache {
+	if ttl <= 0 {
+		return nil
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultResponseCacheMaxBytes
+	}
+	return &ResponseCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// Stats returns the number of cache hits and misses since the cache creation
+func (c *ResponseCache) Stats() (hits uint64, misses uint64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+func (c *ResponseCache) get(key string) (*responseCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok {
+		entry := elem.Value.(*responseCacheEntry)
+		if time.Now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(elem)
+			c.hits.Add(1)
+			return entry, true
+		}
+		c.remove(elem)
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+func (c *ResponseCache) put(key string, response json.RawMessage, executedRequest string) {
+	entry := &responseCacheEntry{
+		key:             key,
+		response:        response,
+		executedRequest: executedRequest,
+		expiresAt:       time.Now().Add(c.ttl),
+	}
+	if entry.size() > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	for c.size+entry.size() > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += entry.size()
+}
+
+func (c *ResponseCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*responseCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size()
+}
+
+// cachingClient serves the search requests from the response cache and only
+// sends the missing ones to Quickwit
+type cachingClient struct {
+	client Client
+	cache  *ResponseCache
+	ds     *DatasourceInfo
+	index  string
+}
+
+func (c *cachingClient) ExecuteMultisearch(requests []*SearchRequest) (*MultiSearchResponse, error) {
+	keys := make([]string, len(requests))
+	msr := &MultiSearchResponse{
+		Responses:        make([]*json.RawMessage, len(requests)),
+		ExecutedRequests: make([]string, len(requests)),
+	}
+
+	var missing []*SearchRequest
+	var missingIdx []int
+	var stats MultiSearchStats
+	for i, r := range requests {
+		if r.NoCache {
+			missing = append(missing, r)
+			missingIdx = append(missingIdx, i)
+			continue
+		}
+		key, err := searchRequestKey(c.ds, c.index, r)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+		if entry, ok := c.cache.get(key); ok {
+			response := entry.response
+			msr.Responses[i] = &response
+			msr.ExecutedRequests[i] = entry.executedRequest
+			stats.CacheHits++
+			continue
+		}
+		stats.CacheMisses++
+		missing = append(missing, r)
+		missingIdx = append(missingIdx, i)
+	}
+
+	hits, misses := c.cache.Stats()
+	logger.Debug("Response cache lookup", "requests", len(requests), "missing", len(missing), "hits", hits, "misses", misses)
+	msr.Stats = stats
+	if len(missing) == 0 {
+		return msr, nil
+	}
+
+	res, err := c.client.ExecuteMultisearch(missing)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Responses) != len(missing) {
+		return nil, &DecodeError{Err: fmt.Errorf("unexpected number of responses: %d", len(res.Responses))}
+	}
+	msr.Stats = res.Stats
+	msr.Stats.CacheHits, msr.Stats.CacheMisses = stats.CacheHits, stats.CacheMisses
+	for j, i := range missingIdx {
+		msr.Responses[i] = res.Responses[j]
+		if j < len(res.ExecutedRequests) {
+			msr.ExecutedRequests[i] = res.ExecutedRequests[j]
+		}
+		if keys[i] != "" && isCacheableResponse(res.Responses[j]) {
+			c.cache.put(keys[i], *res.Responses[j], msr.ExecutedRequests[i])
+		}
+	}
+	return msr, nil
+}
+
+// identityHeaders are the forwarded headers which identify the user to Quickwit
+var identityHeaders = []string{
+	backend.OAuthIdentityTokenHeaderName,
+	backend.OAuthIdentityIDTokenHeaderName,
+	backend.GrafanaUserSignInTokenHeaderName,
+	backend.CookiesHeaderName,
+}
+
+// ForwardedIdentity returns a hash of the identity headers forwarded to
+// Quickwit with the searches, empty when none is forwarded
+func ForwardedIdentity(headers http.Header) string {
+	h := sha256.New()
+	forwarded := false
+	for _, name := range identityHeaders {
+		values := headers.Values(name)
+		forwarded = forwarded || len(values) > 0
+		fmt.Fprintf(h, "%s: %q\n", name, values)
+	}
+	if !forwarded {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// searchRequestKey returns the key of a search request. The time range is part
+// of the marshalled request, it is aligned on the interval by the datasource so
+// that the neighbouring refreshes share their key. The forwarded identity is
+// part of the key, Quickwit may answer differently to each user.
+func searchRequestKey(ds *DatasourceInfo, index string, r *SearchRequest) (string, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%v\n%s\n", ds.ForwardedIdentity, ds.SearchAPI, index, searchGroups(ds.ConfiguredFields, index), r.Interval)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// isCacheableResponse returns false for the error responses, which are not cached
+func isCacheableResponse(rawRes *json.RawMessage) bool {
+	if rawRes == nil {
+		return false
+	}
+	var res struct {
+		Status   int             `json:"status"`
+		Error    json.RawMessage `json:"error"`
+		TimedOut bool            `json:"timed_out"`
+	}
+	if err := json.Unmarshal(*rawRes, &res); err != nil {
+		return false
+	}
+	hasError := len(res.Error) > 0 && string(res.Error) != "null"
+	return res.Status < 400 && !hasError && !res.TimedOut
+}
@@ -0,0 +1,145 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCache(t *testing.T) {
+	t.Run("A zero TTL disables the cache", func(t *testing.T) {
+		assert.Nil(t, NewResponseCache(0, 1024))
+	})
+
+	t.Run("Expired entries are misses", func(t *testing.T) {
+		cache := NewResponseCache(time.Millisecond, 1024)
+		cache.put("a", json.RawMessage(`{}`), "")
+		time.Sleep(5 * time.Millisecond)
+		_, ok := cache.get("a")
+		assert.False(t, ok)
+		hits, misses := cache.Stats()
+		assert.Equal(t, uint64(0), hits)
+		assert.Equal(t, uint64(1), misses)
+	})
+
+	t.Run("The least recently used entries are evicted", func(t *testing.T) {
+		cache := NewResponseCache(time.Minute, 20)
+		cache.put("a", json.RawMessage(`{"a":1}`), "")
+		cache.put("b", json.RawMessage(`{"b":1}`), "")
+		_, ok := cache.get("a")
+		require.True(t, ok)
+		cache.put("c", json.RawMessage(`{"c":1}`), "")
+
+		_, ok = cache.get("b")
+		assert.False(t, ok)
+		_, ok = cache.get("a")
+		assert.True(t, ok)
+		_, ok = cache.get("c")
+		assert.True(t, ok)
+		assert.LessOrEqual(t, cache.size, cache.maxBytes)
+
+		cache.put("d", json.RawMessage(strings.Repeat("x", 100)), "")
+		_, ok = cache.get("d")
+		assert.False(t, ok, "entries larger than the cache are not cached")
+	})
+}
+
+func TestCachingClient(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+
+		responses := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+			switch {
+			case strings.Contains(line, "invalid"):
+				responses = append(responses, `{ "error": { "reason": "invalid query" }, "status": 400 }`)
+			case strings.Contains(line, "query_string"):
+				responses = append(responses, `{ "hits": { "hits": [] }, "status": 200 }`)
+			}
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, err = rw.Write([]byte(`{ "responses": [` + strings.Join(responses, ",") + `] }`))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(ts.Close)
+
+	ds := &DatasourceInfo{
+		URL:              ts.URL,
+		HTTPClient:       ts.Client(),
+		Database:         "logs",
+		ConfiguredFields: ConfiguredFields{TimeField: "timestamp"},
+		ResponseCache:    NewResponseCache(time.Minute, 0),
+	}
+	c, err := NewClient(context.Background(), ds)
+	require.NoError(t, err)
+
+	res, err := c.ExecuteMultisearch([]*SearchRequest{
+		createSearchForTest(t, "service:api"),
+		createSearchForTest(t, "invalid"),
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Responses, 2)
+	require.Len(t, bodies, 1)
+	assert.Equal(t, 0, res.Stats.CacheHits)
+	assert.Equal(t, 2, res.Stats.CacheMisses)
+
+	bypassed := createSearchForTest(t, "service:api")
+	bypassed.NoCache = true
+	res, err = c.ExecuteMultisearch([]*SearchRequest{
+		createSearchForTest(t, "invalid"),
+		createSearchForTest(t, "service:api"),
+		bypassed,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Responses, 3)
+	require.Len(t, bodies, 2)
+	// The bypassed request is not looked up in the cache
+	assert.Equal(t, 1, res.Stats.CacheHits)
+	assert.Equal(t, 1, res.Stats.CacheMisses)
+
+	// The cached response is served, the error response and the bypassed request are sent again
+	assert.Equal(t, 2, strings.Count(bodies[1], "query_string"))
+	assert.Contains(t, bodies[1], "invalid")
+	assert.Contains(t, string(*res.Responses[0]), "invalid query")
+	assert.JSONEq(t, `{ "hits": { "hits": [] }, "status": 200 }`, string(*res.Responses[1]))
+	assert.Contains(t, res.ExecutedRequests[1], "service:api")
+
+	hits, misses := ds.ResponseCache.Stats()
+	assert.Equal(t, uint64(1), hits)
+	assert.Equal(t, uint64(3), misses)
+}
+
+func TestSearchRequestKey(t *testing.T) {
+	ds := &DatasourceInfo{ConfiguredFields: ConfiguredFields{TimeField: "timestamp"}}
+	search := createSearchForTest(t, "service:api")
+	anonymous, err := searchRequestKey(ds, "logs", search)
+	require.NoError(t, err)
+
+	assert.Empty(t, ForwardedIdentity(http.Header{"X-Grafana-Org-Id": {"1"}}))
+	alice := ForwardedIdentity(http.Header{"Authorization": {"Bearer alice"}})
+	bob := ForwardedIdentity(http.Header{"Authorization": {"Bearer bob"}})
+	require.NotEmpty(t, alice)
+	assert.NotEqual(t, alice, bob)
+	assert.NotEqual(t, alice, ForwardedIdentity(http.Header{"Cookie": {"Bearer alice"}}))
+
+	// The searches forwarding different users never share their key
+	keys := map[string]bool{anonymous: true}
+	for _, identity := range []string{alice, bob} {
+		ds.ForwardedIdentity = identity
+		key, err := searchRequestKey(ds, "logs", search)
+		require.NoError(t, err)
+		assert.False(t, keys[key])
+		keys[key] = true
+	}
+}
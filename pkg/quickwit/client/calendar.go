@@ -0,0 +1,148 @@
+package es
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// calendarClient regroups the fixed interval buckets of the calendar date
+// histograms into the buckets of their calendar interval. Quickwit has no
+// calendar_interval, the searches of the calendar date histograms bucket by
+// the hour, or by the day when the offset of the time zone does not change, and
+// the buckets are added up into the days, weeks, months, quarters and years of
+// the time zone. It wraps the cache and the limiter, whose
+// responses are shared by the searches of any calendar interval.
+type calendarClient struct {
+	client Client
+}
+
+func (c *calendarClient) ExecuteMultisearch(requests []*SearchRequest) (*MultiSearchResponse, error) {
+	res, err := c.client.ExecuteMultisearch(requests)
+	if err != nil || len(res.Responses) != len(requests) {
+		return res, err
+	}
+	for i, r := range requests {
+		if !hasCalendarHistogram(r.Aggs) {
+			continue
+		}
+		regrouped, err := regroupCalendarBuckets(r, res.Responses[i])
+		if err != nil {
+			return nil, &DecodeError{Err: err}
+		}
+		res.Responses[i] = regrouped
+	}
+	return res, nil
+}
+
+func hasCalendarHistogram(aggs AggArray) bool {
+	for _, agg := range aggs {
+		if h, ok := agg.Aggregation.Aggregation.(*DateHistogramAgg); ok && h.CalendarInterval != "" {
+			return true
+		}
+		if hasCalendarHistogram(agg.Aggregation.Aggs) {
+			return true
+		}
+	}
+	return false
+}
+
+// regroupCalendarBuckets regroups the buckets of the calendar date histograms
+// of a search response, error responses are returned as is
+func regroupCalendarBuckets(r *SearchRequest, raw *json.RawMessage) (*json.RawMessage, error) {
+	res, err := decodeSearchResponse(raw)
+	if err != nil {
+		return nil, err
+	}
+	aggs, ok := res["aggregations"].(map[string]interface{})
+	if !ok {
+		return raw, nil
+	}
+	regroupAggregations(aggs, r.Aggs)
+	return marshalRawResponse(res)
+}
+
+func regroupAggregations(aggs map[string]interface{}, defs AggArray) {
+	for _, def := range defs {
+		agg, ok := aggs[def.Key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if h, ok := def.Aggregation.Aggregation.(*DateHistogramAgg); ok && h.CalendarInterval != "" {
+			regroupBuckets(agg, h, def.Aggregation.Aggs)
+		}
+		if len(def.Aggregation.Aggs) == 0 {
+			continue
+		}
+		switch buckets := agg["buckets"].(type) {
+		case []interface{}:
+			for _, b := range buckets {
+				if bucket, ok := b.(map[string]interface{}); ok {
+					regroupAggregations(bucket, def.Aggregation.Aggs)
+				}
+			}
+		case map[string]interface{}:
+			for _, b := range buckets {
+				if bucket, ok := b.(map[string]interface{}); ok {
+					regroupAggregations(bucket, def.Aggregation.Aggs)
+				}
+			}
+		default:
+			// Single bucket aggregations, like nested
+			regroupAggregations(agg, def.Aggregation.Aggs)
+		}
+	}
+}
+
+// regroupBuckets merges the sorted buckets of a date histogram starting in the
+// same calendar interval, keyed by the start of the interval
+func regroupBuckets(agg map[string]interface{}, h *DateHistogramAgg, aggs AggArray) {
+	location := h.CalendarLocation
+	if location == nil {
+		location = time.UTC
+	}
+	buckets, _ := agg["buckets"].([]interface{})
+	regrouped := make([]interface{}, 0, len(buckets))
+	var last map[string]interface{}
+	var lastStart int64
+	for _, b := range buckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key, ok := toFloat(bucket["key"])
+		if !ok {
+			continue
+		}
+		start := calendarIntervalStart(time.UnixMilli(int64(key)).In(location), h.CalendarInterval).UnixMilli()
+		if last != nil && start == lastStart {
+			mergeBucket(last, bucket, aggs)
+			continue
+		}
+		bucket["key"] = json.Number(strconv.FormatInt(start, 10))
+		delete(bucket, "key_as_string")
+		last, lastStart = bucket, start
+		regrouped = append(regrouped, bucket)
+	}
+	agg["buckets"] = regrouped
+}
+
+// calendarIntervalStart returns the start of the calendar interval containing
+// t, in the location of t. The weeks start on Monday. The calendar minutes and
+// hours are the fixed interval buckets, t is returned as is.
+func calendarIntervalStart(t time.Time, interval string) time.Time {
+	year, month, day := t.Date()
+	switch interval {
+	case "1d":
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	case "1w":
+		return time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case "1M":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case "1q":
+		return time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, t.Location())
+	case "1y":
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+	return t
+}
@@ -0,0 +1,88 @@
+package es
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticClient struct {
+	responses []*json.RawMessage
+}
+
+func (c *staticClient) ExecuteMultisearch(requests []*SearchRequest) (*MultiSearchResponse, error) {
+	return &MultiSearchResponse{Responses: c.responses}, nil
+}
+
+func TestCalendarClient(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	newSearch := func(interval string) []*SearchRequest {
+		msb := NewMultiSearchRequestBuilder()
+		msb.Search(0).Agg().DateHistogram("2", "@timestamp", func(a *DateHistogramAgg, ab AggBuilder) {
+			a.FixedInterval = "1h"
+			a.CalendarInterval = interval
+			a.CalendarLocation = paris
+			ab.Metric("1", "avg", "value", nil)
+		})
+		ms, err := msb.Build()
+		require.NoError(t, err)
+		return ms
+	}
+
+	// The hours of the 30th, 31st and 1st of April in Paris, whose clocks move
+	// forward on the 31st of March
+	hours := rawResponsesForTest(t, `{
+		"aggregations": {
+			"2": {
+				"buckets": [
+					{ "key": 1711836000000, "key_as_string": "2024-03-30T22:00:00Z", "doc_count": 2, "1": { "value": 5 } },
+					{ "key": 1711839600000, "doc_count": 1, "1": { "value": 10 } },
+					{ "key": 1711918800000, "doc_count": 3, "1": { "value": 20 } },
+					{ "key": 1711922400000, "doc_count": 1, "1": { "value": 1 } }
+				]
+			}
+		},
+		"status": 200
+	}`)
+
+	t.Run("Regroups the hours into the days of the time zone", func(t *testing.T) {
+		c := &calendarClient{client: &staticClient{responses: hours}}
+		res, err := c.ExecuteMultisearch(newSearch("1d"))
+		require.NoError(t, err)
+
+		item, err := decodeSearchResponse(res.Responses[0])
+		require.NoError(t, err)
+		buckets := item["aggregations"].(map[string]interface{})["2"].(map[string]interface{})["buckets"].([]interface{})
+		require.Len(t, buckets, 3)
+
+		expected := []struct {
+			day      time.Time
+			docCount string
+			avg      string
+		}{
+			{time.Date(2024, time.March, 30, 0, 0, 0, 0, paris), "2", "5"},
+			{time.Date(2024, time.March, 31, 0, 0, 0, 0, paris), "4", "17.5"},
+			{time.Date(2024, time.April, 1, 0, 0, 0, 0, paris), "1", "1"},
+		}
+		for i, e := range expected {
+			bucket := buckets[i].(map[string]interface{})
+			assert.Equal(t, json.Number(strconv.FormatInt(e.day.UnixMilli(), 10)), bucket["key"])
+			assert.Equal(t, json.Number(e.docCount), bucket["doc_count"])
+			assert.Equal(t, json.Number(e.avg), bucket["1"].(map[string]interface{})["value"])
+			assert.NotContains(t, bucket, "key_as_string")
+		}
+	})
+
+	t.Run("Starts the weeks on Monday", func(t *testing.T) {
+		monday := time.Date(2024, time.March, 25, 0, 0, 0, 0, paris)
+		assert.Equal(t, monday, calendarIntervalStart(time.Date(2024, time.March, 31, 23, 0, 0, 0, paris), "1w"))
+		assert.Equal(t, monday, calendarIntervalStart(monday, "1w"))
+		assert.Equal(t, time.Date(2024, time.January, 1, 0, 0, 0, 0, paris), calendarIntervalStart(time.Date(2024, time.March, 31, 23, 0, 0, 0, paris), "1q"))
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"path"
@@ -15,11 +16,6 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
 
-type ReadyStatus struct {
-	IsReady bool
-	Err     error
-}
-
 type DatasourceInfo struct {
 	ID                         int64
 	UID                        string
@@ -34,34 +30,90 @@ type DatasourceInfo struct {
 	Database                   string
 	ConfiguredFields           ConfiguredFields
 	MaxConcurrentShardRequests int64
-	ReadyStatus                chan ReadyStatus
-	ShouldInit                 bool
+	// SearchAPI selects the Quickwit search endpoint, SearchAPIElastic by default
+	SearchAPI string
+	// ResponseCache caches the search responses, nil when disabled
+	ResponseCache *ResponseCache
+	// RangeShards is the number of searches the time range of the logs and date
+	// histogram queries is split into, the range is not split below 2
+	RangeShards int
+	// SearchTimeout is the default timeout of the queries, 0 for no timeout
+	SearchTimeout time.Duration
+	// RetryPolicy retries the search calls failing with a transient error, nil
+	// when disabled
+	RetryPolicy *RetryPolicy
+	// RequestLimiter bounds and coalesces the multisearches of the datasource
+	// instance, nil when disabled
+	RequestLimiter *RequestLimiter
+	// ForwardedIdentity identifies the user whose headers are forwarded to
+	// Quickwit, the searches of different users never share their responses
+	ForwardedIdentity string
 }
 
+const (
+	// SearchAPIElastic searches through the Elasticsearch compatible _msearch endpoint
+	SearchAPIElastic = "elastic"
+	// SearchAPINative searches through the native search endpoint of each index
+	SearchAPINative = "native"
+)
+
 // TODO: Move ConfiguredFields closer to handlers, the client layer doesn't need this stuff
 type ConfiguredFields struct {
 	TimeField        string
 	TimeOutputFormat string
 	LogMessageField  string
 	LogLevelField    string
+	// Timestamp infos of each index matching the datasource index pattern, by index ID
+	IndexTimestampInfos map[string]TimestampInfo
 }
 
 // Client represents a client which can interact with elasticsearch api
 type Client interface {
-	ExecuteMultisearch(r []*SearchRequest) ([]*json.RawMessage, error)
+	ExecuteMultisearch(r []*SearchRequest) (*MultiSearchResponse, error)
 }
 
 var logger = log.New()
 
 // NewClient creates a new Quickwit client
 var NewClient = func(ctx context.Context, ds *DatasourceInfo) (Client, error) {
-	logger.Debug("Creating new client", "index", ds.Database)
+	logger.Debug("Creating new client", "index", ds.Database, "searchApi", ds.SearchAPI)
 
-	return &baseClientImpl{
-		ctx:   ctx,
-		ds:    ds,
-		index: ds.Database,
-	}, nil
+	newBaseClient := func(ctx context.Context) Client {
+		if ds.SearchAPI == SearchAPINative {
+			return &nativeClientImpl{
+				ctx:   ctx,
+				ds:    ds,
+				index: ds.Database,
+			}
+		}
+		return &baseClientImpl{
+			ctx:   ctx,
+			ds:    ds,
+			index: ds.Database,
+		}
+	}
+
+	// The cached responses are served without waiting for the limiter
+	client := newBaseClient(ctx)
+	if ds.RequestLimiter != nil {
+		client = &limitingClient{
+			ctx:       ctx,
+			newClient: newBaseClient,
+			limiter:   ds.RequestLimiter,
+			ds:        ds,
+			index:     ds.Database,
+		}
+	}
+	if ds.ResponseCache != nil {
+		client = &cachingClient{
+			client: client,
+			cache:  ds.ResponseCache,
+			ds:     ds,
+			index:  ds.Database,
+		}
+	}
+	// The cached and coalesced responses are shared by the calendar intervals
+	return &calendarClient{client: client}, nil
 }
 
 type baseClientImpl struct {
@@ -71,7 +123,11 @@ type baseClientImpl struct {
 }
 
 func (c *baseClientImpl) makeRequest(method, uriPath, uriQuery string, body []byte) (*http.Request, error) {
-	u, err := url.Parse(c.ds.URL)
+	return newRequest(c.ctx, c.ds, method, uriPath, uriQuery, body)
+}
+
+func newRequest(ctx context.Context, ds *DatasourceInfo, method, uriPath, uriQuery string, body []byte) (*http.Request, error) {
+	u, err := url.Parse(ds.URL)
 	if err != nil {
 		return nil, err
 	}
@@ -80,9 +136,9 @@ func (c *baseClientImpl) makeRequest(method, uriPath, uriQuery string, body []by
 
 	var req *http.Request
 	if method == http.MethodPost {
-		req, err = http.NewRequestWithContext(c.ctx, http.MethodPost, u.String(), bytes.NewBuffer(body))
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewBuffer(body))
 	} else {
-		req, err = http.NewRequestWithContext(c.ctx, http.MethodGet, u.String(), nil)
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	}
 	if err != nil {
 		return nil, err
@@ -94,18 +150,45 @@ func (c *baseClientImpl) makeRequest(method, uriPath, uriQuery string, body []by
 // Multisearch uses a shallow unmarshalled struct to defer the decoding to downstream handlers
 type MultiSearchResponse struct {
 	Responses []*json.RawMessage `json:"responses"`
+	// Stats are measured by the client, they are not part of the Quickwit response
+	Stats MultiSearchStats `json:"-"`
+	// ExecutedRequests holds the requests sent to Quickwit after interpolation,
+	// one per search request
+	ExecutedRequests []string `json:"-"`
+}
+
+// MultiSearchStats holds the client side measurements of a multisearch
+type MultiSearchStats struct {
+	// RoundTrip is the time between sending the request and receiving the response headers
+	RoundTrip time.Duration
+	// Decode is the time spent reading and decoding the response body
+	Decode time.Duration
+	// Attempts is the number of times the request was sent, including the retries
+	Attempts int
+	// CacheHits and CacheMisses are the number of search requests found and
+	// not found in the response cache
+	CacheHits   int
+	CacheMisses int
 }
 
-func (c *baseClientImpl) ExecuteMultisearch(requests []*SearchRequest) ([]*json.RawMessage, error) {
-	req, err := c.createMultiSearchRequest(requests, c.index)
+func (c *baseClientImpl) ExecuteMultisearch(requests []*SearchRequest) (*MultiSearchResponse, error) {
+	req, executedRequests, err := c.createMultiSearchRequest(requests, c.index)
 	if err != nil {
 		return nil, err
 	}
+	ctx, cancel := withSearchTimeout(c.ctx, requests)
+	defer cancel()
+	req = req.WithContext(ctx)
 
-	res, err := c.ds.HTTPClient.Do(req)
+	roundTripStart := time.Now()
+	res, attempts, err := doSearch(c.ds, req)
 	if err != nil {
-		return nil, err
+		if isSearchTimeout(c.ctx, ctx, err) {
+			return timedOutMultiSearchResponse(requests, executedRequests, MultiSearchStats{RoundTrip: time.Since(roundTripStart), Attempts: attempts})
+		}
+		return nil, &TransportError{Err: err}
 	}
+	roundTrip := time.Since(roundTripStart)
 	defer func() {
 		if err := res.Body.Close(); err != nil {
 			logger.Warn("Failed to close response body", "err", err)
@@ -115,17 +198,13 @@ func (c *baseClientImpl) ExecuteMultisearch(requests []*SearchRequest) ([]*json.
 	logger.Debug("Received multisearch response", "code", res.StatusCode, "status", res.Status, "content-length", res.ContentLength)
 
 	if res.StatusCode >= 400 {
-		qe := QuickwitQueryError{
-			Status:       res.StatusCode,
-			Message:      "Error on multisearch",
-			ResponseBody: res.Body,
-			QueryParam:   req.URL.RawQuery,
-			RequestBody:  requests,
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			return nil, &TransportError{Err: err}
 		}
-
-		errorPayload, _ := json.Marshal(qe)
-		logger.Error(string(errorPayload))
-		return nil, fmt.Errorf("%s", string(errorPayload))
+		qe := NewQuickwitError(res.StatusCode, body, c.index, requests)
+		logger.Error("Error on multisearch", "status", qe.StatusCode, "index", qe.Index, "message", qe.Message, "query_param", req.URL.RawQuery)
+		return nil, qe
 	}
 
 	start := time.Now()
@@ -135,48 +214,87 @@ func (c *baseClientImpl) ExecuteMultisearch(requests []*SearchRequest) ([]*json.
 	dec := json.NewDecoder(res.Body)
 	err = dec.Decode(&msr)
 	if err != nil {
-		return nil, err
+		if isSearchTimeout(c.ctx, ctx, err) {
+			return timedOutMultiSearchResponse(requests, executedRequests, MultiSearchStats{RoundTrip: roundTrip, Attempts: attempts})
+		}
+		return nil, &DecodeError{Err: err}
 	}
 
 	elapsed := time.Since(start)
 	logger.Debug("Decoded multisearch json response", "took", elapsed)
+	msr.Stats = MultiSearchStats{RoundTrip: roundTrip, Decode: elapsed, Attempts: attempts}
+	msr.ExecutedRequests = executedRequests
 
-	return msr.Responses, nil
+	if c.ds.ConfiguredFields.HasMultipleTimestampGroups() {
+		msr.Responses, err = mergeTimestampGroupResponses(requests, c.ds.ConfiguredFields, msr.Responses)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &msr, nil
 }
 
-func (c *baseClientImpl) makeMultiSearchPayload(searchRequests []*SearchRequest, index string) ([]byte, error) {
+// makeMultiSearchPayload formats the search requests as ndjson. When the
+// indexes use different timestamp fields, each request is sent once per index
+// group, in the order of ConfiguredFields.TimestampGroups. The ndjson lines of
+// each search request are also returned, to show the executed requests.
+func (c *baseClientImpl) makeMultiSearchPayload(searchRequests []*SearchRequest, index string) ([]byte, []string, error) {
+	groups := searchGroups(c.ds.ConfiguredFields, index)
+
 	// Format, marshall and interpolate
 	payload := bytes.Buffer{}
+	executedRequests := make([]string, 0, len(searchRequests))
 	for _, r := range searchRequests {
-		header := map[string]interface{}{
-			"ignore_unavailable": true,
-			"index":              strings.Split(index, ","),
-		}
-		reqHeader, err := json.Marshal(header)
-		if err != nil {
-			return nil, err
-		}
-		payload.WriteString(string(reqHeader) + "\n")
-
 		reqBody, err := json.Marshal(r)
 
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		body := string(reqBody)
-		body = strings.ReplaceAll(body, "$__interval_ms", strconv.FormatInt(r.Interval.Milliseconds(), 10))
-		body = strings.ReplaceAll(body, "$__interval", r.Interval.String())
+		lines := strings.Builder{}
+		for _, group := range groups {
+			header := map[string]interface{}{
+				"ignore_unavailable": true,
+				"index":              group.Indexes,
+			}
+			if r.Timeout > 0 {
+				header["timeout"] = formatTimeout(r.Timeout)
+			}
+			reqHeader, err := json.Marshal(header)
+			if err != nil {
+				return nil, nil, err
+			}
+			lines.WriteString(string(reqHeader) + "\n")
+			lines.WriteString(interpolateSearchBody(string(reqBody), r, group.Field) + "\n")
+		}
+		payload.WriteString(lines.String())
+		executedRequests = append(executedRequests, lines.String())
+	}
+	return payload.Bytes(), executedRequests, nil
+}
 
-		payload.WriteString(body + "\n")
+// searchGroups returns the index groups each search request is sent to
+func searchGroups(fields ConfiguredFields, index string) []TimestampGroup {
+	if fields.HasMultipleTimestampGroups() {
+		return fields.TimestampGroups()
 	}
-	return payload.Bytes(), nil
+	return []TimestampGroup{{
+		TimestampInfo: TimestampInfo{Field: fields.TimeField, OutputFormat: fields.TimeOutputFormat},
+		Indexes:       strings.Split(index, ","),
+	}}
 }
 
-func (c *baseClientImpl) createMultiSearchRequest(requests []*SearchRequest, index string) (*http.Request, error) {
-	body, err := c.makeMultiSearchPayload(requests, index)
+// interpolateSearchBody replaces the variables of a marshalled search request
+func interpolateSearchBody(body string, r *SearchRequest, timeField string) string {
+	body = strings.ReplaceAll(body, "$__interval_ms", strconv.FormatInt(r.Interval.Milliseconds(), 10))
+	body = strings.ReplaceAll(body, "$__interval", r.Interval.String())
+	return strings.ReplaceAll(body, TimeFieldVariable, timeField)
+}
+
+func (c *baseClientImpl) createMultiSearchRequest(requests []*SearchRequest, index string) (*http.Request, []string, error) {
+	body, executedRequests, err := c.makeMultiSearchPayload(requests, index)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var qs []string
@@ -187,5 +305,9 @@ func (c *baseClientImpl) createMultiSearchRequest(requests []*SearchRequest, ind
 	qs = append(qs, fmt.Sprintf("max_concurrent_shard_requests=%d", maxConcurrentShardRequests))
 	queryParams := strings.Join(qs, "&")
 
-	return c.makeRequest(http.MethodPost, "_elastic/_msearch", queryParams, body)
+	req, err := c.makeRequest(http.MethodPost, "_elastic/_msearch", queryParams, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return req, executedRequests, nil
 }
@@ -94,10 +94,101 @@ func TestClient_ExecuteMultisearch(t *testing.T) {
 
 		assert.Equal(t, "15s", jBody.GetPath("aggs", "2", "date_histogram", "fixed_interval").MustString())
 
-		require.Len(t, res, 1)
+		require.Len(t, res.Responses, 1)
+		assert.Positive(t, res.Stats.RoundTrip)
+		assert.Equal(t, []string{string(headerBytes) + string(bodyBytes)}, res.ExecutedRequests)
 	})
 }
 
+func TestClient_ExecuteMultisearchWithIndexTimestampGroups(t *testing.T) {
+	var requestBody []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		buf, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requestBody = buf
+
+		rw.Header().Set("Content-Type", "application/x-ndjson")
+		_, err = rw.Write([]byte(`{
+			"responses": [
+				{
+					"hits": { "hits": [
+						{ "_source": { "timestamp": "2024-01-01T00:00:03Z", "line": "a" }, "sort": [3000000000] },
+						{ "_source": { "timestamp": "2024-01-01T00:00:01Z", "line": "b" }, "sort": [1000000000] }
+					], "total": { "value": 2, "relation": "eq" } },
+					"status": 200
+				},
+				{
+					"hits": { "hits": [
+						{ "_source": { "attributes": { "ts": 1704067202 }, "line": "c" }, "sort": [2000000000] }
+					], "total": { "value": 1, "relation": "eq" } },
+					"status": 200
+				}
+			]
+		}`))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(ts.Close)
+
+	configuredFields := ConfiguredFields{LogMessageField: "line"}.WithIndexTimestampInfos(map[string]TimestampInfo{
+		"logs-a":    {Field: "timestamp", OutputFormat: "rfc3339"},
+		"logs-b":    {Field: "timestamp", OutputFormat: "rfc3339"},
+		"logs-otel": {Field: "attributes.ts", OutputFormat: "unix_timestamp_secs"},
+	})
+	assert.Equal(t, "timestamp", configuredFields.TimeField)
+	assert.Equal(t, "unix_timestamp_nanos", configuredFields.TimeOutputFormat)
+
+	ds := DatasourceInfo{
+		URL:              ts.URL,
+		HTTPClient:       ts.Client(),
+		Database:         "logs-*",
+		ConfiguredFields: configuredFields,
+	}
+	c, err := NewClient(context.Background(), &ds)
+	require.NoError(t, err)
+
+	msb := NewMultiSearchRequestBuilder()
+	s := msb.Search(15 * time.Second)
+	s.Size(2)
+	s.Sort(SortOrderDesc, configuredFields.SearchTimeField(), "epoch_nanos_int")
+	s.Query().Bool().Filter().AddDateRangeFilter(configuredFields.SearchTimeField(), 2000, 1000)
+	ms, err := msb.Build()
+	require.NoError(t, err)
+
+	res, err := c.ExecuteMultisearch(ms)
+	require.NoError(t, err)
+
+	lines := bytes.Split(bytes.TrimSpace(requestBody), []byte("\n"))
+	require.Len(t, lines, 4)
+	for i, expected := range []struct {
+		indexes   []string
+		timeField string
+	}{
+		{[]string{"logs-a", "logs-b"}, "timestamp"},
+		{[]string{"logs-otel"}, "attributes.ts"},
+	} {
+		jHeader, err := simplejson.NewJson(lines[2*i])
+		require.NoError(t, err)
+		assert.Equal(t, expected.indexes, jHeader.Get("index").MustStringArray())
+
+		jBody, err := simplejson.NewJson(lines[2*i+1])
+		require.NoError(t, err)
+		_, ok := jBody.Get("sort").GetIndex(0).CheckGet(expected.timeField)
+		assert.True(t, ok)
+		assert.NotContains(t, string(lines[2*i+1]), TimeFieldVariable)
+	}
+
+	require.Len(t, res.Responses, 1)
+	jRes, err := simplejson.NewJson(*res.Responses[0])
+	require.NoError(t, err)
+	assert.Equal(t, 3, jRes.GetPath("hits", "total", "value").MustInt())
+	hits := jRes.GetPath("hits", "hits").MustArray()
+	require.Len(t, hits, 2)
+	assert.Equal(t, "a", jRes.GetPath("hits", "hits").GetIndex(0).GetPath("_source", "line").MustString())
+	assert.Equal(t, "c", jRes.GetPath("hits", "hits").GetIndex(1).GetPath("_source", "line").MustString())
+	assert.Equal(t, int64(1704067202000000000), jRes.GetPath("hits", "hits").GetIndex(1).GetPath("_source", "timestamp").MustInt64())
+	assert.Equal(t, int64(1704067203000000000), jRes.GetPath("hits", "hits").GetIndex(0).GetPath("_source", "timestamp").MustInt64())
+}
+
 func createMultisearchForTest(t *testing.T) ([]*SearchRequest, error) {
 	t.Helper()
 
@@ -112,3 +203,15 @@ func createMultisearchForTest(t *testing.T) ([]*SearchRequest, error) {
 	})
 	return msb.Build()
 }
+
+func createSearchForTest(t *testing.T, query string) *SearchRequest {
+	t.Helper()
+
+	msb := NewMultiSearchRequestBuilder()
+	s := msb.Search(15 * time.Second)
+	s.Size(0)
+	s.Query().Bool().Filter().AddQueryStringFilter(query, true, "AND")
+	requests, err := msb.Build()
+	require.NoError(t, err)
+	return requests[0]
+}
@@ -0,0 +1,248 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// MaxCompositePages bounds the number of pages of a composite aggregation
+// fetched for a search request
+const MaxCompositePages = 100
+
+// rootCompositeAgg returns the composite aggregation at the root of the
+// aggregations of a search request
+func rootCompositeAgg(r *SearchRequest) (*Agg, *CompositeAggregation, bool) {
+	for _, agg := range r.Aggs {
+		if composite, ok := agg.Aggregation.Aggregation.(*CompositeAggregation); ok {
+			return agg, composite, true
+		}
+	}
+	return nil, nil, false
+}
+
+// NextCompositePage returns the search request of the page following the
+// response of r, for the composite aggregation at the root of r. It returns
+// false when r has no composite aggregation or when response is the last page,
+// with less buckets than the size of the pages.
+func NextCompositePage(r *SearchRequest, response *json.RawMessage) (*SearchRequest, bool, error) {
+	agg, composite, ok := rootCompositeAgg(r)
+	if !ok || composite.Size <= 0 {
+		return nil, false, nil
+	}
+	res, err := decodeSearchResponse(response)
+	if err != nil {
+		return nil, false, err
+	}
+	aggregations, _ := res["aggregations"].(map[string]interface{})
+	compositeRes, _ := aggregations[agg.Key].(map[string]interface{})
+	afterKey, _ := compositeRes["after_key"].(map[string]interface{})
+	buckets, _ := compositeRes["buckets"].([]interface{})
+	if len(afterKey) == 0 || len(buckets) < composite.Size {
+		return nil, false, nil
+	}
+
+	next := *r
+	next.Aggs = make(AggArray, len(r.Aggs))
+	for i, a := range r.Aggs {
+		if a != agg {
+			next.Aggs[i] = a
+			continue
+		}
+		nextComposite := *composite
+		nextComposite.After = afterKey
+		next.Aggs[i] = &Agg{
+			Key: a.Key,
+			Aggregation: &aggContainer{
+				Type:        a.Aggregation.Type,
+				Aggregation: &nextComposite,
+				Aggs:        a.Aggregation.Aggs,
+			},
+		}
+	}
+	return &next, true, nil
+}
+
+// AppendCompositePage appends the buckets of the next page of the composite
+// aggregation at the root of r to its response
+func AppendCompositePage(r *SearchRequest, response *json.RawMessage, page *json.RawMessage) (*json.RawMessage, error) {
+	agg, _, ok := rootCompositeAgg(r)
+	if !ok {
+		return response, nil
+	}
+	res, err := decodeSearchResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	pageRes, err := decodeSearchResponse(page)
+	if err != nil {
+		return nil, err
+	}
+	if pageRes["error"] != nil {
+		// The error of a page fails the whole query
+		return page, nil
+	}
+
+	aggregations, _ := res["aggregations"].(map[string]interface{})
+	compositeRes, _ := aggregations[agg.Key].(map[string]interface{})
+	pageAggregations, _ := pageRes["aggregations"].(map[string]interface{})
+	pageComposite, _ := pageAggregations[agg.Key].(map[string]interface{})
+	if compositeRes == nil || pageComposite == nil {
+		return response, nil
+	}
+
+	buckets, _ := compositeRes["buckets"].([]interface{})
+	pageBuckets, _ := pageComposite["buckets"].([]interface{})
+	compositeRes["buckets"] = append(buckets, pageBuckets...)
+	if afterKey, ok := pageComposite["after_key"]; ok {
+		compositeRes["after_key"] = afterKey
+	} else {
+		delete(compositeRes, "after_key")
+	}
+	if timedOut, _ := pageRes["timed_out"].(bool); timedOut {
+		res["timed_out"] = true
+	}
+	res["took"] = addNumbers(res["took"], pageRes["took"])
+	return marshalRawResponse(res)
+}
+
+// FetchCompositePages fetches the following pages of the composite
+// aggregations at the root of the requests, and appends their buckets to the
+// responses of res, which answer the requests in order. The next pages of all
+// the requests are fetched together, with one multisearch per page.
+func FetchCompositePages(client Client, requests []*SearchRequest, res *MultiSearchResponse) error {
+	if len(res.Responses) != len(requests) {
+		return &DecodeError{Err: fmt.Errorf("expected %d multisearch responses, got %d", len(requests), len(res.Responses))}
+	}
+	current := append([]*SearchRequest(nil), requests...)
+	lastPages := append([]*json.RawMessage(nil), res.Responses...)
+	for page := 1; ; page++ {
+		var next []*SearchRequest
+		var indexes []int
+		for i, r := range current {
+			if r == nil {
+				continue
+			}
+			nextRequest, ok, err := NextCompositePage(r, lastPages[i])
+			if err != nil {
+				return err
+			}
+			if !ok {
+				current[i] = nil
+				continue
+			}
+			current[i] = nextRequest
+			next = append(next, nextRequest)
+			indexes = append(indexes, i)
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		if page >= MaxCompositePages {
+			logger.Warn("Composite aggregation truncated to the maximum number of pages", "pages", page, "requests", len(next))
+			for _, i := range indexes {
+				truncated, err := markTruncated(res.Responses[i])
+				if err != nil {
+					return err
+				}
+				res.Responses[i] = truncated
+			}
+			return nil
+		}
+
+		logger.Debug("Fetching the next page of composite aggregations", "page", page+1, "requests", len(next))
+		pages, err := client.ExecuteMultisearch(next)
+		if err != nil {
+			return err
+		}
+		if len(pages.Responses) != len(next) {
+			return &DecodeError{Err: fmt.Errorf("expected %d multisearch responses, got %d", len(next), len(pages.Responses))}
+		}
+		for j, i := range indexes {
+			lastPages[i] = pages.Responses[j]
+			if res.Responses[i], err = AppendCompositePage(current[i], res.Responses[i], pages.Responses[j]); err != nil {
+				return err
+			}
+			if i < len(res.ExecutedRequests) && j < len(pages.ExecutedRequests) {
+				res.ExecutedRequests[i] += pages.ExecutedRequests[j]
+			}
+		}
+		res.Stats.RoundTrip += pages.Stats.RoundTrip
+		res.Stats.Decode += pages.Stats.Decode
+		res.Stats.CacheHits += pages.Stats.CacheHits
+		res.Stats.CacheMisses += pages.Stats.CacheMisses
+	}
+}
+
+// markTruncated flags a response whose composite aggregation has more pages
+// than MaxCompositePages, see SearchResponse.Truncated
+func markTruncated(response *json.RawMessage) (*json.RawMessage, error) {
+	res, err := decodeSearchResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	res["truncated"] = true
+	return marshalRawResponse(res)
+}
+
+// mergeCompositeBuckets merges the pages of a composite aggregation returned
+// by several index groups. The merged page ends with the smallest last key of
+// the full pages, the following buckets are fetched again with the next page.
+func mergeCompositeBuckets(dst, src map[string]interface{}, container *aggContainer) {
+	composite, _ := container.Aggregation.(*CompositeAggregation)
+	if composite == nil {
+		return
+	}
+	compareKeys := func(a, b interface{}) int {
+		aKey, _ := a.(map[string]interface{})
+		bKey, _ := b.(map[string]interface{})
+		for _, source := range composite.Sources {
+			if cmp := compareValues(aKey[source.Name], bKey[source.Name]); cmp != 0 {
+				return cmp
+			}
+		}
+		return 0
+	}
+
+	// A group has more buckets when it returned a full page, the group with the
+	// smallest last key returned at least a page of buckets up to this key
+	hasMore := func(res map[string]interface{}) (interface{}, bool) {
+		buckets, _ := res["buckets"].([]interface{})
+		after, ok := res["after_key"]
+		return after, ok && after != nil && len(buckets) >= composite.Size
+	}
+	dstAfter, dstHasMore := hasMore(dst)
+	srcAfter, srcHasMore := hasMore(src)
+	var after interface{}
+	switch {
+	case dstHasMore && srcHasMore:
+		after = dstAfter
+		if compareKeys(srcAfter, dstAfter) < 0 {
+			after = srcAfter
+		}
+	case dstHasMore:
+		after = dstAfter
+	case srcHasMore:
+		after = srcAfter
+	}
+
+	mergeBuckets(dst, src, container)
+	buckets, _ := dst["buckets"].([]interface{})
+	sort.SliceStable(buckets, func(i, j int) bool {
+		iBucket, _ := buckets[i].(map[string]interface{})
+		jBucket, _ := buckets[j].(map[string]interface{})
+		return compareKeys(iBucket["key"], jBucket["key"]) < 0
+	})
+
+	if after == nil {
+		delete(dst, "after_key")
+		dst["buckets"] = buckets
+		return
+	}
+	end := sort.Search(len(buckets), func(i int) bool {
+		bucket, _ := buckets[i].(map[string]interface{})
+		return compareKeys(bucket["key"], after) > 0
+	})
+	dst["buckets"] = buckets[:end]
+	dst["after_key"] = after
+}
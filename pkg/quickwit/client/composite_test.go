@@ -0,0 +1,121 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
+)
+
+// pagingClient answers the composite aggregations with the pages of keys,
+// starting after the key of the request
+type pagingClient struct {
+	keys     []string
+	requests [][]*SearchRequest
+}
+
+func (c *pagingClient) ExecuteMultisearch(requests []*SearchRequest) (*MultiSearchResponse, error) {
+	c.requests = append(c.requests, requests)
+	res := &MultiSearchResponse{}
+	for _, r := range requests {
+		agg, composite, _ := rootCompositeAgg(r)
+		start := 0
+		if after, ok := composite.After["service"]; ok {
+			start = sort.SearchStrings(c.keys, fmt.Sprint(after)) + 1
+		}
+		end := min(start+composite.Size, len(c.keys))
+		buckets := make([]string, 0)
+		for _, key := range c.keys[start:end] {
+			buckets = append(buckets, fmt.Sprintf(`{ "key": { "service": %q }, "doc_count": 1 }`, key))
+		}
+		afterKey := ""
+		if end > start {
+			afterKey = fmt.Sprintf(`, "after_key": { "service": %q }`, c.keys[end-1])
+		}
+		raw := json.RawMessage(fmt.Sprintf(`{ "aggregations": { %q: { "buckets": [%s]%s } } }`, agg.Key, strings.Join(buckets, ","), afterKey))
+		res.Responses = append(res.Responses, &raw)
+		res.ExecutedRequests = append(res.ExecutedRequests, "page\n")
+	}
+	return res, nil
+}
+
+func TestFetchCompositePages(t *testing.T) {
+	manyKeys := make([]string, 0, 2*MaxCompositePages+1)
+	for i := range cap(manyKeys) {
+		manyKeys = append(manyKeys, fmt.Sprintf("%04d", i))
+	}
+
+	for _, tc := range []struct {
+		name      string
+		keys      []string
+		size      int
+		pages     int
+		buckets   int
+		truncated bool
+	}{
+		{name: "Fetches the pages until the last one", keys: []string{"a", "b", "c", "d", "e"}, size: 2, pages: 3, buckets: 5},
+		{name: "Does not fetch a page after an incomplete page", keys: []string{"a", "b", "c"}, size: 5, pages: 1, buckets: 3},
+		{name: "Flags the responses truncated to the maximum number of pages", keys: manyKeys, size: 2, pages: MaxCompositePages, buckets: 2 * MaxCompositePages, truncated: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msb := NewMultiSearchRequestBuilder()
+			msb.Search(0).Agg().Composite("2", func(a *CompositeAggregation, b AggBuilder) {
+				a.Size = tc.size
+				a.Sources = append(a.Sources, &CompositeSource{Name: "service", Field: "service"})
+			})
+			requests, err := msb.Build()
+			require.NoError(t, err)
+
+			client := &pagingClient{keys: tc.keys}
+			res, err := client.ExecuteMultisearch(requests)
+			require.NoError(t, err)
+
+			require.NoError(t, FetchCompositePages(client, requests, res))
+			require.Len(t, client.requests, tc.pages)
+			if tc.pages > 1 {
+				// Each page starts after the last key of the previous one
+				body, err := json.Marshal(client.requests[tc.pages-1][0].Aggs)
+				require.NoError(t, err)
+				assert.Contains(t, string(body), fmt.Sprintf(`"after":{"service":%q}`, tc.keys[(tc.pages-1)*tc.size-1]))
+			}
+
+			page, err := simplejson.NewJson(*res.Responses[0])
+			require.NoError(t, err)
+			buckets := page.GetPath("aggregations", "2", "buckets")
+			require.Len(t, buckets.MustArray(), tc.buckets)
+			assert.Equal(t, tc.keys[tc.buckets-1], buckets.GetIndex(tc.buckets-1).GetPath("key", "service").MustString())
+			assert.Equal(t, strings.Repeat("page\n", tc.pages), res.ExecutedRequests[0])
+			assert.Equal(t, tc.truncated, page.Get("truncated").MustBool())
+		})
+	}
+
+	t.Run("Merged pages end with the smallest last key of the full pages", func(t *testing.T) {
+		agg := &aggContainer{Type: "composite", Aggregation: &CompositeAggregation{
+			Size:    2,
+			Sources: []*CompositeSource{{Name: "service", Field: "service"}},
+		}}
+		dst := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(`{
+			"buckets": [{ "key": { "service": "a" }, "doc_count": 1 }, { "key": { "service": "d" }, "doc_count": 1 }],
+			"after_key": { "service": "d" }
+		}`), &dst))
+		src := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(`{
+			"buckets": [{ "key": { "service": "a" }, "doc_count": 2 }, { "key": { "service": "b" }, "doc_count": 1 }],
+			"after_key": { "service": "b" }
+		}`), &src))
+
+		mergeCompositeBuckets(dst, src, agg)
+		buckets := dst["buckets"].([]interface{})
+		require.Len(t, buckets, 2)
+		assert.Equal(t, map[string]interface{}{"service": "a"}, buckets[0].(map[string]interface{})["key"])
+		assert.Equal(t, map[string]interface{}{"service": "b"}, buckets[1].(map[string]interface{})["key"])
+		assert.Equal(t, map[string]interface{}{"service": "b"}, dst["after_key"])
+	})
+}
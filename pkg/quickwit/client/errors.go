@@ -0,0 +1,168 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Error is implemented by the errors returned by the client, it tells how a
+// failure must be reported to Grafana
+type Error interface {
+	error
+	// Status returns the status of the data responses failed by the error
+	Status() backend.Status
+	// Source returns whether the failure comes from the plugin or from Quickwit
+	Source() backend.ErrorSource
+}
+
+// QuickwitError is returned when Quickwit answers a request with an error status
+type QuickwitError struct {
+	StatusCode  int
+	Message     string
+	Index       string
+	RequestBody []*SearchRequest
+}
+
+func (e *QuickwitError) Error() string {
+	if e.Index == "" {
+		return fmt.Sprintf("quickwit returned status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("quickwit returned status %d for index %s: %s", e.StatusCode, e.Index, e.Message)
+}
+
+func (e *QuickwitError) Status() backend.Status {
+	return backend.Status(e.StatusCode)
+}
+
+func (e *QuickwitError) Source() backend.ErrorSource {
+	return backend.ErrorSourceFromHTTPStatus(e.StatusCode)
+}
+
+// IsQueryError returns true when Quickwit rejected the content of the request,
+// in which case the other requests of a batch may still succeed on their own
+func (e *QuickwitError) IsQueryError() bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusProxyAuthRequired, http.StatusTooManyRequests:
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// NewQuickwitError creates a QuickwitError from an error response body,
+// extracting the message from the error payloads of Quickwit and of its
+// Elasticsearch compatible API
+func NewQuickwitError(statusCode int, body []byte, index string, requests []*SearchRequest) *QuickwitError {
+	return &QuickwitError{
+		StatusCode:  statusCode,
+		Message:     errorMessageFromBody(statusCode, body),
+		Index:       index,
+		RequestBody: requests,
+	}
+}
+
+func errorMessageFromBody(statusCode int, body []byte) string {
+	var payload struct {
+		Message string          `json:"message"`
+		Error   json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil {
+		if payload.Message != "" {
+			return payload.Message
+		}
+		var reason string
+		if err := json.Unmarshal(payload.Error, &reason); err == nil && reason != "" {
+			return reason
+		}
+		var elasticError struct {
+			Reason   string `json:"reason"`
+			CausedBy struct {
+				Reason string `json:"reason"`
+			} `json:"caused_by"`
+		}
+		if err := json.Unmarshal(payload.Error, &elasticError); err == nil {
+			if elasticError.CausedBy.Reason != "" {
+				return elasticError.CausedBy.Reason
+			}
+			if elasticError.Reason != "" {
+				return elasticError.Reason
+			}
+		}
+	}
+
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		return http.StatusText(statusCode)
+	}
+	return message
+}
+
+// TransportError is returned when the request could not be sent to Quickwit or
+// its response could not be read
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("failed to reach quickwit: %s", e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TransportError) Status() backend.Status {
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return backend.StatusTimeout
+	}
+	return backend.StatusBadGateway
+}
+
+func (e *TransportError) Source() backend.ErrorSource {
+	return backend.ErrorSourceDownstream
+}
+
+// DecodeError is returned when a Quickwit response cannot be decoded
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode quickwit response: %s", e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecodeError) Status() backend.Status {
+	return backend.StatusInternal
+}
+
+func (e *DecodeError) Source() backend.ErrorSource {
+	return backend.ErrorSourcePlugin
+}
+
+// QueueTimeoutError is returned when a multisearch waited too long for a free
+// slot of the request limiter of the datasource
+type QueueTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *QueueTimeoutError) Error() string {
+	return fmt.Sprintf("too many concurrent queries, no free slot after waiting %s", e.Timeout)
+}
+
+func (e *QueueTimeoutError) Status() backend.Status {
+	return backend.StatusTooManyRequests
+}
+
+func (e *QueueTimeoutError) Source() backend.ErrorSource {
+	return backend.ErrorSourcePlugin
+}
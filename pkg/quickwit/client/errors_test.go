@@ -0,0 +1,43 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewQuickwitError(t *testing.T) {
+	for body, expected := range map[string]string{
+		`{"message": "index not found"}`:                                         "index not found",
+		`{"error": "No ElasticsearchException found", "status": 400}`:            "No ElasticsearchException found",
+		`{"error": {"reason": "", "caused_by": {"reason": "too many buckets"}}}`: "too many buckets",
+		`{"error": {"reason": "illegal argument"}}`:                              "illegal argument",
+		`Access to the database is forbidden`:                                    "Access to the database is forbidden",
+		``:                                                                       "Bad Request",
+	} {
+		qe := NewQuickwitError(400, []byte(body), "my-index", nil)
+		assert.Equal(t, expected, qe.Message, body)
+	}
+
+	qe := NewQuickwitError(404, []byte(`{"message": "index not found"}`), "my-index", nil)
+	assert.EqualError(t, qe, "quickwit returned status 404 for index my-index: index not found")
+	assert.Equal(t, backend.StatusNotFound, qe.Status())
+	assert.Equal(t, backend.ErrorSourceDownstream, qe.Source())
+	assert.True(t, qe.IsQueryError())
+	assert.False(t, NewQuickwitError(403, nil, "", nil).IsQueryError())
+	assert.False(t, NewQuickwitError(500, nil, "", nil).IsQueryError())
+}
+
+func TestClientErrorStatus(t *testing.T) {
+	var clientErr Error = &TransportError{Err: context.DeadlineExceeded}
+	assert.Equal(t, backend.StatusTimeout, clientErr.Status())
+	assert.Equal(t, backend.ErrorSourceDownstream, clientErr.Source())
+	assert.ErrorIs(t, clientErr, context.DeadlineExceeded)
+
+	clientErr = &DecodeError{Err: errors.New("unexpected EOF")}
+	assert.Equal(t, backend.StatusInternal, clientErr.Status())
+	assert.Equal(t, backend.ErrorSourcePlugin, clientErr.Source())
+}
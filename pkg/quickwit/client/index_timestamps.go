@@ -0,0 +1,94 @@
+package es
+
+import (
+	"sort"
+
+	"github.com/quickwit-oss/quickwit-datasource/pkg/utils"
+)
+
+// TimeFieldVariable is used in place of the time field in search requests
+// when the indexes matching the datasource index pattern use different
+// timestamp fields. It is interpolated for each index group when building the
+// multisearch payload.
+const TimeFieldVariable = "$__timeField"
+
+// TimestampInfo represents the timestamp field of an index and its output format
+type TimestampInfo struct {
+	Field        string
+	OutputFormat string
+}
+
+// TimestampGroup represents indexes sharing the same timestamp infos
+type TimestampGroup struct {
+	TimestampInfo
+	Indexes []string
+}
+
+// TimestampGroups returns the indexes grouped by timestamp infos. The group
+// with the most indexes comes first, ties are broken by field and format so
+// that the order is stable.
+func (f ConfiguredFields) TimestampGroups() []TimestampGroup {
+	indexesByInfo := make(map[TimestampInfo][]string)
+	for index, info := range f.IndexTimestampInfos {
+		indexesByInfo[info] = append(indexesByInfo[info], index)
+	}
+
+	groups := make([]TimestampGroup, 0, len(indexesByInfo))
+	for info, indexes := range indexesByInfo {
+		sort.Strings(indexes)
+		groups = append(groups, TimestampGroup{TimestampInfo: info, Indexes: indexes})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Indexes) != len(groups[j].Indexes) {
+			return len(groups[i].Indexes) > len(groups[j].Indexes)
+		}
+		if groups[i].Field != groups[j].Field {
+			return groups[i].Field < groups[j].Field
+		}
+		return groups[i].OutputFormat < groups[j].OutputFormat
+	})
+	return groups
+}
+
+// HasMultipleTimestampGroups returns true when search requests must be fanned
+// out to several index groups
+func (f ConfiguredFields) HasMultipleTimestampGroups() bool {
+	var first *TimestampInfo
+	for _, info := range f.IndexTimestampInfos {
+		if first == nil {
+			first = &info
+		} else if info != *first {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchTimeField returns the time field to use when building search requests
+func (f ConfiguredFields) SearchTimeField() string {
+	if f.HasMultipleTimestampGroups() {
+		return TimeFieldVariable
+	}
+	return f.TimeField
+}
+
+// WithIndexTimestampInfos returns the configured fields completed with the
+// timestamp infos of the given indexes. When the indexes do not share the same
+// timestamp infos, the time field of the largest group is used for the
+// documents of every index, and their timestamps are normalized to
+// nanoseconds when the responses are merged.
+func (f ConfiguredFields) WithIndexTimestampInfos(infos map[string]TimestampInfo) ConfiguredFields {
+	f.IndexTimestampInfos = infos
+	groups := f.TimestampGroups()
+	switch len(groups) {
+	case 0:
+		return f
+	case 1:
+		f.TimeField = groups[0].Field
+		f.TimeOutputFormat = groups[0].OutputFormat
+	default:
+		f.TimeField = groups[0].Field
+		f.TimeOutputFormat = utils.TimestampNanos
+	}
+	return f
+}
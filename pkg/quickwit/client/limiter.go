@@ -0,0 +1,221 @@
+package es
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultQueueTimeout is the time a multisearch waits for a free slot when the
+// datasource does not configure it
+const DefaultQueueTimeout = 30 * time.Second
+
+// RequestLimiter is shared by the clients of a datasource instance. It bounds
+// the number of multisearches sent to Quickwit at once, the others wait in a
+// queue for at most queueTimeout. The identical multisearches in flight at the
+// same moment share the response of a single upstream call.
+type RequestLimiter struct {
+	// slots is nil when the number of concurrent multisearches is not limited
+	slots        chan struct{}
+	queueTimeout time.Duration
+
+	mu       sync.Mutex
+	inFlight map[string]*inFlightMultisearch
+
+	queued    atomic.Int64
+	running   atomic.Int64
+	coalesced atomic.Uint64
+}
+
+// inFlightMultisearch is a multisearch sent to Quickwit, done is closed once
+// its response is available
+type inFlightMultisearch struct {
+	done chan struct{}
+	res  *MultiSearchResponse
+	err  error
+
+	// ctx has the latest deadline of the callers waiting for the response
+	ctx context.Context
+	// waiters is the number of callers waiting for the response, the call is
+	// cancelled once all of them gave up
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// NewRequestLimiter creates a request limiter running at most maxConcurrent
+// multisearches at once, without limit when maxConcurrent is not positive
+func NewRequestLimiter(maxConcurrent int, queueTimeout time.Duration) *RequestLimiter {
+	if queueTimeout <= 0 {
+		queueTimeout = DefaultQueueTimeout
+	}
+	l := &RequestLimiter{
+		queueTimeout: queueTimeout,
+		inFlight:     map[string]*inFlightMultisearch{},
+	}
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// Stats returns the number of multisearches running and waiting for a slot,
+// and the number of multisearches which shared the call of another one
+func (l *RequestLimiter) Stats() (running int64, queued int64, coalesced uint64) {
+	return l.running.Load(), l.queued.Load(), l.coalesced.Load()
+}
+
+// acquire waits for a free slot, until the queue timeout or the cancellation
+// of the context
+func (l *RequestLimiter) acquire(ctx context.Context) error {
+	if l.slots == nil {
+		l.running.Add(1)
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		l.running.Add(1)
+		return nil
+	default:
+	}
+
+	queued := l.queued.Add(1)
+	defer l.queued.Add(-1)
+	logger.Debug("Waiting for a free multisearch slot", "queued", queued, "running", l.running.Load(), "limit", cap(l.slots))
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		l.running.Add(1)
+		return nil
+	case <-timer.C:
+		logger.Warn("Timed out waiting for a free multisearch slot", "queued", l.queued.Load(), "limit", cap(l.slots), "timeout", l.queueTimeout)
+		return &QueueTimeoutError{Timeout: l.queueTimeout}
+	case <-ctx.Done():
+		return &TransportError{Err: ctx.Err()}
+	}
+}
+
+func (l *RequestLimiter) release() {
+	l.running.Add(-1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// do runs execute once for all the multisearches with the same key in flight.
+// The call runs on a context detached from the caller which started it, so
+// that its cancellation does not fail the other callers, and is only
+// cancelled once all its callers gave up. The call keeps the deadline of the
+// caller which started it: a caller with a later deadline starts a new call,
+// shared by the identical multisearches sent afterwards.
+func (l *RequestLimiter) do(ctx context.Context, key string, execute func(context.Context) (*MultiSearchResponse, error)) (*MultiSearchResponse, error) {
+	l.mu.Lock()
+	call, ok := l.inFlight[key]
+	if ok && call.outlives(ctx) {
+		l.coalesced.Add(1)
+		logger.Debug("Sharing the response of an identical multisearch in flight")
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		if deadline, ok := ctx.Deadline(); ok {
+			callCtx, cancel = context.WithDeadline(context.WithoutCancel(ctx), deadline)
+		}
+		call = &inFlightMultisearch{done: make(chan struct{}), ctx: callCtx, cancel: cancel}
+		l.inFlight[key] = call
+		go l.run(callCtx, key, call, execute)
+	}
+	call.waiters++
+	l.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.response()
+	case <-ctx.Done():
+		l.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody waits for the response anymore
+			call.cancel()
+			l.forget(key, call)
+		}
+		l.mu.Unlock()
+		return nil, &TransportError{Err: ctx.Err()}
+	}
+}
+
+// run waits for a free slot and executes the call
+func (l *RequestLimiter) run(ctx context.Context, key string, call *inFlightMultisearch, execute func(context.Context) (*MultiSearchResponse, error)) {
+	defer func() {
+		l.mu.Lock()
+		l.forget(key, call)
+		l.mu.Unlock()
+		call.cancel()
+		close(call.done)
+	}()
+
+	if call.err = l.acquire(ctx); call.err != nil {
+		return
+	}
+	defer l.release()
+	call.res, call.err = execute(ctx)
+}
+
+// forget removes the call from the calls in flight, the identical
+// multisearches sent afterwards start a new call. It must be called with the
+// mutex held.
+func (l *RequestLimiter) forget(key string, call *inFlightMultisearch) {
+	if l.inFlight[key] == call {
+		delete(l.inFlight, key)
+	}
+}
+
+// outlives tells whether the call runs at least until the deadline of ctx
+func (c *inFlightMultisearch) outlives(ctx context.Context) bool {
+	callDeadline, ok := c.ctx.Deadline()
+	if !ok {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && !deadline.After(callDeadline)
+}
+
+// response returns a copy of the response of the call, so that the callers
+// sharing it do not see the changes of each other
+func (c *inFlightMultisearch) response() (*MultiSearchResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	res := *c.res
+	res.Responses = append([]*json.RawMessage(nil), c.res.Responses...)
+	res.ExecutedRequests = append([]string(nil), c.res.ExecutedRequests...)
+	return &res, nil
+}
+
+// limitingClient sends the multisearches of a client through the request
+// limiter of the datasource. The client executing a multisearch is created
+// with the context of the limiter call, not the one of the caller.
+type limitingClient struct {
+	ctx       context.Context
+	newClient func(ctx context.Context) Client
+	limiter   *RequestLimiter
+	ds        *DatasourceInfo
+	index     string
+}
+
+func (c *limitingClient) ExecuteMultisearch(requests []*SearchRequest) (*MultiSearchResponse, error) {
+	h := sha256.New()
+	for _, r := range requests {
+		key, err := searchRequestKey(c.ds, c.index, r)
+		if err != nil {
+			return nil, err
+		}
+		h.Write([]byte(key))
+	}
+	return c.limiter.do(c.ctx, hex.EncodeToString(h.Sum(nil)), func(ctx context.Context) (*MultiSearchResponse, error) {
+		return c.newClient(ctx).ExecuteMultisearch(requests)
+	})
+}
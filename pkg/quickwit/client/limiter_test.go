@@ -0,0 +1,245 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingClient answers the multisearches once release is closed
+type blockingClient struct {
+	calls   atomic.Int64
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingClient() *blockingClient {
+	return &blockingClient{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (c *blockingClient) ExecuteMultisearch(requests []*SearchRequest) (*MultiSearchResponse, error) {
+	c.calls.Add(1)
+	c.started <- struct{}{}
+	<-c.release
+	response := json.RawMessage(`{}`)
+	return &MultiSearchResponse{Responses: []*json.RawMessage{&response}}, nil
+}
+
+func newLimitingTestClient(client Client, limiter *RequestLimiter) *limitingClient {
+	return &limitingClient{
+		ctx:       context.Background(),
+		newClient: func(context.Context) Client { return client },
+		limiter:   limiter,
+		ds:        &DatasourceInfo{ConfiguredFields: ConfiguredFields{TimeField: "timestamp"}},
+		index:     "logs",
+	}
+}
+
+func TestRequestLimiter(t *testing.T) {
+	t.Run("Multisearches wait for a free slot until the queue timeout", func(t *testing.T) {
+		blocking := newBlockingClient()
+		limiter := NewRequestLimiter(1, 20*time.Millisecond)
+		c := newLimitingTestClient(blocking, limiter)
+
+		search := createSearchForTest(t, "service:api")
+		done := make(chan error)
+		go func() {
+			_, err := c.ExecuteMultisearch([]*SearchRequest{search})
+			done <- err
+		}()
+		<-blocking.started
+
+		_, err := c.ExecuteMultisearch([]*SearchRequest{createSearchForTest(t, "service:web")})
+		var qe *QueueTimeoutError
+		require.ErrorAs(t, err, &qe)
+		assert.Equal(t, 20*time.Millisecond, qe.Timeout)
+
+		close(blocking.release)
+		require.NoError(t, <-done)
+		running, queued, _ := limiter.Stats()
+		assert.Equal(t, int64(0), running)
+		assert.Equal(t, int64(0), queued)
+
+		// The slot is free again
+		_, err = c.ExecuteMultisearch([]*SearchRequest{createSearchForTest(t, "service:web")})
+		require.NoError(t, err)
+	})
+
+	t.Run("Identical multisearches in flight share one call", func(t *testing.T) {
+		blocking := newBlockingClient()
+		limiter := NewRequestLimiter(0, 0)
+		c := newLimitingTestClient(blocking, limiter)
+
+		search := createSearchForTest(t, "service:api")
+		var wg sync.WaitGroup
+		errs := make([]error, 3)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = c.ExecuteMultisearch([]*SearchRequest{search})
+			}(i)
+		}
+		<-blocking.started
+		require.Eventually(t, func() bool {
+			_, _, coalesced := limiter.Stats()
+			return coalesced == 2
+		}, time.Second, time.Millisecond)
+		close(blocking.release)
+		wg.Wait()
+
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int64(1), blocking.calls.Load())
+
+		// Once answered, the same multisearch is sent again
+		_, err := c.ExecuteMultisearch([]*SearchRequest{createSearchForTest(t, "service:api")})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), blocking.calls.Load())
+	})
+
+	t.Run("Identical multisearches in flight survive the cancellation of the first caller", func(t *testing.T) {
+		blocking := newBlockingClient()
+		limiter := NewRequestLimiter(0, 0)
+		leaderCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		leader := newLimitingTestClient(blocking, limiter)
+		leader.ctx = leaderCtx
+		callCtxs := make(chan context.Context, 1)
+		leader.newClient = func(ctx context.Context) Client {
+			callCtxs <- ctx
+			return blocking
+		}
+		follower := newLimitingTestClient(blocking, limiter)
+
+		search := createSearchForTest(t, "service:api")
+		leaderDone := make(chan error)
+		go func() {
+			_, err := leader.ExecuteMultisearch([]*SearchRequest{search})
+			leaderDone <- err
+		}()
+		<-blocking.started
+		followerDone := make(chan error)
+		go func() {
+			_, err := follower.ExecuteMultisearch([]*SearchRequest{search})
+			followerDone <- err
+		}()
+		require.Eventually(t, func() bool {
+			_, _, coalesced := limiter.Stats()
+			return coalesced == 1
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		var te *TransportError
+		require.ErrorAs(t, <-leaderDone, &te)
+		assert.ErrorIs(t, te.Err, context.Canceled)
+		callCtx := <-callCtxs
+		require.NoError(t, callCtx.Err())
+
+		close(blocking.release)
+		require.NoError(t, <-followerDone)
+		assert.Equal(t, int64(1), blocking.calls.Load())
+	})
+
+	t.Run("Multisearches in flight are cancelled once all their callers gave up", func(t *testing.T) {
+		blocking := newBlockingClient()
+		defer close(blocking.release)
+		limiter := NewRequestLimiter(0, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		c := newLimitingTestClient(blocking, limiter)
+		c.ctx = ctx
+		callCtxs := make(chan context.Context, 1)
+		c.newClient = func(ctx context.Context) Client {
+			callCtxs <- ctx
+			return blocking
+		}
+
+		done := make(chan error)
+		go func() {
+			_, err := c.ExecuteMultisearch([]*SearchRequest{createSearchForTest(t, "service:api")})
+			done <- err
+		}()
+		<-blocking.started
+		cancel()
+		require.Error(t, <-done)
+		assert.ErrorIs(t, (<-callCtxs).Err(), context.Canceled)
+	})
+}
+
+func TestRequestLimiterDeadlines(t *testing.T) {
+	blocking := newBlockingClient()
+	limiter := NewRequestLimiter(0, 0)
+	callCtxs := make(chan context.Context, 10)
+	newClient := func(deadline time.Time) *limitingClient {
+		c := newLimitingTestClient(blocking, limiter)
+		if !deadline.IsZero() {
+			var cancel context.CancelFunc
+			c.ctx, cancel = context.WithDeadline(context.Background(), deadline)
+			t.Cleanup(cancel)
+		}
+		c.newClient = func(ctx context.Context) Client {
+			callCtxs <- ctx
+			return blocking
+		}
+		return c
+	}
+
+	search := createSearchForTest(t, "service:api")
+	soon := time.Now().Add(time.Minute)
+	var wg sync.WaitGroup
+	execute := func(c *limitingClient) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.ExecuteMultisearch([]*SearchRequest{search})
+			assert.NoError(t, err)
+		}()
+	}
+
+	// The call has the deadline of the caller which started it
+	execute(newClient(soon))
+	<-blocking.started
+	deadline, ok := (<-callCtxs).Deadline()
+	require.True(t, ok)
+	assert.Equal(t, soon, deadline)
+
+	// The callers with an earlier deadline share it
+	execute(newClient(soon.Add(-time.Second)))
+	require.Eventually(t, func() bool {
+		_, _, coalesced := limiter.Stats()
+		return coalesced == 1
+	}, time.Second, time.Millisecond)
+
+	// The callers with a later deadline or without deadline start a new call
+	execute(newClient(soon.Add(time.Second)))
+	<-blocking.started
+	deadline, ok = (<-callCtxs).Deadline()
+	require.True(t, ok)
+	assert.Equal(t, soon.Add(time.Second), deadline)
+
+	execute(newClient(time.Time{}))
+	<-blocking.started
+	_, ok = (<-callCtxs).Deadline()
+	assert.False(t, ok)
+
+	// The identical multisearches sent afterwards share the latest call
+	execute(newClient(soon.Add(time.Hour)))
+	require.Eventually(t, func() bool {
+		_, _, coalesced := limiter.Stats()
+		return coalesced == 2
+	}, time.Second, time.Millisecond)
+
+	close(blocking.release)
+	wg.Wait()
+	assert.Equal(t, int64(3), blocking.calls.Load())
+}
@@ -0,0 +1,636 @@
+package es
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/quickwit-oss/quickwit-datasource/pkg/utils"
+)
+
+// mergeTimestampGroupResponses merges the responses of the requests fanned out
+// to each timestamp group back into a single response per request.
+func mergeTimestampGroupResponses(requests []*SearchRequest, fields ConfiguredFields, responses []*json.RawMessage) ([]*json.RawMessage, error) {
+	groups := fields.TimestampGroups()
+	if len(responses) != len(requests)*len(groups) {
+		return nil, fmt.Errorf("expected %d multisearch responses for %d index groups, got %d", len(requests)*len(groups), len(groups), len(responses))
+	}
+
+	merged := make([]*json.RawMessage, len(requests))
+	for i, r := range requests {
+		res, err := mergeSearchResponses(r, fields, groups, responses[i*len(groups):(i+1)*len(groups)])
+		if err != nil {
+			return nil, err
+		}
+		merged[i] = res
+	}
+	return merged, nil
+}
+
+// MergeSearchResponses merges the responses of searches sharing the request r
+// except for their time range, like the shards of a split time range. The hits
+// are sorted following the request and the aggregation buckets merged by key.
+func MergeSearchResponses(r *SearchRequest, responses []*json.RawMessage) (*json.RawMessage, error) {
+	return mergeSearchResponses(r, ConfiguredFields{}, nil, responses)
+}
+
+// mergeSearchResponses merges the responses of a request. The hit timestamps
+// are normalized with the timestamp infos of the groups when they are given.
+func mergeSearchResponses(r *SearchRequest, fields ConfiguredFields, groups []TimestampGroup, responses []*json.RawMessage) (*json.RawMessage, error) {
+	if name, ok := unmergeableAgg(r.Aggs); ok && len(responses) > 1 {
+		return marshalRawResponse(map[string]interface{}{
+			"status": 400,
+			"error": map[string]interface{}{
+				"reason": name + " cannot be computed by merging the results of several searches",
+			},
+		})
+	}
+
+	var merged map[string]interface{}
+	for i, raw := range responses {
+		res, err := decodeSearchResponse(raw)
+		if err != nil {
+			return nil, &DecodeError{Err: err}
+		}
+		// The failure of any index group fails the whole request
+		if _, ok := res["error"]; ok {
+			return raw, nil
+		}
+
+		if groups != nil {
+			normalizeHitTimestamps(res, groups[i].TimestampInfo, fields.TimeField)
+		}
+		if merged == nil {
+			merged = res
+			continue
+		}
+
+		mergedCount, resCount := totalHits(merged), totalHits(res)
+		mergeHits(merged, res)
+		if aggs, ok := res["aggregations"].(map[string]interface{}); ok {
+			mergedAggs, ok := merged["aggregations"].(map[string]interface{})
+			if !ok {
+				merged["aggregations"] = aggs
+			} else {
+				mergeAggregations(mergedAggs, aggs, r.Aggs, mergedCount, resCount)
+			}
+		}
+		if took, ok := toFloat(res["took"]); ok {
+			if mergedTook, ok := toFloat(merged["took"]); !ok || took > mergedTook {
+				merged["took"] = res["took"]
+			}
+		}
+		if timedOut, _ := res["timed_out"].(bool); timedOut {
+			merged["timed_out"] = true
+		}
+		mergeShards(merged, res)
+	}
+
+	sortHits(merged, r)
+
+	body, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	message := json.RawMessage(body)
+	return &message, nil
+}
+
+// unmergeableMetrics are the metrics whose value cannot be computed from the
+// values of several searches, by name. The unique values of two searches may
+// overlap, and the percentiles of the whole data are not a function of the
+// percentiles of its parts. Pipeline aggregations are computed from the
+// sibling buckets of a single search and are wrong once buckets are merged.
+var unmergeableMetrics = map[string]string{
+	"cardinality":      "unique counts",
+	"percentiles":      "percentiles",
+	"percentile_ranks": "percentile ranks",
+	"derivative":       "derivatives",
+	"moving_avg":       "moving averages",
+	"cumulative_sum":   "cumulative sums",
+	"bucket_script":    "bucket scripts",
+	"serial_diff":      "serial differences",
+}
+
+// unmergeableAgg returns the name of the first metric of the aggregations
+// that cannot be merged
+func unmergeableAgg(aggs AggArray) (string, bool) {
+	for _, agg := range aggs {
+		if name, ok := unmergeableMetrics[agg.Aggregation.Type]; ok {
+			return name, true
+		}
+		if name, ok := unmergeableAgg(agg.Aggregation.Aggs); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func decodeSearchResponse(raw *json.RawMessage) (map[string]interface{}, error) {
+	res := map[string]interface{}{}
+	if raw == nil {
+		return res, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(*raw))
+	dec.UseNumber()
+	if err := dec.Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// normalizeHitTimestamps moves the timestamp of each hit to the configured
+// time field as nanoseconds, parsing it with the output format of its index.
+func normalizeHitTimestamps(res map[string]interface{}, info TimestampInfo, timeField string) {
+	for _, hit := range responseHits(res) {
+		source, ok := hit["_source"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value := popSourceValue(source, info.Field)
+		if value == nil {
+			continue
+		}
+		timestamp, err := utils.ParseTime(value, info.OutputFormat)
+		if err != nil {
+			logger.Debug("Failed to parse hit timestamp", "field", info.Field, "format", info.OutputFormat, "err", err)
+			continue
+		}
+		source[timeField] = json.Number(strconv.FormatInt(timestamp.UnixNano(), 10))
+	}
+}
+
+// popSourceValue removes and returns the value at the given path, which may
+// point to a nested object field
+func popSourceValue(source map[string]interface{}, path string) interface{} {
+	if value, ok := source[path]; ok {
+		delete(source, path)
+		return value
+	}
+	for i := range path {
+		if path[i] != '.' {
+			continue
+		}
+		if child, ok := source[path[:i]].(map[string]interface{}); ok {
+			if value := popSourceValue(child, path[i+1:]); value != nil {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+func responseHits(res map[string]interface{}) []map[string]interface{} {
+	hits, _ := res["hits"].(map[string]interface{})
+	rawHits, _ := hits["hits"].([]interface{})
+	result := make([]map[string]interface{}, 0, len(rawHits))
+	for _, rawHit := range rawHits {
+		if hit, ok := rawHit.(map[string]interface{}); ok {
+			result = append(result, hit)
+		}
+	}
+	return result
+}
+
+func totalHits(res map[string]interface{}) float64 {
+	hits, _ := res["hits"].(map[string]interface{})
+	total, _ := hits["total"].(map[string]interface{})
+	value, _ := toFloat(total["value"])
+	return value
+}
+
+func mergeHits(dst, src map[string]interface{}) {
+	srcHits, ok := src["hits"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	dstHits, ok := dst["hits"].(map[string]interface{})
+	if !ok {
+		dst["hits"] = srcHits
+		return
+	}
+
+	dstList, _ := dstHits["hits"].([]interface{})
+	srcList, _ := srcHits["hits"].([]interface{})
+	dstHits["hits"] = append(dstList, srcList...)
+
+	if srcTotal, ok := srcHits["total"].(map[string]interface{}); ok {
+		dstTotal, ok := dstHits["total"].(map[string]interface{})
+		if !ok {
+			dstHits["total"] = srcTotal
+		} else {
+			dstTotal["value"] = addNumbers(dstTotal["value"], srcTotal["value"])
+		}
+	}
+}
+
+// mergeShards sums the split counts of the index groups
+func mergeShards(dst, src map[string]interface{}) {
+	srcShards, ok := src["_shards"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	dstShards, ok := dst["_shards"].(map[string]interface{})
+	if !ok {
+		dst["_shards"] = srcShards
+		return
+	}
+	for key, value := range srcShards {
+		dstShards[key] = addNumbers(dstShards[key], value)
+	}
+}
+
+// sortHits sorts the merged hits by their sort values, following the sort
+// orders of the request, and keeps the requested number of hits
+func sortHits(res map[string]interface{}, r *SearchRequest) {
+	hits, ok := res["hits"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	list, ok := hits["hits"].([]interface{})
+	if !ok {
+		return
+	}
+
+	orders := make([]string, 0, len(r.Sort))
+	for _, sortField := range r.Sort {
+		for _, options := range sortField {
+			order, _ := options["order"].(string)
+			orders = append(orders, order)
+		}
+	}
+	if len(orders) > 0 {
+		sort.SliceStable(list, func(i, j int) bool {
+			a, _ := list[i].(map[string]interface{})
+			b, _ := list[j].(map[string]interface{})
+			aValues, _ := a["sort"].([]interface{})
+			bValues, _ := b["sort"].([]interface{})
+			for k, order := range orders {
+				if k >= len(aValues) || k >= len(bValues) {
+					break
+				}
+				cmp := compareValues(aValues[k], bValues[k])
+				if order == string(SortOrderDesc) {
+					cmp = -cmp
+				}
+				if cmp != 0 {
+					return cmp < 0
+				}
+			}
+			return false
+		})
+	}
+
+	if r.Size >= 0 && len(list) > r.Size {
+		list = list[:r.Size]
+	}
+	hits["hits"] = list
+}
+
+// mergeAggregations merges the aggregations of src into dst, following the
+// aggregation definitions of the request. dstCount and srcCount are the
+// document counts of the enclosing buckets, used to weight averages.
+func mergeAggregations(dst, src map[string]interface{}, aggs AggArray, dstCount, srcCount float64) {
+	for _, agg := range aggs {
+		srcAgg, ok := src[agg.Key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		dstAgg, ok := dst[agg.Key].(map[string]interface{})
+		if !ok {
+			dst[agg.Key] = srcAgg
+			continue
+		}
+
+		switch agg.Aggregation.Type {
+		case "date_histogram", "histogram", "terms", "geohash_grid":
+			mergeBuckets(dstAgg, srcAgg, agg.Aggregation)
+		case "filters":
+			mergeKeyedBuckets(dstAgg, srcAgg, agg.Aggregation.Aggs)
+		case "composite":
+			mergeCompositeBuckets(dstAgg, srcAgg, agg.Aggregation)
+		case "range", "date_range":
+			if _, keyed := srcAgg["buckets"].(map[string]interface{}); keyed {
+				mergeKeyedBuckets(dstAgg, srcAgg, agg.Aggregation.Aggs)
+			} else {
+				mergeBuckets(dstAgg, srcAgg, agg.Aggregation)
+			}
+		case "nested":
+			mergeBucket(dstAgg, srcAgg, agg.Aggregation.Aggs)
+		default:
+			mergeMetric(dstAgg, srcAgg, agg.Aggregation, dstCount, srcCount)
+		}
+	}
+}
+
+func mergeBucket(dst, src map[string]interface{}, aggs AggArray) {
+	dstCount, _ := toFloat(dst["doc_count"])
+	srcCount, _ := toFloat(src["doc_count"])
+	mergeAggregations(dst, src, aggs, dstCount, srcCount)
+	dst["doc_count"] = addNumbers(dst["doc_count"], src["doc_count"])
+}
+
+func mergeKeyedBuckets(dst, src map[string]interface{}, aggs AggArray) {
+	dstBuckets, ok := dst["buckets"].(map[string]interface{})
+	if !ok {
+		dst["buckets"] = src["buckets"]
+		return
+	}
+	srcBuckets, _ := src["buckets"].(map[string]interface{})
+	for key, value := range srcBuckets {
+		srcBucket, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		dstBucket, ok := dstBuckets[key].(map[string]interface{})
+		if !ok {
+			dstBuckets[key] = srcBucket
+			continue
+		}
+		mergeBucket(dstBucket, srcBucket, aggs)
+	}
+}
+
+func mergeBuckets(dst, src map[string]interface{}, container *aggContainer) {
+	dstBuckets, _ := dst["buckets"].([]interface{})
+	srcBuckets, _ := src["buckets"].([]interface{})
+
+	bucketsByKey := make(map[string]map[string]interface{}, len(dstBuckets))
+	for _, b := range dstBuckets {
+		if bucket, ok := b.(map[string]interface{}); ok {
+			bucketsByKey[fmt.Sprint(bucket["key"])] = bucket
+		}
+	}
+	for _, b := range srcBuckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key := fmt.Sprint(bucket["key"])
+		if dstBucket, ok := bucketsByKey[key]; ok {
+			mergeBucket(dstBucket, bucket, container.Aggs)
+			continue
+		}
+		bucketsByKey[key] = bucket
+		dstBuckets = append(dstBuckets, bucket)
+	}
+
+	switch container.Type {
+	case "date_histogram", "histogram":
+		sort.SliceStable(dstBuckets, func(i, j int) bool {
+			return compareBucketValues(dstBuckets[i], dstBuckets[j], "_key") < 0
+		})
+	case "terms":
+		terms, _ := container.Aggregation.(*TermsAggregation)
+		sortTermsBuckets(dstBuckets, terms)
+		for _, field := range []string{"sum_other_doc_count", "doc_count_error_upper_bound"} {
+			if _, ok := src[field]; ok {
+				dst[field] = addNumbers(dst[field], src[field])
+			}
+		}
+		if terms != nil && terms.Size > 0 && len(dstBuckets) > terms.Size {
+			for _, b := range dstBuckets[terms.Size:] {
+				bucket, _ := b.(map[string]interface{})
+				dst["sum_other_doc_count"] = addNumbers(dst["sum_other_doc_count"], bucket["doc_count"])
+			}
+			dstBuckets = dstBuckets[:terms.Size]
+		}
+	case "geohash_grid":
+		sortTermsBuckets(dstBuckets, nil)
+	}
+	dst["buckets"] = dstBuckets
+}
+
+// sortTermsBuckets sorts buckets following the order of the terms
+// aggregation, by descending document count by default
+func sortTermsBuckets(buckets []interface{}, terms *TermsAggregation) {
+	orderBy, direction := "_count", string(SortOrderDesc)
+	if terms != nil {
+		for key, value := range terms.Order {
+			orderBy = key
+			direction, _ = value.(string)
+		}
+	}
+	if orderBy == "_term" {
+		orderBy = "_key"
+	}
+
+	sort.SliceStable(buckets, func(i, j int) bool {
+		cmp := compareBucketValues(buckets[i], buckets[j], orderBy)
+		if direction == string(SortOrderDesc) {
+			cmp = -cmp
+		}
+		return cmp < 0
+	})
+}
+
+func compareBucketValues(a, b interface{}, orderBy string) int {
+	aBucket, _ := a.(map[string]interface{})
+	bBucket, _ := b.(map[string]interface{})
+	value := func(bucket map[string]interface{}) interface{} {
+		switch orderBy {
+		case "_key":
+			return bucket["key"]
+		case "_count":
+			return bucket["doc_count"]
+		default:
+			// Ordered by a metric sub-aggregation
+			metric, _ := bucket[orderBy].(map[string]interface{})
+			return metric["value"]
+		}
+	}
+	return compareValues(value(aBucket), value(bBucket))
+}
+
+func mergeMetric(dst, src map[string]interface{}, container *aggContainer, dstCount, srcCount float64) {
+	switch container.Type {
+	case "sum", "value_count":
+		dst["value"] = addNumbers(dst["value"], src["value"])
+	case "min":
+		if compareValues(src["value"], dst["value"]) < 0 {
+			dst["value"] = src["value"]
+		}
+	case "max":
+		if src["value"] != nil && (dst["value"] == nil || compareValues(src["value"], dst["value"]) > 0) {
+			dst["value"] = src["value"]
+		}
+	case "avg":
+		dst["value"] = weightedAverage(dst["value"], src["value"], dstCount, srcCount)
+	case "extended_stats", "stats":
+		mergeStats(dst, src, container)
+	case "top_metrics":
+		metric, _ := container.Aggregation.(*MetricAggregation)
+		order := string(SortOrderDesc)
+		if metric != nil {
+			if o, ok := metric.Settings["order"].(string); ok {
+				order = o
+			}
+		}
+		dstTop, _ := dst["top"].([]interface{})
+		srcTop, _ := src["top"].([]interface{})
+		if len(srcTop) == 0 {
+			return
+		}
+		if len(dstTop) == 0 {
+			dst["top"] = srcTop
+			return
+		}
+		dstFirst, _ := dstTop[0].(map[string]interface{})
+		srcFirst, _ := srcTop[0].(map[string]interface{})
+		dstSort, _ := dstFirst["sort"].([]interface{})
+		srcSort, _ := srcFirst["sort"].([]interface{})
+		if len(dstSort) == 0 || len(srcSort) == 0 {
+			return
+		}
+		cmp := compareValues(srcSort[0], dstSort[0])
+		if (order == string(SortOrderAsc) && cmp < 0) || (order != string(SortOrderAsc) && cmp > 0) {
+			dst["top"] = srcTop
+		}
+	}
+}
+
+func mergeStats(dst, src map[string]interface{}, container *aggContainer) {
+	srcCount, _ := toFloat(src["count"])
+	if srcCount == 0 {
+		return
+	}
+	dstCount, _ := toFloat(dst["count"])
+	if dstCount == 0 {
+		for key, value := range src {
+			dst[key] = value
+		}
+		return
+	}
+
+	count := dstCount + srcCount
+	sum := addNumbers(dst["sum"], src["sum"])
+	sumValue, _ := toFloat(sum)
+	avg := sumValue / count
+	dst["count"] = numberValue(count)
+	dst["sum"] = sum
+	dst["avg"] = numberValue(avg)
+	if compareValues(src["min"], dst["min"]) < 0 {
+		dst["min"] = src["min"]
+	}
+	if compareValues(src["max"], dst["max"]) > 0 {
+		dst["max"] = src["max"]
+	}
+
+	if _, ok := dst["sum_of_squares"]; !ok {
+		return
+	}
+	sumOfSquares := addNumbers(dst["sum_of_squares"], src["sum_of_squares"])
+	sumOfSquaresValue, _ := toFloat(sumOfSquares)
+	variance := math.Max(sumOfSquaresValue/count-avg*avg, 0)
+	stdDeviation := math.Sqrt(variance)
+	dst["sum_of_squares"] = sumOfSquares
+	dst["variance"] = numberValue(variance)
+	dst["std_deviation"] = numberValue(stdDeviation)
+
+	sigma := 2.0
+	if metric, ok := container.Aggregation.(*MetricAggregation); ok {
+		if s, ok := toFloat(metric.Settings["sigma"]); ok {
+			sigma = s
+		}
+	}
+	dst["std_deviation_bounds"] = map[string]interface{}{
+		"upper": numberValue(avg + sigma*stdDeviation),
+		"lower": numberValue(avg - sigma*stdDeviation),
+	}
+}
+
+func weightedAverage(a, b interface{}, aWeight, bWeight float64) interface{} {
+	aValue, aOk := toFloat(a)
+	bValue, bOk := toFloat(b)
+	switch {
+	case !aOk:
+		return b
+	case !bOk:
+		return a
+	case aWeight+bWeight == 0:
+		return numberValue((aValue + bValue) / 2)
+	default:
+		return numberValue((aValue*aWeight + bValue*bWeight) / (aWeight + bWeight))
+	}
+}
+
+func addNumbers(a, b interface{}) interface{} {
+	aValue, aOk := toFloat(a)
+	bValue, bOk := toFloat(b)
+	switch {
+	case !aOk:
+		return b
+	case !bOk:
+		return a
+	default:
+		return numberValue(aValue + bValue)
+	}
+}
+
+func numberValue(value float64) json.Number {
+	return json.Number(strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// compareValues compares two sort or bucket values, numerically when both are
+// numbers. Missing values are sorted last.
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	aNumber, aIsNumber := a.(json.Number)
+	bNumber, bIsNumber := b.(json.Number)
+	if aIsNumber && bIsNumber {
+		aInt, aErr := aNumber.Int64()
+		bInt, bErr := bNumber.Int64()
+		if aErr == nil && bErr == nil {
+			switch {
+			case aInt < bInt:
+				return -1
+			case aInt > bInt:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	aFloat, aOk := toFloat(a)
+	bFloat, bOk := toFloat(b)
+	if aOk && bOk && (aIsNumber || bIsNumber) {
+		switch {
+		case aFloat < bFloat:
+			return -1
+		case aFloat > bFloat:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
@@ -0,0 +1,260 @@
+package es
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
+)
+
+func rawResponsesForTest(t *testing.T, responses ...string) []*json.RawMessage {
+	t.Helper()
+	result := make([]*json.RawMessage, len(responses))
+	for i, response := range responses {
+		raw := json.RawMessage(response)
+		require.True(t, json.Valid(raw), response)
+		result[i] = &raw
+	}
+	return result
+}
+
+func TestTimestampGroups(t *testing.T) {
+	fields := ConfiguredFields{IndexTimestampInfos: map[string]TimestampInfo{
+		"c": {Field: "ts", OutputFormat: "unix_timestamp_secs"},
+		"b": {Field: "timestamp", OutputFormat: "rfc3339"},
+		"a": {Field: "timestamp", OutputFormat: "rfc3339"},
+	}}
+
+	groups := fields.TimestampGroups()
+	require.Len(t, groups, 2)
+	assert.Equal(t, []string{"a", "b"}, groups[0].Indexes)
+	assert.Equal(t, "timestamp", groups[0].Field)
+	assert.Equal(t, []string{"c"}, groups[1].Indexes)
+	assert.True(t, fields.HasMultipleTimestampGroups())
+	assert.Equal(t, TimeFieldVariable, fields.SearchTimeField())
+
+	single := ConfiguredFields{}.WithIndexTimestampInfos(map[string]TimestampInfo{
+		"a": {Field: "timestamp", OutputFormat: "rfc3339"},
+		"b": {Field: "timestamp", OutputFormat: "rfc3339"},
+	})
+	assert.False(t, single.HasMultipleTimestampGroups())
+	assert.Equal(t, "timestamp", single.SearchTimeField())
+	assert.Equal(t, "rfc3339", single.TimeOutputFormat)
+}
+
+func TestMergeTimestampGroupResponses(t *testing.T) {
+	fields := ConfiguredFields{}.WithIndexTimestampInfos(map[string]TimestampInfo{
+		"a": {Field: "timestamp", OutputFormat: "rfc3339"},
+		"b": {Field: "ts", OutputFormat: "unix_timestamp_millis"},
+	})
+
+	t.Run("Merges date histogram buckets and metrics", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		s := msb.Search(0)
+		s.Agg().DateHistogram("2", fields.SearchTimeField(), func(a *DateHistogramAgg, ab AggBuilder) {
+			ab.Metric("1", "avg", "value", nil)
+			ab.Metric("3", "max", "value", nil)
+			ab.Metric("4", "sum", "value", nil)
+		})
+		requests, err := msb.Build()
+		require.NoError(t, err)
+
+		responses := rawResponsesForTest(t,
+			`{ "aggregations": { "2": { "buckets": [
+				{ "key": 1000, "doc_count": 1, "1": { "value": 10 }, "3": { "value": 10 }, "4": { "value": 10 } },
+				{ "key": 3000, "doc_count": 3, "1": { "value": 2 }, "3": { "value": 4 }, "4": { "value": 6 } }
+			] } } }`,
+			`{ "aggregations": { "2": { "buckets": [
+				{ "key": 2000, "doc_count": 2, "1": { "value": 5 }, "3": { "value": 6 }, "4": { "value": 10 } },
+				{ "key": 3000, "doc_count": 1, "1": { "value": 6 }, "3": { "value": null }, "4": { "value": 6 } }
+			] } } }`,
+		)
+
+		merged, err := mergeTimestampGroupResponses(requests, fields, responses)
+		require.NoError(t, err)
+		require.Len(t, merged, 1)
+
+		res, err := simplejson.NewJson(*merged[0])
+		require.NoError(t, err)
+		buckets := res.GetPath("aggregations", "2", "buckets")
+		require.Len(t, buckets.MustArray(), 3)
+		assert.Equal(t, []int{1000, 2000, 3000}, []int{
+			buckets.GetIndex(0).Get("key").MustInt(),
+			buckets.GetIndex(1).Get("key").MustInt(),
+			buckets.GetIndex(2).Get("key").MustInt(),
+		})
+		last := buckets.GetIndex(2)
+		assert.Equal(t, 4, last.Get("doc_count").MustInt())
+		assert.Equal(t, 3.0, last.GetPath("1", "value").MustFloat64())
+		assert.Equal(t, 4.0, last.GetPath("3", "value").MustFloat64())
+		assert.Equal(t, 12.0, last.GetPath("4", "value").MustFloat64())
+	})
+
+	t.Run("Merges terms buckets following the terms order and size", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		s := msb.Search(0)
+		s.Agg().Terms("2", "host", func(a *TermsAggregation, ab AggBuilder) {
+			a.Size = 2
+			a.Order["_count"] = "desc"
+		})
+		requests, err := msb.Build()
+		require.NoError(t, err)
+
+		responses := rawResponsesForTest(t,
+			`{ "aggregations": { "2": { "sum_other_doc_count": 0, "buckets": [
+				{ "key": "x", "doc_count": 5 },
+				{ "key": "y", "doc_count": 3 }
+			] } } }`,
+			`{ "aggregations": { "2": { "sum_other_doc_count": 1, "buckets": [
+				{ "key": "z", "doc_count": 4 },
+				{ "key": "y", "doc_count": 4 }
+			] } } }`,
+		)
+
+		merged, err := mergeTimestampGroupResponses(requests, fields, responses)
+		require.NoError(t, err)
+
+		res, err := simplejson.NewJson(*merged[0])
+		require.NoError(t, err)
+		buckets := res.GetPath("aggregations", "2", "buckets")
+		require.Len(t, buckets.MustArray(), 2)
+		assert.Equal(t, "y", buckets.GetIndex(0).Get("key").MustString())
+		assert.Equal(t, 7, buckets.GetIndex(0).Get("doc_count").MustInt())
+		assert.Equal(t, "x", buckets.GetIndex(1).Get("key").MustString())
+		assert.Equal(t, 5, res.GetPath("aggregations", "2", "sum_other_doc_count").MustInt())
+	})
+
+	t.Run("Merges range buckets and averages", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		s := msb.Search(0)
+		s.Agg().Range("2", "bytes", func(a *RangeAggregation, ab AggBuilder) {
+			a.Keyed = true
+			ab.Metric("3", "avg", "latency", nil)
+		})
+		requests, err := msb.Build()
+		require.NoError(t, err)
+
+		responses := rawResponsesForTest(t,
+			`{ "aggregations": { "2": { "buckets": {
+				"small": { "to": 100, "doc_count": 1, "3": { "value": 40 } }
+			} } } }`,
+			`{ "aggregations": { "2": { "buckets": {
+				"small": { "to": 100, "doc_count": 3, "3": { "value": 80 } },
+				"large": { "from": 100, "doc_count": 2 }
+			} } } }`,
+		)
+
+		merged, err := mergeTimestampGroupResponses(requests, fields, responses)
+		require.NoError(t, err)
+
+		res, err := simplejson.NewJson(*merged[0])
+		require.NoError(t, err)
+		buckets := res.GetPath("aggregations", "2", "buckets")
+		require.Len(t, buckets.MustMap(), 2)
+		assert.Equal(t, 4, buckets.GetPath("small", "doc_count").MustInt())
+		assert.Equal(t, 70.0, buckets.GetPath("small", "3", "value").MustFloat64())
+		assert.Equal(t, 2, buckets.GetPath("large", "doc_count").MustInt())
+	})
+
+	t.Run("Returns the error of an index group", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		msb.Search(0)
+		requests, err := msb.Build()
+		require.NoError(t, err)
+
+		responses := rawResponsesForTest(t,
+			`{ "hits": { "hits": [] } }`,
+			`{ "error": { "reason": "unknown field" }, "status": 400 }`,
+		)
+
+		merged, err := mergeTimestampGroupResponses(requests, fields, responses)
+		require.NoError(t, err)
+		assert.Equal(t, *responses[1], *merged[0])
+	})
+
+	t.Run("Refuses to add unique counts", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		s := msb.Search(0)
+		s.Agg().Terms("2", "service", func(a *TermsAggregation, ab AggBuilder) {
+			ab.Metric("1", "cardinality", "user", nil)
+		})
+		requests, err := msb.Build()
+		require.NoError(t, err)
+
+		responses := rawResponsesForTest(t,
+			`{ "aggregations": { "2": { "buckets": [{ "key": "api", "doc_count": 2, "1": { "value": 2 } }] } } }`,
+			`{ "aggregations": { "2": { "buckets": [{ "key": "api", "doc_count": 3, "1": { "value": 3 } }] } } }`,
+		)
+
+		merged, err := mergeTimestampGroupResponses(requests, fields, responses)
+		require.NoError(t, err)
+		res, err := simplejson.NewJson(*merged[0])
+		require.NoError(t, err)
+		assert.Equal(t, 400, res.Get("status").MustInt())
+		assert.Contains(t, res.GetPath("error", "reason").MustString(), "unique counts")
+	})
+
+	t.Run("Refuses to merge percentiles", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		s := msb.Search(0)
+		s.Agg().Terms("2", "service", func(a *TermsAggregation, ab AggBuilder) {
+			ab.Metric("1", "percentiles", "latency", nil)
+		})
+		requests, err := msb.Build()
+		require.NoError(t, err)
+
+		responses := rawResponsesForTest(t,
+			`{ "aggregations": { "2": { "buckets": [{ "key": "api", "doc_count": 2, "1": { "values": { "95.0": 20 } } }] } } }`,
+			`{ "aggregations": { "2": { "buckets": [{ "key": "api", "doc_count": 3, "1": { "values": { "95.0": 30 } } }] } } }`,
+		)
+
+		merged, err := mergeTimestampGroupResponses(requests, fields, responses)
+		require.NoError(t, err)
+		res, err := simplejson.NewJson(*merged[0])
+		require.NoError(t, err)
+		assert.Equal(t, 400, res.Get("status").MustInt())
+		assert.Contains(t, res.GetPath("error", "reason").MustString(), "percentiles cannot be computed")
+	})
+
+	t.Run("Refuses to merge derivatives", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		s := msb.Search(0)
+		s.Agg().DateHistogram("2", fields.SearchTimeField(), func(a *DateHistogramAgg, ab AggBuilder) {
+			ab.Metric("1", "sum", "value", nil)
+			ab.Pipeline("3", "derivative", "1", nil)
+		})
+		requests, err := msb.Build()
+		require.NoError(t, err)
+
+		responses := rawResponsesForTest(t,
+			`{ "aggregations": { "2": { "buckets": [
+				{ "key": 1000, "doc_count": 1, "1": { "value": 10 } },
+				{ "key": 2000, "doc_count": 1, "1": { "value": 15 }, "3": { "value": 5 } }
+			] } } }`,
+			`{ "aggregations": { "2": { "buckets": [
+				{ "key": 1000, "doc_count": 1, "1": { "value": 2 } },
+				{ "key": 2000, "doc_count": 1, "1": { "value": 4 }, "3": { "value": 2 } }
+			] } } }`,
+		)
+
+		merged, err := mergeTimestampGroupResponses(requests, fields, responses)
+		require.NoError(t, err)
+		res, err := simplejson.NewJson(*merged[0])
+		require.NoError(t, err)
+		assert.Equal(t, 400, res.Get("status").MustInt())
+		assert.Contains(t, res.GetPath("error", "reason").MustString(), "derivatives cannot be computed")
+	})
+
+	t.Run("Fails on an unexpected number of responses", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		msb.Search(0)
+		requests, err := msb.Build()
+		require.NoError(t, err)
+
+		_, err = mergeTimestampGroupResponses(requests, fields, rawResponsesForTest(t, `{}`))
+		require.Error(t, err)
+	})
+}
@@ -2,7 +2,6 @@ package es
 
 import (
 	"encoding/json"
-	"io"
 	"time"
 )
 
@@ -15,6 +14,10 @@ type SearchRequest struct {
 	Query       *Query
 	Aggs        AggArray
 	CustomProps map[string]interface{}
+	// NoCache bypasses the response cache of the datasource
+	NoCache bool
+	// Timeout is the time Quickwit searches before returning partial results
+	Timeout time.Duration
 }
 
 // MarshalJSON returns the JSON encoding of the request.
@@ -36,27 +39,59 @@ func (r *SearchRequest) MarshalJSON() ([]byte, error) {
 		root["aggs"] = r.Aggs
 	}
 
+	if r.Timeout > 0 {
+		root["timeout"] = formatTimeout(r.Timeout)
+	}
+
 	return json.Marshal(root)
 }
 
 // SearchResponseHits represents search response hits
 type SearchResponseHits struct {
-	Hits []map[string]interface{}
+	Total *SearchResponseHitsTotal `json:"total"`
+	Hits  []map[string]interface{}
 }
 
-type QuickwitQueryError struct {
-	Status       int              `json:"status"`
-	Message      string           `json:"message"`
-	ResponseBody io.ReadCloser    `json:"response_body"`
-	RequestBody  []*SearchRequest `json:"request_body"`
-	QueryParam   string           `json:"query_param"`
+// SearchResponseHitsTotal represents the number of documents matching a search
+type SearchResponseHitsTotal struct {
+	Value    int64  `json:"value"`
+	Relation string `json:"relation"`
+}
+
+// UnmarshalJSON decodes the total either as an object or, like older
+// Elasticsearch responses, as a plain number.
+func (t *SearchResponseHitsTotal) UnmarshalJSON(b []byte) error {
+	var value int64
+	if err := json.Unmarshal(b, &value); err == nil {
+		t.Value, t.Relation = value, "eq"
+		return nil
+	}
+	type hitsTotal SearchResponseHitsTotal
+	return json.Unmarshal(b, (*hitsTotal)(t))
+}
+
+// SearchResponseShards represents the number of splits searched, Quickwit
+// reports its splits as shards
+type SearchResponseShards struct {
+	Total      int64 `json:"total"`
+	Successful int64 `json:"successful"`
+	Skipped    int64 `json:"skipped"`
+	Failed     int64 `json:"failed"`
 }
 
 // SearchResponse represents a search response
 type SearchResponse struct {
-	Error        map[string]interface{} `json:"error"`
+	// Error is either an error object or, without detailed errors, a message
+	Error        interface{}            `json:"error"`
+	Status       int                    `json:"status"`
+	Took         *int64                 `json:"took"`
+	TimedOut     bool                   `json:"timed_out"`
+	Shards       *SearchResponseShards  `json:"_shards"`
 	Aggregations map[string]interface{} `json:"aggregations"`
 	Hits         *SearchResponseHits    `json:"hits"`
+	// Truncated is not returned by Quickwit, it flags the responses whose
+	// composite aggregation has more pages than MaxCompositePages
+	Truncated bool `json:"truncated"`
 }
 
 // Query represents a query
@@ -120,9 +155,11 @@ type RangeFilter struct {
 // RangeFilter represents a range search filter
 type DateRangeFilter struct {
 	Filter
-	Key    string
-	Gte    string
-	Lte    string
+	Key string
+	Gte string
+	Lte string
+	// Lt replaces Lte when set, to exclude the end of the range
+	Lt     string
 	Format string
 }
 
@@ -144,12 +181,17 @@ func (f *RangeFilter) MarshalJSON() ([]byte, error) {
 
 // MarshalJSON returns the JSON encoding of the query string filter.
 func (f *DateRangeFilter) MarshalJSON() ([]byte, error) {
+	bounds := map[string]interface{}{
+		"gte": f.Gte,
+	}
+	if f.Lt != "" {
+		bounds["lt"] = f.Lt
+	} else {
+		bounds["lte"] = f.Lte
+	}
 	root := map[string]map[string]map[string]interface{}{
 		"range": {
-			f.Key: {
-				"lte": f.Lte,
-				"gte": f.Gte,
-			},
+			f.Key: bounds,
 		},
 	}
 	return json.Marshal(root)
@@ -238,6 +280,10 @@ type DateHistogramAgg struct {
 	// Format         string          `json:"format"`
 	Offset   string `json:"offset,omitempty"`
 	TimeZone string `json:"time_zone,omitempty"`
+	// CalendarInterval regroups the fixed interval buckets into the calendar
+	// intervals of CalendarLocation once received, see calendarClient
+	CalendarInterval string         `json:"-"`
+	CalendarLocation *time.Location `json:"-"`
 }
 
 // FiltersAggregation represents a filters aggregation
@@ -272,6 +318,67 @@ type GeoHashGridAggregation struct {
 	Precision int    `json:"precision"`
 }
 
+// RangeAggregation represents a range aggregation
+type RangeAggregation struct {
+	Field  string      `json:"field"`
+	Ranges []*AggRange `json:"ranges"`
+	Keyed  bool        `json:"keyed,omitempty"`
+}
+
+// DateRangeAggregation represents a date range aggregation, its bounds are
+// dates or date math expressions like now-1d
+type DateRangeAggregation struct {
+	Field    string      `json:"field"`
+	Ranges   []*AggRange `json:"ranges"`
+	Keyed    bool        `json:"keyed,omitempty"`
+	Format   string      `json:"format,omitempty"`
+	TimeZone string      `json:"time_zone,omitempty"`
+}
+
+// AggRange represents a range of a range or date range aggregation, From is
+// included and To is excluded. Nil bounds are unbounded.
+type AggRange struct {
+	Key  string      `json:"key,omitempty"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// CompositeAggregation represents a composite aggregation, its buckets are the
+// combinations of the values of its sources. The buckets are returned by pages
+// of Size buckets, After is the key of the last bucket of the previous page.
+type CompositeAggregation struct {
+	Size    int
+	Sources []*CompositeSource
+	After   map[string]interface{}
+}
+
+// CompositeSource represents a terms source of a composite aggregation
+type CompositeSource struct {
+	Name  string
+	Field string
+}
+
+// MarshalJSON returns the JSON encoding of the composite aggregation
+func (a *CompositeAggregation) MarshalJSON() ([]byte, error) {
+	sources := make([]map[string]interface{}, 0, len(a.Sources))
+	for _, source := range a.Sources {
+		sources = append(sources, map[string]interface{}{
+			source.Name: map[string]interface{}{
+				"terms": map[string]interface{}{"field": source.Field},
+			},
+		})
+	}
+	root := map[string]interface{}{
+		"size":    a.Size,
+		"sources": sources,
+	}
+	if len(a.After) > 0 {
+		root["after"] = a.After
+	}
+
+	return json.Marshal(root)
+}
+
 // MetricAggregation represents a metric aggregation
 type MetricAggregation struct {
 	Type     string
@@ -0,0 +1,402 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quickwit-oss/quickwit-datasource/pkg/utils"
+)
+
+// nativeClientImpl executes the search requests on the native search endpoint
+// of Quickwit, one HTTP request per search request, and converts the responses
+// to the shape of the _msearch responses so that they are parsed the same way.
+type nativeClientImpl struct {
+	ctx   context.Context
+	ds    *DatasourceInfo
+	index string
+}
+
+// nativeSearchRequest represents the body of a native search request
+type nativeSearchRequest struct {
+	Query          string          `json:"query"`
+	StartTimestamp *int64          `json:"start_timestamp,omitempty"`
+	EndTimestamp   *int64          `json:"end_timestamp,omitempty"`
+	MaxHits        int             `json:"max_hits"`
+	SortBy         string          `json:"sort_by,omitempty"`
+	Aggs           json.RawMessage `json:"aggs,omitempty"`
+}
+
+// nativeSearchResponse represents the response of a native search request
+type nativeSearchResponse struct {
+	NumHits           int64                    `json:"num_hits"`
+	Hits              []map[string]interface{} `json:"hits"`
+	ElapsedTimeMicros int64                    `json:"elapsed_time_micros"`
+	Errors            []interface{}            `json:"errors"`
+	Aggregations      json.RawMessage          `json:"aggregations,omitempty"`
+	// NumSuccessfulSplits is only reported by recent Quickwit versions
+	NumSuccessfulSplits *int64 `json:"num_successful_splits,omitempty"`
+}
+
+func (c *nativeClientImpl) ExecuteMultisearch(requests []*SearchRequest) (*MultiSearchResponse, error) {
+	groups := searchGroups(c.ds.ConfiguredFields, c.index)
+
+	responses := make([]*json.RawMessage, len(requests)*len(groups))
+	stats := make([]MultiSearchStats, len(responses))
+	executedRequests := make([]string, len(responses))
+	errs := make([]error, len(responses))
+	// Bound the number of searches running at once, the time range of a query
+	// may be split into many searches
+	maxConcurrentSearches := int(c.ds.MaxConcurrentShardRequests)
+	if maxConcurrentSearches <= 0 {
+		maxConcurrentSearches = 5
+	}
+	searches := make(chan int)
+	var wg sync.WaitGroup
+	for range min(maxConcurrentSearches, len(responses)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range searches {
+				r, group := requests[idx/len(groups)], groups[idx%len(groups)]
+				responses[idx], errs[idx] = c.search(r, group, &stats[idx], &executedRequests[idx])
+			}
+		}()
+	}
+	for idx := range responses {
+		searches <- idx
+	}
+	close(searches)
+	wg.Wait()
+
+	// A failed search only fails its own query, unless the whole multisearch
+	// was cancelled
+	if err := c.ctx.Err(); err != nil {
+		return nil, &TransportError{Err: err}
+	}
+	for idx, err := range errs {
+		if err == nil {
+			continue
+		}
+		logger.Error("Failed native search", "index", strings.Join(groups[idx%len(groups)].Indexes, ","), "err", err)
+		res, err := searchErrorResponse(err)
+		if err != nil {
+			return nil, err
+		}
+		responses[idx] = res
+	}
+
+	// The searches run concurrently, the slowest one gives the time of the whole
+	// and the most retried one its number of attempts
+	msr := &MultiSearchResponse{Responses: responses}
+	for _, s := range stats {
+		msr.Stats.RoundTrip = max(msr.Stats.RoundTrip, s.RoundTrip)
+		msr.Stats.Decode = max(msr.Stats.Decode, s.Decode)
+		msr.Stats.Attempts = max(msr.Stats.Attempts, s.Attempts)
+	}
+	for i := range requests {
+		msr.ExecutedRequests = append(msr.ExecutedRequests, strings.Join(executedRequests[i*len(groups):(i+1)*len(groups)], ""))
+	}
+
+	if len(groups) > 1 {
+		merged, err := mergeTimestampGroupResponses(requests, c.ds.ConfiguredFields, responses)
+		if err != nil {
+			return nil, err
+		}
+		msr.Responses = merged
+	}
+	return msr, nil
+}
+
+// search executes a search request on an index group. Quickwit errors are
+// returned as error responses so that they only fail their own query.
+func (c *nativeClientImpl) search(r *SearchRequest, group TimestampGroup, stats *MultiSearchStats, executedRequest *string) (*json.RawMessage, error) {
+	nativeRequest, err := newNativeSearchRequest(r, group.Field)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(nativeRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	uriPath := strings.Join(group.Indexes, ",") + "/search"
+	*executedRequest = fmt.Sprintf("POST %s\n%s\n", uriPath, body)
+	// The native search endpoint has no timeout parameter, the timeout of the
+	// search cancels the HTTP request
+	ctx, cancel := withSearchTimeout(c.ctx, []*SearchRequest{r})
+	defer cancel()
+	req, err := newRequest(ctx, c.ds, http.MethodPost, uriPath, "", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	res, attempts, err := doSearch(c.ds, req)
+	if err != nil {
+		if isSearchTimeout(c.ctx, ctx, err) {
+			return timedOutResponse()
+		}
+		return nil, &TransportError{Err: err}
+	}
+	stats.RoundTrip = time.Since(start)
+	stats.Attempts = attempts
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body", "err", err)
+		}
+	}()
+
+	logger.Debug("Received native search response", "code", res.StatusCode, "status", res.Status, "content-length", res.ContentLength)
+
+	if res.StatusCode >= 400 {
+		resBody, err := io.ReadAll(res.Body)
+		if err != nil {
+			return nil, &TransportError{Err: err}
+		}
+		qe := NewQuickwitError(res.StatusCode, resBody, strings.Join(group.Indexes, ","), []*SearchRequest{r})
+		logger.Error("Error on native search", "status", qe.StatusCode, "index", qe.Index, "message", qe.Message)
+		return marshalRawResponse(map[string]interface{}{
+			"error":  map[string]interface{}{"reason": qe.Message},
+			"status": qe.StatusCode,
+		})
+	}
+
+	start = time.Now()
+	var nativeResponse nativeSearchResponse
+	dec := json.NewDecoder(res.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&nativeResponse); err != nil {
+		if isSearchTimeout(c.ctx, ctx, err) {
+			return timedOutResponse()
+		}
+		return nil, &DecodeError{Err: err}
+	}
+	stats.Decode = time.Since(start)
+
+	return marshalRawResponse(nativeResponse.toSearchResponse(r, group.TimestampInfo))
+}
+
+// searchErrorResponse returns the error response of a search which could not
+// be sent or whose response could not be read
+func searchErrorResponse(err error) (*json.RawMessage, error) {
+	status := http.StatusInternalServerError
+	var te *TransportError
+	if errors.As(err, &te) {
+		status = http.StatusBadGateway
+	} else if errors.Is(err, errNativeSearchAfter) {
+		status = http.StatusBadRequest
+	}
+	return marshalRawResponse(map[string]interface{}{
+		"error":  map[string]interface{}{"reason": err.Error()},
+		"status": status,
+	})
+}
+
+// docSortField is the sort on the order of the documents in the index, which
+// the native API does not support
+const docSortField = "_doc"
+
+// errNativeSearchAfter is returned for the searches paging with search_after,
+// such as the live tail and the log context, which need the _msearch API
+var errNativeSearchAfter = errors.New("paging with a search_after cursor is not supported by the native search API, disable the native search API in the datasource settings")
+
+// newNativeSearchRequest translates a search request to a native search
+// request. The time range filter becomes the start and end timestamps used
+// by Quickwit to prune splits, and is kept in the query to preserve the
+// millisecond precision.
+func newNativeSearchRequest(r *SearchRequest, timeField string) (*nativeSearchRequest, error) {
+	nativeRequest := &nativeSearchRequest{MaxHits: r.Size}
+
+	var clauses []string
+	if r.Query != nil && r.Query.Bool != nil {
+		for _, filter := range r.Query.Bool.Filters {
+			switch f := filter.(type) {
+			case *QueryStringFilter:
+				if query := strings.TrimSpace(f.Query); query != "" && query != "*" {
+					clauses = append(clauses, "("+query+")")
+				}
+			case *DateRangeFilter:
+				gte, err := time.Parse(time.RFC3339Nano, f.Gte)
+				if err != nil {
+					return nil, err
+				}
+				// The end timestamp is exclusive
+				start := gte.Unix()
+				var end int64
+				if f.Lt != "" {
+					lt, err := time.Parse(time.RFC3339Nano, f.Lt)
+					if err != nil {
+						return nil, err
+					}
+					end = lt.Unix()
+					if lt.Nanosecond() > 0 {
+						end++
+					}
+					clauses = append(clauses, fmt.Sprintf("%s:[%s TO %s}", f.Key, f.Gte, f.Lt))
+				} else {
+					lte, err := time.Parse(time.RFC3339Nano, f.Lte)
+					if err != nil {
+						return nil, err
+					}
+					end = lte.Unix() + 1
+					clauses = append(clauses, fmt.Sprintf("%s:[%s TO %s]", f.Key, f.Gte, f.Lte))
+				}
+				nativeRequest.StartTimestamp = &start
+				nativeRequest.EndTimestamp = &end
+			case *RangeFilter:
+				clauses = append(clauses, fmt.Sprintf("%s:[%d TO %d]", f.Key, f.Gte, f.Lte))
+			}
+		}
+	}
+
+	// search_after is not supported by the native API, and a range on the sort
+	// value would either drop or repeat the documents sharing the cursor
+	if searchAfter, ok := r.CustomProps["search_after"].([]any); ok && len(searchAfter) > 0 {
+		return nil, errNativeSearchAfter
+	}
+
+	if len(clauses) == 0 {
+		nativeRequest.Query = "*"
+	} else {
+		nativeRequest.Query = strings.Join(clauses, " AND ")
+	}
+
+	var sortBy []string
+	for _, sortField := range r.Sort {
+		for field, options := range sortField {
+			// The native API sorts by fields only
+			if field == docSortField {
+				continue
+			}
+			if options["order"] == string(SortOrderAsc) {
+				sortBy = append(sortBy, "+"+field)
+			} else {
+				sortBy = append(sortBy, "-"+field)
+			}
+		}
+	}
+	nativeRequest.SortBy = strings.Join(sortBy, ",")
+
+	if len(r.Aggs) > 0 {
+		aggs, err := json.Marshal(r.Aggs)
+		if err != nil {
+			return nil, err
+		}
+		nativeRequest.Aggs = json.RawMessage(aggs)
+	}
+
+	// The request is interpolated as a whole, the time field variable may be
+	// used in the query, the sort and the aggregations
+	body, err := json.Marshal(nativeRequest)
+	if err != nil {
+		return nil, err
+	}
+	interpolated := &nativeSearchRequest{}
+	if err := json.Unmarshal([]byte(interpolateSearchBody(string(body), r, timeField)), interpolated); err != nil {
+		return nil, err
+	}
+	return interpolated, nil
+}
+
+// toSearchResponse converts a native search response to the shape of a
+// _msearch response. Native hits have no sort values, they are computed from
+// the documents for the sort fields of the request.
+func (res *nativeSearchResponse) toSearchResponse(r *SearchRequest, timestamp TimestampInfo) map[string]interface{} {
+	hits := make([]interface{}, 0, len(res.Hits))
+	for _, doc := range res.Hits {
+		hit := map[string]interface{}{"_source": doc}
+		if len(r.Sort) > 0 {
+			sortValues := make([]interface{}, 0, len(r.Sort))
+			for _, sortField := range r.Sort {
+				for field := range sortField {
+					if field == docSortField {
+						continue
+					}
+					sortValues = append(sortValues, nativeSortValue(doc, field, timestamp))
+				}
+			}
+			hit["sort"] = sortValues
+		}
+		hits = append(hits, hit)
+	}
+
+	searchResponse := map[string]interface{}{
+		"took": res.ElapsedTimeMicros / 1000,
+		"hits": map[string]interface{}{
+			"total": map[string]interface{}{"value": res.NumHits, "relation": "eq"},
+			"hits":  hits,
+		},
+		"status": http.StatusOK,
+	}
+	if len(res.Aggregations) > 0 {
+		searchResponse["aggregations"] = res.Aggregations
+	}
+	// Each error of a native search reports a failed split
+	if res.NumSuccessfulSplits != nil {
+		failed := int64(len(res.Errors))
+		searchResponse["_shards"] = map[string]interface{}{
+			"total":      *res.NumSuccessfulSplits + failed,
+			"successful": *res.NumSuccessfulSplits,
+			"skipped":    0,
+			"failed":     failed,
+		}
+	}
+	if len(res.Errors) > 0 {
+		logger.Warn("Native search returned partial errors", "errors", res.Errors)
+	}
+	return searchResponse
+}
+
+// nativeSortValue returns the sort value of a document, timestamps are
+// returned as nanoseconds like with the epoch_nanos_int sort format
+func nativeSortValue(doc map[string]interface{}, field string, timestamp TimestampInfo) interface{} {
+	if field == TimeFieldVariable {
+		field = timestamp.Field
+	}
+	value := lookupSourceValue(doc, field)
+	if field != timestamp.Field || value == nil {
+		return value
+	}
+	t, err := utils.ParseTime(value, timestamp.OutputFormat)
+	if err != nil {
+		return value
+	}
+	return json.Number(strconv.FormatInt(t.UnixNano(), 10))
+}
+
+// lookupSourceValue returns the value at the given path, which may point to a
+// nested object field
+func lookupSourceValue(source map[string]interface{}, path string) interface{} {
+	if value, ok := source[path]; ok {
+		return value
+	}
+	for i := range path {
+		if path[i] != '.' {
+			continue
+		}
+		if child, ok := source[path[:i]].(map[string]interface{}); ok {
+			if value := lookupSourceValue(child, path[i+1:]); value != nil {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+func marshalRawResponse(res map[string]interface{}) (*json.RawMessage, error) {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	message := json.RawMessage(body)
+	return &message, nil
+}
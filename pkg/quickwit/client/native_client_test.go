@@ -0,0 +1,238 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
+)
+
+func TestNativeClient_ExecuteMultisearch(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]*simplejson.Json{}
+	var inFlight, maxInFlight atomic.Int64
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		buf, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		body, err := simplejson.NewJson(buf)
+		require.NoError(t, err)
+		mu.Lock()
+		requests[r.URL.Path] = body
+		mu.Unlock()
+
+		rw.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/v1/slow/search" {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if r.URL.Path == "/api/v1/truncated/search" {
+			_, err = rw.Write([]byte(`{"num_hits": 1, "hits": [`))
+			require.NoError(t, err)
+			return
+		}
+		if r.URL.Path == "/api/v1/broken/search" {
+			rw.WriteHeader(400)
+			_, err = rw.Write([]byte(`{"message": "failed to parse query"}`))
+			require.NoError(t, err)
+			return
+		}
+		_, err = rw.Write([]byte(`{
+			"num_hits": 12,
+			"hits": [
+				{ "timestamp": "2024-01-01T00:00:02Z", "line": "b" },
+				{ "timestamp": "2024-01-01T00:00:01Z", "line": "a" }
+			],
+			"elapsed_time_micros": 4200,
+			"errors": [],
+			"num_successful_splits": 3,
+			"aggregations": { "2": { "buckets": [{ "key": 1000, "doc_count": 12 }] } }
+		}`))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(ts.Close)
+
+	newSearch := func() []*SearchRequest {
+		msb := NewMultiSearchRequestBuilder()
+		s := msb.Search(15 * time.Second)
+		s.Size(2)
+		s.Sort(SortOrderDesc, "timestamp", "epoch_nanos_int")
+		filters := s.Query().Bool().Filter()
+		filters.AddDateRangeFilter("timestamp", 1704067205500, 1704067200000)
+		filters.AddQueryStringFilter("level:error", true, "AND")
+		s.Agg().DateHistogram("2", "timestamp", func(a *DateHistogramAgg, ab AggBuilder) {
+			a.FixedInterval = "$__interval_msms"
+		})
+		ms, err := msb.Build()
+		require.NoError(t, err)
+		return ms
+	}
+
+	newClient := func(index string) Client {
+		c, err := NewClient(context.Background(), &DatasourceInfo{
+			URL:        ts.URL + "/api/v1",
+			HTTPClient: ts.Client(),
+			Database:   index,
+			SearchAPI:  SearchAPINative,
+			ConfiguredFields: ConfiguredFields{
+				TimeField:        "timestamp",
+				TimeOutputFormat: "rfc3339",
+			},
+		})
+		require.NoError(t, err)
+		return c
+	}
+
+	t.Run("Translates the search request", func(t *testing.T) {
+		res, err := newClient("logs").ExecuteMultisearch(newSearch())
+		require.NoError(t, err)
+
+		body := requests["/api/v1/logs/search"]
+		require.NotNil(t, body)
+		assert.Equal(t, "timestamp:[2024-01-01T00:00:00Z TO 2024-01-01T00:00:05.5Z] AND (level:error)", body.Get("query").MustString())
+		assert.Equal(t, int64(1704067200), body.Get("start_timestamp").MustInt64())
+		assert.Equal(t, int64(1704067206), body.Get("end_timestamp").MustInt64())
+		assert.Equal(t, 2, body.Get("max_hits").MustInt())
+		assert.Equal(t, "-timestamp", body.Get("sort_by").MustString())
+		assert.Equal(t, "15000ms", body.GetPath("aggs", "2", "date_histogram", "fixed_interval").MustString())
+
+		require.Len(t, res.Responses, 1)
+		jRes, err := simplejson.NewJson(*res.Responses[0])
+		require.NoError(t, err)
+		assert.Equal(t, 12, jRes.GetPath("hits", "total", "value").MustInt())
+		assert.Equal(t, 4, jRes.Get("took").MustInt())
+		assert.Equal(t, 3, jRes.GetPath("_shards", "total").MustInt())
+		assert.Positive(t, res.Stats.RoundTrip)
+		require.Len(t, res.ExecutedRequests, 1)
+		assert.True(t, strings.HasPrefix(res.ExecutedRequests[0], "POST logs/search\n{"))
+		hit := jRes.GetPath("hits", "hits").GetIndex(0)
+		assert.Equal(t, "b", hit.GetPath("_source", "line").MustString())
+		assert.Equal(t, int64(1704067202000000000), hit.Get("sort").GetIndex(0).MustInt64())
+		assert.Equal(t, 12, jRes.GetPath("aggregations", "2", "buckets").GetIndex(0).Get("doc_count").MustInt())
+	})
+
+	t.Run("Returns Quickwit errors as error responses", func(t *testing.T) {
+		res, err := newClient("broken").ExecuteMultisearch(newSearch())
+		require.NoError(t, err)
+		require.Len(t, res.Responses, 1)
+
+		var item SearchResponse
+		require.NoError(t, json.Unmarshal(*res.Responses[0], &item))
+		assert.Equal(t, 400, item.Status)
+		assert.Equal(t, map[string]interface{}{"reason": "failed to parse query"}, item.Error)
+	})
+
+	t.Run("Returns the searches failing to decode as error responses", func(t *testing.T) {
+		c := newClient("logs").(*calendarClient).client.(*nativeClientImpl)
+		c.ds.ConfiguredFields = ConfiguredFields{}.WithIndexTimestampInfos(map[string]TimestampInfo{
+			"logs":      {Field: "timestamp", OutputFormat: "rfc3339"},
+			"truncated": {Field: "ts", OutputFormat: "rfc3339"},
+		})
+		res, err := c.ExecuteMultisearch(append(newSearch(), newSearch()...))
+		require.NoError(t, err)
+		require.Len(t, res.Responses, 2)
+
+		for _, raw := range res.Responses {
+			var item SearchResponse
+			require.NoError(t, json.Unmarshal(*raw, &item))
+			assert.Equal(t, 500, item.Status)
+			assert.Contains(t, item.Error.(map[string]interface{})["reason"], "failed to decode")
+		}
+	})
+
+	t.Run("Runs a bounded number of searches at once", func(t *testing.T) {
+		c := newClient("slow").(*calendarClient).client.(*nativeClientImpl)
+		c.ds.MaxConcurrentShardRequests = 2
+		searches := []*SearchRequest{}
+		for range 10 {
+			searches = append(searches, newSearch()...)
+		}
+		res, err := c.ExecuteMultisearch(searches)
+		require.NoError(t, err)
+		require.Len(t, res.Responses, 10)
+		assert.Equal(t, int64(2), maxInFlight.Load())
+	})
+}
+
+func TestNewNativeSearchRequest(t *testing.T) {
+	t.Run("Rejects search after", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		s := msb.Search(0)
+		s.Sort(SortOrderAsc, TimeFieldVariable, "epoch_nanos_int")
+		s.AddSearchAfter(int64(1704067200000000001))
+		ms, err := msb.Build()
+		require.NoError(t, err)
+
+		_, err = newNativeSearchRequest(ms[0], "ts")
+		require.ErrorIs(t, err, errNativeSearchAfter)
+
+		res, err := searchErrorResponse(err)
+		require.NoError(t, err)
+		var item SearchResponse
+		require.NoError(t, json.Unmarshal(*res, &item))
+		assert.Equal(t, 400, item.Status)
+	})
+
+	t.Run("Sorts by fields only", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		s := msb.Search(0)
+		s.Sort(SortOrderDesc, TimeFieldVariable, "epoch_nanos_int")
+		s.Sort(SortOrderDesc, "_doc", "")
+		ms, err := msb.Build()
+		require.NoError(t, err)
+
+		nativeRequest, err := newNativeSearchRequest(ms[0], "ts")
+		require.NoError(t, err)
+		assert.Equal(t, "-ts", nativeRequest.SortBy)
+
+		res := (&nativeSearchResponse{Hits: []map[string]interface{}{{"ts": "2024-01-01T00:00:00Z"}}}).toSearchResponse(ms[0], TimestampInfo{Field: "ts", OutputFormat: "iso8601"})
+		hits := res["hits"].(map[string]interface{})["hits"].([]interface{})
+		assert.Len(t, hits[0].(map[string]interface{})["sort"], 1)
+	})
+
+	t.Run("Excludes the end of half-open time ranges", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		msb.Search(0).Query().Bool().Filter().AddHalfOpenDateRangeFilter("timestamp", 1704067205000, 1704067200000)
+		ms, err := msb.Build()
+		require.NoError(t, err)
+
+		body, err := json.Marshal(ms[0].Query.Bool.Filters[0])
+		require.NoError(t, err)
+		assert.JSONEq(t, `{ "range": { "timestamp": { "gte": "2024-01-01T00:00:00Z", "lt": "2024-01-01T00:00:05Z" } } }`, string(body))
+
+		nativeRequest, err := newNativeSearchRequest(ms[0], "ts")
+		require.NoError(t, err)
+		assert.Equal(t, "timestamp:[2024-01-01T00:00:00Z TO 2024-01-01T00:00:05Z}", nativeRequest.Query)
+		assert.Equal(t, int64(1704067205), *nativeRequest.EndTimestamp)
+	})
+
+	t.Run("Matches all documents without filters", func(t *testing.T) {
+		msb := NewMultiSearchRequestBuilder()
+		msb.Search(0).Query().Bool().Filter().AddQueryStringFilter("*", true, "AND")
+		ms, err := msb.Build()
+		require.NoError(t, err)
+
+		nativeRequest, err := newNativeSearchRequest(ms[0], "ts")
+		require.NoError(t, err)
+		assert.Equal(t, "*", nativeRequest.Query)
+		assert.Empty(t, nativeRequest.Aggs)
+	})
+}
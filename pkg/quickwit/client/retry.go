@@ -0,0 +1,154 @@
+package es
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultRetryInitialBackoff is the maximum wait before the first retry
+	DefaultRetryInitialBackoff = 100 * time.Millisecond
+	// DefaultRetryMaxBackoff is the maximum wait between two attempts
+	DefaultRetryMaxBackoff = 5 * time.Second
+)
+
+// DefaultRetryStatusCodes are the Quickwit status codes retried when the
+// datasource does not configure them, the searchers are overloaded or restarting
+var DefaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy tells how the search calls failing with a transient error are
+// sent again. Searches do not change the indexes, they are safe to retry.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a search call is sent, including the first one
+	MaxAttempts int
+	// InitialBackoff is the maximum wait before the first retry, it doubles
+	// with each retry up to MaxBackoff. The wait is picked at random below it.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// StatusCodes are the retried status codes, the connection failures are
+	// always retried
+	StatusCodes []int
+}
+
+// NewRetryPolicy creates a retry policy, it returns nil when maxAttempts is
+// below 2, which disables the retries
+func NewRetryPolicy(maxAttempts int, initialBackoff, maxBackoff time.Duration, statusCodes []int) *RetryPolicy {
+	if maxAttempts < 2 {
+		return nil
+	}
+	if initialBackoff <= 0 {
+		initialBackoff = DefaultRetryInitialBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultRetryMaxBackoff
+	}
+	if statusCodes == nil {
+		statusCodes = DefaultRetryStatusCodes
+	}
+	return &RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     max(maxBackoff, initialBackoff),
+		StatusCodes:    statusCodes,
+	}
+}
+
+// backoff returns the wait before the retry following the given attempt: the
+// delay asked by Quickwit with Retry-After, or an exponential backoff with
+// jitter. It returns false when Quickwit asks to wait longer than MaxBackoff.
+func (p *RetryPolicy) backoff(attempt int, res *http.Response) (time.Duration, bool) {
+	if res != nil {
+		if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+			return retryAfter, retryAfter <= p.MaxBackoff
+		}
+	}
+	backoff := p.InitialBackoff << (attempt - 1)
+	if backoff <= 0 || backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1)), true
+}
+
+// isRetryable returns true when the failure of a search call is transient
+func (p *RetryPolicy) isRetryable(ctx context.Context, res *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return slices.Contains(p.StatusCodes, res.StatusCode)
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// doSearch sends a search call, and sends it again following the retry policy
+// of the datasource while it fails with a transient error. The retries stop
+// when the wait would go past the deadline of the request context. It returns
+// the number of attempts.
+func doSearch(ds *DatasourceInfo, req *http.Request) (*http.Response, int, error) {
+	ctx := req.Context()
+	policy := ds.RetryPolicy
+	for attempt := 1; ; attempt++ {
+		res, err := ds.HTTPClient.Do(req)
+		if policy == nil || attempt >= policy.MaxAttempts || !policy.isRetryable(ctx, res, err) {
+			return res, attempt, err
+		}
+		wait, ok := policy.backoff(attempt, res)
+		if deadline, hasDeadline := ctx.Deadline(); !ok || (hasDeadline && time.Until(deadline) <= wait) {
+			return res, attempt, err
+		}
+		if req.GetBody == nil && req.Body != nil {
+			return res, attempt, err
+		}
+
+		if err != nil {
+			logger.Warn("Retrying search call", "attempt", attempt, "wait", wait, "err", err)
+		} else {
+			logger.Warn("Retrying search call", "attempt", attempt, "wait", wait, "status", res.StatusCode)
+			// Drain the body so that the connection is reused
+			_, _ = io.Copy(io.Discard, res.Body)
+			if err := res.Body.Close(); err != nil {
+				logger.Warn("Failed to close response body", "err", err)
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, attempt, ctx.Err()
+		}
+
+		retry := req.Clone(ctx)
+		if req.GetBody != nil {
+			if retry.Body, err = req.GetBody(); err != nil {
+				return nil, attempt, err
+			}
+		}
+		req = retry
+	}
+}
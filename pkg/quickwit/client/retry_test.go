@@ -0,0 +1,124 @@
+package es
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	t.Run("Less than 2 attempts disables the retries", func(t *testing.T) {
+		assert.Nil(t, NewRetryPolicy(1, 0, 0, nil))
+	})
+
+	t.Run("The backoff grows exponentially up to the maximum", func(t *testing.T) {
+		p := NewRetryPolicy(5, 10*time.Millisecond, 30*time.Millisecond, nil)
+		for attempt, limit := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 30 * time.Millisecond, 10: 30 * time.Millisecond} {
+			wait, ok := p.backoff(attempt, nil)
+			assert.True(t, ok)
+			assert.LessOrEqual(t, wait, limit)
+		}
+	})
+
+	t.Run("Retry-After is honoured", func(t *testing.T) {
+		p := NewRetryPolicy(3, 10*time.Millisecond, 5*time.Second, nil)
+		res := &http.Response{Header: http.Header{"Retry-After": []string{"2"}}}
+		wait, ok := p.backoff(1, res)
+		assert.True(t, ok)
+		assert.Equal(t, 2*time.Second, wait)
+
+		res.Header.Set("Retry-After", "60")
+		_, ok = p.backoff(1, res)
+		assert.False(t, ok, "waits longer than the maximum backoff are not retried")
+	})
+}
+
+func TestExecuteMultisearchRetries(t *testing.T) {
+	var calls atomic.Int64
+	var statuses []int
+	retryAfter := "0"
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1)) - 1
+		status := http.StatusOK
+		if call < len(statuses) {
+			status = statuses[call]
+		}
+		switch status {
+		case 0:
+			// Reset the connection
+			conn, _, err := rw.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+			return
+		case http.StatusOK:
+			rw.Header().Set("Content-Type", "application/json")
+			_, err := rw.Write([]byte(`{ "responses": [{ "hits": { "hits": [] }, "status": 200 }] }`))
+			require.NoError(t, err)
+		default:
+			rw.Header().Set("Retry-After", retryAfter)
+			rw.WriteHeader(status)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	newRetryClient := func(ctx context.Context, maxBackoff time.Duration) Client {
+		c, err := NewClient(ctx, &DatasourceInfo{
+			URL:              ts.URL,
+			HTTPClient:       ts.Client(),
+			Database:         "logs",
+			ConfiguredFields: ConfiguredFields{TimeField: "timestamp"},
+			RetryPolicy:      NewRetryPolicy(3, time.Millisecond, maxBackoff, nil),
+		})
+		require.NoError(t, err)
+		return c
+	}
+	search := []*SearchRequest{createSearchForTest(t, "service:api")}
+
+	t.Run("Transient failures are retried", func(t *testing.T) {
+		calls.Store(0)
+		statuses = []int{0, http.StatusServiceUnavailable}
+		res, err := newRetryClient(context.Background(), 10*time.Millisecond).ExecuteMultisearch(search)
+		require.NoError(t, err)
+		assert.Equal(t, 3, res.Stats.Attempts)
+		assert.Equal(t, int64(3), calls.Load())
+	})
+
+	t.Run("Gives up after the maximum number of attempts", func(t *testing.T) {
+		calls.Store(0)
+		statuses = []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests}
+		_, err := newRetryClient(context.Background(), 10*time.Millisecond).ExecuteMultisearch(search)
+		var qe *QuickwitError
+		require.ErrorAs(t, err, &qe)
+		assert.Equal(t, http.StatusTooManyRequests, qe.StatusCode)
+		assert.Equal(t, int64(3), calls.Load())
+	})
+
+	t.Run("Other status codes are not retried", func(t *testing.T) {
+		calls.Store(0)
+		statuses = []int{http.StatusBadRequest}
+		_, err := newRetryClient(context.Background(), 10*time.Millisecond).ExecuteMultisearch(search)
+		require.Error(t, err)
+		assert.Equal(t, int64(1), calls.Load())
+	})
+
+	t.Run("Retries stop at the context deadline", func(t *testing.T) {
+		calls.Store(0)
+		statuses = []int{http.StatusServiceUnavailable}
+		retryAfter = "1"
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := newRetryClient(ctx, 5*time.Second).ExecuteMultisearch(search)
+		var qe *QuickwitError
+		require.ErrorAs(t, err, &qe)
+		assert.Equal(t, http.StatusServiceUnavailable, qe.StatusCode)
+		assert.Equal(t, int64(1), calls.Load())
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
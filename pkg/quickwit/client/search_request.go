@@ -21,6 +21,8 @@ type SearchRequestBuilder struct {
 	queryBuilder *QueryBuilder
 	aggBuilders  []AggBuilder
 	customProps  map[string]interface{}
+	noCache      bool
+	timeout      time.Duration
 }
 
 // NewSearchRequestBuilder create a new search request builder
@@ -42,6 +44,8 @@ func (b *SearchRequestBuilder) Build() (*SearchRequest, error) {
 		Size:        b.size,
 		Sort:        b.sort,
 		CustomProps: b.customProps,
+		NoCache:     b.noCache,
+		Timeout:     b.timeout,
 	}
 
 	if b.queryBuilder != nil {
@@ -67,6 +71,18 @@ func (b *SearchRequestBuilder) Build() (*SearchRequest, error) {
 	return &sr, nil
 }
 
+// DisableCache bypasses the response cache for the search request
+func (b *SearchRequestBuilder) DisableCache() *SearchRequestBuilder {
+	b.noCache = true
+	return b
+}
+
+// Timeout sets the time Quickwit searches before returning partial results
+func (b *SearchRequestBuilder) Timeout(timeout time.Duration) *SearchRequestBuilder {
+	b.timeout = timeout
+	return b
+}
+
 // Size sets the size of the search request
 func (b *SearchRequestBuilder) Size(size int) *SearchRequestBuilder {
 	b.size = size
@@ -254,6 +270,19 @@ func (b *FilterQueryBuilder) AddDateRangeFilter(timeField string, lteMillisecs i
 	return b
 }
 
+// AddHalfOpenDateRangeFilter adds a new time range filter excluding the end of
+// the range, so that contiguous ranges do not share their boundary
+func (b *FilterQueryBuilder) AddHalfOpenDateRangeFilter(timeField string, ltMillisecs int64, gteMillisecs int64) *FilterQueryBuilder {
+	ltTime := time.Unix(0, ltMillisecs*int64(time.Millisecond)).UTC()
+	gteTime := time.Unix(0, gteMillisecs*int64(time.Millisecond)).UTC()
+	b.filters = append(b.filters, &DateRangeFilter{
+		Key: timeField,
+		Lt:  ltTime.Format(time.RFC3339Nano),
+		Gte: gteTime.Format(time.RFC3339Nano),
+	})
+	return b
+}
+
 // AddQueryStringFilter adds a new query string filter
 func (b *FilterQueryBuilder) AddQueryStringFilter(querystring string, analyseWildcard bool, defaultOperator string) *FilterQueryBuilder {
 	if len(strings.TrimSpace(querystring)) == 0 {
@@ -276,6 +305,9 @@ type AggBuilder interface {
 	Nested(key, path string, fn func(a *NestedAggregation, b AggBuilder)) AggBuilder
 	Filters(key string, fn func(a *FiltersAggregation, b AggBuilder)) AggBuilder
 	GeoHashGrid(key, field string, fn func(a *GeoHashGridAggregation, b AggBuilder)) AggBuilder
+	Range(key, field string, fn func(a *RangeAggregation, b AggBuilder)) AggBuilder
+	DateRange(key, field string, fn func(a *DateRangeAggregation, b AggBuilder)) AggBuilder
+	Composite(key string, fn func(a *CompositeAggregation, b AggBuilder)) AggBuilder
 	Metric(key, metricType, field string, fn func(a *MetricAggregation)) AggBuilder
 	Pipeline(key, pipelineType string, bucketPath interface{}, fn func(a *PipelineAggregation)) AggBuilder
 	Build() (AggArray, error)
@@ -446,6 +478,68 @@ func (b *aggBuilderImpl) GeoHashGrid(key, field string, fn func(a *GeoHashGridAg
 	return b
 }
 
+func (b *aggBuilderImpl) Range(key, field string, fn func(a *RangeAggregation, b AggBuilder)) AggBuilder {
+	innerAgg := &RangeAggregation{
+		Field:  field,
+		Ranges: make([]*AggRange, 0),
+	}
+	aggDef := newAggDef(key, &aggContainer{
+		Type:        "range",
+		Aggregation: innerAgg,
+	})
+
+	if fn != nil {
+		builder := newAggBuilder()
+		aggDef.builders = append(aggDef.builders, builder)
+		fn(innerAgg, builder)
+	}
+
+	b.aggDefs = append(b.aggDefs, aggDef)
+
+	return b
+}
+
+func (b *aggBuilderImpl) DateRange(key, field string, fn func(a *DateRangeAggregation, b AggBuilder)) AggBuilder {
+	innerAgg := &DateRangeAggregation{
+		Field:  field,
+		Ranges: make([]*AggRange, 0),
+	}
+	aggDef := newAggDef(key, &aggContainer{
+		Type:        "date_range",
+		Aggregation: innerAgg,
+	})
+
+	if fn != nil {
+		builder := newAggBuilder()
+		aggDef.builders = append(aggDef.builders, builder)
+		fn(innerAgg, builder)
+	}
+
+	b.aggDefs = append(b.aggDefs, aggDef)
+
+	return b
+}
+
+func (b *aggBuilderImpl) Composite(key string, fn func(a *CompositeAggregation, b AggBuilder)) AggBuilder {
+	innerAgg := &CompositeAggregation{
+		Sources: make([]*CompositeSource, 0),
+	}
+	aggDef := newAggDef(key, &aggContainer{
+		Type:        "composite",
+		Aggregation: innerAgg,
+	})
+
+	if fn != nil {
+		builder := newAggBuilder()
+		aggDef.builders = append(aggDef.builders, builder)
+		fn(innerAgg, builder)
+	}
+
+	b.aggDefs = append(b.aggDefs, aggDef)
+
+	return b
+}
+
 func (b *aggBuilderImpl) Metric(key, metricType, field string, fn func(a *MetricAggregation)) AggBuilder {
 	innerAgg := &MetricAggregation{
 		Type:     metricType,
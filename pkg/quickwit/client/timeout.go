@@ -0,0 +1,64 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// searchTimeoutGrace is the time left to Quickwit to answer with the partial
+// results of a timed out search, before the HTTP request is cancelled
+var searchTimeoutGrace = 2 * time.Second
+
+// formatTimeout formats a search timeout with the time units of Quickwit
+func formatTimeout(timeout time.Duration) string {
+	return strconv.FormatInt(timeout.Milliseconds(), 10) + "ms"
+}
+
+// withSearchTimeout returns the context of the HTTP request sending the search
+// requests, cancelled a little after the longest timeout of the requests
+func withSearchTimeout(ctx context.Context, requests []*SearchRequest) (context.Context, context.CancelFunc) {
+	var timeout time.Duration
+	for _, r := range requests {
+		timeout = max(timeout, r.Timeout)
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout+searchTimeoutGrace)
+}
+
+// isSearchTimeout returns true when err comes from the timeout of the search
+// context, and not from the cancellation of the parent context
+func isSearchTimeout(parent context.Context, ctx context.Context, err error) bool {
+	return parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && errors.Is(err, context.DeadlineExceeded)
+}
+
+// timedOutResponse returns the response of a search cancelled by its timeout,
+// a timed out response without results
+func timedOutResponse() (*json.RawMessage, error) {
+	return marshalRawResponse(map[string]interface{}{
+		"timed_out": true,
+		"hits": map[string]interface{}{
+			"total": map[string]interface{}{"value": 0, "relation": "eq"},
+			"hits":  []interface{}{},
+		},
+	})
+}
+
+// timedOutMultiSearchResponse returns the response of a multisearch cancelled
+// by the timeout of its searches
+func timedOutMultiSearchResponse(requests []*SearchRequest, executedRequests []string, stats MultiSearchStats) (*MultiSearchResponse, error) {
+	logger.Warn("Multisearch cancelled by the search timeout", "requests", len(requests))
+	msr := &MultiSearchResponse{Stats: stats, ExecutedRequests: executedRequests}
+	for range requests {
+		res, err := timedOutResponse()
+		if err != nil {
+			return nil, err
+		}
+		msr.Responses = append(msr.Responses, res)
+	}
+	return msr, nil
+}
@@ -0,0 +1,86 @@
+package es
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchTimeout(t *testing.T) {
+	grace := searchTimeoutGrace
+	searchTimeoutGrace = 10 * time.Millisecond
+	t.Cleanup(func() { searchTimeoutGrace = grace })
+
+	var mu sync.Mutex
+	var body string
+	delay := time.Duration(0)
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		mu.Lock()
+		body = string(b)
+		delay := delay
+		mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, err = rw.Write([]byte(`{ "responses": [{ "hits": { "hits": [] }, "status": 200 }] }`))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(ts.Close)
+
+	newTimeoutSearch := func(timeout time.Duration) []*SearchRequest {
+		b := NewSearchRequestBuilder(15 * time.Second)
+		b.Timeout(timeout)
+		sr, err := b.Build()
+		require.NoError(t, err)
+		return []*SearchRequest{sr}
+	}
+	for _, searchAPI := range []string{SearchAPIElastic, SearchAPINative} {
+		c, err := NewClient(context.Background(), &DatasourceInfo{
+			URL:              ts.URL,
+			HTTPClient:       ts.Client(),
+			Database:         "logs",
+			ConfiguredFields: ConfiguredFields{TimeField: "timestamp"},
+			SearchAPI:        searchAPI,
+		})
+		require.NoError(t, err)
+
+		t.Run(searchAPI+": The timeout is sent to Quickwit", func(t *testing.T) {
+			mu.Lock()
+			delay = 0
+			mu.Unlock()
+			_, err := c.ExecuteMultisearch(newTimeoutSearch(5 * time.Second))
+			require.NoError(t, err)
+			if searchAPI == SearchAPIElastic {
+				mu.Lock()
+				defer mu.Unlock()
+				lines := strings.Split(strings.TrimSpace(body), "\n")
+				require.Len(t, lines, 2)
+				assert.Contains(t, lines[0], `"timeout":"5000ms"`)
+				assert.Contains(t, lines[1], `"timeout":"5000ms"`)
+			}
+		})
+
+		t.Run(searchAPI+": Searches cancelled by their timeout return a timed out response", func(t *testing.T) {
+			mu.Lock()
+			delay = time.Second
+			mu.Unlock()
+			res, err := c.ExecuteMultisearch(newTimeoutSearch(10 * time.Millisecond))
+			require.NoError(t, err)
+			require.Len(t, res.Responses, 1)
+			assert.JSONEq(t, `{ "timed_out": true, "hits": { "total": { "value": 0, "relation": "eq" }, "hits": [] } }`, string(*res.Responses[0]))
+		})
+	}
+}
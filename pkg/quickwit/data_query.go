@@ -2,9 +2,14 @@ package quickwit
 
 import (
 	"fmt"
+	"maps"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
 
 	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
 	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
@@ -12,10 +17,17 @@ import (
 
 const (
 	defaultSize = 100
+	// defaultCompositeSize is the number of buckets of a page of a composite
+	// aggregation
+	defaultCompositeSize = 1000
+	// termsTotalSuffix suffixes the ID of the date histogram counting all the
+	// documents next to a terms aggregation with an other bucket
+	termsTotalSuffix = "_total"
 )
 
-func buildMSR(queries []*Query, defaultTimeField string, forcedQueryFilter string) ([]*es.SearchRequest, error) {
+func buildMSR(queries []*Query, configuredFields es.ConfiguredFields, forcedQueryFilter string) ([]*es.SearchRequest, error) {
 	ms := es.NewMultiSearchRequestBuilder()
+	defaultTimeField := configuredFields.SearchTimeField()
 
 	for _, q := range queries {
 		err := isQueryWithError(q)
@@ -23,36 +35,76 @@ func buildMSR(queries []*Query, defaultTimeField string, forcedQueryFilter strin
 			return nil, err
 		}
 
-		b := ms.Search(q.Interval)
-		b.Size(0)
-		filters := b.Query().Bool().Filter()
-		// Always pass Grafana's picker range through. Quickwit's metastore
-		// prunes splits whose timestamps fall outside this window, so even
-		// trace_id lookups go from "scan every split" to "scan a few" — the
-		// same speedup the native Jaeger endpoint gets via auto-derived bounds.
-		filters.AddDateRangeFilter(defaultTimeField, q.RangeTo, q.RangeFrom)
-		filters.AddQueryStringFilter(applyForcedQueryFilter(q.RawQuery, forcedQueryFilter), true, "AND")
-		if isTraceSearchQuery(q) {
-			filters.AddQueryStringFilter(traceSearchSettingsQuery(q), true, "AND")
-		}
-
-		if isLogsQuery(q) {
-			processLogsQuery(q, b, q.RangeFrom, q.RangeTo, defaultTimeField)
-		} else if isTraceSearchQuery(q) {
-			processTraceSearchQuery(q, b, defaultTimeField)
-		} else if isTracesQuery(q) {
-			processTracesQuery(q, b, defaultTimeField)
-		} else if isDocumentQuery(q) {
-			processDocumentQuery(q, b, q.RangeFrom, q.RangeTo, defaultTimeField)
-		} else {
-			// Otherwise, it is a time series query and we process it
-			processTimeSeriesQuery(q, b, q.RangeFrom, q.RangeTo, defaultTimeField)
+		// The time range of the query may be split into shards searched
+		// concurrently, their responses are merged by executeQueries
+		for _, shard := range queryRangeShards(q, defaultTimeField) {
+			b := ms.Search(q.Interval)
+			b.Size(0)
+			filters := b.Query().Bool().Filter()
+			// Always pass Grafana's picker range through. Quickwit's metastore
+			// prunes splits whose timestamps fall outside this window, so even
+			// trace_id lookups go from "scan every split" to "scan a few" — the
+			// same speedup the native Jaeger endpoint gets via auto-derived bounds.
+			rangeField := defaultTimeField
+			if isAnnotationsQuery(q) {
+				rangeField = annotationTimeField(q, defaultTimeField)
+			}
+			from, to := shard.from, shard.to
+			if shard.last {
+				filters.AddDateRangeFilter(rangeField, to, from)
+			} else {
+				filters.AddHalfOpenDateRangeFilter(rangeField, to, from)
+				// The extended bounds of the date histograms are inclusive
+				to--
+			}
+			filters.AddQueryStringFilter(applyForcedQueryFilter(q.RawQuery, forcedQueryFilter), true, "AND")
+			if isTraceSearchQuery(q) {
+				filters.AddQueryStringFilter(traceSearchSettingsQuery(q), true, "AND")
+			}
+			if q.DisableCache {
+				b.DisableCache()
+			}
+			if q.Timeout > 0 {
+				b.Timeout(q.Timeout)
+			}
+
+			if isAnnotationsQuery(q) {
+				processAnnotationsQuery(q, b, defaultTimeField)
+			} else if isLogsQuery(q) {
+				processLogsQuery(q, b, from, to, defaultTimeField)
+			} else if isLogsVolumeQuery(q) {
+				processLogsVolumeQuery(q, b, from, to, defaultTimeField, configuredFields.LogLevelField)
+			} else if isTraceSearchQuery(q) {
+				processTraceSearchQuery(q, b, defaultTimeField)
+			} else if isTracesQuery(q) {
+				processTracesQuery(q, b, defaultTimeField)
+			} else if isDocumentQuery(q) {
+				processDocumentQuery(q, b, from, to, defaultTimeField)
+			} else {
+				// Otherwise, it is a time series query and we process it
+				if err := processTimeSeriesQuery(q, b, from, to, defaultTimeField); err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
 
 	return ms.Build()
 }
 
+// alignQueryRange snaps the time range of a cached query on its interval, so
+// that the refreshes happening within an interval share their cache entries
+func alignQueryRange(q *Query) {
+	interval := q.Interval.Milliseconds()
+	if q.DisableCache || interval <= 0 {
+		return
+	}
+	q.RangeFrom -= q.RangeFrom % interval
+	if remainder := q.RangeTo % interval; remainder != 0 {
+		q.RangeTo += interval - remainder
+	}
+}
+
 func applyForcedQueryFilter(rawQuery string, forcedQueryFilter string) string {
 	query := strings.TrimSpace(rawQuery)
 	forced := strings.TrimSpace(forcedQueryFilter)
@@ -83,6 +135,16 @@ func setIntPath(settings *simplejson.Json, path ...string) {
 	}
 }
 
+func setFloatArrayPath(settings *simplejson.Json, path ...string) {
+	if stringValues, err := settings.GetPath(path...).StringArray(); err == nil {
+		values := make([]float64, len(stringValues))
+		for i, v := range stringValues {
+			values[i], _ = strconv.ParseFloat(v, 64)
+		}
+		settings.SetPath(path, values)
+	}
+}
+
 // Casts values to float when required by Elastic's query DSL
 func (metricAggregation MetricAgg) generateSettingsForDSL() map[string]interface{} {
 	switch metricAggregation.Type {
@@ -97,12 +159,11 @@ func (metricAggregation MetricAgg) generateSettingsForDSL() map[string]interface
 		setFloatPath(metricAggregation.Settings, "lag")
 	case "percentiles":
 		// Quickwit only suppport percents in integers or floats
-		percents := metricAggregation.Settings.GetPath("percents").MustStringArray()
-		floatPercents := make([]float64, len(percents))
-		for i, p := range percents {
-			floatPercents[i], _ = strconv.ParseFloat(p, 64)
-		}
-		metricAggregation.Settings.SetPath([]string{"percents"}, floatPercents)
+		// The percents are only converted once, a query split into shards is
+		// built once per shard
+		setFloatArrayPath(metricAggregation.Settings, "percents")
+	case "percentile_ranks":
+		setFloatArrayPath(metricAggregation.Settings, "values")
 	}
 
 	if isMetricAggregationWithInlineScriptSupport(metricAggregation.Type) {
@@ -117,7 +178,15 @@ func (metricAggregation MetricAgg) generateSettingsForDSL() map[string]interface
 		}
 	}
 
-	return metricAggregation.Settings.MustMap()
+	settings := metricAggregation.Settings.MustMap()
+	if _, ok := unitParameterAggType[metricAggregation.Type]; !ok {
+		if _, ok := settings["unit"]; ok {
+			// The unit of the values is only used to display them
+			settings = maps.Clone(settings)
+			delete(settings, "unit")
+		}
+	}
+	return settings
 }
 
 func (bucketAgg BucketAgg) generateSettingsForDSL() map[string]interface{} {
@@ -164,17 +233,160 @@ func addDateHistogramAgg(aggBuilder es.AggBuilder, bucketAgg *BucketAgg, timeFro
 		}
 
 		if timezone, err := bucketAgg.Settings.Get("timeZone").String(); err == nil {
-			if timezone != "utc" {
+			if !isUTCTimeZone(timezone) {
 				a.TimeZone = timezone
 			}
 		}
 
+		// The calendar buckets are regrouped from the buckets of a fixed
+		// interval starting on the minutes or hours of the time zone
+		if interval, ok := calendarInterval(bucketAgg.Settings); ok {
+			location := calendarLocation(bucketAgg.Settings)
+			a.FixedInterval = calendarFixedInterval(interval, location, timeFrom, timeTo)
+			a.Offset = calendarOffset(location, a.FixedInterval, timeFrom)
+			a.TimeZone = ""
+			a.CalendarInterval = interval
+			a.CalendarLocation = location
+		}
+
 		aggBuilder = b
 	})
 
 	return aggBuilder, nil
 }
 
+// calendarIntervals are the intervals of the date histograms in calendar mode,
+// with the fixed interval searched for them. Quickwit has no
+// calendar_interval: the days, weeks, months, quarters and years, whose length
+// varies, are regrouped from the hours of the time zone, see es.calendarClient,
+// or from its days when its offset does not change, see calendarFixedInterval.
+var calendarIntervals = map[string]string{
+	"1m": "1m",
+	"1h": "1h",
+	"1d": "1h",
+	"1w": "1h",
+	"1M": "1h",
+	"1q": "1h",
+	"1y": "1h",
+}
+
+// maxCalendarBuckets is the maximum number of fixed interval buckets searched
+// for a calendar date histogram, the default maximum number of buckets of a
+// Quickwit search
+const maxCalendarBuckets = 65000
+
+// calendarUnmergeableMetrics are the metrics which cannot be computed from the
+// values of the hours regrouped into a calendar interval. The unique values of
+// the hours may overlap, the percentiles of the interval are not a function of
+// the percentiles of its hours, and the rates and pipeline aggregations are
+// computed from the hours.
+var calendarUnmergeableMetrics = map[string]string{
+	"cardinality":      "unique counts",
+	"percentiles":      "percentiles",
+	"percentile_ranks": "percentile ranks",
+	"rate":             "rates",
+}
+
+// calendarInterval returns the interval of a date histogram in calendar mode,
+// whose buckets follow the calendar of its time zone. The other intervals are
+// fixed intervals.
+func calendarInterval(settings *simplejson.Json) (string, bool) {
+	if settings.Get("intervalType").MustString() != "calendar" {
+		return "", false
+	}
+	interval := settings.Get("interval").MustString()
+	_, ok := calendarIntervals[interval]
+	return interval, ok
+}
+
+// calendarLocation returns the time zone of a date histogram, UTC when the
+// time zone is unknown
+func calendarLocation(settings *simplejson.Json) *time.Location {
+	timeZone := settings.Get("timeZone").MustString()
+	if isUTCTimeZone(timeZone) {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// calendarFixedInterval returns the fixed interval searched for a date
+// histogram in calendar mode over a time range, in milliseconds. The days are
+// the largest fixed interval whose buckets start on the days of the time zone,
+// as long as its offset does not change over the range.
+func calendarFixedInterval(interval string, location *time.Location, timeFrom, timeTo int64) string {
+	fixedInterval := calendarIntervals[interval]
+	if fixedInterval == "1h" && !hasZoneTransition(location, timeFrom, timeTo) {
+		return "1d"
+	}
+	return fixedInterval
+}
+
+// hasZoneTransition returns true when the offset of the time zone changes
+// between timeFrom and timeTo, in milliseconds, like on daylight saving time.
+// The offset is compared from day to day.
+func hasZoneTransition(location *time.Location, timeFrom, timeTo int64) bool {
+	if location == time.UTC {
+		return false
+	}
+	start := time.UnixMilli(timeFrom).In(location)
+	_, offset := start.Zone()
+	for t := start; t.UnixMilli() < timeTo; t = t.Add(24 * time.Hour) {
+		if _, o := t.Zone(); o != offset {
+			return true
+		}
+	}
+	_, o := time.UnixMilli(timeTo).In(location).Zone()
+	return o != offset
+}
+
+// calendarOffset returns the offset of the fixed interval buckets starting on
+// the hours or on the days of the time zone. The hourly buckets only need an
+// offset for the time zones whose offset is not a whole number of hours.
+func calendarOffset(location *time.Location, fixedInterval string, timeFrom int64) string {
+	_, offset := time.UnixMilli(timeFrom).In(location).Zone()
+	var minutes int
+	switch fixedInterval {
+	case "1h":
+		minutes = (offset/60%60 + 60) % 60
+	case "1d":
+		minutes = ((-offset/60)%(24*60) + 24*60) % (24 * 60)
+	}
+	if minutes == 0 {
+		return ""
+	}
+	if minutes%60 == 0 {
+		return fmt.Sprintf("%dh", minutes/60)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// calendarBuckets returns the number of fixed interval buckets searched for a
+// calendar date histogram of a query, for each bucket of its terms
+// aggregations
+func calendarBuckets(query *Query, interval string, location *time.Location) int64 {
+	fixedInterval, err := gtime.ParseDuration(calendarFixedInterval(interval, location, query.RangeFrom, query.RangeTo))
+	if err != nil || fixedInterval <= 0 {
+		return 0
+	}
+	buckets := (query.RangeTo-query.RangeFrom)/fixedInterval.Milliseconds() + 1
+	for _, bucketAgg := range query.BucketAggs {
+		if bucketAgg.Type == termsType {
+			buckets *= int64(max(termsSize(bucketAgg), 1))
+		}
+	}
+	return buckets
+}
+
+// isUTCTimeZone returns true for the time zone settings of UTC, the default
+// time zone of the date aggregations
+func isUTCTimeZone(timeZone string) bool {
+	return timeZone == "" || strings.EqualFold(timeZone, "utc")
+}
+
 func addHistogramAgg(aggBuilder es.AggBuilder, bucketAgg *BucketAgg) es.AggBuilder {
 	aggBuilder.Histogram(bucketAgg.ID, bucketAgg.Field, func(a *es.HistogramAgg, b es.AggBuilder) {
 		a.Interval = stringToIntWithDefaultValue(bucketAgg.Settings.Get("interval").MustString(), 1000)
@@ -190,13 +402,17 @@ func addHistogramAgg(aggBuilder es.AggBuilder, bucketAgg *BucketAgg) es.AggBuild
 	return aggBuilder
 }
 
+// termsSize returns the number of terms of a terms aggregation
+func termsSize(bucketAgg *BucketAgg) int {
+	if size, err := bucketAgg.Settings.Get("size").Int(); err == nil {
+		return size
+	}
+	return stringToIntWithDefaultValue(bucketAgg.Settings.Get("size").MustString(), defaultSize)
+}
+
 func addTermsAgg(aggBuilder es.AggBuilder, bucketAgg *BucketAgg, metrics []*MetricAgg) es.AggBuilder {
 	aggBuilder.Terms(bucketAgg.ID, bucketAgg.Field, func(a *es.TermsAggregation, b es.AggBuilder) {
-		if size, err := bucketAgg.Settings.Get("size").Int(); err == nil {
-			a.Size = size
-		} else {
-			a.Size = stringToIntWithDefaultValue(bucketAgg.Settings.Get("size").MustString(), defaultSize)
-		}
+		a.Size = termsSize(bucketAgg)
 		if shard_size, err := bucketAgg.Settings.Get("shard_size").Int(); err == nil {
 			a.ShardSize = shard_size
 		} else {
@@ -241,6 +457,28 @@ func addTermsAgg(aggBuilder es.AggBuilder, bucketAgg *BucketAgg, metrics []*Metr
 	return aggBuilder
 }
 
+// addTermsTotalAgg adds the date histogram counting the documents of the
+// parent bucket of a terms aggregation with an other bucket, when the terms
+// aggregation is followed by the date histogram of a time series
+func addTermsTotalAgg(aggBuilder es.AggBuilder, q *Query, from, to int64, timeField string) error {
+	n := len(q.BucketAggs)
+	if n < 2 {
+		return nil
+	}
+	terms, dateHist := q.BucketAggs[n-2], q.BucketAggs[n-1]
+	if terms.Type != termsType || dateHist.Type != dateHistType || !terms.Settings.Get("otherBucket").MustBool() {
+		return nil
+	}
+	total := &BucketAgg{
+		ID:       terms.ID + termsTotalSuffix,
+		Field:    dateHist.Field,
+		Settings: dateHist.Settings,
+		Type:     dateHistType,
+	}
+	_, err := addDateHistogramAgg(aggBuilder, total, from, to, timeField)
+	return err
+}
+
 func addNestedAgg(aggBuilder es.AggBuilder, bucketAgg *BucketAgg) es.AggBuilder {
 	aggBuilder.Nested(bucketAgg.ID, bucketAgg.Field, func(a *es.NestedAggregation, b es.AggBuilder) {
 		aggBuilder = b
@@ -280,6 +518,166 @@ func addGeoHashGridAgg(aggBuilder es.AggBuilder, bucketAgg *BucketAgg) es.AggBui
 	return aggBuilder
 }
 
+func addRangeAgg(aggBuilder es.AggBuilder, bucketAgg *BucketAgg) es.AggBuilder {
+	aggBuilder.Range(bucketAgg.ID, bucketAgg.Field, func(a *es.RangeAggregation, b es.AggBuilder) {
+		for _, r := range bucketAgg.Settings.Get("ranges").MustArray() {
+			rangeJSON := simplejson.NewFromAny(r)
+			aggRange := &es.AggRange{Key: rangeJSON.Get("key").MustString()}
+			if from, ok := rangeBoundValue(rangeJSON.Get("from")); ok {
+				aggRange.From = from
+			}
+			if to, ok := rangeBoundValue(rangeJSON.Get("to")); ok {
+				aggRange.To = to
+			}
+			a.Ranges = append(a.Ranges, aggRange)
+		}
+		a.Keyed = bucketAgg.Settings.Get("keyed").MustBool(false)
+		aggBuilder = b
+	})
+
+	return aggBuilder
+}
+
+// rangeBoundValue returns the number of a range bound, false when the range is
+// unbounded on this side
+func rangeBoundValue(bound *simplejson.Json) (float64, bool) {
+	if value, err := bound.Float64(); err == nil {
+		return value, true
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(bound.MustString()), 64)
+	return value, err == nil
+}
+
+func addDateRangeAgg(aggBuilder es.AggBuilder, bucketAgg *BucketAgg, timeField string) es.AggBuilder {
+	// If no field is specified, use the time field
+	field := bucketAgg.Field
+	if field == "" {
+		field = timeField
+	}
+
+	aggBuilder.DateRange(bucketAgg.ID, field, func(a *es.DateRangeAggregation, b es.AggBuilder) {
+		for _, r := range bucketAgg.Settings.Get("ranges").MustArray() {
+			rangeJSON := simplejson.NewFromAny(r)
+			aggRange := &es.AggRange{Key: rangeJSON.Get("key").MustString()}
+			// Date bounds are epoch milliseconds, dates or date math
+			// expressions, validated by isValidDateRangeBound
+			if from := rangeJSON.Get("from").Interface(); from != nil && from != "" {
+				aggRange.From = from
+			}
+			if to := rangeJSON.Get("to").Interface(); to != nil && to != "" {
+				aggRange.To = to
+			}
+			a.Ranges = append(a.Ranges, aggRange)
+		}
+		a.Keyed = bucketAgg.Settings.Get("keyed").MustBool(false)
+
+		if format, err := bucketAgg.Settings.Get("format").String(); err == nil {
+			a.Format = format
+		}
+		if timezone, err := bucketAgg.Settings.Get("timeZone").String(); err == nil {
+			if !isUTCTimeZone(timezone) {
+				a.TimeZone = timezone
+			}
+		}
+
+		aggBuilder = b
+	})
+
+	return aggBuilder
+}
+
+// dateMathOperation matches the first operation of a date math expression,
+// adding or subtracting a duration, or rounding to a unit
+var dateMathOperation = regexp.MustCompile(`^(?:([+-])(\d+[yMwdhHms])|/[yMwdhHms])`)
+
+// isValidDateRangeBound tells whether a bound of a date range is epoch
+// milliseconds, a date or a date math expression. The date math expressions
+// are anchored on now or on a date followed by ||, then add or subtract
+// durations parsed by gtime and round to a unit, as in now-1d/d or
+// 2024-01-01||+1M. The dates are RFC 3339 dates or days, unless the
+// aggregation sets a format, which Quickwit parses them with.
+func isValidDateRangeBound(bound *simplejson.Json, hasFormat bool) bool {
+	if _, ok := rangeBoundValue(bound); ok {
+		return true
+	}
+	value, err := bound.String()
+	if err != nil {
+		return bound.Interface() == nil
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return true
+	}
+
+	isDate := func(date string) bool {
+		if hasFormat {
+			return date != ""
+		}
+		if _, err := time.Parse(time.RFC3339, date); err == nil {
+			return true
+		}
+		_, err := time.Parse(time.DateOnly, date)
+		return err == nil
+	}
+	var operations string
+	if rest, ok := strings.CutPrefix(value, "now"); ok {
+		operations = rest
+	} else if date, rest, ok := strings.Cut(value, "||"); ok {
+		if !isDate(date) {
+			return false
+		}
+		operations = rest
+	} else {
+		return isDate(value)
+	}
+
+	for operations != "" {
+		match := dateMathOperation.FindStringSubmatch(operations)
+		if match == nil {
+			return false
+		}
+		if match[1] != "" {
+			// gtime parses the hours as h only
+			if _, err := gtime.ParseDuration(strings.ReplaceAll(match[2], "H", "h")); err != nil {
+				return false
+			}
+		}
+		operations = operations[len(match[0]):]
+	}
+	return true
+}
+
+func addCompositeAgg(aggBuilder es.AggBuilder, bucketAgg *BucketAgg) es.AggBuilder {
+	aggBuilder.Composite(bucketAgg.ID, func(a *es.CompositeAggregation, b es.AggBuilder) {
+		if size, err := bucketAgg.Settings.Get("size").Int(); err == nil && size > 0 {
+			a.Size = size
+		} else {
+			a.Size = stringToIntWithDefaultValue(bucketAgg.Settings.Get("size").MustString(), defaultCompositeSize)
+		}
+		// The fields are the names of the sources, the keys of the buckets are
+		// returned as one label per field
+		for _, field := range compositeFields(bucketAgg) {
+			a.Sources = append(a.Sources, &es.CompositeSource{Name: field, Field: field})
+		}
+		aggBuilder = b
+	})
+
+	return aggBuilder
+}
+
+// compositeFields returns the distinct fields grouped by a composite
+// aggregation, in order
+func compositeFields(bucketAgg *BucketAgg) []string {
+	var fields []string
+	for _, field := range bucketAgg.Settings.Get("fields").MustStringArray() {
+		field = strings.TrimSpace(field)
+		if field != "" && !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
 func getPipelineAggField(m *MetricAgg) string {
 	// In frontend we are using Field as pipelineAggField
 	// There might be historical reason why in backend we were using PipelineAggregate as pipelineAggField
@@ -294,9 +692,16 @@ func getPipelineAggField(m *MetricAgg) string {
 }
 
 func isQueryWithError(query *Query) error {
+	if query.TimeoutError != nil {
+		return query.TimeoutError
+	}
+	if isAnnotationsQuery(query) {
+		// Annotations queries only fetch documents, their aggregations are ignored
+		return nil
+	}
 	if len(query.BucketAggs) == 0 {
 		// If no aggregations, only document, logs, and trace queries are valid
-		if len(query.Metrics) == 0 || !(isLogsQuery(query) || isTraceSearchQuery(query) || isTracesQuery(query) || isDocumentQuery(query)) {
+		if len(query.Metrics) == 0 || !(isLogsQuery(query) || isLogsVolumeQuery(query) || isTraceSearchQuery(query) || isTracesQuery(query) || isDocumentQuery(query)) {
 			return fmt.Errorf("invalid query, missing metrics and aggregations")
 		}
 	} else {
@@ -304,18 +709,67 @@ func isQueryWithError(query *Query) error {
 		for _, bucketAgg := range query.BucketAggs {
 			// Check which aggregation types require fields
 			switch bucketAgg.Type {
-			case dateHistType:
-				// For date_histogram, field can be empty (will use timeField as fallback)
+			case dateHistType, dateRangeType:
+				// For date_histogram and date_range, field can be empty (will use timeField as fallback)
 				// Validation will happen at query processing time
+				if bucketAgg.Type == dateRangeType {
+					_, hasFormat := bucketAgg.Settings.CheckGet("format")
+					for _, r := range bucketAgg.Settings.Get("ranges").MustArray() {
+						rangeJSON := simplejson.NewFromAny(r)
+						for _, name := range []string{"from", "to"} {
+							if !isValidDateRangeBound(rangeJSON.Get(name), hasFormat) {
+								return fmt.Errorf("invalid query, bucket aggregation '%s' (type: %s) has an invalid date range bound '%v'", bucketAgg.ID, bucketAgg.Type, rangeJSON.Get(name).Interface())
+							}
+						}
+					}
+					continue
+				}
+				if bucketAgg.Settings.Get("intervalType").MustString() == "calendar" {
+					interval, ok := calendarInterval(bucketAgg.Settings)
+					if !ok {
+						return fmt.Errorf("invalid query, bucket aggregation '%s' (type: %s) has an unsupported calendar interval '%s'", bucketAgg.ID, bucketAgg.Type, bucketAgg.Settings.Get("interval").MustString())
+					}
+					if timeZone := bucketAgg.Settings.Get("timeZone").MustString(); !isUTCTimeZone(timeZone) {
+						if _, err := time.LoadLocation(timeZone); err != nil {
+							return fmt.Errorf("invalid query, bucket aggregation '%s' (type: %s) has an unknown time zone '%s'", bucketAgg.ID, bucketAgg.Type, timeZone)
+						}
+					}
+					// The hours or days regrouped into the calendar intervals of a
+					// long time range may exceed the buckets of a search
+					if buckets := calendarBuckets(query, interval, calendarLocation(bucketAgg.Settings)); buckets > maxCalendarBuckets {
+						return fmt.Errorf("invalid query, bucket aggregation '%s' (type: %s) with calendar interval '%s' searches %d buckets, more than %d: reduce the time range or the size of the terms", bucketAgg.ID, bucketAgg.Type, interval, buckets, maxCalendarBuckets)
+					}
+					if calendarIntervals[interval] != interval {
+						for _, m := range query.Metrics {
+							name, ok := calendarUnmergeableMetrics[m.Type]
+							if isPipelineAgg(m.Type) {
+								name, ok = metricAggType[m.Type], true
+							}
+							if ok {
+								return fmt.Errorf("invalid query, %s cannot be computed by calendar interval '%s'", name, interval)
+							}
+						}
+					}
+				}
 				continue
-			case histogramType, termsType, geohashGridType, nestedType:
+			case histogramType, termsType, geohashGridType, nestedType, rangeType:
 				// These aggregation types require a field
 				if bucketAgg.Field == "" {
 					return fmt.Errorf("invalid query, bucket aggregation '%s' (type: %s) is missing required field", bucketAgg.ID, bucketAgg.Type)
 				}
+				// The missing bucket groups the documents without value under
+				// the missing value, which must match the type of the field
+				if bucketAgg.Type == termsType && bucketAgg.Settings.Get("missingBucket").MustBool() && bucketAgg.Settings.Get("missing").MustString() == "" {
+					return fmt.Errorf("invalid query, bucket aggregation '%s' (type: %s) has a missing bucket without missing value", bucketAgg.ID, bucketAgg.Type)
+				}
 			case filtersType:
 				// Filters aggregations don't need a field
 				continue
+			case compositeType:
+				// Composite aggregations group by the fields of their settings
+				if len(compositeFields(bucketAgg)) == 0 {
+					return fmt.Errorf("invalid query, bucket aggregation '%s' (type: %s) is missing required fields", bucketAgg.ID, bucketAgg.Type)
+				}
 			default:
 				// For unknown aggregation types, be conservative and require field
 				if bucketAgg.Field == "" {
@@ -331,6 +785,10 @@ func isLogsQuery(query *Query) bool {
 	return queryMetricType(query) == logsType
 }
 
+func isLogsVolumeQuery(query *Query) bool {
+	return queryMetricType(query) == logsVolumeType
+}
+
 func isTracesQuery(query *Query) bool {
 	return queryMetricType(query) == tracesType
 }
@@ -398,6 +856,16 @@ func traceSearchSettingsQuery(query *Query) string {
 	return strings.Join(clauses, " AND ")
 }
 
+// queryTermEscaper escapes the special characters of the query language in a
+// term, like the frontend escapes the keys of the ad hoc filters
+var queryTermEscaper = strings.NewReplacer(
+	`\`, `\\`, `+`, `\+`, `-`, `\-`, `!`, `\!`, `(`, `\(`, `)`, `\)`, `{`, `\{`, `}`, `\}`,
+	`[`, `\[`, `]`, `\]`, `^`, `\^`, `"`, `\"`, `?`, `\?`, `:`, `\:`, `&`, `\&`, `|`, `\|`,
+	`'`, `\'`, `/`, `\/`, `*`, `\*`, `~`, `\~`, " ", `\ `, "\t", "\\\t", "\n", "\\\n",
+)
+
+// traceSearchPhraseClause matches the phrase of a value in a field, the value
+// being escaped like the values of the ad hoc filters
 func traceSearchPhraseClause(fieldName, value string) string {
 	escaped := strings.ReplaceAll(value, `\`, `\\`)
 	escaped = strings.ReplaceAll(escaped, `"`, `\"`)
@@ -511,24 +979,66 @@ func normalizeInternalLinkTraceQuery(query *Query) {
 
 func processLogsQuery(q *Query, b *es.SearchRequestBuilder, from, to int64, defaultTimeField string) {
 	metric := q.Metrics[0]
-	sort := es.SortOrderDesc
-	if metric.Settings.Get("sortDirection").MustString() == "asc" {
-		// This is currently used only for log context query
-		sort = es.SortOrderAsc
-	}
+	sort := sortOrderFromSettings(metric.Settings)
 	b.Sort(sort, defaultTimeField, "epoch_nanos_int")
+	// The searchAfter cursors page through the rows sharing a timestamp by
+	// document
+	b.Sort(sort, "_doc", "")
 	b.Size(stringToIntWithDefaultValue(metric.Settings.Get("limit").MustString(), defaultSize))
 	// TODO when hightlight is supported in quickwit
 	// b.AddHighlight()
 
-	// This is currently used only for log context query to get
-	// log lines before and after the selected log line
-	searchAfter := metric.Settings.Get("searchAfter").MustArray()
-	for _, value := range searchAfter {
+	addSearchAfter(b, metric.Settings)
+}
+
+// sortOrderFromSettings returns the sort direction of the logs and documents
+// queries, the most recent documents come first by default
+func sortOrderFromSettings(settings *simplejson.Json) es.SortOrder {
+	if settings.Get("sortDirection").MustString() == "asc" {
+		return es.SortOrderAsc
+	}
+	return es.SortOrderDesc
+}
+
+// addSearchAfter resumes the search after the cursor of a previous page. The
+// cursor holds the sort values of the last row of that page, it is used to
+// load more rows and to get the log lines around a log context row.
+func addSearchAfter(b *es.SearchRequestBuilder, settings *simplejson.Json) {
+	for _, value := range settings.Get("searchAfter").MustArray() {
 		b.AddSearchAfter(value)
 	}
 }
 
+func processLogsVolumeQuery(q *Query, b *es.SearchRequestBuilder, from, to int64, defaultTimeField string, logLevelField string) {
+	rootAggBuilder := b.Agg()
+	aggBuilder := rootAggBuilder
+	addHistogram := func(aggBuilder es.AggBuilder, id string) {
+		aggBuilder.DateHistogram(id, defaultTimeField, func(a *es.DateHistogramAgg, ab es.AggBuilder) {
+			a.FixedInterval = "$__interval_msms"
+			a.MinDocCount = 0
+			a.ExtendedBounds = &es.ExtendedBounds{Min: from, Max: to}
+		})
+	}
+
+	// Split the volume by log level when the datasource has a level field,
+	// documents without level are counted in the unknown level
+	if logLevelField != "" {
+		aggBuilder.Terms(logsVolumeLevelAggID, logLevelField, func(a *es.TermsAggregation, ab es.AggBuilder) {
+			missing := logLevelUnknown
+			a.Size = logsVolumeLevelsSize
+			a.ShardSize = logsVolumeLevelsSize
+			a.Missing = &missing
+			a.Order["_count"] = "desc"
+			aggBuilder = ab
+		})
+		// The documents of the levels beyond the size of the terms are
+		// counted in the unknown level, from the volume of all the levels
+		addHistogram(rootAggBuilder, logsVolumeTotalAggID)
+	}
+
+	addHistogram(aggBuilder, logsVolumeHistogramAggID)
+}
+
 func processTracesQuery(q *Query, b *es.SearchRequestBuilder, defaultTimeField string) {
 	metric := q.Metrics[0]
 	b.Sort(es.SortOrderAsc, defaultTimeField, "epoch_nanos_int")
@@ -543,21 +1053,29 @@ func processTraceSearchQuery(q *Query, b *es.SearchRequestBuilder, defaultTimeFi
 
 func processDocumentQuery(q *Query, b *es.SearchRequestBuilder, from, to int64, defaultTimeField string) {
 	metric := q.Metrics[0]
-	b.Sort(es.SortOrderDesc, defaultTimeField, "epoch_nanos_int")
-	b.Sort(es.SortOrderDesc, "_doc", "")
+	sort := sortOrderFromSettings(metric.Settings)
+	b.Sort(sort, defaultTimeField, "epoch_nanos_int")
+	b.Sort(sort, "_doc", "")
 	// Note: not supported in Quickwit
 	// b.AddDocValueField(defaultTimeField)
 	b.Size(stringToIntWithDefaultValue(metric.Settings.Get("size").MustString(), defaultSize))
+
+	addSearchAfter(b, metric.Settings)
 }
 
 func processTimeSeriesQuery(q *Query, b *es.SearchRequestBuilder, from, to int64, defaultTimeField string) error {
 	aggBuilder := b.Agg()
+	// termsParentBuilder builds the parent of the last terms aggregation
+	var termsParentBuilder es.AggBuilder
 	// Process buckets
 	// iterate backwards to create aggregations bottom-down
 	for _, bucketAgg := range q.BucketAggs {
 		bucketAgg.Settings = simplejson.NewFromAny(
 			bucketAgg.generateSettingsForDSL(),
 		)
+		if bucketAgg.Type == termsType {
+			termsParentBuilder = aggBuilder
+		}
 		switch bucketAgg.Type {
 		case dateHistType:
 			var err error
@@ -575,6 +1093,17 @@ func processTimeSeriesQuery(q *Query, b *es.SearchRequestBuilder, from, to int64
 			aggBuilder = addGeoHashGridAgg(aggBuilder, bucketAgg)
 		case nestedType:
 			aggBuilder = addNestedAgg(aggBuilder, bucketAgg)
+		case rangeType:
+			aggBuilder = addRangeAgg(aggBuilder, bucketAgg)
+		case dateRangeType:
+			aggBuilder = addDateRangeAgg(aggBuilder, bucketAgg, defaultTimeField)
+		case compositeType:
+			aggBuilder = addCompositeAgg(aggBuilder, bucketAgg)
+		}
+	}
+	if termsParentBuilder != nil {
+		if err := addTermsTotalAgg(termsParentBuilder, q, from, to, defaultTimeField); err != nil {
+			return err
 		}
 	}
 
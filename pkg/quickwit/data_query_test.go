@@ -1,7 +1,9 @@
 package quickwit
 
 import (
+	"context"
 	"encoding/json"
+	"sort"
 	"testing"
 	"time"
 
@@ -10,6 +12,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
 )
 
 func TestQueryTypeHelpersDoNotPanicWithoutMetrics(t *testing.T) {
@@ -38,6 +41,33 @@ func TestApplyForcedQueryFilter(t *testing.T) {
 	})
 }
 
+func TestAlignQueryRange(t *testing.T) {
+	t.Run("snaps the range on the interval", func(t *testing.T) {
+		q := &Query{Interval: 30 * time.Second, RangeFrom: 1_000_010_000, RangeTo: 1_000_040_000}
+		alignQueryRange(q)
+		assert.Equal(t, int64(999_990_000), q.RangeFrom)
+		assert.Equal(t, int64(1_000_050_000), q.RangeTo)
+	})
+
+	t.Run("keeps the range of the queries bypassing the cache", func(t *testing.T) {
+		q := &Query{Interval: 30 * time.Second, RangeFrom: 1_000_010_000, RangeTo: 1_000_040_000, DisableCache: true}
+		alignQueryRange(q)
+		assert.Equal(t, int64(1_000_010_000), q.RangeFrom)
+		assert.Equal(t, int64(1_000_040_000), q.RangeTo)
+	})
+
+	t.Run("disables the cache of the search request", func(t *testing.T) {
+		queries, err := parseQuery([]backend.DataQuery{{
+			JSON:      json.RawMessage(`{ "disableCache": true, "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2" }] }`),
+			TimeRange: backend.TimeRange{From: time.UnixMilli(0), To: time.UnixMilli(1000)},
+		}})
+		require.NoError(t, err)
+		req, err := buildMSR(queries, es.ConfiguredFields{TimeField: "timestamp"}, "")
+		require.NoError(t, err)
+		assert.True(t, req[0].NoCache)
+	})
+}
+
 func TestExecuteElasticsearchDataQuery(t *testing.T) {
 	from := time.Date(2018, 5, 15, 17, 50, 0, 0, time.UTC)
 	to := time.Date(2018, 5, 15, 17, 55, 0, 0, time.UTC)
@@ -654,6 +684,322 @@ func TestExecuteElasticsearchDataQuery(t *testing.T) {
 			require.Equal(t, ghGridAgg.Precision, 3)
 		})
 
+		t.Run("With range agg", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{
+						"id": "3",
+						"type": "range",
+						"field": "bytes",
+						"settings": {
+							"keyed": true,
+							"ranges": [
+								{ "to": "100", "key": "small" },
+								{ "from": "100", "to": 1000 },
+								{ "from": "1000", "to": "" }
+							]
+						}
+					}
+				],
+				"metrics": [
+					{ "type": "count", "id": "1" },
+					{ "type": "value_count", "id": "2", "field": "status" },
+					{ "type": "percentile_ranks", "id": "4", "field": "latency", "settings": { "values": ["100", "500.5"] } }
+				]
+			}`, from, to)
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+
+			firstLevel := sr.Aggs[0]
+			require.Equal(t, "3", firstLevel.Key)
+			require.Equal(t, "range", firstLevel.Aggregation.Type)
+			rangeAgg := firstLevel.Aggregation.Aggregation.(*es.RangeAggregation)
+			require.Equal(t, "bytes", rangeAgg.Field)
+			require.True(t, rangeAgg.Keyed)
+			require.Equal(t, []*es.AggRange{
+				{Key: "small", To: 100.0},
+				{From: 100.0, To: 1000.0},
+				{From: 1000.0},
+			}, rangeAgg.Ranges)
+
+			require.Len(t, firstLevel.Aggregation.Aggs, 2)
+			require.Equal(t, "value_count", firstLevel.Aggregation.Aggs[0].Aggregation.Type)
+			ranksAgg := firstLevel.Aggregation.Aggs[1].Aggregation.Aggregation.(*es.MetricAggregation)
+			require.Equal(t, "percentile_ranks", ranksAgg.Type)
+			require.Equal(t, []float64{100, 500.5}, ranksAgg.Settings["values"])
+		})
+
+		t.Run("With terms agg with other and missing buckets", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{
+						"type": "terms",
+						"field": "@host",
+						"id": "2",
+						"settings": { "size": "5", "otherBucket": true, "missing": "__missing__", "missingBucket": true }
+					},
+					{ "type": "date_histogram", "field": "@timestamp", "id": "3" }
+				],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, from, to)
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+			require.Len(t, sr.Aggs, 2)
+
+			termsAgg := sr.Aggs[0].Aggregation.Aggregation.(*es.TermsAggregation)
+			require.Equal(t, "__missing__", *termsAgg.Missing)
+			require.Equal(t, "3", sr.Aggs[0].Aggregation.Aggs[0].Key)
+
+			totalAgg := sr.Aggs[1]
+			require.Equal(t, "2_total", totalAgg.Key)
+			require.Equal(t, "date_histogram", totalAgg.Aggregation.Type)
+			require.Equal(t, "@timestamp", totalAgg.Aggregation.Aggregation.(*es.DateHistogramAgg).Field)
+		})
+
+		t.Run("With terms agg with missing value and missing bucket", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{ "type": "terms", "field": "@host", "id": "2", "settings": { "missing": "none", "missingBucket": true } }
+				],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, from, to)
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+			require.Len(t, sr.Aggs, 1)
+			require.Equal(t, "none", *sr.Aggs[0].Aggregation.Aggregation.(*es.TermsAggregation).Missing)
+		})
+
+		t.Run("With terms agg with missing bucket without missing value", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{ "type": "terms", "field": "status", "id": "2", "settings": { "missingBucket": true } }
+				],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, from, to)
+			require.ErrorContains(t, err, "missing bucket without missing value")
+			require.Empty(t, c.multisearchRequests)
+		})
+
+		t.Run("With calendar date histogram", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{
+						"type": "date_histogram",
+						"field": "@timestamp",
+						"id": "2",
+						"settings": { "interval": "1d", "intervalType": "calendar", "timeZone": "Europe/Paris" }
+					}
+				],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, from, to)
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+
+			dateHistogram := sr.Aggs[0].Aggregation.Aggregation.(*es.DateHistogramAgg)
+			require.Equal(t, "1d", dateHistogram.FixedInterval)
+			require.Empty(t, dateHistogram.TimeZone)
+			require.Equal(t, "22h", dateHistogram.Offset)
+			require.Equal(t, "1d", dateHistogram.CalendarInterval)
+			require.Equal(t, "Europe/Paris", dateHistogram.CalendarLocation.String())
+		})
+
+		t.Run("With calendar date histogram in a time zone with a half hour offset", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{ "type": "date_histogram", "field": "@timestamp", "id": "2", "settings": { "interval": "1M", "intervalType": "calendar", "timeZone": "Asia/Kolkata" } }
+				],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, from, to)
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+
+			dateHistogram := sr.Aggs[0].Aggregation.Aggregation.(*es.DateHistogramAgg)
+			require.Equal(t, "1d", dateHistogram.FixedInterval)
+			require.Equal(t, "1110m", dateHistogram.Offset)
+			require.Equal(t, "1M", dateHistogram.CalendarInterval)
+		})
+
+		t.Run("With calendar date histogram over a daylight saving time change", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{ "type": "date_histogram", "field": "@timestamp", "id": "2", "settings": { "interval": "1w", "intervalType": "calendar", "timeZone": "Europe/Paris" } }
+				],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC))
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+
+			dateHistogram := sr.Aggs[0].Aggregation.Aggregation.(*es.DateHistogramAgg)
+			require.Equal(t, "1h", dateHistogram.FixedInterval)
+			require.Empty(t, dateHistogram.Offset)
+			require.Equal(t, "1w", dateHistogram.CalendarInterval)
+		})
+
+		t.Run("With calendar date histogram over a long range", func(t *testing.T) {
+			longFrom := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+			longTo := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{ "type": "terms", "field": "host", "id": "3", "settings": { "size": "10" } },
+					{ "type": "date_histogram", "field": "@timestamp", "id": "2", "settings": { "interval": "1y", "intervalType": "calendar" } }
+				],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, longFrom, longTo)
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+
+			dateHistogram := sr.Aggs[0].Aggregation.Aggs[0].Aggregation.Aggregation.(*es.DateHistogramAgg)
+			require.Equal(t, "1d", dateHistogram.FixedInterval)
+			require.Empty(t, dateHistogram.Offset)
+			require.Equal(t, "1y", dateHistogram.CalendarInterval)
+
+			c = newFakeClient()
+			_, err = executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{ "type": "terms", "field": "host", "id": "3", "settings": { "size": "10" } },
+					{ "type": "date_histogram", "field": "@timestamp", "id": "2", "settings": { "interval": "1y", "intervalType": "calendar", "timeZone": "Europe/Paris" } }
+				],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, longFrom, longTo)
+			require.ErrorContains(t, err, "with calendar interval '1y' searches 876730 buckets, more than 65000")
+			require.Empty(t, c.multisearchRequests)
+		})
+
+		t.Run("With calendar date histogram and unsupported interval", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{ "type": "date_histogram", "field": "@timestamp", "id": "2", "settings": { "interval": "2d", "intervalType": "calendar" } }
+				],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, from, to)
+			require.ErrorContains(t, err, "unsupported calendar interval '2d'")
+			require.Empty(t, c.multisearchRequests)
+		})
+
+		t.Run("With calendar date histogram and unique count", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{ "type": "date_histogram", "field": "@timestamp", "id": "2", "settings": { "interval": "1w", "intervalType": "calendar" } }
+				],
+				"metrics": [{ "type": "cardinality", "field": "host", "id": "1" }]
+			}`, from, to)
+			require.ErrorContains(t, err, "unique counts cannot be computed by calendar interval '1w'")
+			require.Empty(t, c.multisearchRequests)
+		})
+
+		t.Run("With metric unit", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [{ "type": "date_histogram", "field": "@timestamp", "id": "2" }],
+				"metrics": [
+					{ "type": "avg", "field": "latency", "id": "1", "settings": { "unit": "ms" } },
+					{ "type": "derivative", "field": "1", "id": "3", "settings": { "unit": "1m" } }
+				]
+			}`, from, to)
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+
+			dateHistogramAgg := sr.Aggs[0].Aggregation
+			avgAgg := dateHistogramAgg.Aggs[0].Aggregation.Aggregation.(*es.MetricAggregation)
+			require.NotContains(t, avgAgg.Settings, "unit")
+			derivativeAgg := dateHistogramAgg.Aggs[1].Aggregation.Aggregation.(*es.PipelineAggregation)
+			require.Equal(t, "1m", derivativeAgg.Settings["unit"])
+		})
+
+		t.Run("With composite agg", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{
+						"id": "2",
+						"type": "composite",
+						"settings": { "fields": ["service", "endpoint", "service"], "size": "500" }
+					},
+					{ "type": "date_histogram", "field": "@timestamp", "id": "3" }
+				],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, from, to)
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+
+			firstLevel := sr.Aggs[0]
+			require.Equal(t, "composite", firstLevel.Aggregation.Type)
+			compositeAgg := firstLevel.Aggregation.Aggregation.(*es.CompositeAggregation)
+			require.Equal(t, 500, compositeAgg.Size)
+			require.Equal(t, []*es.CompositeSource{
+				{Name: "service", Field: "service"},
+				{Name: "endpoint", Field: "endpoint"},
+			}, compositeAgg.Sources)
+			require.Equal(t, "date_histogram", firstLevel.Aggregation.Aggs[0].Aggregation.Type)
+		})
+
+		t.Run("With composite agg without fields", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [{ "id": "2", "type": "composite", "settings": { "fields": [] } }],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, from, to)
+			require.ErrorContains(t, err, "missing required fields")
+			require.Empty(t, c.multisearchRequests)
+		})
+
+		t.Run("With date range agg", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{
+						"id": "3",
+						"type": "date_range",
+						"settings": {
+							"timeZone": "Europe/Paris",
+							"ranges": [
+								{ "to": "now-1d/d" },
+								{ "from": "now-1d/d", "key": "today" }
+							]
+						}
+					}
+				],
+				"metrics": [{ "type": "stats", "id": "1", "field": "bytes" }]
+			}`, from, to)
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+
+			firstLevel := sr.Aggs[0]
+			require.Equal(t, "date_range", firstLevel.Aggregation.Type)
+			dateRangeAgg := firstLevel.Aggregation.Aggregation.(*es.DateRangeAggregation)
+			require.Equal(t, "@timestamp", dateRangeAgg.Field)
+			require.Equal(t, "Europe/Paris", dateRangeAgg.TimeZone)
+			require.False(t, dateRangeAgg.Keyed)
+			require.Equal(t, []*es.AggRange{
+				{To: "now-1d/d"},
+				{Key: "today", From: "now-1d/d"},
+			}, dateRangeAgg.Ranges)
+			require.Equal(t, "stats", firstLevel.Aggregation.Aggs[0].Aggregation.Type)
+		})
+
+		t.Run("With date range agg having an invalid bound", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"bucketAggs": [
+					{ "id": "3", "type": "date_range", "settings": { "ranges": [{ "from": "now-1 day" }] } }
+				],
+				"metrics": [{ "type": "count", "id": "1" }]
+			}`, from, to)
+			require.ErrorContains(t, err, "invalid date range bound 'now-1 day'")
+			require.Empty(t, c.multisearchRequests)
+		})
+
 		t.Run("With moving average (from frontend tests)", func(t *testing.T) {
 			c := newFakeClient()
 			_, err := executeElasticsearchDataQuery(c, `{
@@ -1335,6 +1681,7 @@ func TestExecuteElasticsearchDataQuery(t *testing.T) {
 			require.Equal(t, rangeFilter.Lte, "2018-05-15T17:55:00Z")
 			require.Equal(t, rangeFilter.Gte, "2018-05-15T17:50:00Z")
 			require.Equal(t, sr.Sort[0]["@timestamp"]["order"], "desc")
+			require.Equal(t, sr.Sort[1]["_doc"]["order"], "desc")
 		})
 
 		t.Run("With log query with limit should return query with correct size", func(t *testing.T) {
@@ -1347,6 +1694,19 @@ func TestExecuteElasticsearchDataQuery(t *testing.T) {
 			require.Equal(t, sr.Size, 1000)
 		})
 
+		t.Run("With raw data query with cursor should return the next page", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"metrics": [{ "type": "raw_data", "id": "1", "settings": { "size": "50", "sortDirection": "asc", "searchAfter": [1526406600000000000, 12] }}]
+			}`, from, to)
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+			require.Equal(t, sr.Size, 50)
+			require.Equal(t, sr.Sort[0]["@timestamp"]["order"], "asc")
+			require.Equal(t, sr.Sort[1]["_doc"]["order"], "asc")
+			require.Len(t, sr.CustomProps["search_after"], 2)
+		})
+
 		t.Run("With traces query should return query sorted by ascending time and apply the picker range", func(t *testing.T) {
 			c := newFakeClient()
 			_, err := executeElasticsearchDataQuery(c, `{
@@ -1428,6 +1788,41 @@ func TestExecuteElasticsearchDataQuery(t *testing.T) {
 			require.Equal(t, `service_name:"checkout" AND span_name:"GET /checkout" AND (span_status.code:Error OR span_status.code:ERROR OR span_status.code:error OR span_status.code:STATUS_CODE_ERROR OR span_status.code:2 OR span_attributes.error:true OR span_attributes.otel.status_code:ERROR) AND span_duration_millis:>=100 AND span_duration_millis:<=1200`, traceSearchFilter.Query)
 		})
 
+		t.Run("With logs volume query", func(t *testing.T) {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQuery(c, `{
+				"query": "service:api",
+				"metrics": [{ "type": "logs_volume", "id": "1" }]
+			}`, from, to)
+			require.NoError(t, err)
+			sr := c.multisearchRequests[0][0]
+
+			require.Equal(t, 0, sr.Size)
+			require.Len(t, sr.Aggs, 2)
+			levelAgg := sr.Aggs[0]
+			require.Equal(t, "level", levelAgg.Key)
+			termsAgg := levelAgg.Aggregation.Aggregation.(*es.TermsAggregation)
+			require.Equal(t, "lvl", termsAgg.Field)
+			require.Equal(t, logsVolumeLevelsSize, termsAgg.Size)
+			require.Equal(t, "desc", termsAgg.Order["_count"])
+			// The documents without level are counted in the unknown level
+			require.Equal(t, "unknown", *termsAgg.Missing)
+
+			histogramAgg := levelAgg.Aggregation.Aggs[0]
+			require.Equal(t, "volume", histogramAgg.Key)
+			dateHistogram := histogramAgg.Aggregation.Aggregation.(*es.DateHistogramAgg)
+			require.Equal(t, "@timestamp", dateHistogram.Field)
+			require.Equal(t, "$__interval_msms", dateHistogram.FixedInterval)
+			require.Equal(t, 0, dateHistogram.MinDocCount)
+			require.Equal(t, fromMs, dateHistogram.ExtendedBounds.Min)
+			require.Equal(t, toMs, dateHistogram.ExtendedBounds.Max)
+
+			// The volume of all the levels counts the levels beyond the terms size
+			totalAgg := sr.Aggs[1]
+			require.Equal(t, "volume_total", totalAgg.Key)
+			require.Equal(t, dateHistogram, totalAgg.Aggregation.Aggregation)
+		})
+
 		t.Run("With invalid query should return error", (func(t *testing.T) {
 			c := newFakeClient()
 			_, err := executeElasticsearchDataQuery(c, `{
@@ -1774,9 +2169,9 @@ func newFakeClient() *fakeClient {
 	}
 }
 
-func (c *fakeClient) ExecuteMultisearch(r []*es.SearchRequest) ([]*json.RawMessage, error) {
+func (c *fakeClient) ExecuteMultisearch(r []*es.SearchRequest) (*es.MultiSearchResponse, error) {
 	c.multisearchRequests = append(c.multisearchRequests, r)
-	return c.multiSearchResponse.Responses, c.multiSearchError
+	return c.multiSearchResponse, c.multiSearchError
 }
 
 func newDataQuery(body string) (backend.QueryDataRequest, error) {
@@ -1815,18 +2210,77 @@ func executeElasticsearchDataQuery(c es.Client, body string, from, to time.Time)
 	if err != nil {
 		return nil, err
 	}
-	req, err := buildMSR(queries, configuredFields.TimeField, "")
+	req, err := buildMSR(queries, configuredFields, "")
 	if err != nil {
 		return &backend.QueryDataResponse{}, err
 	}
 
 	res, err := c.ExecuteMultisearch(req)
-	result, err := handleQuickwitErrors(err)
-	if result != nil {
-		return result, nil
-	} else if err != nil {
-		return &backend.QueryDataResponse{}, err
+	if err != nil {
+		return handleMultisearchError(c, req, queries, configuredFields, nil, err)
 	}
 
 	return parseResponse(res, queries, configuredFields, nil)
 }
+
+// executeElasticsearchDataQueries runs the queries, keyed by refId, through
+// the whole query flow with the given client. The datasource info defaults to
+// the configured fields of executeElasticsearchDataQuery.
+func executeElasticsearchDataQueries(c es.Client, bodies map[string]string, from, to time.Time, dsInfo *es.DatasourceInfo, incremental *incrementalQueryCache) (*backend.QueryDataResponse, error) {
+	if dsInfo == nil {
+		dsInfo = &es.DatasourceInfo{
+			ConfiguredFields: es.ConfiguredFields{
+				TimeField:       "@timestamp",
+				LogMessageField: "line",
+				LogLevelField:   "lvl",
+			},
+		}
+	}
+
+	refIDs := make([]string, 0, len(bodies))
+	for refID := range bodies {
+		refIDs = append(refIDs, refID)
+	}
+	sort.Strings(refIDs)
+
+	dataQueries := make([]backend.DataQuery, 0, len(bodies))
+	for _, refID := range refIDs {
+		dataQueries = append(dataQueries, backend.DataQuery{
+			RefID:     refID,
+			JSON:      json.RawMessage(bodies[refID]),
+			TimeRange: backend.TimeRange{From: from, To: to},
+		})
+	}
+
+	return queryDataWithClient(context.Background(), c, dataQueries, dsInfo, incremental)
+}
+
+func TestIsValidDateRangeBound(t *testing.T) {
+	for _, tc := range []struct {
+		bound     interface{}
+		hasFormat bool
+		valid     bool
+	}{
+		{nil, false, true},
+		{"", false, true},
+		{1700000000000, false, true},
+		{"1700000000000", false, true},
+		{"now", false, true},
+		{"now-1d/d", false, true},
+		{"now+2H-30m/h", false, true},
+		{"now-1M/M", false, true},
+		{"2024-01-01", false, true},
+		{"2024-01-01T12:00:00Z", false, true},
+		{"2024-01-01||+1M/d", false, true},
+		{"01/01/2024", true, true},
+		{"now-1 day", false, false},
+		{"now-1x", false, false},
+		{"now/q", false, false},
+		{"yesterday", false, false},
+		{"01/01/2024", false, false},
+		{"2024-01-01||-1", false, false},
+		{true, false, false},
+	} {
+		assert.Equal(t, tc.valid, isValidDateRangeBound(simplejson.NewFromAny(tc.bound), tc.hasFormat), "%v", tc.bound)
+	}
+}
@@ -2,7 +2,7 @@ package quickwit
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -10,9 +10,20 @@ import (
 	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
 )
 
-// separate function to allow testing the whole transformation and query flow
-func queryData(ctx context.Context, dataQueries []backend.DataQuery, dsInfo *es.DatasourceInfo) (*backend.QueryDataResponse, error) {
+// separate function to allow testing the whole transformation and query flow.
+// The date histogram queries are fetched incrementally when incremental is set.
+func queryData(ctx context.Context, dataQueries []backend.DataQuery, dsInfo *es.DatasourceInfo, incremental *incrementalQueryCache) (*backend.QueryDataResponse, error) {
+	// Create a client
+	client, err := es.NewClient(ctx, dsInfo)
+	if err != nil {
+		return &backend.QueryDataResponse{}, err
+	}
+
+	return queryDataWithClient(ctx, client, dataQueries, dsInfo, incremental)
+}
 
+// queryDataWithClient runs the query flow of queryData with the given client
+func queryDataWithClient(ctx context.Context, client es.Client, dataQueries []backend.DataQuery, dsInfo *es.DatasourceInfo, incremental *incrementalQueryCache) (*backend.QueryDataResponse, error) {
 	// First validate and parse
 	if len(dataQueries) == 0 {
 		return &backend.QueryDataResponse{}, fmt.Errorf("query contains no queries")
@@ -22,48 +33,147 @@ func queryData(ctx context.Context, dataQueries []backend.DataQuery, dsInfo *es.
 	if err != nil {
 		return nil, err
 	}
+	variableQueries, queries := splitVariableQueries(queries)
+
+	result := &backend.QueryDataResponse{Responses: backend.Responses{}}
+	if len(variableQueries) > 0 {
+		result.Responses = queryVariables(ctx, client, variableQueries, dsInfo)
+	}
+	if len(queries) == 0 {
+		return result, nil
+	}
+
+	if dsInfo.ResponseCache != nil {
+		for _, q := range queries {
+			alignQueryRange(q)
+		}
+	}
+
+	if dsInfo.SearchTimeout > 0 {
+		for _, q := range queries {
+			if q.Timeout == 0 {
+				q.Timeout = dsInfo.SearchTimeout
+			}
+		}
+	}
+
+	if dsInfo.RangeShards > 1 {
+		for _, q := range queries {
+			q.RangeShards = dsInfo.RangeShards
+		}
+	}
+
+	var incrementalQueries map[string]*incrementalQuery
+	if incremental != nil {
+		incrementalQueries = incremental.prepare(queries, dsInfo)
+	}
 
 	// Create a request
-	// NODE : Params should probably be assembled in a dedicated structure to be reused by parseResponse
-	req, err := buildMSR(queries, dsInfo.ConfiguredFields.TimeField, dsInfo.ForcedQueryFilter)
-	if err != nil {
-		return &backend.QueryDataResponse{}, err
+	queries, req := buildQueryRequests(queries, dsInfo, result)
+	if len(queries) == 0 {
+		return result, nil
 	}
 
-	// Create a client and execute request
-	client, err := es.NewClient(ctx, dsInfo)
+	// Execute request
+	var queriesResult *backend.QueryDataResponse
+	res, err := executeQueries(client, req, queries, dsInfo.ConfiguredFields.SearchTimeField())
 	if err != nil {
-		return &backend.QueryDataResponse{}, err
+		queriesResult, err = handleMultisearchError(client, req, queries, dsInfo.ConfiguredFields, dsInfo, err)
+	} else {
+		queriesResult, err = parseResponse(res, queries, dsInfo.ConfiguredFields, dsInfo)
+	}
+	if err != nil {
+		return queriesResult, err
+	}
+	if incremental != nil {
+		incremental.merge(queriesResult, incrementalQueries)
 	}
-	res, err := client.ExecuteMultisearch(req)
 
-	// TODO : refactor client error handling
-	result, err := handleQuickwitErrors(err)
-	if result != nil {
-		return result, nil
-	} else if err != nil {
-		return &backend.QueryDataResponse{}, err
+	for refID, response := range queriesResult.Responses {
+		result.Responses[refID] = response
 	}
+	return result, nil
+}
 
-	return parseResponse(res, queries, dsInfo.ConfiguredFields, dsInfo)
+// buildQueryRequests builds the search requests of the queries one by one.
+// The error of each invalid query is set on its own data response, so that it
+// does not fail the other queries of the batch. It returns the valid queries
+// and their search requests, in order.
+func buildQueryRequests(queries []*Query, dsInfo *es.DatasourceInfo, result *backend.QueryDataResponse) ([]*Query, []*es.SearchRequest) {
+	valid := make([]*Query, 0, len(queries))
+	requests := make([]*es.SearchRequest, 0, len(queries))
+	for _, q := range queries {
+		req, err := buildMSR([]*Query{q}, dsInfo.ConfiguredFields, dsInfo.ForcedQueryFilter)
+		if err != nil {
+			result.Responses[q.RefID] = backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourcePlugin, err.Error())
+			continue
+		}
+		valid = append(valid, q)
+		requests = append(requests, req...)
+	}
+	return valid, requests
 }
 
-func handleQuickwitErrors(err error) (*backend.QueryDataResponse, error) {
-	if nil == err {
-		return nil, nil
+// handleMultisearchError reports the failure of a multisearch on the data
+// response of each query. When Quickwit rejected the batch, the queries are
+// retried by halves so that a single invalid query does not fail the others.
+func handleMultisearchError(client es.Client, requests []*es.SearchRequest, queries []*Query, configuredFields es.ConfiguredFields, dsInfo *es.DatasourceInfo, err error) (*backend.QueryDataResponse, error) {
+	result := &backend.QueryDataResponse{
+		Responses: backend.Responses{},
 	}
 
-	var payload = err.Error()
-	var qe es.QuickwitQueryError
-	unmarshall_err := json.Unmarshal([]byte(payload), &qe)
-	if unmarshall_err == nil {
-		return nil, err
+	grouped, groupErr := queryRequests(requests, queries, configuredFields.SearchTimeField())
+	if groupErr != nil {
+		for _, q := range queries {
+			result.Responses[q.RefID] = errorDataResponse(err)
+		}
+		return result, nil
 	}
+	isolateQueryErrors(client, grouped, queries, configuredFields, dsInfo, err, result)
+	return result, nil
+}
 
-	result := backend.QueryDataResponse{
-		Responses: backend.Responses{},
+// isolateQueryErrors reports the error of a batch of queries rejected by
+// Quickwit on the queries which caused it. The batch is split in halves which
+// are executed again, the halves rejected again being split in turn, so that
+// a single invalid query among n costs about 2*log2(n) multisearches.
+func isolateQueryErrors(client es.Client, grouped [][]*es.SearchRequest, queries []*Query, configuredFields es.ConfiguredFields, dsInfo *es.DatasourceInfo, err error, result *backend.QueryDataResponse) {
+	var qe *es.QuickwitError
+	if len(queries) < 2 || !errors.As(err, &qe) || !qe.IsQueryError() {
+		for _, q := range queries {
+			result.Responses[q.RefID] = errorDataResponse(err)
+		}
+		return
 	}
 
-	result.Responses["__queryDataError"] = backend.ErrDataResponse(backend.Status(qe.Status), payload)
-	return &result, nil
+	half := len(queries) / 2
+	for _, part := range [][2]int{{0, half}, {half, len(queries)}} {
+		partGrouped, partQueries := grouped[part[0]:part[1]], queries[part[0]:part[1]]
+		var requests []*es.SearchRequest
+		for _, shardRequests := range partGrouped {
+			requests = append(requests, shardRequests...)
+		}
+		res, err := executeQueries(client, requests, partQueries, configuredFields.SearchTimeField())
+		if err != nil {
+			isolateQueryErrors(client, partGrouped, partQueries, configuredFields, dsInfo, err, result)
+			continue
+		}
+		partResult, err := parseResponse(res, partQueries, configuredFields, dsInfo)
+		for _, q := range partQueries {
+			if err != nil {
+				result.Responses[q.RefID] = errorDataResponse(err)
+				continue
+			}
+			result.Responses[q.RefID] = partResult.Responses[q.RefID]
+		}
+	}
+}
+
+// errorDataResponse returns the data response of a query failed by err
+func errorDataResponse(err error) backend.DataResponse {
+	var clientErr es.Error
+	if errors.As(err, &clientErr) {
+		return backend.ErrDataResponseWithSource(clientErr.Status(), clientErr.Source(), clientErr.Error())
+	}
+	return backend.ErrDataResponseWithSource(backend.StatusInternal, backend.ErrorSourcePlugin, err.Error())
 }
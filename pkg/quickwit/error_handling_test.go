@@ -1,8 +1,14 @@
 package quickwit
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
 	"github.com/stretchr/testify/require"
 )
@@ -47,7 +53,10 @@ func TestErrorAvgMissingField(t *testing.T) {
 
 	result, err := queryDataTestWithResponseCode(query, 400, response, configuredFields)
 	require.Nil(t, err)
-	require.Contains(t, result.response.Responses["__queryDataError"].Error.Error(), "\"status\":400")
+	dataResponse := result.response.Responses["A"]
+	require.ErrorContains(t, dataResponse.Error, "Required one of fields [field, script], but none were specified.")
+	require.Equal(t, backend.StatusBadRequest, dataResponse.Status)
+	require.Equal(t, backend.ErrorSourceDownstream, dataResponse.ErrorSource)
 }
 
 func TestErrorAvgMissingFieldNoDetailedErrors(t *testing.T) {
@@ -80,7 +89,9 @@ func TestErrorAvgMissingFieldNoDetailedErrors(t *testing.T) {
 
 	result, err := queryDataTestWithResponseCode(query, 400, response, configuredFields)
 	require.Nil(t, err)
-	require.Contains(t, result.response.Responses["__queryDataError"].Error.Error(), "\"status\":400")
+	dataResponse := result.response.Responses["A"]
+	require.ErrorContains(t, dataResponse.Error, "No ElasticsearchException found")
+	require.Equal(t, backend.StatusBadRequest, dataResponse.Status)
 }
 
 func TestErrorTooManyDateHistogramBuckets(t *testing.T) {
@@ -165,5 +176,284 @@ func TestNonElasticError(t *testing.T) {
 
 	result, err := queryDataTestWithResponseCode(query, 403, response, configuredFields)
 	require.Nil(t, err)
-	require.Contains(t, result.response.Responses["__queryDataError"].Error.Error(), "\"status\":403")
+	dataResponse := result.response.Responses["A"]
+	require.ErrorContains(t, dataResponse.Error, "Access to the database is forbidden")
+	require.Equal(t, backend.StatusForbidden, dataResponse.Status)
+	require.Equal(t, backend.ErrorSourceDownstream, dataResponse.ErrorSource)
+}
+
+// batchRejectingClient rejects the multisearch requests containing an invalid
+// query, like Quickwit rejects a whole batch
+type batchRejectingClient struct {
+	calls int
+}
+
+func (c *batchRejectingClient) ExecuteMultisearch(r []*es.SearchRequest) (*es.MultiSearchResponse, error) {
+	c.calls++
+	responses := make([]*json.RawMessage, 0, len(r))
+	for _, request := range r {
+		body, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(string(body), "invalid:") {
+			return nil, es.NewQuickwitError(400, []byte(`{"message": "failed to parse query"}`), "my-index", r)
+		}
+		response := json.RawMessage(`{"hits": {"hits": []}, "aggregations": {"2": {"buckets": [{"key": 1000, "doc_count": 3}]}}}`)
+		responses = append(responses, &response)
+	}
+	return &es.MultiSearchResponse{Responses: responses}, nil
+}
+
+func TestErrorIsolatedToFailingQuery(t *testing.T) {
+	dataQueries := []backend.DataQuery{}
+	for refID, query := range map[string]string{"A": "*", "B": "invalid:"} {
+		dataQueries = append(dataQueries, backend.DataQuery{
+			RefID: refID,
+			JSON: json.RawMessage(`{
+				"query": "` + query + `",
+				"metrics": [{ "type": "count", "id": "1" }],
+				"bucketAggs": [{ "type": "date_histogram", "field": "@timestamp", "id": "2" }]
+			}`),
+			TimeRange: backend.TimeRange{From: time.UnixMilli(1000), To: time.UnixMilli(2000)},
+		})
+	}
+	queries, err := parseQuery(dataQueries)
+	require.NoError(t, err)
+	configuredFields := es.ConfiguredFields{TimeField: "@timestamp"}
+	requests, err := buildMSR(queries, configuredFields, "")
+	require.NoError(t, err)
+
+	c := &batchRejectingClient{}
+	_, err = c.ExecuteMultisearch(requests)
+	require.Error(t, err)
+
+	result, err := handleMultisearchError(c, requests, queries, configuredFields, nil, err)
+	require.NoError(t, err)
+	require.Equal(t, 3, c.calls)
+	require.Len(t, result.Responses, 2)
+
+	require.NoError(t, result.Responses["A"].Error)
+	require.Len(t, result.Responses["A"].Frames, 1)
+
+	require.ErrorContains(t, result.Responses["B"].Error, "failed to parse query")
+	require.Equal(t, backend.StatusBadRequest, result.Responses["B"].Status)
+}
+
+func TestErrorIsolatedToFailingQueryAmongMany(t *testing.T) {
+	dataQueries := []backend.DataQuery{}
+	for i := 0; i < 16; i++ {
+		query := "*"
+		if i == 11 {
+			query = "invalid:"
+		}
+		dataQueries = append(dataQueries, backend.DataQuery{
+			RefID: fmt.Sprintf("Q%d", i),
+			JSON: json.RawMessage(`{
+				"query": "` + query + `",
+				"metrics": [{ "type": "count", "id": "1" }],
+				"bucketAggs": [{ "type": "date_histogram", "field": "@timestamp", "id": "2" }]
+			}`),
+			TimeRange: backend.TimeRange{From: time.UnixMilli(1000), To: time.UnixMilli(2000)},
+		})
+	}
+	queries, err := parseQuery(dataQueries)
+	require.NoError(t, err)
+	configuredFields := es.ConfiguredFields{TimeField: "@timestamp"}
+	requests, err := buildMSR(queries, configuredFields, "")
+	require.NoError(t, err)
+
+	c := &batchRejectingClient{}
+	_, err = c.ExecuteMultisearch(requests)
+	require.Error(t, err)
+
+	// The halves holding the invalid query are split again, the others succeed
+	result, err := handleMultisearchError(c, requests, queries, configuredFields, nil, err)
+	require.NoError(t, err)
+	require.Equal(t, 9, c.calls)
+	require.Len(t, result.Responses, 16)
+	for refID, res := range result.Responses {
+		if refID == "Q11" {
+			require.ErrorContains(t, res.Error, "failed to parse query")
+			continue
+		}
+		require.NoError(t, res.Error, refID)
+		require.Len(t, res.Frames, 1, refID)
+	}
+}
+
+func TestErrorIsolatedToInvalidQuery(t *testing.T) {
+	query := []byte(`
+	[
+		{
+			"refId": "A",
+			"metrics": [{ "type": "count", "id": "1" }],
+			"bucketAggs": [{ "type": "date_histogram", "field": "testtime", "id": "2" }]
+		},
+		{
+			"refId": "B",
+			"metrics": [{ "type": "count", "id": "1" }],
+			"bucketAggs": [{ "type": "date_histogram", "field": "testtime", "id": "2" }, { "type": "terms", "id": "3" }]
+		}
+	]
+	`)
+
+	response := []byte(`
+	{
+		"responses": [
+			{
+				"aggregations": { "2": { "buckets": [{ "key": 1000, "doc_count": 3 }] } },
+				"hits": { "hits": [] }
+			}
+		]
+	}
+	`)
+
+	result, err := queryDataTest(query, response)
+	require.NoError(t, err)
+	require.Len(t, result.response.Responses, 2)
+
+	require.NoError(t, result.response.Responses["A"].Error)
+	require.Len(t, result.response.Responses["A"].Frames, 1)
+
+	require.ErrorContains(t, result.response.Responses["B"].Error, "missing required field")
+	require.Equal(t, backend.StatusBadRequest, result.response.Responses["B"].Status)
+	require.Equal(t, backend.ErrorSourcePlugin, result.response.Responses["B"].ErrorSource)
+	require.Equal(t, 1, strings.Count(string(result.requestBytes), `"size"`))
+}
+
+func TestErrorIsolatedToDateHistogramWithoutTimeField(t *testing.T) {
+	query := []byte(`
+	[
+		{
+			"refId": "A",
+			"metrics": [{ "type": "count", "id": "1" }],
+			"bucketAggs": [{ "type": "terms", "field": "host", "id": "2" }]
+		},
+		{
+			"refId": "B",
+			"metrics": [{ "type": "count", "id": "1" }],
+			"bucketAggs": [{ "type": "date_histogram", "id": "2" }]
+		}
+	]
+	`)
+
+	response := []byte(`
+	{
+		"responses": [
+			{
+				"aggregations": { "2": { "buckets": [{ "key": "server1", "doc_count": 3 }] } },
+				"hits": { "hits": [] }
+			}
+		]
+	}
+	`)
+
+	result, err := queryDataTestWithResponseCode(query, 200, response, es.ConfiguredFields{})
+	require.NoError(t, err)
+	require.Len(t, result.response.Responses, 2)
+
+	require.NoError(t, result.response.Responses["A"].Error)
+	require.ErrorContains(t, result.response.Responses["B"].Error, "has no field specified")
+	require.Equal(t, backend.StatusBadRequest, result.response.Responses["B"].Status)
+	require.Equal(t, 1, strings.Count(string(result.requestBytes), `"aggs"`))
+}
+
+func TestErrorIsolatedToInvalidTimeout(t *testing.T) {
+	query := []byte(`
+	[
+		{
+			"refId": "A",
+			"metrics": [{ "type": "count", "id": "1" }],
+			"bucketAggs": [{ "type": "date_histogram", "field": "testtime", "id": "2" }]
+		},
+		{
+			"refId": "B",
+			"timeout": "soon",
+			"metrics": [{ "type": "count", "id": "1" }],
+			"bucketAggs": [{ "type": "date_histogram", "field": "testtime", "id": "2" }]
+		}
+	]
+	`)
+
+	response := []byte(`
+	{
+		"responses": [
+			{
+				"aggregations": { "2": { "buckets": [{ "key": 1000, "doc_count": 3 }] } },
+				"hits": { "hits": [] }
+			}
+		]
+	}
+	`)
+
+	result, err := queryDataTest(query, response)
+	require.NoError(t, err)
+	require.Len(t, result.response.Responses, 2)
+
+	require.NoError(t, result.response.Responses["A"].Error)
+	require.Len(t, result.response.Responses["A"].Frames, 1)
+
+	require.ErrorContains(t, result.response.Responses["B"].Error, `invalid query timeout "soon"`)
+	require.Equal(t, backend.StatusBadRequest, result.response.Responses["B"].Status)
+}
+
+func TestErrorWithoutQuickwitResponse(t *testing.T) {
+	queries, err := parseQuery([]backend.DataQuery{
+		{RefID: "A", JSON: json.RawMessage(`{ "metrics": [{ "type": "count", "id": "1" }] }`)},
+		{RefID: "B", JSON: json.RawMessage(`{ "metrics": [{ "type": "count", "id": "1" }] }`)},
+	})
+	require.NoError(t, err)
+
+	result, err := handleMultisearchError(nil, nil, queries, es.ConfiguredFields{}, nil, &es.TransportError{Err: errors.New("connection refused")})
+	require.NoError(t, err)
+	for _, refID := range []string{"A", "B"} {
+		require.ErrorContains(t, result.Responses[refID].Error, "connection refused")
+		require.Equal(t, backend.StatusBadGateway, result.Responses[refID].Status)
+		require.Equal(t, backend.ErrorSourceDownstream, result.Responses[refID].ErrorSource)
+	}
+}
+
+func TestErrorIsolatedToFailingResponse(t *testing.T) {
+	dataQueries := []backend.DataQuery{}
+	for _, refID := range []string{"A", "B", "C", "D"} {
+		dataQueries = append(dataQueries, backend.DataQuery{
+			RefID: refID,
+			JSON: json.RawMessage(`{
+				"metrics": [{ "type": "count", "id": "1" }],
+				"bucketAggs": [{ "type": "date_histogram", "field": "@timestamp", "id": "2" }]
+			}`),
+			TimeRange: backend.TimeRange{From: time.UnixMilli(1000), To: time.UnixMilli(2000)},
+		})
+	}
+	queries, err := parseQuery(dataQueries)
+	require.NoError(t, err)
+
+	var response es.MultiSearchResponse
+	err = json.Unmarshal([]byte(`{
+		"responses": [
+			{ "aggregations": { "2": { "buckets": [{ "key": 1000, "doc_count": 3 }] } }, "status": 200 },
+			{ "aggregations": "not an object", "status": 200 },
+			{ "error": { "reason": "index not found" }, "status": 404 },
+			{ "aggregations": { "2": { "buckets": [{ "key": "not a timestamp", "doc_count": 3 }] } }, "status": 200 }
+		]
+	}`), &response)
+	require.NoError(t, err)
+
+	result, err := parseResponse(&response, queries, es.ConfiguredFields{TimeField: "@timestamp"}, nil)
+	require.NoError(t, err)
+	require.Len(t, result.Responses, 4)
+
+	require.NoError(t, result.Responses["A"].Error)
+	require.Len(t, result.Responses["A"].Frames, 1)
+
+	require.ErrorContains(t, result.Responses["B"].Error, "failed to decode quickwit response")
+	require.Equal(t, backend.ErrorSourcePlugin, result.Responses["B"].ErrorSource)
+
+	require.ErrorContains(t, result.Responses["C"].Error, "index not found")
+	require.Equal(t, backend.StatusNotFound, result.Responses["C"].Status)
+	require.Equal(t, backend.ErrorSourceDownstream, result.Responses["C"].ErrorSource)
+
+	require.Error(t, result.Responses["D"].Error)
+	require.Equal(t, backend.StatusInternal, result.Responses["D"].Status)
 }
@@ -0,0 +1,360 @@
+package quickwit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+)
+
+const (
+	// Default time before the end of the cached range that is fetched again,
+	// to include the documents indexed late
+	defaultIncrementalQueryOverlap = 10 * time.Minute
+	// Maximum number of queries whose series are remembered
+	maxIncrementalQueryEntries = 1000
+)
+
+// incrementalQueryCache remembers the time series of the date histogram
+// queries by query fingerprint, so that a refresh only fetches the buckets
+// after the last complete bucket and merges them with the remembered ones
+type incrementalQueryCache struct {
+	overlap time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*incrementalQueryEntry
+}
+
+// incrementalQueryEntry holds the series of a query over a time range, in milliseconds
+type incrementalQueryEntry struct {
+	from   int64
+	to     int64
+	frames data.Frames
+	usedAt time.Time
+}
+
+// incrementalQuery is a query whose range may have been reduced to the
+// buckets missing from the cache
+type incrementalQuery struct {
+	fingerprint string
+	// from and to are the time range requested, in milliseconds
+	from int64
+	to   int64
+	// bucketFrom is the start of the first bucket of the requested range
+	bucketFrom int64
+	// tailFrom is the start of the fetched range when cached is set
+	tailFrom int64
+	cached   *incrementalQueryEntry
+}
+
+func newIncrementalQueryCache(overlap time.Duration) *incrementalQueryCache {
+	if overlap < 0 {
+		overlap = defaultIncrementalQueryOverlap
+	}
+	return &incrementalQueryCache{
+		overlap: overlap,
+		entries: map[string]*incrementalQueryEntry{},
+	}
+}
+
+// incrementalBucketInterval returns the interval of the date histogram of
+// the queries whose buckets can be fetched incrementally. These queries end
+// with a date histogram with fixed bucket boundaries, their buckets do not
+// depend on the previous ones nor on the documents of the whole time range.
+// Only the time series of the metric queries are merged with the cached ones,
+// the other queries keep their bucket aggregations but return other frames.
+func incrementalBucketInterval(q *Query) (time.Duration, bool) {
+	if q.DisableCache || isAnnotationsQuery(q) || len(q.BucketAggs) == 0 || len(q.Metrics) == 0 {
+		return 0, false
+	}
+	if isLogsQuery(q) || isLogsVolumeQuery(q) || isTracesQuery(q) || isTraceSearchQuery(q) || isDocumentQuery(q) {
+		return 0, false
+	}
+	for i, agg := range q.BucketAggs {
+		if (agg.Type == dateHistType) != (i == len(q.BucketAggs)-1) || bucketsDependOnRange(agg) {
+			return 0, false
+		}
+	}
+	for _, m := range q.Metrics {
+		if isPipelineAgg(m.Type) {
+			return 0, false
+		}
+	}
+
+	return dateHistogramInterval(q, q.BucketAggs[len(q.BucketAggs)-1])
+}
+
+// bucketsDependOnRange returns true when the buckets of a bucket aggregation
+// are chosen among the documents of the whole time range, so that the buckets
+// of two parts of the range cannot be merged. The terms aggregations always
+// have a size, they keep the top terms of the range. The histogram buckets
+// with a minimum document count keep the values frequent over the range.
+func bucketsDependOnRange(agg *BucketAgg) bool {
+	switch agg.Type {
+	case dateHistType, filtersType, rangeType, dateRangeType, nestedType:
+		return false
+	case histogramType:
+		minDocCount, err := castToInt(agg.Settings.Get("min_doc_count"))
+		return err == nil && minDocCount > 1
+	default:
+		return true
+	}
+}
+
+// dateHistogramInterval returns the interval of a date histogram whose
+// buckets start on multiples of the interval, without offset nor trimmed edges.
+// The interval is at least a millisecond, the unit of the time ranges.
+func dateHistogramInterval(q *Query, agg *BucketAgg) (time.Duration, bool) {
+	settings := agg.Settings
+	if trimEdges, err := castToInt(settings.Get("trimEdges")); err == nil && trimEdges > 0 {
+		return 0, false
+	}
+	if settings.Get("offset").MustString() != "" {
+		return 0, false
+	}
+	// The calendar buckets and the buckets of another time zone start on the
+	// days of their time zone
+	if _, ok := calendarInterval(settings); ok || !isUTCTimeZone(settings.Get("timeZone").MustString()) {
+		return 0, false
+	}
+	interval := settings.Get("interval").MustString("auto")
+	if interval == "auto" {
+		return q.Interval, q.Interval >= time.Millisecond
+	}
+	d, err := gtime.ParseDuration(interval)
+	if err != nil || d < time.Millisecond {
+		return 0, false
+	}
+	return d, true
+}
+
+// queryFingerprint identifies a query of a user regardless of its time range
+func queryFingerprint(q *Query, dsInfo *es.DatasourceInfo) (string, error) {
+	withoutRange := *q
+	withoutRange.RangeFrom = 0
+	withoutRange.RangeTo = 0
+	body, err := json.Marshal(struct {
+		Query             *Query
+		Interval          time.Duration
+		ForcedQueryFilter string
+		ForwardedIdentity string
+	}{&withoutRange, q.Interval, dsInfo.ForcedQueryFilter, dsInfo.ForwardedIdentity})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// prepare reduces the range of the queries having cached series to the
+// buckets after the last complete cached bucket, minus the overlap window.
+// It must be called before buildMSR, which changes the metric settings the
+// fingerprint of the query is computed from.
+func (c *incrementalQueryCache) prepare(queries []*Query, dsInfo *es.DatasourceInfo) map[string]*incrementalQuery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prepared := map[string]*incrementalQuery{}
+	for _, q := range queries {
+		interval, ok := incrementalBucketInterval(q)
+		if !ok {
+			continue
+		}
+		fingerprint, err := queryFingerprint(q, dsInfo)
+		if err != nil {
+			continue
+		}
+		intervalMs := interval.Milliseconds()
+		inc := &incrementalQuery{
+			fingerprint: fingerprint,
+			from:        q.RangeFrom,
+			to:          q.RangeTo,
+			bucketFrom:  q.RangeFrom - q.RangeFrom%intervalMs,
+		}
+		prepared[q.RefID] = inc
+
+		entry, ok := c.entries[fingerprint]
+		if !ok || entry.from > q.RangeFrom || entry.to <= q.RangeFrom || entry.to > q.RangeTo {
+			continue
+		}
+		tailFrom := entry.to - c.overlap.Milliseconds()
+		tailFrom -= tailFrom % intervalMs
+		if tailFrom <= q.RangeFrom {
+			continue
+		}
+		entry.usedAt = time.Now()
+		inc.cached = entry
+		inc.tailFrom = tailFrom
+		q.RangeFrom = tailFrom
+		qwlog.Debug("Fetching the tail of an incremental query", "refId", q.RefID, "from", tailFrom, "to", q.RangeTo)
+	}
+	return prepared
+}
+
+// merge completes the responses of the reduced queries with their cached
+// series, then remembers the series of the successful queries
+func (c *incrementalQueryCache) merge(result *backend.QueryDataResponse, prepared map[string]*incrementalQuery) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for refID, inc := range prepared {
+		res, ok := result.Responses[refID]
+		if !ok || res.Error != nil {
+			delete(c.entries, inc.fingerprint)
+			continue
+		}
+		if isTimedOutResponse(res) {
+			// The partial series are not remembered, but the cached ones are
+			// still valid
+			if inc.cached != nil {
+				res.Frames = mergeIncrementalFrames(inc.cached.frames, res.Frames, inc.bucketFrom, inc.tailFrom)
+				result.Responses[refID] = res
+			}
+			continue
+		}
+		if inc.cached != nil {
+			res.Frames = mergeIncrementalFrames(inc.cached.frames, res.Frames, inc.bucketFrom, inc.tailFrom)
+			result.Responses[refID] = res
+		}
+		c.entries[inc.fingerprint] = &incrementalQueryEntry{
+			from:   inc.from,
+			to:     inc.to,
+			frames: copyFrames(res.Frames),
+			usedAt: time.Now(),
+		}
+	}
+
+	for len(c.entries) > maxIncrementalQueryEntries {
+		var oldest string
+		for fingerprint, entry := range c.entries {
+			if oldest == "" || entry.usedAt.Before(c.entries[oldest].usedAt) {
+				oldest = fingerprint
+			}
+		}
+		delete(c.entries, oldest)
+	}
+}
+
+// seriesKey identifies a time series frame among the frames of a query
+func seriesKey(frame *data.Frame) string {
+	if len(frame.Fields) < 2 {
+		return ""
+	}
+	valueField := frame.Fields[1]
+	key := valueField.Name + "\x00" + valueField.Labels.String()
+	if valueField.Config != nil {
+		key += "\x00" + valueField.Config.DisplayNameFromDS
+	}
+	return key
+}
+
+// mergeIncrementalFrames prepends the cached points from the first bucket of
+// the range to the start of the tail to the series of the tail. The series
+// missing from the tail keep their cached points.
+func mergeIncrementalFrames(cached data.Frames, tail data.Frames, bucketFrom int64, tailFrom int64) data.Frames {
+	cachedByKey := map[string]*data.Frame{}
+	for _, frame := range cached {
+		cachedByKey[seriesKey(frame)] = frame
+	}
+
+	merged := make(data.Frames, 0, len(tail))
+	seen := map[string]bool{}
+	for _, frame := range tail {
+		key := seriesKey(frame)
+		seen[key] = true
+		cachedFrame, ok := cachedByKey[key]
+		if !ok || frame.Meta == nil || frame.Meta.Type != data.FrameTypeTimeSeriesMulti {
+			merged = append(merged, frame)
+			continue
+		}
+		merged = append(merged, mergeSeries(cachedFrame, frame, bucketFrom, tailFrom))
+	}
+	for _, frame := range cached {
+		key := seriesKey(frame)
+		if seen[key] || frame.Meta == nil || frame.Meta.Type != data.FrameTypeTimeSeriesMulti {
+			continue
+		}
+		merged = append(merged, mergeSeries(frame, nil, bucketFrom, tailFrom))
+	}
+	return merged
+}
+
+// mergeSeries returns a time series frame with the points of the cached frame
+// between bucketFrom and tailFrom followed by the points of the tail frame
+func mergeSeries(cached *data.Frame, tail *data.Frame, bucketFrom int64, tailFrom int64) *data.Frame {
+	model := cached
+	if tail != nil {
+		model = tail
+	}
+	fields := make([]*data.Field, len(model.Fields))
+	for i, f := range model.Fields {
+		fields[i] = data.NewFieldFromFieldType(f.Type(), 0)
+		fields[i].Name = f.Name
+		fields[i].Labels = f.Labels
+		fields[i].Config = f.Config
+	}
+
+	timeField := cached.Fields[0]
+	for row := 0; row < cached.Rows(); row++ {
+		t, ok := timeField.ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		ms := t.(time.Time).UnixMilli()
+		if ms < bucketFrom || ms >= tailFrom {
+			continue
+		}
+		for i := range fields {
+			fields[i].Append(cached.Fields[i].At(row))
+		}
+	}
+	if tail != nil {
+		for row := 0; row < tail.Rows(); row++ {
+			for i := range fields {
+				fields[i].Append(tail.Fields[i].At(row))
+			}
+		}
+	}
+
+	frame := data.NewFrame(model.Name, fields...)
+	frame.RefID = model.RefID
+	frame.Meta = copyFrameMeta(model.Meta)
+	return frame
+}
+
+// copyFrames returns copies of the frames sharing their fields but not their
+// metadata, so that the statistics and notices added to the frames of a
+// response do not pile up on the cached ones
+func copyFrames(frames data.Frames) data.Frames {
+	copied := make(data.Frames, len(frames))
+	for i, frame := range frames {
+		f := *frame
+		f.Meta = copyFrameMeta(frame.Meta)
+		copied[i] = &f
+	}
+	return copied
+}
+
+// copyFrameMeta returns a copy of the metadata of a frame with its own
+// statistics, notices and custom metadata
+func copyFrameMeta(meta *data.FrameMeta) *data.FrameMeta {
+	if meta == nil {
+		return nil
+	}
+	copied := *meta
+	copied.Stats = slices.Clone(meta.Stats)
+	copied.Notices = slices.Clone(meta.Notices)
+	if custom, ok := meta.Custom.(map[string]interface{}); ok {
+		copied.Custom = maps.Clone(custom)
+	}
+	return &copied
+}
@@ -0,0 +1,200 @@
+package quickwit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+)
+
+// minuteBuckets returns the date histogram buckets of a minute in [from, to)
+func minuteBuckets(from, to time.Duration, docCount int) string {
+	buckets := []string{}
+	for m := from; m < to; m += time.Minute {
+		buckets = append(buckets, fmt.Sprintf(`{ "key": %d, "doc_count": %d }`, m.Milliseconds(), docCount))
+	}
+	return "[" + strings.Join(buckets, ", ") + "]"
+}
+
+// searchedFrom returns the start of the time range of a search request
+func searchedFrom(sr *es.SearchRequest) string {
+	return sr.Query.Bool.Filters[0].(*es.DateRangeFilter).Gte
+}
+
+func TestIncrementalQuery(t *testing.T) {
+	const histogramQuery = `{
+		"metrics": [{ "type": "count", "id": "1" }],
+		"bucketAggs": [
+			{ "type": "filters", "id": "3", "settings": { "filters": [{ "query": "host:web-1" }, { "query": "host:web-2" }] } },
+			{ "type": "date_histogram", "id": "2", "settings": { "interval": "1m" } }
+		]
+	}`
+	minutes := func(d time.Duration) time.Time {
+		return time.UnixMilli(d.Milliseconds())
+	}
+	newResponse := func(t *testing.T, c *fakeClient, body string) {
+		t.Helper()
+		c.multiSearchResponse = &es.MultiSearchResponse{}
+		require.NoError(t, json.Unmarshal([]byte(body), c.multiSearchResponse))
+	}
+
+	t.Run("Only the date histogram queries with stable buckets are incremental", func(t *testing.T) {
+		for model, expected := range map[string]bool{
+			histogramQuery: true,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "1m" } }] }`:                                                                                             true,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2" }] }`:                                                                                                                               false,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "terms", "field": "host", "id": "2" }] }`:                                                                                                                       false,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "terms", "field": "host", "id": "3" }, { "type": "date_histogram", "id": "2", "settings": { "interval": "1m" } }] }`:                                            false,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "histogram", "field": "bytes", "id": "3", "settings": { "min_doc_count": "5" } }, { "type": "date_histogram", "id": "2", "settings": { "interval": "1m" } }] }`: false,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "1m" } }, { "type": "terms", "field": "host", "id": "3" }] }`:                                            false,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "1m", "trimEdges": "1" } }] }`:                                                                           false,
+			`{ "metrics": [{ "type": "count", "id": "1" }, { "type": "derivative", "id": "3", "field": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "1m" } }] }`:                                          false,
+			`{ "disableCache": true, "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "1m" } }] }`:                                                                       false,
+			`{ "metrics": [{ "type": "raw_data", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "1m" } }] }`:                                                                                          false,
+			`{ "metrics": [{ "type": "logs", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "1m" } }] }`:                                                                                              false,
+			`{ "metrics": [{ "type": "avg", "id": "1", "settings": { "script": { "inline": "_value * 2" } } }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "1m" } }] }`:                                         true,
+		} {
+			cache := newIncrementalQueryCache(10 * time.Minute)
+			c := newFakeClient()
+			newResponse(t, c, `{ "responses": [{ "hits": { "hits": [] }, "aggregations": {} }] }`)
+
+			_, err := executeElasticsearchDataQueries(c, map[string]string{"A": model}, minutes(0), minutes(time.Hour), nil, cache)
+			require.NoError(t, err, model)
+			_, err = executeElasticsearchDataQueries(c, map[string]string{"A": model}, minutes(5*time.Minute+30*time.Second), minutes(65*time.Minute), nil, cache)
+			require.NoError(t, err, model)
+
+			require.Len(t, c.multisearchRequests, 2, model)
+			if expected {
+				assert.Equal(t, "1970-01-01T00:50:00Z", searchedFrom(c.multisearchRequests[1][0]), model)
+			} else {
+				assert.Equal(t, "1970-01-01T00:05:30Z", searchedFrom(c.multisearchRequests[1][0]), model)
+			}
+		}
+	})
+
+	t.Run("The auto intervals shorter than a millisecond are not incremental", func(t *testing.T) {
+		queries, err := parseQuery([]backend.DataQuery{{
+			RefID:     "A",
+			JSON:      json.RawMessage(`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2" }] }`),
+			Interval:  500 * time.Microsecond,
+			TimeRange: backend.TimeRange{From: minutes(0), To: minutes(time.Hour)},
+		}})
+		require.NoError(t, err)
+
+		cache := newIncrementalQueryCache(10 * time.Minute)
+		assert.Empty(t, cache.prepare(queries, &es.DatasourceInfo{}))
+	})
+
+	t.Run("Refreshes fetch the tail and merge it with the cached series", func(t *testing.T) {
+		cache := newIncrementalQueryCache(10 * time.Minute)
+		c := newFakeClient()
+		newResponse(t, c, `{ "responses": [{ "aggregations": { "3": { "buckets": {
+			"host:web-1": { "2": { "buckets": `+minuteBuckets(0, time.Hour, 1)+` } },
+			"host:web-2": { "2": { "buckets": `+minuteBuckets(0, time.Hour, 1)+` } }
+		} } } }] }`)
+		_, err := executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, minutes(0), minutes(time.Hour), nil, cache)
+		require.NoError(t, err)
+		assert.Equal(t, "1970-01-01T00:00:00Z", searchedFrom(c.multisearchRequests[0][0]))
+
+		newResponse(t, c, `{ "responses": [{ "aggregations": { "3": { "buckets": {
+			"host:web-1": { "2": { "buckets": `+minuteBuckets(50*time.Minute, 65*time.Minute, 2)+` } },
+			"host:web-2": { "2": { "buckets": [] } }
+		} } } }] }`)
+		result, err := executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, minutes(5*time.Minute+30*time.Second), minutes(65*time.Minute), nil, cache)
+		require.NoError(t, err)
+		assert.Equal(t, "1970-01-01T00:50:00Z", searchedFrom(c.multisearchRequests[1][0]))
+
+		frames := result.Responses["A"].Frames
+		require.Len(t, frames, 2)
+		web1 := frames[0]
+		assert.Equal(t, data.Labels{"filter": "host:web-1"}, web1.Fields[1].Labels)
+		require.Equal(t, 60, web1.Rows())
+		assert.Equal(t, minutes(5*time.Minute).UTC(), web1.Fields[0].At(0))
+		assert.Equal(t, 1.0, *web1.Fields[1].At(44).(*float64))
+		assert.Equal(t, 2.0, *web1.Fields[1].At(45).(*float64))
+		assert.Equal(t, minutes(64*time.Minute).UTC(), web1.Fields[0].At(59))
+
+		// The series without documents in the tail keep their cached points
+		web2 := frames[1]
+		assert.Equal(t, data.Labels{"filter": "host:web-2"}, web2.Fields[1].Labels)
+		assert.Equal(t, 45, web2.Rows())
+	})
+
+	t.Run("The metadata of the returned frames is not shared with the cached series", func(t *testing.T) {
+		cache := newIncrementalQueryCache(10 * time.Minute)
+		c := newFakeClient()
+		newResponse(t, c, `{ "responses": [{ "aggregations": { "3": { "buckets": {
+			"host:web-1": { "2": { "buckets": `+minuteBuckets(0, time.Hour, 1)+` } },
+			"host:web-2": { "2": { "buckets": `+minuteBuckets(0, time.Hour, 1)+` } }
+		} } } }] }`)
+		result, err := executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, minutes(0), minutes(time.Hour), nil, cache)
+		require.NoError(t, err)
+		frames := result.Responses["A"].Frames
+		require.Len(t, frames, 2)
+		stats := len(frames[1].Meta.Stats)
+		addFrameStats(frames, []data.QueryStat{{Value: 1}}, "")
+		frames[1].AppendNotices(data.Notice{Text: "returned"})
+
+		newResponse(t, c, `{ "responses": [{ "aggregations": { "3": { "buckets": {
+			"host:web-1": { "2": { "buckets": `+minuteBuckets(50*time.Minute, 65*time.Minute, 2)+` } }
+		} } } }] }`)
+		for _, to := range []time.Duration{65 * time.Minute, 66 * time.Minute} {
+			result, err = executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, minutes(5*time.Minute), minutes(to), nil, cache)
+			require.NoError(t, err)
+			frames = result.Responses["A"].Frames
+			require.Len(t, frames, 2)
+			addFrameStats(frames, []data.QueryStat{{Value: 1}}, "")
+
+			// The series without documents in the tail keep the cached metadata
+			web2 := frames[1]
+			assert.Equal(t, data.Labels{"filter": "host:web-2"}, web2.Fields[1].Labels)
+			assert.Len(t, web2.Meta.Stats, stats+1)
+			assert.Empty(t, web2.Meta.Notices)
+		}
+	})
+
+	t.Run("Failed queries are fetched entirely on the next refresh", func(t *testing.T) {
+		cache := newIncrementalQueryCache(10 * time.Minute)
+		c := newFakeClient()
+		newResponse(t, c, `{ "responses": [{ "aggregations": { "3": { "buckets": {
+			"host:web-1": { "2": { "buckets": `+minuteBuckets(0, time.Hour, 1)+` } }
+		} } } }] }`)
+		_, err := executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, minutes(0), minutes(time.Hour), nil, cache)
+		require.NoError(t, err)
+
+		newResponse(t, c, `{ "responses": [{ "error": { "reason": "invalid query" }, "status": 400 }] }`)
+		result, err := executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, minutes(time.Minute), minutes(61*time.Minute), nil, cache)
+		require.NoError(t, err)
+		require.Error(t, result.Responses["A"].Error)
+
+		_, err = executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, minutes(2*time.Minute), minutes(62*time.Minute), nil, cache)
+		require.NoError(t, err)
+		assert.Equal(t, "1970-01-01T00:02:00Z", searchedFrom(c.multisearchRequests[2][0]))
+	})
+
+	t.Run("The series of a user are not reused for another user", func(t *testing.T) {
+		cache := newIncrementalQueryCache(10 * time.Minute)
+		c := newFakeClient()
+		newResponse(t, c, `{ "responses": [{ "aggregations": { "3": { "buckets": {
+			"host:web-1": { "2": { "buckets": `+minuteBuckets(0, time.Hour, 1)+` } }
+		} } } }] }`)
+		configuredFields := es.ConfiguredFields{TimeField: "@timestamp"}
+
+		alice := &es.DatasourceInfo{ConfiguredFields: configuredFields, ForwardedIdentity: "alice"}
+		_, err := executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, minutes(0), minutes(time.Hour), alice, cache)
+		require.NoError(t, err)
+
+		bob := &es.DatasourceInfo{ConfiguredFields: configuredFields, ForwardedIdentity: "bob"}
+		_, err = executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, minutes(time.Minute), minutes(61*time.Minute), bob, cache)
+		require.NoError(t, err)
+		assert.Equal(t, "1970-01-01T00:01:00Z", searchedFrom(c.multisearchRequests[1][0]))
+	})
+}
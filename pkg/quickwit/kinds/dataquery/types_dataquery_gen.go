@@ -20,35 +20,41 @@ const (
 
 // Defines values for BaseBucketAggregationType.
 const (
+	BaseBucketAggregationTypeComposite     BaseBucketAggregationType = "composite"
 	BaseBucketAggregationTypeDateHistogram BaseBucketAggregationType = "date_histogram"
+	BaseBucketAggregationTypeDateRange     BaseBucketAggregationType = "date_range"
 	BaseBucketAggregationTypeFilters       BaseBucketAggregationType = "filters"
 	BaseBucketAggregationTypeGeohashGrid   BaseBucketAggregationType = "geohash_grid"
 	BaseBucketAggregationTypeHistogram     BaseBucketAggregationType = "histogram"
 	BaseBucketAggregationTypeNested        BaseBucketAggregationType = "nested"
+	BaseBucketAggregationTypeRange         BaseBucketAggregationType = "range"
 	BaseBucketAggregationTypeTerms         BaseBucketAggregationType = "terms"
 )
 
 // Defines values for BaseMetricAggregationType.
 const (
-	BaseMetricAggregationTypeAvg           BaseMetricAggregationType = "avg"
-	BaseMetricAggregationTypeBucketScript  BaseMetricAggregationType = "bucket_script"
-	BaseMetricAggregationTypeCardinality   BaseMetricAggregationType = "cardinality"
-	BaseMetricAggregationTypeCount         BaseMetricAggregationType = "count"
-	BaseMetricAggregationTypeCumulativeSum BaseMetricAggregationType = "cumulative_sum"
-	BaseMetricAggregationTypeDerivative    BaseMetricAggregationType = "derivative"
-	BaseMetricAggregationTypeExtendedStats BaseMetricAggregationType = "extended_stats"
-	BaseMetricAggregationTypeLogs          BaseMetricAggregationType = "logs"
-	BaseMetricAggregationTypeMax           BaseMetricAggregationType = "max"
-	BaseMetricAggregationTypeMin           BaseMetricAggregationType = "min"
-	BaseMetricAggregationTypeMovingAvg     BaseMetricAggregationType = "moving_avg"
-	BaseMetricAggregationTypeMovingFn      BaseMetricAggregationType = "moving_fn"
-	BaseMetricAggregationTypePercentiles   BaseMetricAggregationType = "percentiles"
-	BaseMetricAggregationTypeRate          BaseMetricAggregationType = "rate"
-	BaseMetricAggregationTypeRawData       BaseMetricAggregationType = "raw_data"
-	BaseMetricAggregationTypeRawDocument   BaseMetricAggregationType = "raw_document"
-	BaseMetricAggregationTypeSerialDiff    BaseMetricAggregationType = "serial_diff"
-	BaseMetricAggregationTypeSum           BaseMetricAggregationType = "sum"
-	BaseMetricAggregationTypeTopMetrics    BaseMetricAggregationType = "top_metrics"
+	BaseMetricAggregationTypeAvg             BaseMetricAggregationType = "avg"
+	BaseMetricAggregationTypeBucketScript    BaseMetricAggregationType = "bucket_script"
+	BaseMetricAggregationTypeCardinality     BaseMetricAggregationType = "cardinality"
+	BaseMetricAggregationTypeCount           BaseMetricAggregationType = "count"
+	BaseMetricAggregationTypeCumulativeSum   BaseMetricAggregationType = "cumulative_sum"
+	BaseMetricAggregationTypeDerivative      BaseMetricAggregationType = "derivative"
+	BaseMetricAggregationTypeExtendedStats   BaseMetricAggregationType = "extended_stats"
+	BaseMetricAggregationTypeLogs            BaseMetricAggregationType = "logs"
+	BaseMetricAggregationTypeMax             BaseMetricAggregationType = "max"
+	BaseMetricAggregationTypeMin             BaseMetricAggregationType = "min"
+	BaseMetricAggregationTypeMovingAvg       BaseMetricAggregationType = "moving_avg"
+	BaseMetricAggregationTypeMovingFn        BaseMetricAggregationType = "moving_fn"
+	BaseMetricAggregationTypePercentileRanks BaseMetricAggregationType = "percentile_ranks"
+	BaseMetricAggregationTypePercentiles     BaseMetricAggregationType = "percentiles"
+	BaseMetricAggregationTypeRate            BaseMetricAggregationType = "rate"
+	BaseMetricAggregationTypeRawData         BaseMetricAggregationType = "raw_data"
+	BaseMetricAggregationTypeRawDocument     BaseMetricAggregationType = "raw_document"
+	BaseMetricAggregationTypeSerialDiff      BaseMetricAggregationType = "serial_diff"
+	BaseMetricAggregationTypeStats           BaseMetricAggregationType = "stats"
+	BaseMetricAggregationTypeSum             BaseMetricAggregationType = "sum"
+	BaseMetricAggregationTypeTopMetrics      BaseMetricAggregationType = "top_metrics"
+	BaseMetricAggregationTypeValueCount      BaseMetricAggregationType = "value_count"
 )
 
 // Defines values for BaseMovingAverageModelSettingsModel.
@@ -78,11 +84,14 @@ const (
 
 // Defines values for BucketAggregationType.
 const (
+	BucketAggregationTypeComposite     BucketAggregationType = "composite"
 	BucketAggregationTypeDateHistogram BucketAggregationType = "date_histogram"
+	BucketAggregationTypeDateRange     BucketAggregationType = "date_range"
 	BucketAggregationTypeFilters       BucketAggregationType = "filters"
 	BucketAggregationTypeGeohashGrid   BucketAggregationType = "geohash_grid"
 	BucketAggregationTypeHistogram     BucketAggregationType = "histogram"
 	BucketAggregationTypeNested        BucketAggregationType = "nested"
+	BucketAggregationTypeRange         BucketAggregationType = "range"
 	BucketAggregationTypeTerms         BucketAggregationType = "terms"
 )
 
@@ -183,25 +192,28 @@ const (
 
 // Defines values for MetricAggregationType.
 const (
-	MetricAggregationTypeAvg           MetricAggregationType = "avg"
-	MetricAggregationTypeBucketScript  MetricAggregationType = "bucket_script"
-	MetricAggregationTypeCardinality   MetricAggregationType = "cardinality"
-	MetricAggregationTypeCount         MetricAggregationType = "count"
-	MetricAggregationTypeCumulativeSum MetricAggregationType = "cumulative_sum"
-	MetricAggregationTypeDerivative    MetricAggregationType = "derivative"
-	MetricAggregationTypeExtendedStats MetricAggregationType = "extended_stats"
-	MetricAggregationTypeLogs          MetricAggregationType = "logs"
-	MetricAggregationTypeMax           MetricAggregationType = "max"
-	MetricAggregationTypeMin           MetricAggregationType = "min"
-	MetricAggregationTypeMovingAvg     MetricAggregationType = "moving_avg"
-	MetricAggregationTypeMovingFn      MetricAggregationType = "moving_fn"
-	MetricAggregationTypePercentiles   MetricAggregationType = "percentiles"
-	MetricAggregationTypeRate          MetricAggregationType = "rate"
-	MetricAggregationTypeRawData       MetricAggregationType = "raw_data"
-	MetricAggregationTypeRawDocument   MetricAggregationType = "raw_document"
-	MetricAggregationTypeSerialDiff    MetricAggregationType = "serial_diff"
-	MetricAggregationTypeSum           MetricAggregationType = "sum"
-	MetricAggregationTypeTopMetrics    MetricAggregationType = "top_metrics"
+	MetricAggregationTypeAvg             MetricAggregationType = "avg"
+	MetricAggregationTypeBucketScript    MetricAggregationType = "bucket_script"
+	MetricAggregationTypeCardinality     MetricAggregationType = "cardinality"
+	MetricAggregationTypeCount           MetricAggregationType = "count"
+	MetricAggregationTypeCumulativeSum   MetricAggregationType = "cumulative_sum"
+	MetricAggregationTypeDerivative      MetricAggregationType = "derivative"
+	MetricAggregationTypeExtendedStats   MetricAggregationType = "extended_stats"
+	MetricAggregationTypeLogs            MetricAggregationType = "logs"
+	MetricAggregationTypeMax             MetricAggregationType = "max"
+	MetricAggregationTypeMin             MetricAggregationType = "min"
+	MetricAggregationTypeMovingAvg       MetricAggregationType = "moving_avg"
+	MetricAggregationTypeMovingFn        MetricAggregationType = "moving_fn"
+	MetricAggregationTypePercentileRanks MetricAggregationType = "percentile_ranks"
+	MetricAggregationTypePercentiles     MetricAggregationType = "percentiles"
+	MetricAggregationTypeRate            MetricAggregationType = "rate"
+	MetricAggregationTypeRawData         MetricAggregationType = "raw_data"
+	MetricAggregationTypeRawDocument     MetricAggregationType = "raw_document"
+	MetricAggregationTypeSerialDiff      MetricAggregationType = "serial_diff"
+	MetricAggregationTypeStats           MetricAggregationType = "stats"
+	MetricAggregationTypeSum             MetricAggregationType = "sum"
+	MetricAggregationTypeTopMetrics      MetricAggregationType = "top_metrics"
+	MetricAggregationTypeValueCount      MetricAggregationType = "value_count"
 )
 
 // Defines values for MetricAggregationWithFieldType.
@@ -621,6 +633,9 @@ type ElasticsearchDataQuery struct {
 	// TODO this shouldn't be unknown but DataSourceRef | null
 	Datasource *interface{} `json:"datasource,omitempty"`
 
+	// Bypass the response cache of the datasource
+	DisableCache *bool `json:"disableCache,omitempty"`
+
 	// Hide true if query is disabled (ie should not be returned to the dashboard)
 	// Note this does not always imply that the query should not be executed since
 	// the results from a hidden query may be used as the input to other queries (SSE etc)
@@ -629,6 +644,9 @@ type ElasticsearchDataQuery struct {
 	// List of metric aggregations
 	Metrics []MetricsItem `json:"metrics,omitempty"`
 
+	// Output format of the metric queries: table, numeric or time_series
+	OutputFormat *string `json:"outputFormat,omitempty"`
+
 	// Lucene query
 	Query *string `json:"query,omitempty"`
 
@@ -643,6 +661,9 @@ type ElasticsearchDataQuery struct {
 
 	// Name of time field
 	TimeField *string `json:"timeField,omitempty"`
+
+	// Search timeout overriding the one of the datasource, like 30s
+	Timeout *string `json:"timeout,omitempty"`
 }
 
 // BucketAggsSettingsOrder defines model for ElasticsearchDataQuery.BucketAggs.Settings.Order.
@@ -0,0 +1,354 @@
+package quickwit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
+)
+
+// The log context of a row is fetched by posting a logContextRequest to this
+// resource path
+const logContextPath = "log_context"
+
+const (
+	defaultLogContextLimit = 10
+	// defaultLogContextWindow is the time searched before and after the row
+	// when the request has no time range, the window of the log context of
+	// the frontend
+	defaultLogContextWindow = 7 * time.Hour
+)
+
+// logContextRequest is the body of a log context resource call
+type logContextRequest struct {
+	// Query is the query of the logs the row comes from
+	Query string `json:"query"`
+	// RowID is the id of the row, used to find it among the rows sharing its
+	// timestamp when the request has no sort cursor
+	RowID string `json:"rowId"`
+	// Timestamp of the row, in nanoseconds
+	Timestamp int64 `json:"timestamp"`
+	// Sort is the (timestamp, _doc) sort cursor of the row, its timestamp
+	// takes precedence over Timestamp
+	Sort []interface{} `json:"sort"`
+	// Limit is the number of rows fetched before and after the row
+	Limit int `json:"limit"`
+	// Scope restricts the context to the rows having the same values for these fields
+	Scope map[string]string `json:"scope"`
+	// From and To are the time range of the query the row comes from, in
+	// milliseconds. They bound the context searches when they are set.
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// rowTimestamp returns the timestamp of the row, in nanoseconds
+func (r *logContextRequest) rowTimestamp() int64 {
+	sortValues := r.Sort
+	if len(sortValues) == 0 {
+		sortValues = r.rowCursor()
+	}
+	if timestamp, ok := hitSortTimestamp(map[string]interface{}{"sort": sortValues}); ok {
+		return timestamp
+	}
+	return r.Timestamp
+}
+
+// rowCursor returns the (timestamp, _doc) sort cursor of the row. Without a
+// sort cursor in the request, it is read from the row id, which is made of
+// the sort values of the rows without _id, and keeps the nanoseconds that the
+// numbers of the frontend lose.
+func (r *logContextRequest) rowCursor() []interface{} {
+	if len(r.Sort) == 2 {
+		return r.Sort
+	}
+	timestamp, doc, ok := strings.Cut(r.RowID, "#")
+	if !ok {
+		return nil
+	}
+	cursor := []interface{}{json.Number(timestamp), json.Number(doc)}
+	for _, v := range cursor {
+		if _, err := v.(json.Number).Int64(); err != nil {
+			return nil
+		}
+	}
+	return cursor
+}
+
+// rowDoc returns the _doc sort value of the row, false when the request has
+// no sort cursor
+func (r *logContextRequest) rowDoc() (int64, bool) {
+	cursor := r.rowCursor()
+	if len(cursor) != 2 {
+		return 0, false
+	}
+	switch v := cursor[1].(type) {
+	case json.Number:
+		doc, err := v.Int64()
+		return doc, err == nil
+	case float64:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// isRow returns true when the hit is the row of the request, identified by
+// its sort cursor, or by its id when the request has none
+func (r *logContextRequest) isRow(hit map[string]interface{}) bool {
+	if cursor := r.rowCursor(); len(cursor) == 2 {
+		sortValues, _ := hit["sort"].([]interface{})
+		return len(sortValues) == 2 &&
+			fmt.Sprint(sortValues[0]) == fmt.Sprint(cursor[0]) &&
+			fmt.Sprint(sortValues[1]) == fmt.Sprint(cursor[1])
+	}
+	return r.RowID != "" && logsHitID(hit, -1) == r.RowID
+}
+
+// contextRange returns the time range of the context searches, in
+// milliseconds: the time range of the request around the row, or the default
+// window when the request has none
+func (r *logContextRequest) contextRange(timestampMs int64) (from int64, to int64) {
+	from, to = timestampMs-defaultLogContextWindow.Milliseconds(), timestampMs+defaultLogContextWindow.Milliseconds()
+	if r.From > 0 && r.From <= timestampMs {
+		from = r.From
+	}
+	if r.To >= timestampMs {
+		to = r.To
+	}
+	return from, to
+}
+
+// contextQuery returns the query of the context searches, scoped to the
+// field values of the request
+func (r *logContextRequest) contextQuery() string {
+	clauses := []string{}
+	if query := strings.TrimSpace(r.Query); query != "" && query != "*" {
+		clauses = append(clauses, "("+query+")")
+	}
+	fields := make([]string, 0, len(r.Scope))
+	for field := range r.Scope {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		clauses = append(clauses, traceSearchPhraseClause(queryTermEscaper.Replace(field), r.Scope[field]))
+	}
+	return strings.Join(clauses, " AND ")
+}
+
+// logContextQueries returns the logs queries fetching the rows before and
+// after the row, which are sorted by timestamp and document. The rows after
+// the row are searched from the cursor just before it, so that they include
+// the row itself. Without the document of the row, the rows before it are the
+// rows of the previous timestamps and the rows after it the rows from its
+// timestamp.
+func logContextQueries(r *logContextRequest) (before *Query, after *Query) {
+	limit := r.Limit
+	if limit <= 0 {
+		limit = defaultLogContextLimit
+	}
+	timestamp := r.rowTimestamp()
+	timestampMs := timestamp / int64(time.Millisecond)
+
+	newQuery := func(refID, sortDirection string, searchAfter []interface{}, from, to int64) *Query {
+		return &Query{
+			RawQuery: r.contextQuery(),
+			RefID:    refID,
+			Metrics: []*MetricAgg{{
+				ID:   "1",
+				Type: logsType,
+				Settings: simplejson.NewFromAny(map[string]interface{}{
+					"limit":         strconv.Itoa(limit),
+					"sortDirection": sortDirection,
+					"searchAfter":   searchAfter,
+				}),
+			}},
+			RangeFrom: from,
+			RangeTo:   to,
+		}
+	}
+
+	beforeCursor := []interface{}{timestamp, int64(0)}
+	afterCursor := []interface{}{timestamp - 1, int64(math.MaxInt64)}
+	if doc, ok := r.rowDoc(); ok {
+		beforeCursor = []interface{}{timestamp, doc}
+		if doc > 0 {
+			afterCursor = []interface{}{timestamp, doc - 1}
+		}
+	}
+
+	from, to := r.contextRange(timestampMs)
+	// Add 1 to avoid missing results due to precision gap
+	before = newQuery("before", "desc", beforeCursor, from, timestampMs+1)
+	after = newQuery("after", "asc", afterCursor, timestampMs, to)
+	return before, after
+}
+
+// fetchLogContext runs the searches before and after the row in a single
+// multisearch and returns the rows ordered by ascending time. The index of
+// the row is set in the anchorIndex custom meta, -1 when it was not found.
+func fetchLogContext(client es.Client, r *logContextRequest, dsInfo *es.DatasourceInfo) (*data.Frame, error) {
+	before, after := logContextQueries(r)
+	queries := []*Query{before, after}
+	req, err := buildMSR(queries, dsInfo.ConfiguredFields, dsInfo.ForcedQueryFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	msr, err := client.ExecuteMultisearch(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(msr.Responses) != len(queries) {
+		return nil, &es.DecodeError{Err: fmt.Errorf("unexpected number of responses: %d", len(msr.Responses))}
+	}
+	beforeRes, err := decodeLogsSearchResponse(msr.Responses[0])
+	if err != nil {
+		return nil, err
+	}
+	afterRes, err := decodeLogsSearchResponse(msr.Responses[1])
+	if err != nil {
+		return nil, err
+	}
+
+	// The rows before the row are fetched from the most recent one
+	hits := make([]map[string]interface{}, 0, len(beforeRes.Hits.Hits)+len(afterRes.Hits.Hits))
+	for i := len(beforeRes.Hits.Hits) - 1; i >= 0; i-- {
+		hits = append(hits, beforeRes.Hits.Hits[i])
+	}
+	hits = append(hits, afterRes.Hits.Hits...)
+
+	// The row is the first row of its timestamp unless it is found by its
+	// sort cursor or its _id
+	anchorIndex := -1
+	timestamp := r.rowTimestamp()
+	for i := len(beforeRes.Hits.Hits); i < len(hits); i++ {
+		hitTimestamp, ok := hitSortTimestamp(hits[i])
+		if !ok || hitTimestamp != timestamp {
+			continue
+		}
+		if anchorIndex == -1 {
+			anchorIndex = i
+		}
+		if r.isRow(hits[i]) {
+			anchorIndex = i
+			break
+		}
+	}
+
+	queryRes := backend.DataResponse{}
+	merged := &es.SearchResponse{Hits: &es.SearchResponseHits{Hits: hits}}
+	if err := processLogsResponse(merged, after, dsInfo.ConfiguredFields, &queryRes); err != nil {
+		return nil, err
+	}
+	// The pagination of the logs does not apply to the merged rows
+	frame := queryRes.Frames[0]
+	custom, ok := frame.Meta.Custom.(map[string]interface{})
+	if !ok {
+		custom = map[string]interface{}{}
+		frame.Meta.Custom = custom
+	}
+	delete(custom, "cursor")
+	delete(custom, "hasMore")
+	custom["anchorIndex"] = anchorIndex
+	return frame, nil
+}
+
+// decodeLogsSearchResponse decodes the search response of a logs query
+func decodeLogsSearchResponse(rawRes *json.RawMessage) (*es.SearchResponse, error) {
+	if rawRes == nil {
+		return nil, &es.DecodeError{Err: fmt.Errorf("empty response")}
+	}
+	dec := json.NewDecoder(bytes.NewReader(*rawRes))
+	dec.UseNumber()
+	var res *es.SearchResponse
+	if err := dec.Decode(&res); err != nil {
+		return nil, &es.DecodeError{Err: err}
+	}
+	if res == nil {
+		return nil, &es.DecodeError{Err: fmt.Errorf("empty response")}
+	}
+	if res.Error != nil || res.Status >= 400 {
+		status := res.Status
+		if status < 400 {
+			status = http.StatusInternalServerError
+		}
+		return nil, &es.QuickwitError{StatusCode: status, Message: getErrorFromElasticResponse(res)}
+	}
+	if res.Hits == nil {
+		res.Hits = &es.SearchResponseHits{}
+	}
+	return res, nil
+}
+
+// callLogContext serves the log context resource, the frame of the rows is
+// returned as JSON
+func (ds *QuickwitDatasource) callLogContext(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if req.Method != http.MethodPost {
+		return sendResourceError(sender, http.StatusMethodNotAllowed, fmt.Errorf("log context must be requested with POST"))
+	}
+	// The sort values are nanosecond timestamps, which do not fit in a float64
+	var r logContextRequest
+	dec := json.NewDecoder(bytes.NewReader(req.Body))
+	dec.UseNumber()
+	if err := dec.Decode(&r); err != nil {
+		return sendResourceError(sender, http.StatusBadRequest, fmt.Errorf("invalid log context request: %w", err))
+	}
+	if r.rowTimestamp() == 0 {
+		return sendResourceError(sender, http.StatusBadRequest, fmt.Errorf("invalid log context request: missing row timestamp"))
+	}
+
+	dsInfo, err := ds.initializedDatasourceInfo(ctx, req)
+	if err != nil {
+		return sendResourceError(sender, http.StatusInternalServerError, fmt.Errorf("Datasource initialization failed: %w", err))
+	}
+	client, err := es.NewClient(ctx, dsInfo)
+	if err != nil {
+		return sendResourceError(sender, http.StatusInternalServerError, err)
+	}
+
+	frame, err := fetchLogContext(client, &r, dsInfo)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var clientErr es.Error
+		if errors.As(err, &clientErr) {
+			status = int(clientErr.Status())
+		}
+		return sendResourceError(sender, status, err)
+	}
+
+	body, err := data.FrameToJSON(frame, data.IncludeAll)
+	if err != nil {
+		return sendResourceError(sender, http.StatusInternalServerError, err)
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status:  http.StatusOK,
+		Headers: map[string][]string{"content-type": {"application/json"}},
+		Body:    body,
+	})
+}
+
+func sendResourceError(sender backend.CallResourceResponseSender, status int, err error) error {
+	body, marshalErr := json.Marshal(map[string]string{"message": err.Error()})
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status:  status,
+		Headers: map[string][]string{"content-type": {"application/json"}},
+		Body:    body,
+	})
+}
@@ -0,0 +1,136 @@
+package quickwit
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+)
+
+func TestLogContext(t *testing.T) {
+	const rowTimestamp = int64(1_700_000_000_000_000_000)
+	dsInfo := &es.DatasourceInfo{
+		ConfiguredFields: es.ConfiguredFields{
+			TimeField:        "timestamp",
+			TimeOutputFormat: "unix_timestamp_nanos",
+			LogMessageField:  "line",
+		},
+	}
+
+	t.Run("Queries search around the row", func(t *testing.T) {
+		before, after := logContextQueries(&logContextRequest{
+			Query:     "level:error",
+			Sort:      []interface{}{json.Number("1700000000000000000")},
+			Limit:     5,
+			Scope:     map[string]string{"host": "web-1", "trace_id": "abc"},
+			Timestamp: 42,
+		})
+
+		for _, q := range []*Query{before, after} {
+			assert.Equal(t, `(level:error) AND host:"web-1" AND trace_id:"abc"`, q.RawQuery)
+			assert.Equal(t, "5", q.Metrics[0].Settings.Get("limit").MustString())
+		}
+		assert.Equal(t, "desc", before.Metrics[0].Settings.Get("sortDirection").MustString())
+		assert.Equal(t, []interface{}{rowTimestamp, int64(0)}, before.Metrics[0].Settings.Get("searchAfter").MustArray())
+		assert.Equal(t, int64(1_700_000_000_001), before.RangeTo)
+		assert.Equal(t, "asc", after.Metrics[0].Settings.Get("sortDirection").MustString())
+		assert.Equal(t, []interface{}{rowTimestamp - 1, int64(math.MaxInt64)}, after.Metrics[0].Settings.Get("searchAfter").MustArray())
+		assert.Equal(t, int64(1_700_000_000_000), after.RangeFrom)
+		assert.Equal(t, int64(1_700_000_000_000)-defaultLogContextWindow.Milliseconds(), before.RangeFrom)
+		assert.Equal(t, int64(1_700_000_000_000)+defaultLogContextWindow.Milliseconds(), after.RangeTo)
+	})
+
+	t.Run("Queries search before and after the sort cursor of the row", func(t *testing.T) {
+		before, after := logContextQueries(&logContextRequest{
+			Sort:  []interface{}{json.Number("1700000000000000000"), json.Number("7")},
+			Scope: map[string]string{"k8s.pod-name": `web "1"`},
+		})
+
+		assert.Equal(t, `k8s.pod\-name:"web \"1\""`, before.RawQuery)
+		assert.Equal(t, []interface{}{rowTimestamp, int64(7)}, before.Metrics[0].Settings.Get("searchAfter").MustArray())
+		assert.Equal(t, []interface{}{rowTimestamp, int64(6)}, after.Metrics[0].Settings.Get("searchAfter").MustArray())
+	})
+
+	t.Run("Queries read the sort cursor of the row from its id", func(t *testing.T) {
+		before, after := logContextQueries(&logContextRequest{RowID: "1700000000000000123#7"})
+
+		assert.Equal(t, []interface{}{rowTimestamp + 123, int64(7)}, before.Metrics[0].Settings.Get("searchAfter").MustArray())
+		assert.Equal(t, []interface{}{rowTimestamp + 123, int64(6)}, after.Metrics[0].Settings.Get("searchAfter").MustArray())
+	})
+
+	t.Run("Queries search the time range of the row", func(t *testing.T) {
+		before, after := logContextQueries(&logContextRequest{
+			Sort: []interface{}{json.Number("1700000000000000000")},
+			From: 1_699_990_000_000,
+			To:   1_700_010_000_000,
+		})
+
+		assert.Equal(t, int64(1_699_990_000_000), before.RangeFrom)
+		assert.Equal(t, int64(1_700_000_000_001), before.RangeTo)
+		assert.Equal(t, int64(1_700_000_000_000), after.RangeFrom)
+		assert.Equal(t, int64(1_700_010_000_000), after.RangeTo)
+	})
+
+	t.Run("Rows are merged in ascending order with the row marked", func(t *testing.T) {
+		c := newFakeClient()
+		require.NoError(t, json.Unmarshal([]byte(`{
+			"responses": [
+				{ "hits": { "hits": [
+					{ "_source": { "timestamp": 1699999999000000000, "line": "before 1" }, "sort": [1699999999000000000, 8] },
+					{ "_source": { "timestamp": 1699999998000000000, "line": "before 2" }, "sort": [1699999998000000000, 9] }
+				] } },
+				{ "hits": { "hits": [
+					{ "_source": { "timestamp": 1700000000000000000, "line": "same timestamp" }, "sort": [1700000000000000000, 1] },
+					{ "_source": { "timestamp": 1700000000000000000, "line": "row" }, "sort": [1700000000000000000, 2] },
+					{ "_source": { "timestamp": 1700000001000000000, "line": "after" }, "sort": [1700000001000000000, 3] }
+				] } }
+			]
+		}`), c.multiSearchResponse))
+
+		frame, err := fetchLogContext(c, &logContextRequest{
+			Sort:  []interface{}{json.Number("1700000000000000000"), json.Number("2")},
+			RowID: "1",
+		}, dsInfo)
+		require.NoError(t, err)
+
+		require.Len(t, c.multisearchRequests, 1)
+		require.Len(t, c.multisearchRequests[0], 2)
+
+		var lines *data.Field
+		for _, field := range frame.Fields {
+			if field.Name == "line" {
+				lines = field
+			}
+		}
+		require.NotNil(t, lines)
+		values := make([]string, 0, lines.Len())
+		for i := 0; i < lines.Len(); i++ {
+			values = append(values, *lines.At(i).(*string))
+		}
+		assert.Equal(t, []string{"before 2", "before 1", "same timestamp", "row", "after"}, values)
+
+		custom := frame.Meta.Custom.(map[string]interface{})
+		assert.Equal(t, 3, custom["anchorIndex"])
+		assert.NotContains(t, custom, "cursor")
+	})
+
+	t.Run("Quickwit errors fail the log context", func(t *testing.T) {
+		c := newFakeClient()
+		require.NoError(t, json.Unmarshal([]byte(`{
+			"responses": [
+				{ "error": { "reason": "index not found" }, "status": 404 },
+				{ "hits": { "hits": [] } }
+			]
+		}`), c.multiSearchResponse))
+
+		_, err := fetchLogContext(c, &logContextRequest{Timestamp: rowTimestamp}, dsInfo)
+		var qe *es.QuickwitError
+		require.ErrorAs(t, err, &qe)
+		assert.Equal(t, 404, qe.StatusCode)
+	})
+}
@@ -0,0 +1,166 @@
+package quickwit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+)
+
+const (
+	// Default time after which the index metadata is refreshed in the background
+	defaultMetadataCacheTTL = 5 * time.Minute
+	// Minimum time between two refreshes triggered by queries while the metadata is not loaded
+	metadataRetryInterval = 5 * time.Second
+)
+
+// indexMetadataCache holds the timestamp infos resolved from the metadata of
+// each index matching the datasource index pattern. It is refreshed in the
+// background every ttl until closed, closing it cancels the fetch in flight.
+type indexMetadataCache struct {
+	index string
+	ttl   time.Duration
+	fetch func(ctx context.Context) ([]QuickwitIndexMetadata, error)
+
+	// refreshMu serializes the calls to fetch
+	refreshMu sync.Mutex
+
+	mu             sync.RWMutex
+	timestampInfos map[string]es.TimestampInfo
+	lastErr        error
+	attemptedAt    time.Time
+
+	// ctx is cancelled by close
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newIndexMetadataCache(index string, ttl time.Duration, fetch func(ctx context.Context) ([]QuickwitIndexMetadata, error)) *indexMetadataCache {
+	if ttl <= 0 {
+		ttl = defaultMetadataCacheTTL
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &indexMetadataCache{
+		index:  index,
+		ttl:    ttl,
+		fetch:  fetch,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// run loads the metadata then refreshes it every ttl until close is called
+func (c *indexMetadataCache) run() {
+	defer close(c.done)
+
+	c.refresh(c.ctx)
+
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.refresh(c.ctx)
+		}
+	}
+}
+
+// close stops the background refresh and waits for it to exit
+func (c *indexMetadataCache) close() {
+	c.cancel()
+	<-c.done
+}
+
+// refresh fetches the index metadata and updates the timestamp infos. When the
+// fetch fails, the previously loaded timestamp infos are kept and the error is
+// exposed by lastError. The fetch is cancelled with ctx or when the cache is
+// closed.
+func (c *indexMetadataCache) refresh(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	return c.refreshLocked(ctx)
+}
+
+// refreshLocked must be called with refreshMu held
+func (c *indexMetadataCache) refreshLocked(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(c.ctx, cancel)
+	defer stop()
+
+	qwlog.Debug("Refreshing index metadata", "index", c.index)
+	timestampInfos, err := c.resolveTimestampInfos(ctx)
+	if err != nil && ctx.Err() != nil && c.ctx.Err() == nil {
+		// The request was cancelled, not the cache, its state is unchanged
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attemptedAt = time.Now()
+	c.lastErr = err
+	if err == nil {
+		c.timestampInfos = timestampInfos
+	}
+	return err
+}
+
+func (c *indexMetadataCache) resolveTimestampInfos(ctx context.Context) (map[string]es.TimestampInfo, error) {
+	indexMetadataList, err := c.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index metadata : %w", err)
+	}
+	if len(indexMetadataList) == 0 {
+		return nil, fmt.Errorf("no index found for %s", c.index)
+	}
+
+	return GetIndexTimestampInfos(indexMetadataList)
+}
+
+// lastError returns the error of the last refresh, if any
+func (c *indexMetadataCache) lastError() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastErr
+}
+
+// configuredFields returns the given configured fields completed with the
+// cached timestamp infos. If they have never been loaded, a refresh is
+// attempted first with ctx, at most once every metadataRetryInterval.
+func (c *indexMetadataCache) configuredFields(ctx context.Context, fields es.ConfiguredFields) (es.ConfiguredFields, error) {
+	c.mu.RLock()
+	loaded, attemptedAt := c.timestampInfos != nil, c.attemptedAt
+	c.mu.RUnlock()
+
+	if !loaded && time.Since(attemptedAt) >= metadataRetryInterval {
+		c.refreshMu.Lock()
+		c.mu.RLock()
+		// Another caller may have refreshed while we were waiting for the lock
+		refreshed := !c.attemptedAt.Equal(attemptedAt)
+		c.mu.RUnlock()
+		if !refreshed {
+			if err := c.refreshLocked(ctx); err != nil && ctx.Err() != nil {
+				c.refreshMu.Unlock()
+				return fields, err
+			}
+		}
+		c.refreshMu.Unlock()
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.timestampInfos == nil {
+		if c.lastErr != nil {
+			return fields, c.lastErr
+		}
+		return fields, fmt.Errorf("index metadata is not loaded for %s", c.index)
+	}
+
+	return fields.WithIndexTimestampInfos(c.timestampInfos), nil
+}
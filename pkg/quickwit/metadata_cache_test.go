@@ -0,0 +1,179 @@
+package quickwit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+)
+
+func newTestIndexMetadata(indexID, timestampField, outputFormat string) QuickwitIndexMetadata {
+	var metadata QuickwitIndexMetadata
+	metadata.IndexConfig.IndexID = indexID
+	metadata.IndexConfig.DocMapping.TimestampField = timestampField
+	metadata.IndexConfig.DocMapping.FieldMappings = []FieldMappings{
+		{Name: timestampField, Type: "datetime", OutputFormat: &outputFormat},
+	}
+	return metadata
+}
+
+type fakeMetadataFetcher struct {
+	mu       sync.Mutex
+	calls    int
+	metadata []QuickwitIndexMetadata
+	err      error
+}
+
+func (f *fakeMetadataFetcher) fetch(ctx context.Context) ([]QuickwitIndexMetadata, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return f.metadata, f.err
+}
+
+func (f *fakeMetadataFetcher) set(metadata []QuickwitIndexMetadata, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.metadata = metadata
+	f.err = err
+}
+
+func (f *fakeMetadataFetcher) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func TestIndexMetadataCache(t *testing.T) {
+	baseFields := es.ConfiguredFields{LogMessageField: "line", LogLevelField: "lvl"}
+
+	t.Run("Loads timestamp infos on first use", func(t *testing.T) {
+		fetcher := &fakeMetadataFetcher{metadata: []QuickwitIndexMetadata{newTestIndexMetadata("logs", "timestamp", "rfc3339")}}
+		cache := newIndexMetadataCache("logs", time.Hour, fetcher.fetch)
+
+		fields, err := cache.configuredFields(context.Background(), baseFields)
+		require.NoError(t, err)
+		assert.Equal(t, "timestamp", fields.TimeField)
+		assert.Equal(t, "rfc3339", fields.TimeOutputFormat)
+		assert.Equal(t, "line", fields.LogMessageField)
+		assert.NoError(t, cache.lastError())
+
+		_, err = cache.configuredFields(context.Background(), baseFields)
+		require.NoError(t, err)
+		assert.Equal(t, 1, fetcher.callCount())
+	})
+
+	t.Run("Loads the timestamp infos of each index", func(t *testing.T) {
+		fetcher := &fakeMetadataFetcher{metadata: []QuickwitIndexMetadata{
+			newTestIndexMetadata("logs-a", "timestamp", "rfc3339"),
+			newTestIndexMetadata("logs-b", "ts", "unix_timestamp_secs"),
+			newTestIndexMetadata("logs-c", "timestamp", "rfc3339"),
+		}}
+		cache := newIndexMetadataCache("logs-*", time.Hour, fetcher.fetch)
+
+		fields, err := cache.configuredFields(context.Background(), baseFields)
+		require.NoError(t, err)
+		assert.Len(t, fields.IndexTimestampInfos, 3)
+		assert.Equal(t, "timestamp", fields.TimeField)
+		assert.Equal(t, "unix_timestamp_nanos", fields.TimeOutputFormat)
+		assert.Equal(t, es.TimeFieldVariable, fields.SearchTimeField())
+	})
+
+	t.Run("Exposes the error when metadata cannot be loaded", func(t *testing.T) {
+		fetcher := &fakeMetadataFetcher{err: errors.New("connection refused")}
+		cache := newIndexMetadataCache("logs", time.Hour, fetcher.fetch)
+
+		_, err := cache.configuredFields(context.Background(), baseFields)
+		require.ErrorContains(t, err, "connection refused")
+		require.ErrorContains(t, cache.lastError(), "connection refused")
+
+		// Queries do not hammer Quickwit while it is unavailable
+		_, err = cache.configuredFields(context.Background(), baseFields)
+		require.Error(t, err)
+		assert.Equal(t, 1, fetcher.callCount())
+	})
+
+	t.Run("Reports an empty index pattern", func(t *testing.T) {
+		fetcher := &fakeMetadataFetcher{metadata: []QuickwitIndexMetadata{}}
+		cache := newIndexMetadataCache("logs-*", time.Hour, fetcher.fetch)
+
+		require.ErrorContains(t, cache.refresh(context.Background()), "no index found for logs-*")
+	})
+
+	t.Run("Keeps the last timestamp infos when a refresh fails", func(t *testing.T) {
+		fetcher := &fakeMetadataFetcher{metadata: []QuickwitIndexMetadata{newTestIndexMetadata("logs", "timestamp", "rfc3339")}}
+		cache := newIndexMetadataCache("logs", time.Hour, fetcher.fetch)
+		require.NoError(t, cache.refresh(context.Background()))
+
+		fetcher.set(nil, errors.New("service unavailable"))
+		require.Error(t, cache.refresh(context.Background()))
+
+		fields, err := cache.configuredFields(context.Background(), baseFields)
+		require.NoError(t, err)
+		assert.Equal(t, "timestamp", fields.TimeField)
+		require.ErrorContains(t, cache.lastError(), "service unavailable")
+	})
+
+	t.Run("Refreshes in the background until closed", func(t *testing.T) {
+		fetcher := &fakeMetadataFetcher{metadata: []QuickwitIndexMetadata{newTestIndexMetadata("logs", "timestamp", "rfc3339")}}
+		cache := newIndexMetadataCache("logs", 10*time.Millisecond, fetcher.fetch)
+		go cache.run()
+
+		require.Eventually(t, func() bool { return fetcher.callCount() >= 3 }, time.Second, 5*time.Millisecond)
+
+		fetcher.set([]QuickwitIndexMetadata{newTestIndexMetadata("logs", "ts", "unix_timestamp_secs")}, nil)
+		require.Eventually(t, func() bool {
+			fields, err := cache.configuredFields(context.Background(), baseFields)
+			return err == nil && fields.TimeField == "ts"
+		}, time.Second, 5*time.Millisecond)
+
+		cache.close()
+		calls := fetcher.callCount()
+		time.Sleep(30 * time.Millisecond)
+		assert.Equal(t, calls, fetcher.callCount())
+
+		// Closing twice is a no-op
+		cache.close()
+	})
+
+	t.Run("Closing cancels the fetch in flight", func(t *testing.T) {
+		started := make(chan struct{})
+		cache := newIndexMetadataCache("logs", time.Hour, func(ctx context.Context) ([]QuickwitIndexMetadata, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		go cache.run()
+		<-started
+
+		cache.close()
+		require.ErrorIs(t, cache.lastError(), context.Canceled)
+	})
+
+	t.Run("Cancelling the request cancels its fetch only", func(t *testing.T) {
+		fetcher := &fakeMetadataFetcher{metadata: []QuickwitIndexMetadata{newTestIndexMetadata("logs", "timestamp", "rfc3339")}}
+		cache := newIndexMetadataCache("logs", time.Hour, func(ctx context.Context) ([]QuickwitIndexMetadata, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return fetcher.fetch(ctx)
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := cache.configuredFields(ctx, baseFields)
+		require.ErrorIs(t, err, context.Canceled)
+		assert.NoError(t, cache.lastError())
+
+		// The next request is not delayed by the retry interval
+		fields, err := cache.configuredFields(context.Background(), baseFields)
+		require.NoError(t, err)
+		assert.Equal(t, "timestamp", fields.TimeField)
+	})
+}
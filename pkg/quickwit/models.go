@@ -13,12 +13,23 @@ type Query struct {
 	BucketAggs    []*BucketAgg `json:"bucketAggs"`
 	Metrics       []*MetricAgg `json:"metrics"`
 	Alias         string       `json:"alias"`
+	OutputFormat  string       `json:"outputFormat"`
+	DisableCache  bool         `json:"disableCache"`
 	Interval      time.Duration
 	IntervalMs    int64
 	RefID         string
 	MaxDataPoints int64
 	RangeFrom     int64
 	RangeTo       int64
+	// RangeShards is the number of shards the time range may be split into
+	RangeShards int
+	// Timeout overrides the search timeout of the datasource
+	Timeout time.Duration
+	// TimeoutError is the error of an invalid timeout, reported on the
+	// response of the query only
+	TimeoutError error `json:"-"`
+	// Annotation holds the field mappings of the annotations queries
+	Annotation *AnnotationQuery
 }
 
 // BucketAgg represents a bucket aggregation of the time series query model of the datasource
@@ -42,27 +53,31 @@ type MetricAgg struct {
 }
 
 var metricAggType = map[string]string{
-	"count":          "Count",
-	"avg":            "Average",
-	"sum":            "Sum",
-	"max":            "Max",
-	"min":            "Min",
-	"extended_stats": "Extended Stats",
-	"percentiles":    "Percentiles",
-	"top_metrics":    "Top Metrics",
-	"cardinality":    "Unique Count",
-	"moving_avg":     "Moving Average",
-	"moving_fn":      "Moving Function",
-	"cumulative_sum": "Cumulative Sum",
-	"derivative":     "Derivative",
-	"serial_diff":    "Serial Difference",
-	"bucket_script":  "Bucket Script",
-	"raw_document":   "Raw Document",
-	"raw_data":       "Raw Data",
-	"rate":           "Rate",
-	"logs":           "Logs",
-	"traces":         "Traces",
-	"trace_search":   "Trace search",
+	"count":            "Count",
+	"avg":              "Average",
+	"sum":              "Sum",
+	"max":              "Max",
+	"min":              "Min",
+	"extended_stats":   "Extended Stats",
+	"stats":            "Stats",
+	"percentiles":      "Percentiles",
+	"percentile_ranks": "Percentile Ranks",
+	"value_count":      "Value Count",
+	"top_metrics":      "Top Metrics",
+	"cardinality":      "Unique Count",
+	"moving_avg":       "Moving Average",
+	"moving_fn":        "Moving Function",
+	"cumulative_sum":   "Cumulative Sum",
+	"derivative":       "Derivative",
+	"serial_diff":      "Serial Difference",
+	"bucket_script":    "Bucket Script",
+	"raw_document":     "Raw Document",
+	"raw_data":         "Raw Data",
+	"rate":             "Rate",
+	"logs":             "Logs",
+	"logs_volume":      "Logs Volume",
+	"traces":           "Traces",
+	"trace_search":     "Trace search",
 }
 
 var extendedStats = map[string]string{
@@ -99,6 +114,13 @@ var pipelineAggWithMultipleBucketPathsType = map[string]string{
 	"bucket_script": "bucket_script",
 }
 
+// unitParameterAggType lists the aggregations with a unit parameter, the
+// unit setting of the other metrics is the unit of their values
+var unitParameterAggType = map[string]string{
+	"derivative": "derivative",
+	"rate":       "rate",
+}
+
 func isPipelineAgg(metricType string) bool {
 	if _, ok := pipelineAggType[metricType]; ok {
 		return true
@@ -120,6 +142,14 @@ func isPipelineAggWithMultipleBucketPaths(metricType string) bool {
 	return false
 }
 
+// metricUnit returns the unit of the values of a metric
+func metricUnit(metric *MetricAgg) string {
+	if _, ok := unitParameterAggType[metric.Type]; ok {
+		return ""
+	}
+	return metric.Settings.Get("unit").MustString()
+}
+
 func describeMetric(metricType, field string) string {
 	text := metricAggType[metricType]
 	if metricType == countType {
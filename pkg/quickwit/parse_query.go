@@ -1,9 +1,11 @@
 package quickwit
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
 
 	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
 )
@@ -30,6 +32,16 @@ func parseQuery(tsdbQuery []backend.DataQuery) ([]*Query, error) {
 			return nil, err
 		}
 		alias := model.Get("alias").MustString("")
+		outputFormat := model.Get("outputFormat").MustString(outputFormatTable)
+		disableCache := model.Get("disableCache").MustBool(false)
+		var timeout time.Duration
+		var timeoutErr error
+		if v := model.Get("timeout").MustString(); v != "" {
+			// The invalid timeout fails its own query, not the others
+			if timeout, err = gtime.ParseDuration(v); err != nil {
+				timeout, timeoutErr = 0, fmt.Errorf("invalid query timeout %q: %w", v, err)
+			}
+		}
 		intervalMs := model.Get("intervalMs").MustInt64(0)
 		interval := q.Interval
 
@@ -42,6 +54,10 @@ func parseQuery(tsdbQuery []backend.DataQuery) ([]*Query, error) {
 			BucketAggs:    bucketAggs,
 			Metrics:       metrics,
 			Alias:         alias,
+			OutputFormat:  outputFormat,
+			DisableCache:  disableCache,
+			Timeout:       timeout,
+			TimeoutError:  timeoutErr,
 			Interval:      interval,
 			IntervalMs:    intervalMs,
 			RefID:         q.RefID,
@@ -49,7 +65,11 @@ func parseQuery(tsdbQuery []backend.DataQuery) ([]*Query, error) {
 			RangeFrom:     from,
 			RangeTo:       to,
 		}
-		normalizeInternalLinkTraceQuery(query)
+		if isAnnotationsQuery(query) {
+			query.Annotation = parseAnnotationQuery(model)
+		} else {
+			normalizeInternalLinkTraceQuery(query)
+		}
 		queries = append(queries, query)
 	}
 
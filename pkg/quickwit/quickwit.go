@@ -11,6 +11,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
@@ -22,7 +23,10 @@ import (
 var qwlog = log.New()
 
 type QuickwitDatasource struct {
-	dsInfo es.DatasourceInfo
+	dsInfo   es.DatasourceInfo
+	metadata *indexMetadataCache
+	// incremental is nil when the incremental querying is disabled
+	incremental *incrementalQueryCache
 }
 
 type FieldMappings struct {
@@ -115,6 +119,50 @@ func NewQuickwitDatasource(ctx context.Context, settings backend.DataSourceInsta
 		maxConcurrentShardRequests = 256
 	}
 
+	searchAPI, ok := jsonData["searchApi"].(string)
+	if !ok || searchAPI == "" {
+		searchAPI = es.SearchAPIElastic
+	}
+	if searchAPI != es.SearchAPIElastic && searchAPI != es.SearchAPINative {
+		return nil, fmt.Errorf("error reading settings: unknown searchApi %s", searchAPI)
+	}
+
+	metadataCacheTTL := readDurationSetting(jsonData, "metadataCacheTTL", defaultMetadataCacheTTL)
+
+	responseCacheTTL := readDurationSetting(jsonData, "responseCacheTTL", 0)
+	responseCacheMaxBytes := readIntSetting(jsonData, "responseCacheMaxBytes", 0)
+
+	rangeShards := readIntSetting(jsonData, "rangeShards", 0)
+
+	maxConcurrentQueries := readIntSetting(jsonData, "maxConcurrentQueries", 0)
+	queryQueueTimeout := readDurationSetting(jsonData, "queryQueueTimeout", es.DefaultQueueTimeout)
+
+	searchTimeout := readDurationSetting(jsonData, "searchTimeout", 0)
+
+	retryMaxAttempts := readIntSetting(jsonData, "retryMaxAttempts", 0)
+	retryInitialBackoff := readDurationSetting(jsonData, "retryInitialBackoff", 0)
+	retryMaxBackoff := readDurationSetting(jsonData, "retryMaxBackoff", 0)
+
+	// A malformed list falls back to the default retried status codes
+	var retryStatusCodes []int
+	if v, ok := jsonData["retryStatusCodes"].(string); ok && strings.TrimSpace(v) != "" {
+		for _, code := range strings.Split(v, ",") {
+			statusCode, err := strconv.Atoi(strings.TrimSpace(code))
+			if err != nil {
+				qwlog.Warn("Invalid retryStatusCodes setting, using the default status codes", "value", v, "err", err)
+				retryStatusCodes = nil
+				break
+			}
+			retryStatusCodes = append(retryStatusCodes, statusCode)
+		}
+	}
+
+	var incremental *incrementalQueryCache
+	if enabled, ok := jsonData["incrementalQuerying"].(bool); ok && enabled {
+		overlap := readDurationSetting(jsonData, "incrementalQueryOverlapWindow", defaultIncrementalQueryOverlap)
+		incremental = newIncrementalQueryCache(overlap)
+	}
+
 	configuredFields := es.ConfiguredFields{
 		LogLevelField:    logLevelField,
 		LogMessageField:  logMessageField,
@@ -136,62 +184,62 @@ func NewQuickwitDatasource(ctx context.Context, settings backend.DataSourceInsta
 		Database:                   index,
 		MaxConcurrentShardRequests: int64(maxConcurrentShardRequests),
 		ConfiguredFields:           configuredFields,
-		ReadyStatus:                make(chan es.ReadyStatus, 1),
-		ShouldInit:                 true,
+		SearchAPI:                  searchAPI,
+		ResponseCache:              es.NewResponseCache(responseCacheTTL, responseCacheMaxBytes),
+		RangeShards:                int(rangeShards),
+		SearchTimeout:              searchTimeout,
+		RetryPolicy:                es.NewRetryPolicy(int(retryMaxAttempts), retryInitialBackoff, retryMaxBackoff, retryStatusCodes),
+		RequestLimiter:             es.NewRequestLimiter(int(maxConcurrentQueries), queryQueueTimeout),
 	}
 
-	ds := &QuickwitDatasource{dsInfo: model}
+	metadata := newIndexMetadataCache(index, metadataCacheTTL, func(ctx context.Context) ([]QuickwitIndexMetadata, error) {
+		return GetIndexesMetadata(ctx, model.Database, model.URL, model.HTTPClient)
+	})
+	go metadata.run()
+
+	return &QuickwitDatasource{dsInfo: model, metadata: metadata, incremental: incremental}, nil
+}
 
-	// Create an initialization goroutine
-	go func(ds *QuickwitDatasource, readyStatus chan<- es.ReadyStatus) {
-		var status es.ReadyStatus = es.ReadyStatus{
-			IsReady: false,
-			Err:     nil,
+// readIntSetting reads the integer of a setting, given as a number or as a
+// string, defaultValue when the setting is missing, empty or malformed
+func readIntSetting(jsonData map[string]interface{}, key string, defaultValue int64) int64 {
+	switch v := jsonData[key].(type) {
+	case float64:
+		return int64(v)
+	case string:
+		if v == "" {
+			return defaultValue
 		}
-		for {
-			// Will retry init everytime the channel is consumed until ready
-			if !status.IsReady || ds.dsInfo.ShouldInit {
-				qwlog.Debug("Initializing Datasource")
-				status.IsReady = true
-				status.Err = nil
-
-				indexMetadataList, err := GetIndexesMetadata(ds.dsInfo.Database, ds.dsInfo.URL, ds.dsInfo.HTTPClient)
-				if err != nil {
-					status.IsReady = false
-					status.Err = fmt.Errorf("failed to get index metadata : %w", err)
-				} else if len(indexMetadataList) == 0 {
-					status.IsReady = false
-					status.Err = fmt.Errorf("no index found for %s", ds.dsInfo.Database)
-				} else {
-					timeField, timeOutputFormat, err := GetTimestampFieldInfos(indexMetadataList)
-					if nil != err {
-						status.IsReady = false
-						status.Err = err
-					} else if "" == timeField {
-						status.IsReady = false
-						status.Err = fmt.Errorf("timefield is empty for %s", ds.dsInfo.Database)
-					} else if "" == timeOutputFormat {
-						status.Err = fmt.Errorf("timefield's output_format is empty, logs timestamps will not be parsed correctly for %s", ds.dsInfo.Database)
-					}
-
-					ds.dsInfo.ConfiguredFields.TimeField = timeField
-					ds.dsInfo.ConfiguredFields.TimeOutputFormat = timeOutputFormat
-					ds.dsInfo.ShouldInit = false
-				}
-			}
-			readyStatus <- status
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			qwlog.Warn("Invalid integer setting, using the default value", "setting", key, "value", v, "default", defaultValue, "err", err)
+			return defaultValue
 		}
-	}(ds, model.ReadyStatus)
-	return ds, nil
+		return i
+	}
+	return defaultValue
+}
+
+// readDurationSetting reads the duration of a setting, defaultValue when the
+// setting is missing, empty or malformed
+func readDurationSetting(jsonData map[string]interface{}, key string, defaultValue time.Duration) time.Duration {
+	v, ok := jsonData[key].(string)
+	if !ok || v == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		qwlog.Warn("Invalid duration setting, using the default value", "setting", key, "value", v, "default", defaultValue, "err", err)
+		return defaultValue
+	}
+	return d
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
 // created. As soon as datasource settings change detected by SDK old datasource instance will
 // be disposed and a new one will be created using NewSampleDatasource factory function.
 func (ds *QuickwitDatasource) Dispose() {
-	// FIXME: The ReadyStatus channel should probably be closed here, but doing it
-	// causes odd calls to healthcheck to fail. Needs investigation
-	// close(ds.dsInfo.ReadyStatus)
+	ds.metadata.close()
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin.
@@ -203,18 +251,10 @@ func (ds *QuickwitDatasource) CheckHealth(ctx context.Context, req *backend.Chec
 	res.Status = backend.HealthStatusOk
 	res.Message = "plugin is running"
 
-	ds.dsInfo.ShouldInit = true
-	status := <-ds.dsInfo.ReadyStatus
-
-	if nil != status.Err {
-		res.Status = backend.HealthStatusError
-		res.Message = fmt.Errorf("Failed to initialize datasource: %w", status.Err).Error()
-	} else if "" == ds.dsInfo.ConfiguredFields.TimeField {
+	// The test button is expected to reflect the current index config, refresh it
+	if err := ds.metadata.refresh(ctx); err != nil {
 		res.Status = backend.HealthStatusError
-		res.Message = fmt.Sprintf("timefield is missing from index config \"%s\"", ds.dsInfo.Database)
-	} else if "" == ds.dsInfo.ConfiguredFields.TimeOutputFormat {
-		res.Status = backend.HealthStatusError
-		res.Message = fmt.Sprintf("timefield's output_format is missing from index config \"%s\"", ds.dsInfo.Database)
+		res.Message = fmt.Errorf("Failed to initialize datasource: %w", err).Error()
 	}
 	qwlog.Debug(res.Message)
 
@@ -223,25 +263,50 @@ func (ds *QuickwitDatasource) CheckHealth(ctx context.Context, req *backend.Chec
 
 func (ds *QuickwitDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	// Ensure ds is initialized, we need timestamp infos
-	status := <-ds.dsInfo.ReadyStatus
-	if !status.IsReady {
-		qwlog.Debug(fmt.Errorf("Datasource initialization failed: %w", status.Err).Error())
+	dsInfo, err := ds.initializedDatasourceInfo(ctx, req)
+	if err != nil {
+		err = fmt.Errorf("Datasource initialization failed: %w", err)
+		qwlog.Debug(err.Error())
 		response := &backend.QueryDataResponse{
 			Responses: backend.Responses{},
 		}
-		response.Responses["__qwQueryDataError"] = backend.ErrDataResponse(backend.StatusInternal, "Datasource initialization failed")
+		for _, q := range req.Queries {
+			response.Responses[q.RefID] = errorDataResponse(err)
+		}
 		return response, nil
 	}
 
-	return queryData(ctx, req.Queries, &ds.dsInfo)
+	return queryData(ctx, req.Queries, dsInfo, ds.incremental)
+}
+
+// initializedDatasourceInfo returns a copy of the datasource info with the
+// timestamp infos of the metadata cache, for the user of the request
+func (ds *QuickwitDatasource) initializedDatasourceInfo(ctx context.Context, req backend.ForwardHTTPHeaders) (*es.DatasourceInfo, error) {
+	configuredFields, err := ds.metadata.configuredFields(ctx, ds.dsInfo.ConfiguredFields)
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.metadata.lastError(); err != nil {
+		qwlog.Debug("Index metadata refresh failed, using last known timestamp infos", "err", err)
+	}
+
+	dsInfo := ds.dsInfo
+	dsInfo.ConfiguredFields = configuredFields
+	dsInfo.ForwardedIdentity = es.ForwardedIdentity(req.GetHTTPHeaders())
+	return &dsInfo, nil
 }
 
 func (ds *QuickwitDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if req.Path == logContextPath {
+		return ds.callLogContext(ctx, req, sender)
+	}
+
 	// allowed paths for resource calls:
 	// - empty string for fetching db version
 	// - ?/_mapping for fetching index mapping
 	// - _msearch for executing getTerms queries
 	// - _field_caps for getting all the aggregeables fields
+	// - log_context, served by the plugin, for fetching the lines around a log line
 	var isFieldCaps = req.Path != "" && strings.Contains(req.Path, "_elastic") && strings.Contains(req.Path, "/_field_caps")
 	if req.Path != "" && !strings.Contains(req.Path, "indexes/") && req.Path != "_elastic/_msearch" && !isFieldCaps {
 		return fmt.Errorf("invalid resource URL: %s", req.Path)
@@ -0,0 +1,49 @@
+package quickwit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadDurationSetting(t *testing.T) {
+	for name, tc := range map[string]struct {
+		value    interface{}
+		expected time.Duration
+	}{
+		"missing":      {nil, time.Minute},
+		"empty":        {"", time.Minute},
+		"valid":        {"30s", 30 * time.Second},
+		"without unit": {"30", time.Minute},
+		"malformed":    {"soon", time.Minute},
+		"number":       {30.0, time.Minute},
+	} {
+		jsonData := map[string]interface{}{}
+		if tc.value != nil {
+			jsonData["ttl"] = tc.value
+		}
+		assert.Equal(t, tc.expected, readDurationSetting(jsonData, "ttl", time.Minute), name)
+	}
+}
+
+func TestReadIntSetting(t *testing.T) {
+	for name, tc := range map[string]struct {
+		value    interface{}
+		expected int64
+	}{
+		"missing":   {nil, 4},
+		"empty":     {"", 4},
+		"number":    {8.0, 8},
+		"string":    {"8", 8},
+		"decimal":   {"8.5", 4},
+		"malformed": {"eight", 4},
+		"boolean":   {true, 4},
+	} {
+		jsonData := map[string]interface{}{}
+		if tc.value != nil {
+			jsonData["shards"] = tc.value
+		}
+		assert.Equal(t, tc.expected, readIntSetting(jsonData, "shards", 4), name)
+	}
+}
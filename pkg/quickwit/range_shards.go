@@ -0,0 +1,160 @@
+package quickwit
+
+import (
+	"fmt"
+	"strings"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+)
+
+// rangeShard is a part of the time range of a query, in milliseconds. The end
+// of the shard is excluded, except for the last shard which ends with the
+// time range of the query.
+type rangeShard struct {
+	from int64
+	to   int64
+	last bool
+}
+
+// rangeShardAlignment returns the duration in milliseconds the boundaries of
+// the shards of a query fall on, and whether the time range of the query can
+// be split. The hits of the logs and documents queries are merged by their
+// sort values. The buckets of the date histogram queries are merged by key,
+// their shards fall on bucket boundaries so that no bucket is split. Their
+// buckets must not depend on the documents of the whole range.
+func rangeShardAlignment(q *Query, timeField string) (int64, bool) {
+	if q.RangeShards < 2 || isAnnotationsQuery(q) || isTracesQuery(q) || isTraceSearchQuery(q) {
+		return 0, false
+	}
+	if isLogsQuery(q) || isDocumentQuery(q) {
+		// Aligned on the interval to share the cached responses between refreshes
+		return max(q.Interval.Milliseconds(), 1), true
+	}
+	if isLogsVolumeQuery(q) {
+		return max(q.Interval.Milliseconds(), 1), q.Interval > 0
+	}
+
+	for _, m := range q.Metrics {
+		// The unique values of the shards may overlap, their counts cannot be
+		// added, and the percentiles of the shards cannot be merged
+		if isPipelineAgg(m.Type) || m.Type == "cardinality" || m.Type == "percentiles" || m.Type == "percentile_ranks" {
+			return 0, false
+		}
+	}
+	var alignment int64
+	for _, agg := range q.BucketAggs {
+		// The top terms and the composite pages of the shards would differ
+		// from the ones of the whole range
+		if bucketsDependOnRange(agg) {
+			return 0, false
+		}
+		if agg.Type != dateHistType {
+			continue
+		}
+		if alignment > 0 || (agg.Field != "" && agg.Field != timeField) {
+			return 0, false
+		}
+		interval, ok := dateHistogramInterval(q, agg)
+		if !ok {
+			return 0, false
+		}
+		alignment = interval.Milliseconds()
+	}
+	return alignment, alignment > 0
+}
+
+// queryRangeShards returns the time ranges searched for a query, the whole
+// time range of the query when it is not split
+func queryRangeShards(q *Query, timeField string) []rangeShard {
+	whole := []rangeShard{{from: q.RangeFrom, to: q.RangeTo, last: true}}
+	alignment, ok := rangeShardAlignment(q, timeField)
+	if !ok || q.RangeTo <= q.RangeFrom {
+		return whole
+	}
+
+	alignedFrom := q.RangeFrom - q.RangeFrom%alignment
+	units := (q.RangeTo - alignedFrom + alignment - 1) / alignment
+	shards := min(int64(q.RangeShards), units)
+	step := (units + shards - 1) / shards * alignment
+
+	var result []rangeShard
+	from := q.RangeFrom
+	for boundary := alignedFrom + step; boundary < q.RangeTo; boundary += step {
+		if boundary <= from {
+			continue
+		}
+		result = append(result, rangeShard{from: from, to: boundary})
+		from = boundary
+	}
+	return append(result, rangeShard{from: from, to: q.RangeTo, last: true})
+}
+
+// queryRequests groups the search requests built by buildMSR by query
+func queryRequests(requests []*es.SearchRequest, queries []*Query, timeField string) ([][]*es.SearchRequest, error) {
+	grouped := make([][]*es.SearchRequest, len(queries))
+	offset := 0
+	for i, q := range queries {
+		n := len(queryRangeShards(q, timeField))
+		if offset+n > len(requests) {
+			return nil, fmt.Errorf("expected %d search requests for query %s, got %d", n, q.RefID, len(requests)-offset)
+		}
+		grouped[i] = requests[offset : offset+n]
+		offset += n
+	}
+	return grouped, nil
+}
+
+// executeQueries executes the search requests of the queries and merges the
+// responses of the shards of each query, so that there is one response per
+// query. The following pages of the composite aggregations are then fetched.
+func executeQueries(client es.Client, requests []*es.SearchRequest, queries []*Query, timeField string) (*es.MultiSearchResponse, error) {
+	grouped, err := queryRequests(requests, queries, timeField)
+	if err != nil {
+		return nil, err
+	}
+	res, err := executeQueryShards(client, requests, grouped)
+	if err != nil {
+		return res, err
+	}
+
+	// The queries with a composite aggregation are not split into shards
+	firstRequests := make([]*es.SearchRequest, len(grouped))
+	for i, shardRequests := range grouped {
+		firstRequests[i] = shardRequests[0]
+	}
+	if err := es.FetchCompositePages(client, firstRequests, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// executeQueryShards executes the search requests of the queries, grouped by
+// query, and merges the responses of the shards of each query
+func executeQueryShards(client es.Client, requests []*es.SearchRequest, grouped [][]*es.SearchRequest) (*es.MultiSearchResponse, error) {
+	res, err := client.ExecuteMultisearch(requests)
+	if err != nil || len(requests) == len(grouped) {
+		return res, err
+	}
+	if len(res.Responses) != len(requests) {
+		return nil, &es.DecodeError{Err: fmt.Errorf("expected %d multisearch responses, got %d", len(requests), len(res.Responses))}
+	}
+
+	merged := &es.MultiSearchResponse{Stats: res.Stats}
+	offset := 0
+	for _, shardRequests := range grouped {
+		n := len(shardRequests)
+		response := res.Responses[offset]
+		if n > 1 {
+			response, err = es.MergeSearchResponses(shardRequests[0], res.Responses[offset:offset+n])
+			if err != nil {
+				return nil, err
+			}
+		}
+		merged.Responses = append(merged.Responses, response)
+		if offset+n <= len(res.ExecutedRequests) {
+			merged.ExecutedRequests = append(merged.ExecutedRequests, strings.Join(res.ExecutedRequests[offset:offset+n], ""))
+		}
+		offset += n
+	}
+	return merged, nil
+}
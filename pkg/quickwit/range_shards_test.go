@@ -0,0 +1,166 @@
+package quickwit
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+)
+
+func TestRangeShards(t *testing.T) {
+	const logsQuery = `{ "metrics": [{ "type": "logs", "id": "1", "settings": { "limit": "2" } }] }`
+	const histogramQuery = `{
+		"metrics": [{ "type": "count", "id": "1" }],
+		"bucketAggs": [
+			{ "type": "filters", "id": "3", "settings": { "filters": [{ "query": "host:web-1" }] } },
+			{ "type": "date_histogram", "id": "2", "settings": { "interval": "10m" } }
+		]
+	}`
+	from := time.UnixMilli(0)
+	to := time.UnixMilli(time.Hour.Milliseconds())
+	newDsInfo := func(shards int) *es.DatasourceInfo {
+		return &es.DatasourceInfo{
+			ConfiguredFields: es.ConfiguredFields{TimeField: "@timestamp", LogMessageField: "line"},
+			RangeShards:      shards,
+		}
+	}
+
+	t.Run("Only the logs, documents and date histogram queries are split", func(t *testing.T) {
+		for model, expected := range map[string]int{
+			logsQuery: 4,
+			// The 6 buckets are split into shards of 2 buckets
+			histogramQuery: 3,
+			`{ "metrics": [{ "type": "raw_data", "id": "1" }] }`:                                                                                                                                             4,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "terms", "field": "host", "id": "2" }] }`:                                                                               1,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "terms", "field": "host", "id": "3" }, { "type": "date_histogram", "id": "2", "settings": { "interval": "10m" } }] }`:   1,
+			`{ "metrics": [{ "type": "cardinality", "field": "user", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "10m" } }] }`:                             1,
+			`{ "metrics": [{ "type": "percentiles", "field": "latency", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "10m" } }] }`:                          1,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "field": "created_at", "settings": { "interval": "10m" } }] }`:                             1,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "10m", "trimEdges": "1" } }] }`:                                  1,
+			`{ "metrics": [{ "type": "count", "id": "1" }, { "type": "derivative", "id": "3", "field": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "10m" } }] }`: 1,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "1h", "intervalType": "calendar" } }] }`:                         1,
+			`{ "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2", "settings": { "interval": "10m", "timeZone": "Europe/Paris" } }] }`:                        1,
+		} {
+			c := newFakeClient()
+			_, err := executeElasticsearchDataQueries(c, map[string]string{"A": model}, from, to, newDsInfo(4), nil)
+			require.NoError(t, err, model)
+			assert.Len(t, c.multisearchRequests[0], expected, model)
+		}
+
+		c := newFakeClient()
+		_, err := executeElasticsearchDataQueries(c, map[string]string{"A": logsQuery}, from, to, newDsInfo(1), nil)
+		require.NoError(t, err)
+		assert.Len(t, c.multisearchRequests[0], 1)
+	})
+
+	t.Run("The logs volume queries with a sub-millisecond interval are split by millisecond", func(t *testing.T) {
+		q := &Query{
+			Metrics:     []*MetricAgg{{Type: logsVolumeType, ID: "1"}},
+			Interval:    500 * time.Microsecond,
+			RangeShards: 4,
+			RangeFrom:   0,
+			RangeTo:     10,
+		}
+		shards := queryRangeShards(q, "@timestamp")
+		require.Len(t, shards, 4)
+		assert.Equal(t, rangeShard{from: 0, to: 3}, shards[0])
+		assert.Equal(t, rangeShard{from: 9, to: 10, last: true}, shards[3])
+	})
+
+	t.Run("The shards are contiguous and fall on bucket boundaries", func(t *testing.T) {
+		c := newFakeClient()
+		_, err := executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, time.UnixMilli((5 * time.Minute).Milliseconds()), to, newDsInfo(4), nil)
+		require.NoError(t, err)
+		requests := c.multisearchRequests[0]
+		require.Len(t, requests, 3)
+
+		for i, expected := range []es.DateRangeFilter{
+			{Gte: "1970-01-01T00:05:00Z", Lt: "1970-01-01T00:20:00Z"},
+			{Gte: "1970-01-01T00:20:00Z", Lt: "1970-01-01T00:40:00Z"},
+			{Gte: "1970-01-01T00:40:00Z", Lte: "1970-01-01T01:00:00Z"},
+		} {
+			filter := requests[i].Query.Bool.Filters[0].(*es.DateRangeFilter)
+			assert.Equal(t, expected.Gte, filter.Gte)
+			assert.Equal(t, expected.Lt, filter.Lt)
+			if expected.Lte != "" {
+				assert.Equal(t, expected.Lte, filter.Lte)
+			}
+		}
+	})
+
+	t.Run("Each shard is searched with its own time range", func(t *testing.T) {
+		c := newFakeClient()
+		_, err := executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, from, to, newDsInfo(2), nil)
+		require.NoError(t, err)
+		requests := c.multisearchRequests[0]
+		require.Len(t, requests, 2)
+
+		first := requests[0].Query.Bool.Filters[0].(*es.DateRangeFilter)
+		assert.Equal(t, "1970-01-01T00:00:00Z", first.Gte)
+		assert.Equal(t, "1970-01-01T00:30:00Z", first.Lt)
+		bounds := requests[0].Aggs[0].Aggregation.Aggs[0].Aggregation.Aggregation.(*es.DateHistogramAgg).ExtendedBounds
+		assert.Equal(t, &es.ExtendedBounds{Min: 0, Max: (30 * time.Minute).Milliseconds() - 1}, bounds)
+
+		last := requests[1].Query.Bool.Filters[0].(*es.DateRangeFilter)
+		assert.Equal(t, "1970-01-01T00:30:00Z", last.Gte)
+		assert.Equal(t, "1970-01-01T01:00:00Z", last.Lte)
+		assert.Empty(t, last.Lt)
+	})
+
+	t.Run("The hits of the shards are merged and limited", func(t *testing.T) {
+		c := newFakeClient()
+		require.NoError(t, json.Unmarshal([]byte(`{
+			"responses": [
+				{ "hits": { "total": { "value": 2 }, "hits": [{ "_source": { "line": "b" }, "sort": [20] }, { "_source": { "line": "a" }, "sort": [10] }] } },
+				{ "hits": { "total": { "value": 1 }, "hits": [{ "_source": { "line": "c" }, "sort": [40] }] } }
+			]
+		}`), c.multiSearchResponse))
+		c.multiSearchResponse.ExecutedRequests = []string{"first\n", "last\n"}
+
+		result, err := executeElasticsearchDataQueries(c, map[string]string{"A": logsQuery}, from, to, newDsInfo(2), nil)
+		require.NoError(t, err)
+		res := result.Responses["A"]
+		require.NoError(t, res.Error)
+		require.Len(t, res.Frames, 1)
+		frame := res.Frames[0]
+
+		lines := []string{}
+		for _, field := range frame.Fields {
+			if field.Name == "line" {
+				for i := 0; i < field.Len(); i++ {
+					value, _ := field.ConcreteAt(i)
+					lines = append(lines, fmt.Sprint(value))
+				}
+			}
+		}
+		assert.Equal(t, []string{"c", "b"}, lines)
+		assert.Equal(t, "first\nlast\n", frame.Meta.ExecutedQueryString)
+	})
+
+	t.Run("The buckets of the shards are merged by key", func(t *testing.T) {
+		c := newFakeClient()
+		require.NoError(t, json.Unmarshal([]byte(`{
+			"responses": [
+				{ "aggregations": { "3": { "buckets": { "host:web-1": { "doc_count": 2, "2": { "buckets": [{ "key": 0, "doc_count": 2 }] } } } } } },
+				{ "aggregations": { "3": { "buckets": { "host:web-1": { "doc_count": 3, "2": { "buckets": [{ "key": 1800000, "doc_count": 3 }] } } } } } }
+			]
+		}`), c.multiSearchResponse))
+
+		result, err := executeElasticsearchDataQueries(c, map[string]string{"A": histogramQuery}, from, to, newDsInfo(2), nil)
+		require.NoError(t, err)
+		res := result.Responses["A"]
+		require.NoError(t, res.Error)
+		require.Len(t, res.Frames, 1)
+		frame := res.Frames[0]
+		require.Equal(t, 2, frame.Rows())
+		assert.Equal(t, time.UnixMilli(0).UTC(), frame.Fields[0].At(0))
+		assert.Equal(t, 2.0, *frame.Fields[1].At(0).(*float64))
+		assert.Equal(t, time.UnixMilli(1800000).UTC(), frame.Fields[0].At(1))
+		assert.Equal(t, 3.0, *frame.Fields[1].At(1).(*float64))
+	})
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -21,10 +23,12 @@ import (
 
 const (
 	// Metric types
-	countType         = "count"
-	percentilesType   = "percentiles"
-	extendedStatsType = "extended_stats"
-	topMetricsType    = "top_metrics"
+	countType           = "count"
+	percentilesType     = "percentiles"
+	percentileRanksType = "percentile_ranks"
+	extendedStatsType   = "extended_stats"
+	statsType           = "stats"
+	topMetricsType      = "top_metrics"
 	// Bucket types
 	dateHistType    = "date_histogram"
 	nestedType      = "nested"
@@ -32,92 +36,243 @@ const (
 	filtersType     = "filters"
 	termsType       = "terms"
 	geohashGridType = "geohash_grid"
+	rangeType       = "range"
+	dateRangeType   = "date_range"
+	compositeType   = "composite"
 	//  Document types
 	rawDocumentType = "raw_document"
 	rawDataType     = "raw_data"
 	// Logs type
-	logsType = "logs"
+	logsType       = "logs"
+	logsVolumeType = "logs_volume"
+	// Labels of the buckets of the terms aggregations
+	missingBucketLabel      = "(missing)"
+	defaultOtherBucketLabel = "Other"
 )
 
 var searchWordsRegex = regexp.MustCompile(regexp.QuoteMeta(es.HighlightPreTagsString) + `(.*?)` + regexp.QuoteMeta(es.HighlightPostTagsString))
 
-func parseResponse(rawResponses []*json.RawMessage, targets []*Query, configuredFields es.ConfiguredFields, dsInfo *es.DatasourceInfo) (*backend.QueryDataResponse, error) {
+func parseResponse(msr *es.MultiSearchResponse, targets []*Query, configuredFields es.ConfiguredFields, dsInfo *es.DatasourceInfo) (*backend.QueryDataResponse, error) {
 	result := backend.QueryDataResponse{
 		Responses: backend.Responses{},
 	}
-	if rawResponses == nil {
+	if msr == nil || msr.Responses == nil {
 		return &result, nil
 	}
 
-	for i, rawRes := range rawResponses {
-		target := targets[i]
-
-		byteReader := bytes.NewReader(*rawRes)
-		dec := json.NewDecoder(byteReader)
-		if isLogsQuery(target) || isTraceSearchQuery(target) || isTracesQuery(target) {
-			dec.UseNumber()
-		}
-		var res *es.SearchResponse
-		err := dec.Decode(&res)
-		if nil != err {
-			qwlog.Debug("Failed to decode response", "err", err.Error(), "byteRes", *rawRes)
+	for i, target := range targets {
+		if i >= len(msr.Responses) {
+			result.Responses[target.RefID] = errorDataResponse(&es.DecodeError{Err: fmt.Errorf("no response for query %s", target.RefID)})
 			continue
 		}
-
-		if res.Error != nil {
-			errResult := getErrorFromElasticResponse(res)
-			result.Responses[target.RefID] = backend.DataResponse{
-				Error: errors.New(errResult),
-			}
-			continue
+		var executedRequest string
+		if i < len(msr.ExecutedRequests) {
+			executedRequest = msr.ExecutedRequests[i]
 		}
+		result.Responses[target.RefID] = parseTargetResponse(msr.Responses[i], msr.Stats, executedRequest, target, configuredFields, dsInfo)
+	}
+	return &result, nil
+}
 
-		queryRes := backend.DataResponse{}
+// parseTargetResponse parses the multisearch response of a single target.
+// Failures are reported on the data response of the target only, so that the
+// other targets of the request still render.
+func parseTargetResponse(rawRes *json.RawMessage, stats es.MultiSearchStats, executedRequest string, target *Query, configuredFields es.ConfiguredFields, dsInfo *es.DatasourceInfo) backend.DataResponse {
+	if rawRes == nil {
+		return errorDataResponse(&es.DecodeError{Err: fmt.Errorf("no response for query %s", target.RefID)})
+	}
 
-		if isRawDataQuery(target) {
-			err := processRawDataResponse(res, target, configuredFields, &queryRes)
-			if err != nil {
-				return &backend.QueryDataResponse{}, err
-			}
-			result.Responses[target.RefID] = queryRes
-		} else if isRawDocumentQuery(target) {
-			err := processRawDocumentResponse(res, target, &queryRes)
-			if err != nil {
-				return &backend.QueryDataResponse{}, err
-			}
-			result.Responses[target.RefID] = queryRes
-		} else if isLogsQuery(target) {
-			err := processLogsResponse(res, target, configuredFields, &queryRes)
-			if err != nil {
-				return &backend.QueryDataResponse{}, err
-			}
-			result.Responses[target.RefID] = queryRes
-		} else if isTraceSearchQuery(target) {
-			err := processTraceSearchResponse(res, target, dsInfo, &queryRes)
-			if err != nil {
-				return &backend.QueryDataResponse{}, err
-			}
-			result.Responses[target.RefID] = queryRes
-		} else if isTracesQuery(target) {
-			err := processTracesResponse(res, target, configuredFields, dsInfo, &queryRes)
-			if err != nil {
-				return &backend.QueryDataResponse{}, err
-			}
-			result.Responses[target.RefID] = queryRes
+	byteReader := bytes.NewReader(*rawRes)
+	dec := json.NewDecoder(byteReader)
+	if isLogsQuery(target) || isRawDataQuery(target) || isTraceSearchQuery(target) || isTracesQuery(target) || isAnnotationsQuery(target) {
+		dec.UseNumber()
+	}
+	var res *es.SearchResponse
+	err := dec.Decode(&res)
+	if nil != err {
+		qwlog.Debug("Failed to decode response", "err", err.Error(), "byteRes", *rawRes)
+		return errorDataResponse(&es.DecodeError{Err: err})
+	}
+	if res == nil {
+		return errorDataResponse(&es.DecodeError{Err: fmt.Errorf("empty response for query %s", target.RefID)})
+	}
+
+	if res.Error != nil || res.Status >= 400 {
+		status := res.Status
+		if status < 400 {
+			status = int(backend.StatusInternal)
+		}
+		return backend.ErrDataResponseWithSource(backend.Status(status), backend.ErrorSourceFromHTTPStatus(status), getErrorFromElasticResponse(res))
+	}
+
+	queryRes := backend.DataResponse{}
+
+	if isAnnotationsQuery(target) {
+		err = processAnnotationsResponse(res, target, configuredFields, &queryRes)
+	} else if isRawDataQuery(target) {
+		err = processRawDataResponse(res, target, configuredFields, &queryRes)
+	} else if isRawDocumentQuery(target) {
+		err = processRawDocumentResponse(res, target, &queryRes)
+	} else if isLogsQuery(target) {
+		err = processLogsResponse(res, target, configuredFields, &queryRes)
+	} else if isLogsVolumeQuery(target) {
+		err = processLogsVolumeResponse(res, target, &queryRes)
+	} else if isTraceSearchQuery(target) {
+		err = processTraceSearchResponse(res, target, dsInfo, &queryRes)
+	} else if isTracesQuery(target) {
+		err = processTracesResponse(res, target, configuredFields, dsInfo, &queryRes)
+	} else {
+		// Process as metric query result
+		if isMultiDimensionalQuery(target) {
+			err = processMultiDimensionalBuckets(res.Aggregations, target, &queryRes)
 		} else {
-			// Process as metric query result
 			props := make(map[string]string)
-			err := processBuckets(res.Aggregations, target, &queryRes, props, 0)
-			if err != nil {
-				return &backend.QueryDataResponse{}, err
-			}
+			err = processBuckets(res.Aggregations, target, &queryRes, props, 0)
+		}
+		if err == nil {
 			nameFields(queryRes, target)
 			trimDatapoints(queryRes, target)
+		}
+	}
+	if err != nil {
+		qwlog.Debug("Failed to process response", "refId", target.RefID, "err", err.Error())
+		return errorDataResponse(err)
+	}
 
-			result.Responses[target.RefID] = queryRes
+	if res.TimedOut {
+		addTimeoutNotice(&queryRes, target)
+		for _, frame := range queryRes.Frames {
+			setFrameCustomMeta(frame, timedOutCustomMeta, true)
 		}
 	}
-	return &result, nil
+	if res.Truncated {
+		addWarningNotice(&queryRes, target, fmt.Sprintf("The groups are truncated to the first %d pages of the composite aggregation", es.MaxCompositePages))
+	}
+	addFrameStats(queryRes.Frames, searchStats(res, stats), executedRequest)
+
+	return queryRes
+}
+
+// addTimeoutNotice warns that the results of a timed out search are partial
+func addTimeoutNotice(queryRes *backend.DataResponse, target *Query) {
+	text := "The search timed out, the results may be partial"
+	if target.Timeout > 0 {
+		text = fmt.Sprintf("The search timed out after %s, the results may be partial", target.Timeout)
+	}
+	addWarningNotice(queryRes, target, text)
+}
+
+// addWarningNotice adds a warning to the frames of a response. A response
+// without frames gets an empty frame to carry the notice.
+func addWarningNotice(queryRes *backend.DataResponse, target *Query, text string) {
+	if len(queryRes.Frames) == 0 {
+		queryRes.Frames = data.Frames{data.NewFrame("").SetRefID(target.RefID)}
+	}
+	for _, frame := range queryRes.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Notices = append(frame.Meta.Notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: text})
+	}
+}
+
+// isTimedOutResponse returns true when the data response holds the partial
+// results of a timed out search
+func isTimedOutResponse(res backend.DataResponse) bool {
+	for _, frame := range res.Frames {
+		if frame.Meta == nil {
+			continue
+		}
+		if custom, ok := frame.Meta.Custom.(map[string]interface{}); ok {
+			if timedOut, _ := custom[timedOutCustomMeta].(bool); timedOut {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const (
+	// timedOutStat is the statistic set on the frames of the timed out searches
+	timedOutStat = "Timed out"
+	// timedOutCustomMeta flags the frames of the timed out searches in their
+	// custom meta
+	timedOutCustomMeta = "timedOut"
+)
+
+// setFrameCustomMeta sets a value in the custom meta of a frame, keeping the
+// values already set
+func setFrameCustomMeta(frame *data.Frame, key string, value interface{}) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	switch custom := frame.Meta.Custom.(type) {
+	case nil:
+		frame.Meta.Custom = map[string]interface{}{key: value}
+	case map[string]interface{}:
+		custom[key] = value
+	}
+}
+
+// searchStats returns the statistics of a search shown by the query inspector
+func searchStats(res *es.SearchResponse, stats es.MultiSearchStats) []data.QueryStat {
+	var queryStats []data.QueryStat
+	addStat := func(name, unit string, value float64) {
+		queryStats = append(queryStats, data.QueryStat{
+			FieldConfig: data.FieldConfig{DisplayName: name, Unit: unit},
+			Value:       value,
+		})
+	}
+	durationMs := func(d time.Duration) float64 {
+		return float64(d.Microseconds()) / 1000
+	}
+
+	if res.Took != nil {
+		addStat("Quickwit search time", "ms", float64(*res.Took))
+	}
+	if res.TimedOut {
+		addStat(timedOutStat, "", 1)
+	}
+	if res.Hits != nil && res.Hits.Total != nil {
+		addStat("Total hits", "", float64(res.Hits.Total.Value))
+	}
+	if res.Shards != nil {
+		addStat("Splits searched", "", float64(res.Shards.Total))
+		addStat("Splits successful", "", float64(res.Shards.Successful))
+		addStat("Splits skipped", "", float64(res.Shards.Skipped))
+		addStat("Splits failed", "", float64(res.Shards.Failed))
+	}
+	if stats.RoundTrip > 0 {
+		addStat("Request round trip", "ms", durationMs(stats.RoundTrip))
+	}
+	if stats.Decode > 0 {
+		addStat("Response decoding", "ms", durationMs(stats.Decode))
+	}
+	if stats.Attempts > 0 {
+		addStat("Request attempts", "", float64(stats.Attempts))
+	}
+	if stats.CacheHits+stats.CacheMisses > 0 {
+		addStat("Response cache hits", "", float64(stats.CacheHits))
+		addStat("Response cache misses", "", float64(stats.CacheMisses))
+	}
+	return queryStats
+}
+
+// addFrameStats attaches the statistics and the executed request to the
+// frames of a response, so that they are shown by the query inspector. The
+// request is also kept in the custom meta to be copied as is.
+func addFrameStats(frames data.Frames, stats []data.QueryStat, executedRequest string) {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Stats = append(frame.Meta.Stats, stats...)
+		if executedRequest == "" {
+			continue
+		}
+		frame.Meta.ExecutedQueryString = executedRequest
+		setFrameCustomMeta(frame, "executedRequest", executedRequest)
+	}
 }
 
 func processLogsResponse(res *es.SearchResponse, target *Query, configuredFields es.ConfiguredFields, queryRes *backend.DataResponse) error {
@@ -163,19 +318,8 @@ func processLogsResponse(res *es.SearchResponse, target *Query, configuredFields
 		// Always set a unique id per row. Grafana's virtualized log panel uses
 		// LogRowModel.uid (derived from the "id" field) as a cache key for
 		// row height measurements. Without unique ids, rows sharing the same
-		// cache key cause an infinite resetAfterIndex loop. Prefer the hit's
-		// own _index/_id when present (matches built-in ES datasource), fall
-		// back to the row index otherwise.
-		hitIndex, _ := hit["_index"].(string)
-		hitID, _ := hit["_id"].(string)
-		switch {
-		case hitIndex != "" && hitID != "":
-			doc["id"] = hitIndex + "#" + hitID
-		case hitID != "":
-			doc["id"] = hitID
-		default:
-			doc["id"] = strconv.Itoa(hitIdx)
-		}
+		// cache key cause an infinite resetAfterIndex loop.
+		doc["id"] = logsHitID(hit, hitIdx)
 
 		docs[hitIdx] = doc
 	}
@@ -187,13 +331,39 @@ func processLogsResponse(res *es.SearchResponse, target *Query, configuredFields
 	frames := data.Frames{}
 	frame := data.NewFrame("", fields...)
 	setPreferredVisType(frame, data.VisTypeLogs)
-	setLogsCustomMeta(frame, searchWords, stringToIntWithDefaultValue(target.Metrics[0].Settings.Get("limit").MustString(), defaultSize))
+	limit := stringToIntWithDefaultValue(target.Metrics[0].Settings.Get("limit").MustString(), defaultSize)
+	setLogsCustomMeta(frame, searchWords, limit)
+	setPaginationMeta(frame, res, target.Metrics[0].Settings, limit)
 	frames = append(frames, frame)
 
 	queryRes.Frames = frames
 	return nil
 }
 
+// logsHitID returns the row id of a logs hit. Prefer the hit's own _index/_id
+// when present (matches built-in ES datasource), then the sort values of the
+// hit, which Quickwit hits have instead of an _id and which tell the rows of
+// successive pages apart, and fall back to the row index otherwise.
+func logsHitID(hit map[string]interface{}, hitIdx int) string {
+	hitIndex, _ := hit["_index"].(string)
+	hitID, _ := hit["_id"].(string)
+	sortValues, _ := hit["sort"].([]interface{})
+	switch {
+	case hitIndex != "" && hitID != "":
+		return hitIndex + "#" + hitID
+	case hitID != "":
+		return hitID
+	case len(sortValues) > 0:
+		values := make([]string, len(sortValues))
+		for i, v := range sortValues {
+			values[i] = fmt.Sprint(v)
+		}
+		return strings.Join(values, "#")
+	default:
+		return strconv.Itoa(hitIdx)
+	}
+}
+
 func processRawDataResponse(res *es.SearchResponse, target *Query, configuredFields es.ConfiguredFields, queryRes *backend.DataResponse) error {
 	propNames := make(map[string]bool)
 	docs := make([]map[string]interface{}, len(res.Hits.Hits))
@@ -224,12 +394,41 @@ func processRawDataResponse(res *es.SearchResponse, target *Query, configuredFie
 
 	frames := data.Frames{}
 	frame := data.NewFrame("", fields...)
+	setPaginationMeta(frame, res, target.Metrics[0].Settings, stringToIntWithDefaultValue(target.Metrics[0].Settings.Get("size").MustString(), defaultSize))
 	frames = append(frames, frame)
 
 	queryRes.Frames = frames
 	return nil
 }
 
+// setPaginationMeta sets the cursor of the next page, the sort values of the
+// last row, and whether more rows follow it. A full page is assumed to be
+// followed by more rows, unless the exact number of hits tells otherwise.
+func setPaginationMeta(frame *data.Frame, res *es.SearchResponse, settings *simplejson.Json, size int) {
+	hits := res.Hits.Hits
+	hasMore := size > 0 && len(hits) >= size
+	isFirstPage := len(settings.Get("searchAfter").MustArray()) == 0
+	if hasMore && isFirstPage && res.Hits.Total != nil && res.Hits.Total.Relation == "eq" {
+		hasMore = res.Hits.Total.Value > int64(len(hits))
+	}
+
+	var cursor interface{}
+	if len(hits) > 0 {
+		cursor = hits[len(hits)-1]["sort"]
+	}
+
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	custom, ok := frame.Meta.Custom.(map[string]interface{})
+	if !ok {
+		custom = map[string]interface{}{}
+		frame.Meta.Custom = custom
+	}
+	custom["cursor"] = cursor
+	custom["hasMore"] = hasMore
+}
+
 func processRawDocumentResponse(res *es.SearchResponse, target *Query, queryRes *backend.DataResponse) error {
 	docs := make([]map[string]interface{}, len(res.Hits.Hits))
 	for hitIdx, hit := range res.Hits.Hits {
@@ -370,6 +569,9 @@ func processBuckets(aggs map[string]interface{}, target *Query,
 		if aggDef == nil {
 			continue
 		}
+		if aggDef.Type == rangeType || aggDef.Type == dateRangeType {
+			esAgg = rangeBucketsAsArray(esAgg)
+		}
 		if aggDef.Type == nestedType {
 			err = processBuckets(esAgg.MustMap(), target, queryResult, props, depth+1)
 			if err != nil {
@@ -396,13 +598,15 @@ func processBuckets(aggs map[string]interface{}, target *Query,
 					newProps[k] = v
 				}
 
-				if key, err := bucket.Get("key").String(); err == nil {
-					newProps[aggDef.Field] = key
-				} else if key, err := bucket.Get("key").Int64(); err == nil {
-					newProps[aggDef.Field] = strconv.FormatInt(key, 10)
-				}
-
-				if key, err := bucket.Get("key_as_string").String(); err == nil {
+				if aggDef.Type == compositeType {
+					for k, v := range compositeKeyLabels(aggDef, bucket) {
+						newProps[k] = v
+					}
+				} else if aggDef.Type == dateHistType {
+					if key, ok := dateHistogramKeyLabel(aggDef, bucket); ok {
+						newProps[aggDef.Field] = key
+					}
+				} else if key, ok := termsKeyLabel(aggDef, bucket); ok {
 					newProps[aggDef.Field] = key
 				}
 				err = processBuckets(bucket.MustMap(), target, queryResult, newProps, depth+1)
@@ -410,6 +614,12 @@ func processBuckets(aggs map[string]interface{}, target *Query,
 					return err
 				}
 			}
+			if total, ok := aggs[aggDef.ID+termsTotalSuffix]; ok && aggDef.Type == termsType {
+				err = processOtherTermsCount(esAgg, simplejson.NewFromAny(total), aggDef, target, queryResult, props)
+				if err != nil {
+					return err
+				}
+			}
 
 			buckets := esAgg.Get("buckets").MustMap()
 			bucketKeys := make([]string, 0)
@@ -438,6 +648,157 @@ func processBuckets(aggs map[string]interface{}, target *Query,
 	return nil
 }
 
+// rangeBucketsAsArray turns the buckets of a keyed range aggregation into an
+// array of buckets sorted by range, the key of each bucket being its label
+func rangeBucketsAsArray(esAgg *simplejson.Json) *simplejson.Json {
+	keyedBuckets, err := esAgg.Get("buckets").Map()
+	if err != nil {
+		return esAgg
+	}
+	buckets := make([]interface{}, 0, len(keyedBuckets))
+	for key, b := range keyedBuckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		bucket["key"] = key
+		buckets = append(buckets, bucket)
+	}
+	bound := func(b interface{}, name string, unbounded float64) float64 {
+		if value := castToFloat(simplejson.NewFromAny(b).Get(name)); value != nil {
+			return *value
+		}
+		return unbounded
+	}
+	sort.SliceStable(buckets, func(i, j int) bool {
+		iFrom, jFrom := bound(buckets[i], "from", math.Inf(-1)), bound(buckets[j], "from", math.Inf(-1))
+		if iFrom != jFrom {
+			return iFrom < jFrom
+		}
+		return bound(buckets[i], "to", math.Inf(1)) < bound(buckets[j], "to", math.Inf(1))
+	})
+	esAgg.Set("buckets", buckets)
+	return esAgg
+}
+
+// bucketKeyLabel returns the key of a bucket as a label value
+func bucketKeyLabel(bucket *simplejson.Json) (string, bool) {
+	if key, err := bucket.Get("key_as_string").String(); err == nil {
+		return key, true
+	}
+	if key, err := bucket.Get("key").String(); err == nil {
+		return key, true
+	}
+	if key, err := bucket.Get("key").Int64(); err == nil {
+		return strconv.FormatInt(key, 10), true
+	}
+	return "", false
+}
+
+// dateHistogramKeyLabel returns the label of the key of a bucket of a date
+// histogram, the start of the bucket in the time zone of the histogram
+func dateHistogramKeyLabel(aggDef *BucketAgg, bucket *simplejson.Json) (string, bool) {
+	timeZone := aggDef.Settings.Get("timeZone").MustString()
+	if isUTCTimeZone(timeZone) {
+		return bucketKeyLabel(bucket)
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return bucketKeyLabel(bucket)
+	}
+	key, err := getAsTime(bucket.Get("key"))
+	if err != nil {
+		return bucketKeyLabel(bucket)
+	}
+	return key.In(location).Format(time.RFC3339), true
+}
+
+// termsKeyLabel returns the label of the key of a bucket, the bucket of the
+// documents without value of a terms aggregation being labelled as missing
+func termsKeyLabel(aggDef *BucketAgg, bucket *simplejson.Json) (string, bool) {
+	key, ok := bucketKeyLabel(bucket)
+	if ok && aggDef.Type == termsType && aggDef.Settings.Get("missingBucket").MustBool() {
+		if missing := aggDef.Settings.Get("missing").MustString(); missing != "" && key == missing {
+			return missingBucketLabel, true
+		}
+	}
+	return key, ok
+}
+
+// otherBucketLabel returns the label of the other bucket of a terms aggregation
+func otherBucketLabel(aggDef *BucketAgg) string {
+	if label := aggDef.Settings.Get("otherBucketLabel").MustString(); label != "" {
+		return label
+	}
+	return defaultOtherBucketLabel
+}
+
+// processOtherTermsCount adds the count of the documents of the other bucket
+// of a terms aggregation followed by a date histogram, that is the count of
+// all the documents less the counts of the terms
+func processOtherTermsCount(esAgg *simplejson.Json, total *simplejson.Json, aggDef *BucketAgg, target *Query,
+	queryResult *backend.DataResponse, props map[string]string) error {
+	if !slices.ContainsFunc(target.Metrics, func(m *MetricAgg) bool { return m.Type == countType && !m.Hide }) {
+		return nil
+	}
+
+	otherProps := make(map[string]string, len(props)+1)
+	for k, v := range props {
+		otherProps[k] = v
+	}
+	otherProps[aggDef.Field] = otherBucketLabel(aggDef)
+	frames, err := processCountMetric(otherTermsCountBuckets(esAgg, total, target), otherProps)
+	if err != nil {
+		return err
+	}
+	queryResult.Frames = append(queryResult.Frames, frames...)
+	return nil
+}
+
+// otherTermsCountBuckets returns the date histogram buckets of the other
+// bucket of a terms aggregation, from the date histogram counting all the
+// documents of its parent bucket
+func otherTermsCountBuckets(esAgg *simplejson.Json, total *simplejson.Json, target *Query) []*simplejson.Json {
+	dateHistID := target.BucketAggs[len(target.BucketAggs)-1].ID
+
+	termsCounts := make(map[string]float64)
+	for _, b := range esAgg.Get("buckets").MustArray() {
+		for _, h := range simplejson.NewFromAny(b).GetPath(dateHistID, "buckets").MustArray() {
+			bucket := simplejson.NewFromAny(h)
+			if count := castToFloat(bucket.Get("doc_count")); count != nil {
+				termsCounts[fmt.Sprint(bucket.Get("key").Interface())] += *count
+			}
+		}
+	}
+
+	buckets := make([]*simplejson.Json, 0)
+	for _, b := range total.Get("buckets").MustArray() {
+		bucket := simplejson.NewFromAny(b)
+		count := castToFloat(bucket.Get("doc_count"))
+		if count == nil {
+			continue
+		}
+		other := math.Max(*count-termsCounts[fmt.Sprint(bucket.Get("key").Interface())], 0)
+		buckets = append(buckets, simplejson.NewFromAny(map[string]interface{}{
+			"key":       bucket.Get("key").Interface(),
+			"doc_count": other,
+		}))
+	}
+	return buckets
+}
+
+// compositeKeyLabels returns the key of a composite bucket as labels, one per
+// field of the aggregation
+func compositeKeyLabels(aggDef *BucketAgg, bucket *simplejson.Json) map[string]string {
+	labels := make(map[string]string)
+	for _, field := range compositeFields(aggDef) {
+		if value := bucket.GetPath("key", field).Interface(); value != nil {
+			labels[field] = fmt.Sprint(value)
+		}
+	}
+	return labels
+}
+
 func newTimeSeriesFrame(timeData []time.Time, tags map[string]string, values []*float64) *data.Frame {
 	frame := data.NewFrame("",
 		data.NewField(data.TimeSeriesTimeFieldName, nil, timeData),
@@ -494,7 +855,7 @@ func processPercentilesMetric(metric *MetricAgg, buckets []*simplejson.Json, pro
 		for k, v := range props {
 			tags[k] = v
 		}
-		tags["metric"] = "p" + percentileName
+		tags["metric"] = percentileLabel(metric, percentileName)
 		tags["field"] = metric.Field
 		for _, bucket := range buckets {
 			value := castToFloat(bucket.GetPath(metric.ID, "values", percentileName))
@@ -512,6 +873,15 @@ func processPercentilesMetric(metric *MetricAgg, buckets []*simplejson.Json, pro
 	return frames, nil
 }
 
+// percentileLabel returns the label of a value of a percentiles or percentile
+// ranks metric
+func percentileLabel(metric *MetricAgg, key string) string {
+	if metric.Type == percentileRanksType {
+		return "rank " + key
+	}
+	return "p" + key
+}
+
 func processTopMetricsMetric(metric *MetricAgg, buckets []*simplejson.Json, props map[string]string) (data.Frames, error) {
 	metrics := metric.Settings.Get("metrics").MustArray()
 
@@ -558,22 +928,32 @@ func processTopMetricsMetric(metric *MetricAgg, buckets []*simplejson.Json, prop
 	return frames, nil
 }
 
-func processExtendedStatsMetric(metric *MetricAgg, buckets []*simplejson.Json, props map[string]string) (data.Frames, error) {
-	metaKeys := make([]string, 0)
-	meta := metric.Meta.MustMap()
-	for k := range meta {
-		metaKeys = append(metaKeys, k)
+// statsNames are the values of a stats metric
+var statsNames = []string{"avg", "count", "max", "min", "sum"}
+
+// enabledStats returns the sorted names of the stats enabled in the meta of an
+// extended stats or stats metric, a stats metric returns all its stats when
+// none is enabled
+func enabledStats(metric *MetricAgg) []string {
+	names := make([]string, 0)
+	if metric.Meta != nil {
+		for name, v := range metric.Meta.MustMap() {
+			if enabled, ok := v.(bool); ok && enabled {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 && metric.Type == statsType {
+		return statsNames
 	}
-	sort.Strings(metaKeys)
+	sort.Strings(names)
+	return names
+}
 
+func processExtendedStatsMetric(metric *MetricAgg, buckets []*simplejson.Json, props map[string]string) (data.Frames, error) {
 	frames := data.Frames{}
 
-	for _, statName := range metaKeys {
-		v := meta[statName]
-		if enabled, ok := v.(bool); !ok || !enabled {
-			continue
-		}
-
+	for _, statName := range enabledStats(metric) {
 		tags := make(map[string]string, len(props))
 		timeVector := make([]time.Time, 0, len(buckets))
 		values := make([]*float64, 0, len(buckets))
@@ -608,6 +988,20 @@ func processExtendedStatsMetric(metric *MetricAgg, buckets []*simplejson.Json, p
 	return frames, nil
 }
 
+// setValuesUnit sets the unit of the values of time series frames
+func setValuesUnit(frames data.Frames, unit string) {
+	if unit == "" {
+		return
+	}
+	for _, frame := range frames {
+		valueField := frame.Fields[len(frame.Fields)-1]
+		if valueField.Config == nil {
+			valueField.Config = &data.FieldConfig{}
+		}
+		valueField.Config.Unit = unit
+	}
+}
+
 func processDefaultMetric(metric *MetricAgg, buckets []*simplejson.Json, props map[string]string) (data.Frames, error) {
 	tags := make(map[string]string, len(props))
 	timeVector := make([]time.Time, 0, len(buckets))
@@ -658,6 +1052,7 @@ func processMetrics(esAgg *simplejson.Json, target *Query, query *backend.DataRe
 			continue
 		}
 
+		metricFrames := len(frames)
 		switch metric.Type {
 		case countType:
 			countFrames, err := processCountMetric(jsonBuckets, props)
@@ -665,7 +1060,7 @@ func processMetrics(esAgg *simplejson.Json, target *Query, query *backend.DataRe
 				return err
 			}
 			frames = append(frames, countFrames...)
-		case percentilesType:
+		case percentilesType, percentileRanksType:
 			percentileFrames, err := processPercentilesMetric(metric, jsonBuckets, props)
 			if err != nil {
 				return err
@@ -677,7 +1072,7 @@ func processMetrics(esAgg *simplejson.Json, target *Query, query *backend.DataRe
 				return err
 			}
 			frames = append(frames, topMetricsFrames...)
-		case extendedStatsType:
+		case extendedStatsType, statsType:
 			extendedStatsFrames, err := processExtendedStatsMetric(metric, jsonBuckets, props)
 			if err != nil {
 				return err
@@ -691,6 +1086,7 @@ func processMetrics(esAgg *simplejson.Json, target *Query, query *backend.DataRe
 			}
 			frames = append(frames, defaultFrames...)
 		}
+		setValuesUnit(frames[metricFrames:], metricUnit(metric))
 	}
 	if query.Frames != nil {
 		oldFrames := query.Frames
@@ -712,11 +1108,24 @@ func processAggregationDocs(esAgg *simplejson.Json, aggDef *BucketAgg, target *Q
 		return nil
 	}
 
-	for _, v := range esAgg.Get("buckets").MustArray() {
+	buckets := esAgg.Get("buckets").MustArray()
+	// The keys of the terms are labels when the missing or other buckets are
+	// shown, the keys of these buckets being strings
+	termsLabels := aggDef.Type == termsType &&
+		(aggDef.Settings.Get("missingBucket").MustBool() || aggDef.Settings.Get("otherBucket").MustBool())
+	if aggDef.Type == termsType && aggDef.Settings.Get("otherBucket").MustBool() {
+		if other := castToFloat(esAgg.Get("sum_other_doc_count")); other != nil && *other > 0 {
+			buckets = append(buckets, map[string]interface{}{
+				"key":       otherBucketLabel(aggDef),
+				"doc_count": *other,
+			})
+		}
+	}
+
+	for _, v := range buckets {
 		bucket := simplejson.NewFromAny(v)
 		var values []interface{}
 
-		found := false
 		for _, field := range fields {
 			for _, propKey := range propKeys {
 				if field.Name == propKey {
@@ -724,46 +1133,39 @@ func processAggregationDocs(esAgg *simplejson.Json, aggDef *BucketAgg, target *Q
 					field.Append(&value)
 				}
 			}
-			if field.Name == aggDef.Field {
-				found = true
-				if key, err := bucket.Get("key").String(); err == nil {
-					field.Append(&key)
-				} else {
-					f, err := bucket.Get("key").Float64()
-					if err != nil {
-						return err
-					}
-					field.Append(&f)
-				}
-			}
 		}
 
-		if !found {
-			var aggDefField *data.Field
-			if key, err := bucket.Get("key").String(); err == nil {
-				aggDefField = extractDataField(aggDef.Field, &key)
-				aggDefField.Append(&key)
-			} else {
-				f, err := bucket.Get("key").Float64()
-				if err != nil {
+		if aggDef.Type == compositeType {
+			// One column per field of the composite key
+			for _, field := range compositeFields(aggDef) {
+				if err := appendBucketKey(&fields, field, bucket.GetPath("key", field)); err != nil {
 					return err
 				}
-				aggDefField = extractDataField(aggDef.Field, &f)
-				aggDefField.Append(&f)
 			}
-			fields = append(fields, aggDefField)
+		} else if termsLabels {
+			key, ok := termsKeyLabel(aggDef, bucket)
+			if !ok {
+				key = fmt.Sprint(bucket.Get("key").Interface())
+			}
+			if err := appendBucketKey(&fields, aggDef.Field, simplejson.NewFromAny(key)); err != nil {
+				return err
+			}
+		} else if err := appendBucketKey(&fields, aggDef.Field, bucket.Get("key")); err != nil {
+			return err
 		}
 
 		for _, metric := range target.Metrics {
 			switch metric.Type {
 			case countType:
-				addMetricValueToFields(&fields, values, getMetricName(metric.Type), castToFloat(bucket.Get("doc_count")))
-			case extendedStatsType:
-				addExtendedStatsToFields(&fields, bucket, metric, values)
-			case percentilesType:
-				addPercentilesToFields(&fields, bucket, metric, values)
+				metricName := getMetricName(metric.Type)
+				addMetricValueToFields(&fields, values, metricColumnName(target, metric, metricName, false),
+					metricFieldConfig(target, metric, metricName), castToFloat(bucket.Get("doc_count")))
+			case extendedStatsType, statsType:
+				addExtendedStatsToFields(&fields, bucket, metric, values, target)
+			case percentilesType, percentileRanksType:
+				addPercentilesToFields(&fields, bucket, metric, values, target)
 			case topMetricsType:
-				addTopMetricsToFields(&fields, bucket, metric, values)
+				addTopMetricsToFields(&fields, bucket, metric, values, target)
 			default:
 				addOtherMetricsToFields(&fields, bucket, metric, values, target)
 			}
@@ -781,6 +1183,37 @@ func processAggregationDocs(esAgg *simplejson.Json, aggDef *BucketAgg, target *Q
 	return nil
 }
 
+// appendBucketKey appends the key of a bucket to the field with the given
+// name, which is created when missing
+func appendBucketKey(fields *[]*data.Field, name string, key *simplejson.Json) error {
+	var field *data.Field
+	for _, f := range *fields {
+		if f.Name == name {
+			field = f
+			break
+		}
+	}
+
+	if s, err := key.String(); err == nil {
+		if field == nil {
+			field = extractDataField(name, &s)
+			*fields = append(*fields, field)
+		}
+		field.Append(&s)
+		return nil
+	}
+	f, err := key.Float64()
+	if err != nil {
+		return err
+	}
+	if field == nil {
+		field = extractDataField(name, &f)
+		*fields = append(*fields, field)
+	}
+	field.Append(&f)
+	return nil
+}
+
 func extractDataField(name string, v interface{}) *data.Field {
 	var field *data.Field
 	switch v.(type) {
@@ -865,10 +1298,10 @@ func nameFields(queryResult backend.DataResponse, target *Query) {
 	}
 	metricTypeCount := len(set)
 	for _, frame := range frames {
-		if frame.Meta != nil && frame.Meta.Type == data.FrameTypeTimeSeriesMulti {
+		if frame.Meta != nil && (frame.Meta.Type == data.FrameTypeTimeSeriesMulti || frame.Meta.Type == data.FrameTypeNumericMulti) {
 			// if it is a time-series-multi, it means it has two columns, one is "time",
-			// another is "number"
-			valueField := frame.Fields[1]
+			// another is "number". A numeric-multi only has the "number" column.
+			valueField := frame.Fields[len(frame.Fields)-1]
 			fieldName := getFieldName(*valueField, target, metricTypeCount)
 			if valueField.Config == nil {
 				valueField.Config = &data.FieldConfig{}
@@ -892,31 +1325,7 @@ func getFieldName(dataField data.Field, target *Query, metricTypeCount int) stri
 	}
 
 	if target.Alias != "" {
-		frameName := target.Alias
-
-		subMatches := aliasPatternRegex.FindAllStringSubmatch(target.Alias, -1)
-		for _, subMatch := range subMatches {
-			group := subMatch[0]
-
-			if len(subMatch) > 1 {
-				group = subMatch[1]
-			}
-
-			if strings.Index(group, "term ") == 0 {
-				frameName = strings.Replace(frameName, subMatch[0], dataField.Labels[group[5:]], 1)
-			}
-			if v, ok := dataField.Labels[group]; ok {
-				frameName = strings.Replace(frameName, subMatch[0], v, 1)
-			}
-			if group == "metric" {
-				frameName = strings.Replace(frameName, subMatch[0], metricName, 1)
-			}
-			if group == "field" {
-				frameName = strings.Replace(frameName, subMatch[0], field, 1)
-			}
-		}
-
-		return frameName
+		return formatAlias(target.Alias, dataField.Labels, metricName, field)
 	}
 	// todo, if field and pipelineAgg
 	if isPipelineAgg(metricType) {
@@ -974,6 +1383,36 @@ func getFieldName(dataField data.Field, target *Query, metricTypeCount int) stri
 	return strings.TrimSpace(name) + " " + metricName
 }
 
+// formatAlias replaces the {{term field}}, {{label}}, {{metric}} and {{field}}
+// patterns of an alias
+func formatAlias(alias string, labels data.Labels, metricName string, field string) string {
+	name := alias
+
+	subMatches := aliasPatternRegex.FindAllStringSubmatch(alias, -1)
+	for _, subMatch := range subMatches {
+		group := subMatch[0]
+
+		if len(subMatch) > 1 {
+			group = subMatch[1]
+		}
+
+		if strings.Index(group, "term ") == 0 {
+			name = strings.Replace(name, subMatch[0], labels[group[5:]], 1)
+		}
+		if v, ok := labels[group]; ok {
+			name = strings.Replace(name, subMatch[0], v, 1)
+		}
+		if group == "metric" {
+			name = strings.Replace(name, subMatch[0], metricName, 1)
+		}
+		if group == "field" {
+			name = strings.Replace(name, subMatch[0], field, 1)
+		}
+	}
+
+	return name
+}
+
 func getMetricName(metric string) string {
 	if text, ok := metricAggType[metric]; ok {
 		return text
@@ -1044,6 +1483,11 @@ func findAgg(target *Query, aggID string) (*BucketAgg, error) {
 }
 
 func getErrorFromElasticResponse(response *es.SearchResponse) string {
+	// Error responses without detailed errors only hold a message
+	if message, ok := response.Error.(string); ok && message != "" {
+		return message
+	}
+
 	var errorString string
 	json := simplejson.NewFromAny(response.Error)
 	reason := json.Get("reason").MustString()
@@ -1230,7 +1674,7 @@ func createPropKeys(props map[string]string) []string {
 	return propKeys
 }
 
-func addMetricValueToFields(fields *[]*data.Field, values []interface{}, metricName string, value *float64) {
+func addMetricValueToFields(fields *[]*data.Field, values []interface{}, metricName string, config *data.FieldConfig, value *float64) {
 	index := -1
 	for i, f := range *fields {
 		if f.Name == metricName {
@@ -1242,6 +1686,7 @@ func addMetricValueToFields(fields *[]*data.Field, values []interface{}, metricN
 	var field data.Field
 	if index == -1 {
 		field = *data.NewField(metricName, nil, []*float64{})
+		field.Config = config
 		*fields = append(*fields, &field)
 	} else {
 		field = *(*fields)[index]
@@ -1249,26 +1694,106 @@ func addMetricValueToFields(fields *[]*data.Field, values []interface{}, metricN
 	field.Append(value)
 }
 
-func addPercentilesToFields(fields *[]*data.Field, bucket *simplejson.Json, metric *MetricAgg, values []interface{}) {
-	percentiles := bucket.GetPath(metric.ID, "values")
-	for _, percentileName := range getSortedKeys(percentiles.MustMap()) {
-		percentileValue := percentiles.Get(percentileName).MustFloat64()
-		addMetricValueToFields(fields, values, fmt.Sprintf("p%v %v", percentileName, metric.Field), &percentileValue)
+// metricColumnName returns the name of the column of a value of a metric in
+// table mode. The name of the value is kept when no other metric of the query
+// has a value of that name, so that the columns of the existing panels keep
+// their names. Otherwise it is followed by the field of the metric, and by the
+// ID of the metric when another metric has a value of that name on the same
+// field. The field is always given when withField is set.
+func metricColumnName(target *Query, metric *MetricAgg, valueName string, withField bool) string {
+	name := valueName
+	if metric.Field != "" && metric.Type != countType && (withField || metricValueCollides(target, metric, valueName, false)) {
+		name += " " + metric.Field
+	}
+	if metricValueCollides(target, metric, valueName, true) {
+		name += " " + metric.ID
+	}
+	return name
+}
+
+// metricValueCollides tells whether another metric of the query, on the same
+// field when sameField is set, has a value named valueName. The percentiles
+// are named by the keys of the response, so those of the same type collide.
+func metricValueCollides(target *Query, metric *MetricAgg, valueName string, sameField bool) bool {
+	for _, m := range target.Metrics {
+		if m.ID == metric.ID || (sameField && m.Field != metric.Field) {
+			continue
+		}
+		if m.Type == metric.Type && (m.Type == percentilesType || m.Type == percentileRanksType) {
+			return true
+		}
+		if slices.Contains(metricValueNames(m), valueName) {
+			return true
+		}
 	}
+	return false
 }
 
-func addExtendedStatsToFields(fields *[]*data.Field, bucket *simplejson.Json, metric *MetricAgg, values []interface{}) {
-	metaKeys := make([]string, 0)
-	meta := metric.Meta.MustMap()
-	for k := range meta {
-		metaKeys = append(metaKeys, k)
+// metricValueNames returns the names of the values of a metric in table mode,
+// before they are made unique
+func metricValueNames(metric *MetricAgg) []string {
+	switch metric.Type {
+	case extendedStatsType, statsType:
+		names := make([]string, 0)
+		for _, statName := range enabledStats(metric) {
+			names = append(names, getMetricName(statName))
+		}
+		return names
+	case percentilesType, percentileRanksType:
+		return nil
+	case topMetricsType:
+		metrics := metric.Settings.Get("metrics").MustStringArray()
+		names := make([]string, 0, len(metrics))
+		for _, metricField := range metrics {
+			names = append(names, topMetricsValueName(metric, metricField, len(metrics)))
+		}
+		return names
+	default:
+		return []string{getMetricName(metric.Type)}
 	}
-	sort.Strings(metaKeys)
-	for _, statName := range metaKeys {
-		v := meta[statName]
-		if enabled, ok := v.(bool); !ok || !enabled {
-			continue
+}
+
+// metricFieldConfig returns the config of the column of a value of a metric in
+// table mode, with the unit of the metric and the name given by the alias. The
+// name is followed by the ID of the metric when the alias gives the same name
+// to another metric.
+func metricFieldConfig(target *Query, metric *MetricAgg, valueName string) *data.FieldConfig {
+	config := &data.FieldConfig{Unit: metricUnit(metric)}
+	if target.Alias != "" {
+		name := formatAlias(target.Alias, nil, valueName, metric.Field)
+		for _, m := range target.Metrics {
+			if m.ID == metric.ID || m.Hide {
+				continue
+			}
+			otherValueName := valueName
+			if m.Type != metric.Type {
+				otherValueName = getMetricName(m.Type)
+			}
+			if formatAlias(target.Alias, nil, otherValueName, m.Field) == name {
+				name += " " + metric.ID
+				break
+			}
 		}
+		config.DisplayNameFromDS = name
+	}
+	if config.Unit == "" && config.DisplayNameFromDS == "" {
+		return nil
+	}
+	return config
+}
+
+func addPercentilesToFields(fields *[]*data.Field, bucket *simplejson.Json, metric *MetricAgg, values []interface{}, target *Query) {
+	percentiles := bucket.GetPath(metric.ID, "values")
+	for _, percentileName := range getSortedKeys(percentiles.MustMap()) {
+		percentileValue := castToFloat(percentiles.Get(percentileName))
+		valueName := percentileLabel(metric, percentileName)
+		addMetricValueToFields(fields, values, metricColumnName(target, metric, valueName, true),
+			metricFieldConfig(target, metric, valueName), percentileValue)
+	}
+}
+
+func addExtendedStatsToFields(fields *[]*data.Field, bucket *simplejson.Json, metric *MetricAgg, values []interface{}, target *Query) {
+	for _, statName := range enabledStats(metric) {
 		var value *float64
 		switch statName {
 		case "std_deviation_bounds_upper":
@@ -1279,20 +1804,26 @@ func addExtendedStatsToFields(fields *[]*data.Field, bucket *simplejson.Json, me
 			value = castToFloat(bucket.GetPath(metric.ID, statName))
 		}
 
-		addMetricValueToFields(fields, values, getMetricName(metric.Type), value)
-		break
+		valueName := getMetricName(statName)
+		addMetricValueToFields(fields, values, metricColumnName(target, metric, valueName, true),
+			metricFieldConfig(target, metric, valueName), value)
 	}
 }
 
-func addTopMetricsToFields(fields *[]*data.Field, bucket *simplejson.Json, metric *MetricAgg, values []interface{}) {
-	baseName := getMetricName(metric.Type)
+// topMetricsValueName returns the name of a value of a top metrics metric. If
+// we selected more than one metric we also add each metric name.
+func topMetricsValueName(metric *MetricAgg, metricField string, metricsCount int) string {
+	name := getMetricName(metric.Type)
+	if metricsCount > 1 {
+		name += " " + metricField
+	}
+	return name
+}
+
+func addTopMetricsToFields(fields *[]*data.Field, bucket *simplejson.Json, metric *MetricAgg, values []interface{}, target *Query) {
 	metrics := metric.Settings.Get("metrics").MustStringArray()
 	for _, metricField := range metrics {
-		// If we selected more than one metric we also add each metric name
-		metricName := baseName
-		if len(metrics) > 1 {
-			metricName += " " + metricField
-		}
+		valueName := topMetricsValueName(metric, metricField, len(metrics))
 		top := bucket.GetPath(metric.ID, "top").MustArray()
 		metrics, hasMetrics := top[0].(map[string]interface{})["metrics"]
 		if hasMetrics {
@@ -1300,39 +1831,26 @@ func addTopMetricsToFields(fields *[]*data.Field, bucket *simplejson.Json, metri
 			metricValue, hasMetricValue := metrics[metricField]
 			if hasMetricValue && metricValue != nil {
 				v := metricValue.(float64)
-				addMetricValueToFields(fields, values, metricName, &v)
+				addMetricValueToFields(fields, values, metricColumnName(target, metric, valueName, false),
+					metricFieldConfig(target, metric, valueName), &v)
 			}
 		}
 	}
 }
 
 func addOtherMetricsToFields(fields *[]*data.Field, bucket *simplejson.Json, metric *MetricAgg, values []interface{}, target *Query) {
-	metricName := getMetricName(metric.Type)
-	otherMetrics := make([]*MetricAgg, 0)
-
-	for _, m := range target.Metrics {
-		// To other metrics we add metric of the same type that are not the current metric
-		if m.ID != metric.ID && m.Type == metric.Type {
-			otherMetrics = append(otherMetrics, m)
-		}
-	}
-
-	if len(otherMetrics) > 0 {
-		metricName += " " + metric.Field
-
-		// We check if we have metric with the same type and same field name
-		// If so, append metric.ID to the metric name
-		for _, m := range otherMetrics {
-			if m.Field == metric.Field {
-				metricName += " " + metric.ID
+	valueName := getMetricName(metric.Type)
+	columnName := metricColumnName(target, metric, valueName, false)
+	if script := metric.Settings.Get("script").MustString(""); script != "" && metric.Type == "bucket_script" && columnName != valueName {
+		// Use the formula in the column name, and the ID when another bucket
+		// script has the same formula
+		columnName = script
+		for _, m := range target.Metrics {
+			if m.ID != metric.ID && m.Type == metric.Type && m.Settings.Get("script").MustString("") == script {
+				columnName += " " + metric.ID
 				break
 			}
 		}
-
-		if metric.Type == "bucket_script" {
-			// Use the formula in the column name
-			metricName = metric.Settings.Get("script").MustString("")
-		}
 	}
-	addMetricValueToFields(fields, values, metricName, castToFloat(bucket.GetPath(metric.ID, "value")))
+	addMetricValueToFields(fields, values, columnName, metricFieldConfig(target, metric, valueName), castToFloat(bucket.GetPath(metric.ID, "value")))
 }
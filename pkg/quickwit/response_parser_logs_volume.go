@@ -0,0 +1,156 @@
+package quickwit
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
+)
+
+const (
+	logsVolumeLevelAggID     = "level"
+	logsVolumeHistogramAggID = "volume"
+	logsVolumeTotalAggID     = logsVolumeHistogramAggID + termsTotalSuffix
+
+	// logsVolumeLevelsSize is the number of level values of a logs volume,
+	// the documents of the other values are counted in the unknown level
+	logsVolumeLevelsSize = 100
+
+	logLevelCritical = "critical"
+	logLevelError    = "error"
+	logLevelWarning  = "warning"
+	logLevelInfo     = "info"
+	logLevelDebug    = "debug"
+	logLevelUnknown  = "unknown"
+)
+
+// Log levels in the order Grafana stacks them in the logs volume panel
+var logsVolumeLevels = []string{logLevelCritical, logLevelError, logLevelWarning, logLevelInfo, logLevelDebug, logLevelUnknown}
+
+// normalizeLogLevel maps a log level value to one of Grafana's log levels,
+// following the aliases of Grafana's getLogLevelFromKey.
+func normalizeLogLevel(level string) string {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "emerg", "fatal", "alert", "crit", "critical":
+		return logLevelCritical
+	case "err", "eror", "error":
+		return logLevelError
+	case "warn", "warning":
+		return logLevelWarning
+	case "info", "information", "informational", "notice":
+		return logLevelInfo
+	case "dbug", "debug", "trace":
+		return logLevelDebug
+	default:
+		return logLevelUnknown
+	}
+}
+
+func processLogsVolumeResponse(res *es.SearchResponse, target *Query, queryRes *backend.DataResponse) error {
+	aggs := simplejson.NewFromAny(res.Aggregations)
+
+	// counts[level][bucket key] holds the number of documents per normalized level and time bucket
+	counts := make(map[string]map[int64]float64)
+	bucketKeys := make(map[int64]bool)
+
+	addHistogram := func(level string, histogram *simplejson.Json) error {
+		if counts[level] == nil {
+			counts[level] = make(map[int64]float64)
+		}
+		for _, v := range histogram.Get("buckets").MustArray() {
+			bucket := simplejson.NewFromAny(v)
+			key, err := bucket.Get("key").Float64()
+			if err != nil {
+				return err
+			}
+			bucketKeys[int64(key)] = true
+			counts[level][int64(key)] += bucket.Get("doc_count").MustFloat64(0)
+		}
+		return nil
+	}
+
+	if levelAgg, ok := aggs.CheckGet(logsVolumeLevelAggID); ok {
+		for _, v := range levelAgg.Get("buckets").MustArray() {
+			bucket := simplejson.NewFromAny(v)
+			level := normalizeLogLevel(bucket.Get("key").MustString())
+			if err := addHistogram(level, bucket.Get(logsVolumeHistogramAggID)); err != nil {
+				return err
+			}
+		}
+		if total, ok := aggs.CheckGet(logsVolumeTotalAggID); ok {
+			if err := addOtherLevels(counts, total); err != nil {
+				return err
+			}
+		}
+	} else if histogram, ok := aggs.CheckGet(logsVolumeHistogramAggID); ok {
+		if err := addHistogram(logLevelUnknown, histogram); err != nil {
+			return err
+		}
+	}
+
+	sortedKeys := make([]int64, 0, len(bucketKeys))
+	for key := range bucketKeys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Slice(sortedKeys, func(i, j int) bool { return sortedKeys[i] < sortedKeys[j] })
+
+	frames := data.Frames{}
+	for _, level := range logsVolumeLevels {
+		levelCounts, ok := counts[level]
+		if !ok {
+			continue
+		}
+
+		timeVector := make([]time.Time, 0, len(sortedKeys))
+		values := make([]*float64, 0, len(sortedKeys))
+		for _, key := range sortedKeys {
+			value := levelCounts[key]
+			timeVector = append(timeVector, time.UnixMilli(key).UTC())
+			values = append(values, &value)
+		}
+
+		frame := newTimeSeriesFrame(timeVector, data.Labels{"level": level}, values)
+		frame.Fields[1].Config = &data.FieldConfig{DisplayNameFromDS: level}
+		frame.Meta.Custom = map[string]interface{}{
+			"logsVolumeType": "FullRange",
+			"absoluteRange": map[string]int64{
+				"from": target.RangeFrom,
+				"to":   target.RangeTo,
+			},
+		}
+		frames = append(frames, frame)
+	}
+
+	queryRes.Frames = frames
+	return nil
+}
+
+// addOtherLevels counts in the unknown level the documents of the total volume
+// histogram which are not counted in any level, their level values being
+// beyond the size of the level terms
+func addOtherLevels(counts map[string]map[int64]float64, total *simplejson.Json) error {
+	for _, v := range total.Get("buckets").MustArray() {
+		bucket := simplejson.NewFromAny(v)
+		key, err := bucket.Get("key").Float64()
+		if err != nil {
+			return err
+		}
+		other := bucket.Get("doc_count").MustFloat64(0)
+		for _, levelCounts := range counts {
+			other -= levelCounts[int64(key)]
+		}
+		if other <= 0 {
+			continue
+		}
+		if counts[logLevelUnknown] == nil {
+			counts[logLevelUnknown] = make(map[int64]float64)
+		}
+		counts[logLevelUnknown][int64(key)] += other
+	}
+	return nil
+}
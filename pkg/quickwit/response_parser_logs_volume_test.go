@@ -0,0 +1,202 @@
+package quickwit
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeLogLevel(t *testing.T) {
+	for level, expected := range map[string]string{
+		"FATAL":   logLevelCritical,
+		"crit":    logLevelCritical,
+		"ERR":     logLevelError,
+		"Error":   logLevelError,
+		"WARN":    logLevelWarning,
+		"notice":  logLevelInfo,
+		"INFO":    logLevelInfo,
+		"trace":   logLevelDebug,
+		"debug":   logLevelDebug,
+		"verbose": logLevelUnknown,
+		"":        logLevelUnknown,
+	} {
+		assert.Equal(t, expected, normalizeLogLevel(level), level)
+	}
+}
+
+func TestProcessLogsVolumeResponse(t *testing.T) {
+	t.Run("Counts are split by normalized log level", func(t *testing.T) {
+		targets := map[string]string{
+			"A": `{
+				"metrics": [{ "type": "logs_volume", "id": "1" }]
+			}`,
+		}
+		response := `{
+			"responses": [{
+				"aggregations": {
+					"level": {
+						"buckets": [
+							{ "key": "ERROR", "doc_count": 5, "volume": { "buckets": [
+								{ "key": 1000, "doc_count": 2 },
+								{ "key": 2000, "doc_count": 3 }
+							] } },
+							{ "key": "warn", "doc_count": 1, "volume": { "buckets": [
+								{ "key": 1000, "doc_count": 0 },
+								{ "key": 2000, "doc_count": 1 }
+							] } },
+							{ "key": "err", "doc_count": 4, "volume": { "buckets": [
+								{ "key": 1000, "doc_count": 4 },
+								{ "key": 2000, "doc_count": 0 }
+							] } },
+							{ "key": "verbose", "doc_count": 1, "volume": { "buckets": [
+								{ "key": 1000, "doc_count": 1 },
+								{ "key": 2000, "doc_count": 0 }
+							] } }
+						]
+					}
+				}
+			}]
+		}`
+
+		result, err := parseTestResponse(targets, response)
+		require.NoError(t, err)
+		frames := result.Responses["A"].Frames
+		require.Len(t, frames, 3)
+
+		errorFrame := frames[0]
+		requireFrameLength(t, errorFrame, 2)
+		requireTimeValue(t, 1000, errorFrame, 0)
+		requireNumberValue(t, 6, errorFrame, 0)
+		requireNumberValue(t, 3, errorFrame, 1)
+		assert.Equal(t, data.Labels{"level": "error"}, errorFrame.Fields[1].Labels)
+		assert.Equal(t, "error", errorFrame.Fields[1].Config.DisplayNameFromDS)
+		assert.Equal(t, data.FrameTypeTimeSeriesMulti, errorFrame.Meta.Type)
+		custom := errorFrame.Meta.Custom.(map[string]interface{})
+		assert.Equal(t, "FullRange", custom["logsVolumeType"])
+		assert.Contains(t, custom, "absoluteRange")
+
+		assert.Equal(t, "warning", frames[1].Fields[1].Labels["level"])
+		requireNumberValue(t, 0, frames[1], 0)
+		requireNumberValue(t, 1, frames[1], 1)
+
+		assert.Equal(t, "unknown", frames[2].Fields[1].Labels["level"])
+	})
+
+	t.Run("Documents without level are part of the volume", func(t *testing.T) {
+		targets := map[string]string{
+			"A": `{
+				"metrics": [{ "type": "logs_volume", "id": "1" }]
+			}`,
+		}
+		// The documents without level fall in the missing bucket of the level
+		response := `{
+			"responses": [{
+				"hits": { "total": { "value": 10, "relation": "eq" } },
+				"aggregations": {
+					"level": {
+						"buckets": [
+							{ "key": "unknown", "doc_count": 6, "volume": { "buckets": [
+								{ "key": 1000, "doc_count": 4 },
+								{ "key": 2000, "doc_count": 2 }
+							] } },
+							{ "key": "info", "doc_count": 3, "volume": { "buckets": [
+								{ "key": 1000, "doc_count": 1 },
+								{ "key": 2000, "doc_count": 2 }
+							] } },
+							{ "key": "verbose", "doc_count": 1, "volume": { "buckets": [
+								{ "key": 1000, "doc_count": 0 },
+								{ "key": 2000, "doc_count": 1 }
+							] } }
+						]
+					}
+				}
+			}]
+		}`
+
+		result, err := parseTestResponse(targets, response)
+		require.NoError(t, err)
+		frames := result.Responses["A"].Frames
+		require.Len(t, frames, 2)
+		assert.Equal(t, "unknown", frames[1].Fields[1].Labels["level"])
+		requireNumberValue(t, 4, frames[1], 0)
+		requireNumberValue(t, 3, frames[1], 1)
+
+		total := 0.0
+		for _, frame := range frames {
+			for i := 0; i < frame.Rows(); i++ {
+				total += *frame.Fields[1].At(i).(*float64)
+			}
+		}
+		assert.Equal(t, 10.0, total)
+	})
+
+	t.Run("Documents of the levels beyond the terms size are counted as unknown", func(t *testing.T) {
+		targets := map[string]string{
+			"A": `{
+				"metrics": [{ "type": "logs_volume", "id": "1" }]
+			}`,
+		}
+		response := `{
+			"responses": [{
+				"aggregations": {
+					"level": {
+						"sum_other_doc_count": 5,
+						"buckets": [
+							{ "key": "error", "doc_count": 4, "volume": { "buckets": [
+								{ "key": 1000, "doc_count": 3 },
+								{ "key": 2000, "doc_count": 1 }
+							] } },
+							{ "key": "unknown", "doc_count": 1, "volume": { "buckets": [
+								{ "key": 1000, "doc_count": 0 },
+								{ "key": 2000, "doc_count": 1 }
+							] } }
+						]
+					},
+					"volume_total": { "buckets": [
+						{ "key": 1000, "doc_count": 5 },
+						{ "key": 2000, "doc_count": 5 }
+					] }
+				}
+			}]
+		}`
+
+		result, err := parseTestResponse(targets, response)
+		require.NoError(t, err)
+		frames := result.Responses["A"].Frames
+		require.Len(t, frames, 2)
+		assert.Equal(t, "error", frames[0].Fields[1].Labels["level"])
+		requireNumberValue(t, 3, frames[0], 0)
+		requireNumberValue(t, 1, frames[0], 1)
+		assert.Equal(t, "unknown", frames[1].Fields[1].Labels["level"])
+		requireNumberValue(t, 2, frames[1], 0)
+		requireNumberValue(t, 4, frames[1], 1)
+	})
+
+	t.Run("Counts are reported as unknown without log level field", func(t *testing.T) {
+		targets := map[string]string{
+			"A": `{
+				"metrics": [{ "type": "logs_volume", "id": "1" }]
+			}`,
+		}
+		response := `{
+			"responses": [{
+				"aggregations": {
+					"volume": { "buckets": [
+						{ "key": 1000, "doc_count": 7 },
+						{ "key": 2000, "doc_count": 8 }
+					] }
+				}
+			}]
+		}`
+
+		result, err := parseTestResponse(targets, response)
+		require.NoError(t, err)
+		frames := result.Responses["A"].Frames
+		require.Len(t, frames, 1)
+		assert.Equal(t, "unknown", frames[0].Fields[1].Labels["level"])
+		requireNumberValue(t, 7, frames[0], 0)
+		requireNumberValue(t, 8, frames[0], 1)
+	})
+}
@@ -0,0 +1,218 @@
+package quickwit
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
+)
+
+// Output formats of the metric queries
+const (
+	// The buckets of the aggregations ending with a date histogram are returned
+	// as time series, the other buckets as a table
+	outputFormatTable = "table"
+	// The buckets are returned as numeric-multi frames, with the bucket keys as
+	// labels. Trees with a date histogram are returned as time series.
+	outputFormatNumeric = "numeric"
+	// The buckets are returned as time-series-multi frames, with the bucket keys
+	// as labels. Without a date histogram, the series have a single point at
+	// the end of the time range.
+	outputFormatTimeSeries = "time_series"
+)
+
+// isMultiDimensionalQuery returns true when the buckets of the query are
+// returned as labelled series, whatever the aggregation tree
+func isMultiDimensionalQuery(query *Query) bool {
+	return query.OutputFormat == outputFormatNumeric || query.OutputFormat == outputFormatTimeSeries
+}
+
+// bucketSeries holds the buckets of a series, keyed by their time
+type bucketSeries struct {
+	labels  map[string]string
+	buckets []interface{}
+}
+
+// bucketSeriesCollector groups the leaf buckets of an aggregation tree by the
+// keys of their parent buckets
+type bucketSeriesCollector struct {
+	target         *Query
+	series         []*bucketSeries
+	seriesByLabels map[string]*bucketSeries
+	// others are the series of the other buckets of the terms aggregations,
+	// which only have a count
+	others           []*bucketSeries
+	othersByLabels   map[string]*bucketSeries
+	hasDateHistogram bool
+}
+
+func (c *bucketSeriesCollector) add(labels map[string]string, bucket map[string]interface{}) {
+	c.series = addSeriesBucket(c.series, c.seriesByLabels, labels, bucket)
+}
+
+func (c *bucketSeriesCollector) addOther(labels map[string]string, bucket map[string]interface{}) {
+	c.others = addSeriesBucket(c.others, c.othersByLabels, labels, bucket)
+}
+
+// addSeriesBucket adds a bucket to the series of its labels, which is created
+// when it is not yet in seriesByLabels
+func addSeriesBucket(series []*bucketSeries, seriesByLabels map[string]*bucketSeries, labels map[string]string, bucket map[string]interface{}) []*bucketSeries {
+	keys := createPropKeys(labels)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+labels[k])
+	}
+	id := strings.Join(parts, "\x00")
+
+	s, ok := seriesByLabels[id]
+	if !ok {
+		s = &bucketSeries{labels: labels}
+		seriesByLabels[id] = s
+		series = append(series, s)
+	}
+	s.buckets = append(s.buckets, bucket)
+	return series
+}
+
+// collectOther adds the other bucket of a terms aggregation, from the count of
+// the other documents of a leaf terms aggregation or from the date histogram
+// counting all the documents next to the terms aggregation
+func (c *bucketSeriesCollector) collectOther(aggs map[string]interface{}, aggDef *BucketAgg, esAgg *simplejson.Json, props map[string]string, timeKey interface{}, depth int) {
+	if aggDef.Type != termsType || !aggDef.Settings.Get("otherBucket").MustBool() {
+		return
+	}
+	labels := make(map[string]string, len(props)+1)
+	for k, v := range props {
+		labels[k] = v
+	}
+	labels[aggDef.Field] = otherBucketLabel(aggDef)
+
+	if depth == len(c.target.BucketAggs)-1 {
+		if other := castToFloat(esAgg.Get("sum_other_doc_count")); other != nil && *other > 0 {
+			c.addOther(labels, map[string]interface{}{"key": timeKey, "doc_count": *other})
+		}
+		return
+	}
+	if total, ok := aggs[aggDef.ID+termsTotalSuffix]; ok {
+		c.hasDateHistogram = true
+		for _, bucket := range otherTermsCountBuckets(esAgg, simplejson.NewFromAny(total), c.target) {
+			c.addOther(labels, bucket.MustMap())
+		}
+	}
+}
+
+// collect walks the aggregation tree like processBuckets. The date histogram
+// keys become the time of the buckets, the other bucket keys become labels.
+func (c *bucketSeriesCollector) collect(aggs map[string]interface{}, props map[string]string, timeKey interface{}, depth int) {
+	maxDepth := len(c.target.BucketAggs) - 1
+
+	aggIDs := make([]string, 0, len(aggs))
+	for k := range aggs {
+		aggIDs = append(aggIDs, k)
+	}
+	sort.Strings(aggIDs)
+	for _, aggID := range aggIDs {
+		aggDef, _ := findAgg(c.target, aggID)
+		if aggDef == nil {
+			continue
+		}
+		esAgg := simplejson.NewFromAny(aggs[aggID])
+		if aggDef.Type == nestedType {
+			c.collect(esAgg.MustMap(), props, timeKey, depth+1)
+			continue
+		}
+
+		visit := func(bucket map[string]interface{}, filterKey string) {
+			newProps := make(map[string]string, len(props)+1)
+			for k, v := range props {
+				newProps[k] = v
+			}
+			newTimeKey := timeKey
+			switch {
+			case aggDef.Type == dateHistType:
+				c.hasDateHistogram = true
+				newTimeKey = bucket["key"]
+			case filterKey != "":
+				newProps["filter"] = filterKey
+			default:
+				if key, ok := bucketKeyLabel(simplejson.NewFromAny(bucket)); ok {
+					newProps[aggDef.Field] = key
+				}
+			}
+
+			if depth < maxDepth {
+				c.collect(bucket, newProps, newTimeKey, depth+1)
+				return
+			}
+			point := make(map[string]interface{}, len(bucket))
+			for k, v := range bucket {
+				point[k] = v
+			}
+			point["key"] = newTimeKey
+			c.add(newProps, point)
+		}
+
+		for _, b := range esAgg.Get("buckets").MustArray() {
+			if bucket, ok := b.(map[string]interface{}); ok {
+				visit(bucket, "")
+			}
+		}
+		c.collectOther(aggs, aggDef, esAgg, props, timeKey, depth)
+		buckets := esAgg.Get("buckets").MustMap()
+		filterKeys := make([]string, 0, len(buckets))
+		for k := range buckets {
+			filterKeys = append(filterKeys, k)
+		}
+		sort.Strings(filterKeys)
+		for _, k := range filterKeys {
+			if bucket, ok := buckets[k].(map[string]interface{}); ok {
+				visit(bucket, k)
+			}
+		}
+	}
+}
+
+// processMultiDimensionalBuckets returns one frame per metric and series of
+// buckets, the series being labelled with the keys of their buckets
+func processMultiDimensionalBuckets(aggs map[string]interface{}, target *Query, queryResult *backend.DataResponse) error {
+	c := &bucketSeriesCollector{
+		target:         target,
+		seriesByLabels: map[string]*bucketSeries{},
+		othersByLabels: map[string]*bucketSeries{},
+	}
+	// Without a date histogram, the buckets are placed at the end of the time range
+	c.collect(aggs, map[string]string{}, float64(target.RangeTo), 0)
+
+	for _, series := range c.series {
+		esAgg := simplejson.NewFromAny(map[string]interface{}{"buckets": series.buckets})
+		if err := processMetrics(esAgg, target, queryResult, series.labels); err != nil {
+			return err
+		}
+	}
+	if slices.ContainsFunc(target.Metrics, func(m *MetricAgg) bool { return m.Type == countType && !m.Hide }) {
+		for _, series := range c.others {
+			buckets := make([]*simplejson.Json, 0, len(series.buckets))
+			for _, bucket := range series.buckets {
+				buckets = append(buckets, simplejson.NewFromAny(bucket))
+			}
+			frames, err := processCountMetric(buckets, series.labels)
+			if err != nil {
+				return err
+			}
+			queryResult.Frames = append(queryResult.Frames, frames...)
+		}
+	}
+
+	if target.OutputFormat == outputFormatNumeric && !c.hasDateHistogram {
+		for _, frame := range queryResult.Frames {
+			// Drop the time field, each series has a single bucket
+			frame.Fields = frame.Fields[1:]
+			frame.Meta.Type = data.FrameTypeNumericMulti
+		}
+	}
+	return nil
+}
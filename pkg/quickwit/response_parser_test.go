@@ -574,6 +574,55 @@ func TestProcessRawDataResponse(t *testing.T) {
 			require.Equal(t, filterableConfig, *field.Config)
 		}
 	})
+
+	t.Run("Returns the cursor of the next page", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			settings string
+			total    string
+			hasMore  bool
+		}{
+			"full first page with more hits": {`{ "size": "2" }`, `{ "value": 3, "relation": "eq" }`, true},
+			"exact first page":               {`{ "size": "2" }`, `{ "value": 2, "relation": "eq" }`, false},
+			"partial page":                   {`{ "size": "5" }`, `{ "value": 2, "relation": "eq" }`, false},
+			"full next page":                 {`{ "size": "2", "searchAfter": [3, 1] }`, `{ "value": 2, "relation": "eq" }`, true},
+		} {
+			t.Run(name, func(t *testing.T) {
+				query := []byte(`[{ "refId": "A", "metrics": [{ "type": "raw_data", "id": "1", "settings": ` + tc.settings + ` }] }]`)
+				response := []byte(`{
+					"responses": [{
+						"hits": {
+							"total": ` + tc.total + `,
+							"hits": [
+								{ "_source": { "@timestamp": "2023-02-08T15:10:55.830Z" }, "sort": [1675869055830123457, 2] },
+								{ "_source": { "@timestamp": "2023-02-08T15:10:54.830Z" }, "sort": [1675869054830123457, 1] }
+							]
+						}
+					}]
+				}`)
+
+				result, err := queryDataTest(query, response)
+				require.NoError(t, err)
+				frames := result.response.Responses["A"].Frames
+				require.Len(t, frames, 1)
+
+				custom := frames[0].Meta.Custom.(map[string]interface{})
+				// The nanosecond timestamps of the cursor keep all their digits
+				cursor, err := json.Marshal(custom["cursor"])
+				require.NoError(t, err)
+				require.JSONEq(t, `[1675869054830123457, 1]`, string(cursor))
+				require.Equal(t, tc.hasMore, custom["hasMore"])
+
+				// The cursor of the next page is sent back as it is
+				c := newFakeClient()
+				from := time.Date(2023, 2, 8, 15, 0, 0, 0, time.UTC)
+				_, err = executeElasticsearchDataQuery(c, `{ "metrics": [{ "type": "raw_data", "id": "1", "settings": { "size": "2", "searchAfter": `+string(cursor)+` } }] }`, from, from.Add(time.Hour))
+				require.NoError(t, err)
+				searchAfter, err := json.Marshal(c.multisearchRequests[0][0].CustomProps["search_after"])
+				require.NoError(t, err)
+				require.Equal(t, `[1675869054830123457,1]`, string(searchAfter))
+			})
+		}
+	})
 }
 
 func TestProcessRawDocumentResponse(t *testing.T) {
@@ -2284,22 +2333,21 @@ func TestProcessBuckets(t *testing.T) {
 		})
 	})
 
-	t.Run("Multiple bucket agg", func(t *testing.T) {
-		t.Run("Date histogram with 2 filters agg", func(t *testing.T) {
+	t.Run("Ranges", func(t *testing.T) {
+		t.Run("Keyed range agg with date histogram", func(t *testing.T) {
 			query := []byte(`
 	[
 		{
 		  "refId": "A",
-		  "metrics": [{ "type": "count", "id": "1" }],
+		  "metrics": [{ "type": "value_count", "id": "1", "field": "status" }],
 		  "bucketAggs": [
 			{
 			  "id": "2",
-			  "type": "filters",
+			  "type": "range",
+			  "field": "bytes",
 			  "settings": {
-				"filters": [
-				  { "query": "@metric:cpu", "label": "" },
-				  { "query": "@metric:logins.count", "label": "" }
-				]
+				"keyed": true,
+				"ranges": [{ "to": "100", "key": "small" }, { "from": "100" }]
 			  }
 			},
 			{ "type": "date_histogram", "field": "@timestamp", "id": "3" }
@@ -2315,19 +2363,21 @@ func TestProcessBuckets(t *testing.T) {
 			"aggregations": {
 			  "2": {
 				"buckets": {
-				  "@metric:cpu": {
+				  "100.0-*": {
+					"from": 100,
+					"doc_count": 4,
 					"3": {
 					  "buckets": [
-						{ "doc_count": 1, "key": 1000 },
-						{ "doc_count": 3, "key": 2000 }
+						{ "1": { "value": 4 }, "doc_count": 4, "key": 1000 }
 					  ]
 					}
 				  },
-				  "@metric:logins.count": {
+				  "small": {
+					"to": 100,
+					"doc_count": 2,
 					"3": {
 					  "buckets": [
-						{ "doc_count": 2, "key": 1000 },
-						{ "doc_count": 8, "key": 2000 }
+						{ "1": { "value": 2 }, "doc_count": 2, "key": 1000 }
 					  ]
 					}
 				  }
@@ -2342,91 +2392,128 @@ func TestProcessBuckets(t *testing.T) {
 			result, err := queryDataTest(query, response)
 			require.NoError(t, err)
 
-			require.Len(t, result.response.Responses, 1)
 			frames := result.response.Responses["A"].Frames
 			require.Len(t, frames, 2)
-			requireFrameLength(t, frames[0], 2)
-			requireTimeSeriesName(t, "@metric:cpu", frames[0])
-			requireTimeSeriesName(t, "@metric:logins.count", frames[1])
+			requireTimeSeriesName(t, "small", frames[0])
+			requireTimeSeriesName(t, "100.0-*", frames[1])
+			requireNumberValue(t, 2, frames[0], 0)
+			requireNumberValue(t, 4, frames[1], 0)
+			require.Equal(t, "small", frames[0].Fields[1].Labels["bytes"])
 		})
 
-		t.Run("With two filters agg", func(t *testing.T) {
-			targets := map[string]string{
-				"A": `{
-					"metrics": [{ "type": "count", "id": "1" }],
-          "bucketAggs": [
-						{
-							"type": "filters",
-							"id": "2",
-							"settings": {
-								"filters": [{ "query": "@metric:cpu" }, { "query": "@metric:logins.count" }]
-							}
-						},
-						{ "type": "date_histogram", "field": "@timestamp", "id": "3" }
-					]
-				}`,
+		t.Run("Date range agg with stats and percentile ranks", func(t *testing.T) {
+			query := []byte(`
+	[
+		{
+		  "refId": "A",
+		  "metrics": [
+			{ "type": "stats", "id": "1", "field": "bytes", "meta": { "max": true } },
+			{ "type": "percentile_ranks", "id": "3", "field": "latency", "settings": { "values": ["500"] } }
+		  ],
+		  "bucketAggs": [
+			{ "id": "2", "type": "date_range", "settings": { "ranges": [{ "to": "now-1d" }, { "from": "now-1d" }] } }
+		  ]
+		}
+	]
+	`)
+
+			response := []byte(`
+	{
+		"responses": [
+		  {
+			"aggregations": {
+			  "2": {
+				"buckets": [
+				  {
+					"key": "*-2024-01-01",
+					"to": 1704067200000,
+					"doc_count": 3,
+					"1": { "count": 3, "min": 1, "max": 30, "avg": 10, "sum": 30 },
+					"3": { "values": { "500.0": 75 } }
+				  },
+				  {
+					"key": "2024-01-01-*",
+					"from": 1704067200000,
+					"doc_count": 2,
+					"1": { "count": 2, "min": 5, "max": 50, "avg": 27.5, "sum": 55 },
+					"3": { "values": { "500.0": 50 } }
+				  }
+				]
+			  }
 			}
-			response := `{
-        "responses": [
-          {
-            "aggregations": {
-              "2": {
-                "buckets": {
-                  "@metric:cpu": {
-                    "3": {
-                      "buckets": [{ "doc_count": 1, "key": 1000 }, { "doc_count": 3, "key": 2000 }]
-                    }
-                  },
-                  "@metric:logins.count": {
-                    "3": {
-                      "buckets": [{ "doc_count": 2, "key": 1000 }, { "doc_count": 8, "key": 2000 }]
-                    }
-                  }
-                }
-              }
-            }
-          }
-        ]
-			}`
-			result, err := parseTestResponse(targets, response)
-			require.NoError(t, err)
-			require.Len(t, result.Responses, 1)
+		  }
+		]
+	}
+	`)
 
-			queryRes := result.Responses["A"]
-			require.NotNil(t, queryRes)
-			dataframes := queryRes.Frames
+			result, err := queryDataTest(query, response)
 			require.NoError(t, err)
-			require.Len(t, dataframes, 2)
 
-			frame := dataframes[0]
-			require.Len(t, frame.Fields, 2)
-			require.Equal(t, frame.Fields[0].Name, data.TimeSeriesTimeFieldName)
-			require.Equal(t, frame.Fields[0].Len(), 2)
-			require.Equal(t, frame.Fields[1].Name, data.TimeSeriesValueFieldName)
-			require.Equal(t, frame.Fields[1].Len(), 2)
-			assert.Equal(t, frame.Fields[1].Config.DisplayNameFromDS, "@metric:cpu")
+			frames := result.response.Responses["A"].Frames
+			require.Len(t, frames, 1)
+			frame := frames[0]
+			requireFrameLength(t, frame, 2)
+			require.Len(t, frame.Fields, 3)
 
-			frame = dataframes[1]
-			require.Len(t, frame.Fields, 2)
-			require.Equal(t, frame.Fields[0].Name, data.TimeSeriesTimeFieldName)
-			require.Equal(t, frame.Fields[0].Len(), 2)
-			require.Equal(t, frame.Fields[1].Name, data.TimeSeriesValueFieldName)
-			require.Equal(t, frame.Fields[1].Len(), 2)
-			assert.Equal(t, frame.Fields[1].Config.DisplayNameFromDS, "@metric:logins.count")
+			requireStringAt(t, "*-2024-01-01", frame.Fields[0], 0)
+			requireStringAt(t, "2024-01-01-*", frame.Fields[0], 1)
+			require.Equal(t, "Max bytes", frame.Fields[1].Name)
+			requireFloatAt(t, 30, frame.Fields[1], 0)
+			requireFloatAt(t, 50, frame.Fields[1], 1)
+			require.Equal(t, "rank 500.0 latency", frame.Fields[2].Name)
+			requireFloatAt(t, 75, frame.Fields[2], 0)
+			requireFloatAt(t, 50, frame.Fields[2], 1)
+		})
+
+		t.Run("Stats without enabled stats return all of them", func(t *testing.T) {
+			query := []byte(`
+	[
+		{
+		  "refId": "A",
+		  "metrics": [{ "type": "stats", "id": "1", "field": "bytes" }],
+		  "bucketAggs": [{ "type": "date_histogram", "field": "@timestamp", "id": "2" }]
+		}
+	]
+	`)
+
+			response := []byte(`
+	{
+		"responses": [
+		  {
+			"aggregations": {
+			  "2": {
+				"buckets": [
+				  { "1": { "count": 3, "min": 1, "max": 30, "avg": 10, "sum": 30 }, "doc_count": 3, "key": 1000 }
+				]
+			  }
+			}
+		  }
+		]
+	}
+	`)
+
+			result, err := queryDataTest(query, response)
+			require.NoError(t, err)
+
+			frames := result.response.Responses["A"].Frames
+			require.Len(t, frames, 5)
+			requireTimeSeriesName(t, "Average bytes", frames[0])
+			requireTimeSeriesName(t, "Sum bytes", frames[4])
+			requireNumberValue(t, 30, frames[4], 0)
 		})
 	})
 
-	t.Run("With multiple metrics", func(t *testing.T) {
-		t.Run("Multiple metrics with the same type", func(t *testing.T) {
+	t.Run("Terms other and missing buckets", func(t *testing.T) {
+		t.Run("Terms agg with date histogram", func(t *testing.T) {
 			query := []byte(`
 	[
 		{
 		  "refId": "A",
-		  "metrics": [
-			{ "type": "avg", "id": "1", "field": "test" },
-			{ "type": "avg", "id": "2", "field": "test2" }
-		  ],
-		  "bucketAggs": [{ "id": "2", "type": "terms", "field": "host" }]
+		  "metrics": [{ "type": "count", "id": "1" }],
+		  "bucketAggs": [
+			{ "type": "terms", "field": "host", "id": "2", "settings": { "otherBucket": true, "missing": "__missing__", "missingBucket": true } },
+			{ "type": "date_histogram", "field": "@timestamp", "id": "3" }
+		  ]
 		}
 	]
 	`)
@@ -2437,89 +2524,662 @@ func TestProcessBuckets(t *testing.T) {
 		  {
 			"aggregations": {
 			  "2": {
+				"sum_other_doc_count": 6,
 				"buckets": [
 				  {
-					"1": { "value": 1000 },
-					"2": { "value": 3000 },
-					"key": "server-1",
-					"doc_count": 369
+					"key": "server1",
+					"doc_count": 5,
+					"3": { "buckets": [{ "doc_count": 2, "key": 1000 }, { "doc_count": 3, "key": 2000 }] }
+				  },
+				  {
+					"key": "__missing__",
+					"doc_count": 1,
+					"3": { "buckets": [{ "doc_count": 1, "key": 1000 }, { "doc_count": 0, "key": 2000 }] }
 				  }
 				]
+			  },
+			  "2_total": {
+				"buckets": [{ "doc_count": 7, "key": 1000 }, { "doc_count": 5, "key": 2000 }]
 			  }
 			}
 		  }
 		]
-	  }
-		  
+	}
 	`)
 
 			result, err := queryDataTest(query, response)
 			require.NoError(t, err)
 
-			require.Len(t, result.response.Responses, 1)
 			frames := result.response.Responses["A"].Frames
-			require.True(t, len(frames) > 0)
-			requireFrameLength(t, frames[0], 1)
-			require.Len(t, frames[0].Fields, 3)
-
-			requireStringAt(t, "server-1", frames[0].Fields[0], 0)
-			requireFloatAt(t, 1000.0, frames[0].Fields[1], 0)
-			requireFloatAt(t, 3000.0, frames[0].Fields[2], 0)
+			require.Len(t, frames, 3)
+			requireTimeSeriesName(t, "server1", frames[0])
+			requireTimeSeriesName(t, "(missing)", frames[1])
+			requireTimeSeriesName(t, "Other", frames[2])
+			requireNumberValue(t, 4, frames[2], 0)
+			requireNumberValue(t, 2, frames[2], 1)
 		})
 
-		t.Run("Multiple metrics of same type", func(t *testing.T) {
-			targets := map[string]string{
-				"A": `{
-					"metrics": [{ "type": "avg", "field": "test", "id": "1" }, { "type": "avg", "field": "test2", "id": "2" }],
-          "bucketAggs": [{ "type": "terms", "field": "host", "id": "2" }]
-				}`,
+		t.Run("Terms agg as last agg", func(t *testing.T) {
+			query := []byte(`
+	[
+		{
+		  "refId": "A",
+		  "metrics": [{ "type": "count", "id": "1" }, { "type": "avg", "field": "bytes", "id": "4" }],
+		  "bucketAggs": [
+			{
+			  "type": "terms",
+			  "field": "status",
+			  "id": "2",
+			  "settings": { "otherBucket": true, "otherBucketLabel": "Rest", "missing": "0", "missingBucket": true }
 			}
-			response := `{
-        "responses": [
-          {
-            "aggregations": {
-              "2": {
-                "buckets": [
-                  {
-                    "1": { "value": 1000 },
-                    "2": { "value": 3000 },
-                    "key": "server-1",
-                    "doc_count": 369
-                  }
-                ]
-              }
-            }
-          }
-        ]
-			}`
-			result, err := parseTestResponse(targets, response)
-			require.NoError(t, err)
-			require.Len(t, result.Responses, 1)
+		  ]
+		}
+	]
+	`)
 
-			queryRes := result.Responses["A"]
-			require.NotNil(t, queryRes)
-			dataframes := queryRes.Frames
+			response := []byte(`
+	{
+		"responses": [
+		  {
+			"aggregations": {
+			  "2": {
+				"sum_other_doc_count": 3,
+				"buckets": [
+				  { "key": 200, "doc_count": 10, "4": { "value": 100 } },
+				  { "key": 0, "doc_count": 2, "4": { "value": 50 } }
+				]
+			  }
+			}
+		  }
+		]
+	}
+	`)
+
+			result, err := queryDataTest(query, response)
 			require.NoError(t, err)
-			require.Len(t, dataframes, 1)
 
-			frame := dataframes[0]
+			frames := result.response.Responses["A"].Frames
+			require.Len(t, frames, 1)
+			frame := frames[0]
+			requireFrameLength(t, frame, 3)
 			require.Len(t, frame.Fields, 3)
-			require.Equal(t, frame.Fields[0].Name, "host")
-			require.Equal(t, frame.Fields[0].Len(), 1)
-			require.Equal(t, frame.Fields[1].Name, "Average test")
-			require.Equal(t, frame.Fields[1].Len(), 1)
-			require.Equal(t, frame.Fields[2].Name, "Average test2")
-			require.Equal(t, frame.Fields[2].Len(), 1)
-			require.Nil(t, frame.Fields[1].Config)
+
+			requireStringAt(t, "200", frame.Fields[0], 0)
+			requireStringAt(t, "(missing)", frame.Fields[0], 1)
+			requireStringAt(t, "Rest", frame.Fields[0], 2)
+			requireFloatAt(t, 10, frame.Fields[1], 0)
+			requireFloatAt(t, 2, frame.Fields[1], 1)
+			requireFloatAt(t, 3, frame.Fields[1], 2)
+			requireFloatAt(t, 50, frame.Fields[2], 1)
+			require.Nil(t, frame.Fields[2].At(2))
 		})
+	})
 
-		t.Run("No group by time", func(t *testing.T) {
-			targets := map[string]string{
-				"A": `{
-					"metrics": [{ "type": "avg", "id": "1" }, { "type": "count" }],
-         "bucketAggs": [{ "type": "terms", "field": "host", "id": "2" }]
-				}`,
-			}
+	t.Run("Date histogram with time zone and terms agg", func(t *testing.T) {
+		query := []byte(`
+	[
+		{
+		  "refId": "A",
+		  "metrics": [{ "type": "count", "id": "1" }],
+		  "bucketAggs": [
+			{
+			  "type": "date_histogram",
+			  "field": "@timestamp",
+			  "id": "2",
+			  "settings": { "interval": "1d", "intervalType": "calendar", "timeZone": "Europe/Paris" }
+			},
+			{ "type": "terms", "field": "host", "id": "3" }
+		  ]
+		}
+	]
+	`)
+
+		response := []byte(`
+	{
+		"responses": [
+		  {
+			"aggregations": {
+			  "2": {
+				"buckets": [
+				  {
+					"key": 1711839600000,
+					"key_as_string": "2024-03-30T23:00:00Z",
+					"doc_count": 2,
+					"3": { "buckets": [{ "key": "server1", "doc_count": 2 }] }
+				  },
+				  {
+					"key": 1711922400000,
+					"key_as_string": "2024-03-31T22:00:00Z",
+					"doc_count": 1,
+					"3": { "buckets": [{ "key": "server1", "doc_count": 1 }] }
+				  }
+				]
+			  }
+			}
+		  }
+		]
+	}
+	`)
+
+		result, err := queryDataTest(query, response)
+		require.NoError(t, err)
+
+		frames := result.response.Responses["A"].Frames
+		require.Len(t, frames, 1)
+		frame := frames[0]
+		requireFrameLength(t, frame, 2)
+		require.Equal(t, "@timestamp", frame.Fields[0].Name)
+		requireStringAt(t, "2024-03-31T00:00:00+01:00", frame.Fields[0], 0)
+		requireStringAt(t, "2024-04-01T00:00:00+02:00", frame.Fields[0], 1)
+	})
+
+	t.Run("Metric columns", func(t *testing.T) {
+		t.Run("Terms agg with percentiles and extended stats", func(t *testing.T) {
+			query := []byte(`
+	[
+		{
+		  "refId": "A",
+		  "alias": "{{metric}} of {{field}}",
+		  "metrics": [
+			{ "type": "percentiles", "field": "latency", "id": "1", "settings": { "percents": ["95"], "unit": "ms" } },
+			{ "type": "percentiles", "field": "duration", "id": "3", "settings": { "percents": ["95"] } },
+			{ "type": "percentiles", "field": "duration", "id": "4", "settings": { "percents": ["95"] } },
+			{ "type": "extended_stats", "field": "bytes", "id": "5", "meta": { "max": true, "min": true } }
+		  ],
+		  "bucketAggs": [{ "type": "terms", "field": "host", "id": "2" }]
+		}
+	]
+	`)
+
+			response := []byte(`
+	{
+		"responses": [
+		  {
+			"aggregations": {
+			  "2": {
+				"buckets": [
+				  {
+					"key": "server1",
+					"doc_count": 3,
+					"1": { "values": { "95.0": 120 } },
+					"3": { "values": { "95.0": 30 } },
+					"4": { "values": { "95.0": 40 } },
+					"5": { "count": 3, "min": 1, "max": 9, "avg": 5, "sum": 15 }
+				  }
+				]
+			  }
+			}
+		  }
+		]
+	}
+	`)
+
+			result, err := queryDataTest(query, response)
+			require.NoError(t, err)
+
+			frames := result.response.Responses["A"].Frames
+			require.Len(t, frames, 1)
+			frame := frames[0]
+			requireFrameLength(t, frame, 1)
+
+			names := make([]string, 0, len(frame.Fields))
+			for _, field := range frame.Fields {
+				names = append(names, field.Name)
+			}
+			require.Equal(t, []string{"host", "p95.0 latency", "p95.0 duration 3", "p95.0 duration 4", "Max bytes", "Min bytes"}, names)
+
+			require.Equal(t, "ms", frame.Fields[1].Config.Unit)
+			require.Equal(t, "p95.0 of latency", frame.Fields[1].Config.DisplayNameFromDS)
+			require.Equal(t, "p95.0 of duration 3", frame.Fields[2].Config.DisplayNameFromDS)
+			require.Equal(t, "p95.0 of duration 4", frame.Fields[3].Config.DisplayNameFromDS)
+			require.Equal(t, "Min of bytes", frame.Fields[5].Config.DisplayNameFromDS)
+			requireFloatAt(t, 120, frame.Fields[1], 0)
+			requireFloatAt(t, 40, frame.Fields[3], 0)
+			requireFloatAt(t, 9, frame.Fields[4], 0)
+			requireFloatAt(t, 1, frame.Fields[5], 0)
+		})
+
+		t.Run("Date histogram with metric unit", func(t *testing.T) {
+			query := []byte(`
+	[
+		{
+		  "refId": "A",
+		  "metrics": [{ "type": "avg", "field": "latency", "id": "1", "settings": { "unit": "ms" } }],
+		  "bucketAggs": [{ "type": "date_histogram", "field": "@timestamp", "id": "2" }]
+		}
+	]
+	`)
+
+			response := []byte(`
+	{
+		"responses": [
+		  {
+			"aggregations": {
+			  "2": { "buckets": [{ "1": { "value": 12 }, "doc_count": 3, "key": 1000 }] }
+			}
+		  }
+		]
+	}
+	`)
+
+			result, err := queryDataTest(query, response)
+			require.NoError(t, err)
+
+			frames := result.response.Responses["A"].Frames
+			require.Len(t, frames, 1)
+			require.Equal(t, "ms", frames[0].Fields[1].Config.Unit)
+		})
+
+		t.Run("Terms agg with two top metrics", func(t *testing.T) {
+			targets := map[string]string{
+				"A": `{
+				"metrics": [
+					{ "type": "top_metrics", "settings": { "order": "desc", "orderBy": "@timestamp", "metrics": ["@value"] }, "id": "1" },
+					{ "type": "top_metrics", "settings": { "order": "asc", "orderBy": "@timestamp", "metrics": ["@value"] }, "id": "2" }
+				],
+				"bucketAggs": [{ "type": "terms", "field": "id", "id": "3" }]
+			}`,
+			}
+			response := `{
+			"responses": [{
+				"aggregations": {
+					"3": {
+						"buckets": [
+							{
+								"key": "id1",
+								"1": { "top": [{ "sort": [10], "metrics": { "@value": 10 } }] },
+								"2": { "top": [{ "sort": [1], "metrics": { "@value": 1 } }] }
+							}
+						]
+					}
+				}
+			}]
+		}`
+
+			result, err := parseTestResponse(targets, response)
+			require.NoError(t, err)
+			frames := result.Responses["A"].Frames
+			require.Len(t, frames, 1)
+			require.Len(t, frames[0].Fields, 3)
+			require.Equal(t, "Top Metrics 1", frames[0].Fields[1].Name)
+			require.Equal(t, "Top Metrics 2", frames[0].Fields[2].Name)
+			requireFloatAt(t, 10, frames[0].Fields[1], 0)
+			requireFloatAt(t, 1, frames[0].Fields[2], 0)
+		})
+	})
+
+	t.Run("Composite", func(t *testing.T) {
+		t.Run("Composite agg with date histogram", func(t *testing.T) {
+			query := []byte(`
+	[
+		{
+		  "refId": "A",
+		  "metrics": [{ "type": "count", "id": "1" }],
+		  "bucketAggs": [
+			{ "id": "2", "type": "composite", "settings": { "fields": ["service", "status"] } },
+			{ "type": "date_histogram", "field": "@timestamp", "id": "3" }
+		  ]
+		}
+	]
+	`)
+
+			response := []byte(`
+	{
+		"responses": [
+		  {
+			"aggregations": {
+			  "2": {
+				"buckets": [
+				  {
+					"key": { "service": "api", "status": 200 },
+					"doc_count": 3,
+					"3": { "buckets": [{ "doc_count": 3, "key": 1000 }] }
+				  },
+				  {
+					"key": { "service": "api", "status": 500 },
+					"doc_count": 1,
+					"3": { "buckets": [{ "doc_count": 1, "key": 1000 }] }
+				  }
+				]
+			  }
+			}
+		  }
+		]
+	}
+	`)
+
+			result, err := queryDataTest(query, response)
+			require.NoError(t, err)
+
+			frames := result.response.Responses["A"].Frames
+			require.Len(t, frames, 2)
+			require.Equal(t, data.Labels{"service": "api", "status": "200"}, frames[0].Fields[1].Labels)
+			require.Equal(t, data.Labels{"service": "api", "status": "500"}, frames[1].Fields[1].Labels)
+			requireNumberValue(t, 3, frames[0], 0)
+			requireNumberValue(t, 1, frames[1], 0)
+		})
+
+		t.Run("Composite agg as last agg", func(t *testing.T) {
+			query := []byte(`
+	[
+		{
+		  "refId": "A",
+		  "metrics": [{ "type": "count", "id": "1" }],
+		  "bucketAggs": [{ "id": "2", "type": "composite", "settings": { "fields": ["service", "status"] } }]
+		}
+	]
+	`)
+
+			response := []byte(`
+	{
+		"responses": [
+		  {
+			"aggregations": {
+			  "2": {
+				"buckets": [
+				  { "key": { "service": "api", "status": 200 }, "doc_count": 3 },
+				  { "key": { "service": "web", "status": 404 }, "doc_count": 1 }
+				]
+			  }
+			}
+		  }
+		]
+	}
+	`)
+
+			result, err := queryDataTest(query, response)
+			require.NoError(t, err)
+
+			frames := result.response.Responses["A"].Frames
+			require.Len(t, frames, 1)
+			frame := frames[0]
+			requireFrameLength(t, frame, 2)
+			require.Len(t, frame.Fields, 3)
+
+			require.Equal(t, "service", frame.Fields[0].Name)
+			requireStringAt(t, "api", frame.Fields[0], 0)
+			requireStringAt(t, "web", frame.Fields[0], 1)
+			require.Equal(t, "status", frame.Fields[1].Name)
+			requireFloatAt(t, 200, frame.Fields[1], 0)
+			requireFloatAt(t, 404, frame.Fields[1], 1)
+			require.Equal(t, "Count", frame.Fields[2].Name)
+			requireFloatAt(t, 3, frame.Fields[2], 0)
+			requireFloatAt(t, 1, frame.Fields[2], 1)
+		})
+		t.Run("Composite agg truncated to the maximum number of pages", func(t *testing.T) {
+			query := []byte(`
+	[
+		{
+		  "refId": "A",
+		  "metrics": [{ "type": "count", "id": "1" }],
+		  "bucketAggs": [{ "id": "2", "type": "composite", "settings": { "fields": ["service"] } }]
+		}
+	]
+	`)
+
+			response := []byte(`
+	{
+		"responses": [
+		  {
+			"aggregations": {
+			  "2": {
+				"buckets": [{ "key": { "service": "api" }, "doc_count": 3 }],
+				"after_key": { "service": "api" }
+			  }
+			},
+			"truncated": true
+		  }
+		]
+	}
+	`)
+
+			result, err := queryDataTest(query, response)
+			require.NoError(t, err)
+
+			frames := result.response.Responses["A"].Frames
+			require.Len(t, frames, 1)
+			require.Len(t, frames[0].Meta.Notices, 1)
+			require.Equal(t, data.NoticeSeverityWarning, frames[0].Meta.Notices[0].Severity)
+			require.Equal(t, "The groups are truncated to the first 100 pages of the composite aggregation", frames[0].Meta.Notices[0].Text)
+		})
+	})
+
+	t.Run("Multiple bucket agg", func(t *testing.T) {
+		t.Run("Date histogram with 2 filters agg", func(t *testing.T) {
+			query := []byte(`
+	[
+		{
+		  "refId": "A",
+		  "metrics": [{ "type": "count", "id": "1" }],
+		  "bucketAggs": [
+			{
+			  "id": "2",
+			  "type": "filters",
+			  "settings": {
+				"filters": [
+				  { "query": "@metric:cpu", "label": "" },
+				  { "query": "@metric:logins.count", "label": "" }
+				]
+			  }
+			},
+			{ "type": "date_histogram", "field": "@timestamp", "id": "3" }
+		  ]
+		}
+	]
+	`)
+
+			response := []byte(`
+	{
+		"responses": [
+		  {
+			"aggregations": {
+			  "2": {
+				"buckets": {
+				  "@metric:cpu": {
+					"3": {
+					  "buckets": [
+						{ "doc_count": 1, "key": 1000 },
+						{ "doc_count": 3, "key": 2000 }
+					  ]
+					}
+				  },
+				  "@metric:logins.count": {
+					"3": {
+					  "buckets": [
+						{ "doc_count": 2, "key": 1000 },
+						{ "doc_count": 8, "key": 2000 }
+					  ]
+					}
+				  }
+				}
+			  }
+			}
+		  }
+		]
+	}
+	`)
+
+			result, err := queryDataTest(query, response)
+			require.NoError(t, err)
+
+			require.Len(t, result.response.Responses, 1)
+			frames := result.response.Responses["A"].Frames
+			require.Len(t, frames, 2)
+			requireFrameLength(t, frames[0], 2)
+			requireTimeSeriesName(t, "@metric:cpu", frames[0])
+			requireTimeSeriesName(t, "@metric:logins.count", frames[1])
+		})
+
+		t.Run("With two filters agg", func(t *testing.T) {
+			targets := map[string]string{
+				"A": `{
+					"metrics": [{ "type": "count", "id": "1" }],
+          "bucketAggs": [
+						{
+							"type": "filters",
+							"id": "2",
+							"settings": {
+								"filters": [{ "query": "@metric:cpu" }, { "query": "@metric:logins.count" }]
+							}
+						},
+						{ "type": "date_histogram", "field": "@timestamp", "id": "3" }
+					]
+				}`,
+			}
+			response := `{
+        "responses": [
+          {
+            "aggregations": {
+              "2": {
+                "buckets": {
+                  "@metric:cpu": {
+                    "3": {
+                      "buckets": [{ "doc_count": 1, "key": 1000 }, { "doc_count": 3, "key": 2000 }]
+                    }
+                  },
+                  "@metric:logins.count": {
+                    "3": {
+                      "buckets": [{ "doc_count": 2, "key": 1000 }, { "doc_count": 8, "key": 2000 }]
+                    }
+                  }
+                }
+              }
+            }
+          }
+        ]
+			}`
+			result, err := parseTestResponse(targets, response)
+			require.NoError(t, err)
+			require.Len(t, result.Responses, 1)
+
+			queryRes := result.Responses["A"]
+			require.NotNil(t, queryRes)
+			dataframes := queryRes.Frames
+			require.NoError(t, err)
+			require.Len(t, dataframes, 2)
+
+			frame := dataframes[0]
+			require.Len(t, frame.Fields, 2)
+			require.Equal(t, frame.Fields[0].Name, data.TimeSeriesTimeFieldName)
+			require.Equal(t, frame.Fields[0].Len(), 2)
+			require.Equal(t, frame.Fields[1].Name, data.TimeSeriesValueFieldName)
+			require.Equal(t, frame.Fields[1].Len(), 2)
+			assert.Equal(t, frame.Fields[1].Config.DisplayNameFromDS, "@metric:cpu")
+
+			frame = dataframes[1]
+			require.Len(t, frame.Fields, 2)
+			require.Equal(t, frame.Fields[0].Name, data.TimeSeriesTimeFieldName)
+			require.Equal(t, frame.Fields[0].Len(), 2)
+			require.Equal(t, frame.Fields[1].Name, data.TimeSeriesValueFieldName)
+			require.Equal(t, frame.Fields[1].Len(), 2)
+			assert.Equal(t, frame.Fields[1].Config.DisplayNameFromDS, "@metric:logins.count")
+		})
+	})
+
+	t.Run("With multiple metrics", func(t *testing.T) {
+		t.Run("Multiple metrics with the same type", func(t *testing.T) {
+			query := []byte(`
+	[
+		{
+		  "refId": "A",
+		  "metrics": [
+			{ "type": "avg", "id": "1", "field": "test" },
+			{ "type": "avg", "id": "2", "field": "test2" }
+		  ],
+		  "bucketAggs": [{ "id": "2", "type": "terms", "field": "host" }]
+		}
+	]
+	`)
+
+			response := []byte(`
+	{
+		"responses": [
+		  {
+			"aggregations": {
+			  "2": {
+				"buckets": [
+				  {
+					"1": { "value": 1000 },
+					"2": { "value": 3000 },
+					"key": "server-1",
+					"doc_count": 369
+				  }
+				]
+			  }
+			}
+		  }
+		]
+	  }
+		  
+	`)
+
+			result, err := queryDataTest(query, response)
+			require.NoError(t, err)
+
+			require.Len(t, result.response.Responses, 1)
+			frames := result.response.Responses["A"].Frames
+			require.True(t, len(frames) > 0)
+			requireFrameLength(t, frames[0], 1)
+			require.Len(t, frames[0].Fields, 3)
+
+			requireStringAt(t, "server-1", frames[0].Fields[0], 0)
+			requireFloatAt(t, 1000.0, frames[0].Fields[1], 0)
+			requireFloatAt(t, 3000.0, frames[0].Fields[2], 0)
+		})
+
+		t.Run("Multiple metrics of same type", func(t *testing.T) {
+			targets := map[string]string{
+				"A": `{
+					"metrics": [{ "type": "avg", "field": "test", "id": "1" }, { "type": "avg", "field": "test2", "id": "2" }],
+          "bucketAggs": [{ "type": "terms", "field": "host", "id": "2" }]
+				}`,
+			}
+			response := `{
+        "responses": [
+          {
+            "aggregations": {
+              "2": {
+                "buckets": [
+                  {
+                    "1": { "value": 1000 },
+                    "2": { "value": 3000 },
+                    "key": "server-1",
+                    "doc_count": 369
+                  }
+                ]
+              }
+            }
+          }
+        ]
+			}`
+			result, err := parseTestResponse(targets, response)
+			require.NoError(t, err)
+			require.Len(t, result.Responses, 1)
+
+			queryRes := result.Responses["A"]
+			require.NotNil(t, queryRes)
+			dataframes := queryRes.Frames
+			require.NoError(t, err)
+			require.Len(t, dataframes, 1)
+
+			frame := dataframes[0]
+			require.Len(t, frame.Fields, 3)
+			require.Equal(t, frame.Fields[0].Name, "host")
+			require.Equal(t, frame.Fields[0].Len(), 1)
+			require.Equal(t, frame.Fields[1].Name, "Average test")
+			require.Equal(t, frame.Fields[1].Len(), 1)
+			require.Equal(t, frame.Fields[2].Name, "Average test2")
+			require.Equal(t, frame.Fields[2].Len(), 1)
+			require.Nil(t, frame.Fields[1].Config)
+		})
+
+		t.Run("No group by time", func(t *testing.T) {
+			targets := map[string]string{
+				"A": `{
+					"metrics": [{ "type": "avg", "id": "1" }, { "type": "count" }],
+         "bucketAggs": [{ "type": "terms", "field": "host", "id": "2" }]
+				}`,
+			}
 			response := `{
         "responses": [
          {
@@ -3421,6 +4081,260 @@ func TestTrimEdges(t *testing.T) {
 	requireFrameLength(t, frames[0], 1)
 }
 
+func TestMultiDimensionalOutputFormats(t *testing.T) {
+	termsResponse := `{
+		"responses": [
+			{
+				"aggregations": {
+					"2": {
+						"buckets": [
+							{
+								"key": "api",
+								"doc_count": 10,
+								"3": { "buckets": [{ "key": 500, "doc_count": 4, "1": { "value": 12 } }, { "key": 404, "doc_count": 6, "1": { "value": 3 } }] }
+							},
+							{
+								"key": "web",
+								"doc_count": 2,
+								"3": { "buckets": [{ "key": 500, "doc_count": 2, "1": { "value": 7 } }] }
+							}
+						]
+					}
+				}
+			}
+		]
+	}`
+	termsQuery := func(outputFormat string) map[string]string {
+		return map[string]string{"A": `{
+			"outputFormat": "` + outputFormat + `",
+			"metrics": [{ "type": "count", "id": "4" }, { "type": "avg", "field": "latency", "id": "1" }],
+			"bucketAggs": [
+				{ "type": "terms", "field": "service", "id": "2" },
+				{ "type": "terms", "field": "status", "id": "3" }
+			]
+		}`}
+	}
+
+	t.Run("Terms buckets are returned as numeric series", func(t *testing.T) {
+		result, err := parseTestResponse(termsQuery(outputFormatNumeric), termsResponse)
+		require.NoError(t, err)
+		frames := result.Responses["A"].Frames
+		require.Len(t, frames, 6)
+
+		for _, frame := range frames {
+			assert.Equal(t, data.FrameTypeNumericMulti, frame.Meta.Type)
+			require.Len(t, frame.Fields, 1)
+			require.Equal(t, 1, frame.Rows())
+		}
+		assert.Equal(t, data.Labels{"service": "api", "status": "500"}, frames[0].Fields[0].Labels)
+		assert.Equal(t, 4.0, *frames[0].Fields[0].At(0).(*float64))
+		assert.Equal(t, "api 500 Count", frames[0].Fields[0].Config.DisplayNameFromDS)
+		assert.Equal(t, data.Labels{"service": "api", "status": "500"}, frames[1].Fields[0].Labels)
+		assert.Equal(t, 12.0, *frames[1].Fields[0].At(0).(*float64))
+		assert.Equal(t, data.Labels{"service": "web", "status": "500"}, frames[5].Fields[0].Labels)
+		assert.Equal(t, 7.0, *frames[5].Fields[0].At(0).(*float64))
+	})
+
+	t.Run("Terms buckets are returned as time series at the end of the range", func(t *testing.T) {
+		result, err := parseTestResponse(termsQuery(outputFormatTimeSeries), termsResponse)
+		require.NoError(t, err)
+		frames := result.Responses["A"].Frames
+		require.Len(t, frames, 6)
+
+		for _, frame := range frames {
+			assert.Equal(t, data.FrameTypeTimeSeriesMulti, frame.Meta.Type)
+			require.Len(t, frame.Fields, 2)
+			assert.Equal(t, time.Date(2018, 5, 15, 17, 55, 0, 0, time.UTC), frame.Fields[0].At(0))
+		}
+		assert.Equal(t, data.Labels{"service": "api", "status": "404"}, frames[2].Fields[1].Labels)
+		assert.Equal(t, 6.0, *frames[2].Fields[1].At(0).(*float64))
+	})
+
+	t.Run("Date histograms nested in terms are returned as time series", func(t *testing.T) {
+		result, err := parseTestResponse(map[string]string{"A": `{
+			"outputFormat": "numeric",
+			"metrics": [{ "type": "count", "id": "1" }],
+			"bucketAggs": [
+				{ "type": "date_histogram", "field": "@timestamp", "id": "2" },
+				{ "type": "terms", "field": "service", "id": "3" }
+			]
+		}`}, `{
+			"responses": [
+				{
+					"aggregations": {
+						"2": {
+							"buckets": [
+								{ "key": 1000, "doc_count": 3, "3": { "buckets": [{ "key": "api", "doc_count": 2 }, { "key": "web", "doc_count": 1 }] } },
+								{ "key": 2000, "doc_count": 5, "3": { "buckets": [{ "key": "api", "doc_count": 5 }] } }
+							]
+						}
+					}
+				}
+			]
+		}`)
+		require.NoError(t, err)
+		frames := result.Responses["A"].Frames
+		require.Len(t, frames, 2)
+
+		assert.Equal(t, data.FrameTypeTimeSeriesMulti, frames[0].Meta.Type)
+		assert.Equal(t, data.Labels{"service": "api"}, frames[0].Fields[1].Labels)
+		require.Equal(t, 2, frames[0].Rows())
+		assert.Equal(t, time.UnixMilli(2000).UTC(), frames[0].Fields[0].At(1))
+		assert.Equal(t, 5.0, *frames[0].Fields[1].At(1).(*float64))
+		assert.Equal(t, data.Labels{"service": "web"}, frames[1].Fields[1].Labels)
+		require.Equal(t, 1, frames[1].Rows())
+	})
+
+	t.Run("Other buckets of terms are returned as numeric series", func(t *testing.T) {
+		result, err := parseTestResponse(map[string]string{"A": `{
+			"outputFormat": "numeric",
+			"metrics": [{ "type": "count", "id": "1" }],
+			"bucketAggs": [{ "type": "terms", "field": "service", "id": "2", "settings": { "otherBucket": true } }]
+		}`}, `{
+			"responses": [
+				{
+					"aggregations": {
+						"2": { "sum_other_doc_count": 4, "buckets": [{ "key": "api", "doc_count": 10 }] }
+					}
+				}
+			]
+		}`)
+		require.NoError(t, err)
+		frames := result.Responses["A"].Frames
+		require.Len(t, frames, 2)
+
+		assert.Equal(t, data.FrameTypeNumericMulti, frames[1].Meta.Type)
+		require.Len(t, frames[1].Fields, 1)
+		assert.Equal(t, data.Labels{"service": "Other"}, frames[1].Fields[0].Labels)
+		assert.Equal(t, 4.0, *frames[1].Fields[0].At(0).(*float64))
+	})
+
+	t.Run("Other buckets of terms with date histogram are returned as time series", func(t *testing.T) {
+		result, err := parseTestResponse(map[string]string{"A": `{
+			"outputFormat": "time_series",
+			"metrics": [{ "type": "count", "id": "1" }],
+			"bucketAggs": [
+				{ "type": "terms", "field": "service", "id": "2", "settings": { "otherBucket": true } },
+				{ "type": "date_histogram", "field": "@timestamp", "id": "3" }
+			]
+		}`}, `{
+			"responses": [
+				{
+					"aggregations": {
+						"2": {
+							"sum_other_doc_count": 6,
+							"buckets": [
+								{ "key": "api", "doc_count": 5, "3": { "buckets": [{ "key": 1000, "doc_count": 2 }, { "key": 2000, "doc_count": 3 }] } }
+							]
+						},
+						"2_total": { "buckets": [{ "key": 1000, "doc_count": 7 }, { "key": 2000, "doc_count": 4 }] }
+					}
+				}
+			]
+		}`)
+		require.NoError(t, err)
+		frames := result.Responses["A"].Frames
+		require.Len(t, frames, 2)
+
+		assert.Equal(t, data.FrameTypeTimeSeriesMulti, frames[1].Meta.Type)
+		assert.Equal(t, data.Labels{"service": "Other"}, frames[1].Fields[1].Labels)
+		require.Equal(t, 2, frames[1].Rows())
+		assert.Equal(t, 5.0, *frames[1].Fields[1].At(0).(*float64))
+		assert.Equal(t, 1.0, *frames[1].Fields[1].At(1).(*float64))
+	})
+}
+
+func TestParseResponseStats(t *testing.T) {
+	queries, err := parseQuery([]backend.DataQuery{{
+		RefID: "A",
+		JSON: json.RawMessage(`{
+			"query": "level:error",
+			"metrics": [{ "type": "count", "id": "1" }],
+			"bucketAggs": [{ "type": "date_histogram", "field": "@timestamp", "id": "2" }]
+		}`),
+		TimeRange: backend.TimeRange{From: time.UnixMilli(1000), To: time.UnixMilli(2000)},
+	}})
+	require.NoError(t, err)
+
+	var response es.MultiSearchResponse
+	err = json.Unmarshal([]byte(`{
+		"responses": [{
+			"took": 12,
+			"timed_out": false,
+			"_shards": { "total": 5, "successful": 4, "skipped": 0, "failed": 1 },
+			"hits": { "total": { "value": 3, "relation": "eq" }, "hits": [] },
+			"aggregations": { "2": { "buckets": [{ "key": 1000, "doc_count": 3 }] } }
+		}]
+	}`), &response)
+	require.NoError(t, err)
+	response.Stats = es.MultiSearchStats{RoundTrip: 20 * time.Millisecond, Decode: 1500 * time.Microsecond, Attempts: 2, CacheHits: 1, CacheMisses: 3}
+	executedRequest := `{"ignore_unavailable":true,"index":["logs"]}` + "\n" + `{"query":{"bool":{"filter":[]}},"size":0}` + "\n"
+	response.ExecutedRequests = []string{executedRequest}
+
+	result, err := parseResponse(&response, queries, es.ConfiguredFields{TimeField: "@timestamp"}, nil)
+	require.NoError(t, err)
+
+	frames := result.Responses["A"].Frames
+	require.Len(t, frames, 1)
+	meta := frames[0].Meta
+	require.NotNil(t, meta)
+	assert.Equal(t, executedRequest, meta.ExecutedQueryString)
+	assert.Equal(t, map[string]interface{}{"executedRequest": executedRequest}, meta.Custom)
+
+	stats := map[string]float64{}
+	for _, stat := range meta.Stats {
+		stats[stat.DisplayName] = stat.Value
+	}
+	assert.Equal(t, map[string]float64{
+		"Quickwit search time":  12,
+		"Total hits":            3,
+		"Splits searched":       5,
+		"Splits successful":     4,
+		"Splits skipped":        0,
+		"Splits failed":         1,
+		"Request round trip":    20,
+		"Response decoding":     1.5,
+		"Request attempts":      2,
+		"Response cache hits":   1,
+		"Response cache misses": 3,
+	}, stats)
+}
+
+func TestParseTimedOutResponse(t *testing.T) {
+	queries, err := parseQuery([]backend.DataQuery{{
+		RefID: "A",
+		JSON: json.RawMessage(`{
+			"timeout": "30s",
+			"metrics": [{ "type": "count", "id": "1" }],
+			"bucketAggs": [{ "type": "date_histogram", "field": "@timestamp", "id": "2" }]
+		}`),
+		TimeRange: backend.TimeRange{From: time.UnixMilli(1000), To: time.UnixMilli(2000)},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, queries[0].Timeout)
+
+	for name, body := range map[string]string{
+		"with partial results":    `{ "timed_out": true, "hits": { "hits": [] }, "aggregations": { "2": { "buckets": [{ "key": 1000, "doc_count": 3 }] } } }`,
+		"without partial results": `{ "timed_out": true, "hits": { "hits": [] } }`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			raw := json.RawMessage(body)
+			result, err := parseResponse(&es.MultiSearchResponse{Responses: []*json.RawMessage{&raw}}, queries, es.ConfiguredFields{TimeField: "@timestamp"}, nil)
+			require.NoError(t, err)
+
+			res := result.Responses["A"]
+			require.NoError(t, res.Error)
+			require.Len(t, res.Frames, 1)
+			require.Len(t, res.Frames[0].Meta.Notices, 1)
+			notice := res.Frames[0].Meta.Notices[0]
+			assert.Equal(t, data.NoticeSeverityWarning, notice.Severity)
+			assert.Equal(t, "The search timed out after 30s, the results may be partial", notice.Text)
+			assert.True(t, isTimedOutResponse(res))
+			assert.Equal(t, true, res.Frames[0].Meta.Custom.(map[string]interface{})["timedOut"])
+		})
+	}
+}
+
 func parseTestResponse(tsdbQueries map[string]string, responseBody string) (*backend.QueryDataResponse, error) {
 	return parseTestResponseWithDatasourceInfo(tsdbQueries, responseBody, nil)
 }
@@ -3461,7 +4375,7 @@ func parseTestResponseWithDatasourceInfo(tsdbQueries map[string]string, response
 		return nil, err
 	}
 
-	return parseResponse(response.Responses, queries, configuredFields, dsInfo)
+	return parseResponse(&response, queries, configuredFields, dsInfo)
 }
 
 func requireTimeValue(t *testing.T, expected int64, frame *data.Frame, index int) {
@@ -0,0 +1,259 @@
+package quickwit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
+)
+
+// Live tail channels are subscribed as `tail/{refId}/{key}`, the channel data
+// being the logs query model and the key identifying it among the queries
+// sharing the refId.
+const logsTailPathPrefix = "tail/"
+
+// Interval between two polls of a live tail stream
+var logsTailPollInterval = 2 * time.Second
+
+// Maximum number of ids of the rows sent at the cursor timestamp which are
+// remembered to filter them out of the next polls
+const maxLogsTailBoundaryIDs = 10000
+
+// logsTail holds the cursor of a live tail stream
+type logsTail struct {
+	query *Query
+	// cursor is the timestamp, in nanoseconds, of the most recent row sent
+	cursor int64
+	// sent holds the _index#_id ids of the rows sent at the cursor timestamp,
+	// in the order they were sent
+	sent    map[string]bool
+	sentIDs []string
+	// searchAfter holds the (timestamp, _doc) sort values of the last row
+	// fetched at the cursor timestamp when the polls page through its rows,
+	// nil otherwise
+	searchAfter []interface{}
+}
+
+func newLogsTail(query *Query, start time.Time) *logsTail {
+	return &logsTail{
+		query:  query,
+		cursor: start.UnixNano(),
+		sent:   map[string]bool{},
+	}
+}
+
+// nextQuery returns the logs query fetching the rows from the cursor
+// timestamp. The rows of that timestamp are fetched again, the rows indexed in
+// a split published after the previous poll may share it.
+func (t *logsTail) nextQuery(now time.Time) *Query {
+	q := *t.query
+	metric := *t.query.Metrics[0]
+
+	settings := make(map[string]interface{})
+	for k, v := range t.query.Metrics[0].Settings.MustMap() {
+		settings[k] = v
+	}
+	settings["sortDirection"] = "asc"
+	delete(settings, "searchAfter")
+	if t.searchAfter != nil {
+		settings["searchAfter"] = t.searchAfter
+	}
+	metric.Settings = simplejson.NewFromAny(settings)
+
+	q.Metrics = []*MetricAgg{&metric}
+	q.RangeFrom = t.cursor / int64(time.Millisecond)
+	q.RangeTo = now.UnixMilli()
+	return &q
+}
+
+// advance returns the hits of a poll which were not sent yet and moves the
+// cursor after them. The hits before the cursor and the hits at the cursor
+// whose _index#_id id was already sent are filtered out. After a full page,
+// the next poll searches after its last row, and keeps doing so while the
+// cursor timestamp does not move, so that the polls move forward through the
+// rows sharing a timestamp instead of fetching them again from the first one.
+func (t *logsTail) advance(hits []map[string]interface{}) []map[string]interface{} {
+	cursor := t.cursor
+	newHits := make([]map[string]interface{}, 0, len(hits))
+	for i, hit := range hits {
+		timestamp, ok := hitSortTimestamp(hit)
+		if !ok {
+			newHits = append(newHits, hit)
+			continue
+		}
+		id := logsHitID(hit, i)
+		if timestamp < t.cursor || (timestamp == t.cursor && t.sent[id]) {
+			continue
+		}
+		if timestamp > t.cursor {
+			t.cursor = timestamp
+			t.sent = map[string]bool{}
+			t.sentIDs = t.sentIDs[:0]
+		}
+		t.markSent(id)
+		newHits = append(newHits, hit)
+	}
+
+	limit := stringToIntWithDefaultValue(t.query.Metrics[0].Settings.Get("limit").MustString(), defaultSize)
+	fullPage := len(hits) > 0 && len(hits) >= limit
+	if fullPage || t.cursor != cursor {
+		// Without a full page, the rows of the new cursor timestamp are
+		// fetched again to get the rows indexed late
+		t.searchAfter = nil
+	}
+	if fullPage {
+		if sortValues, ok := hits[len(hits)-1]["sort"].([]interface{}); ok && len(sortValues) == 2 {
+			t.searchAfter = sortValues
+		}
+	}
+	return newHits
+}
+
+// markSent remembers the id of a row sent at the cursor timestamp, forgetting
+// the oldest ids beyond maxLogsTailBoundaryIDs
+func (t *logsTail) markSent(id string) {
+	if t.sent[id] {
+		return
+	}
+	if len(t.sentIDs) >= maxLogsTailBoundaryIDs {
+		delete(t.sent, t.sentIDs[0])
+		t.sentIDs = t.sentIDs[1:]
+	}
+	t.sent[id] = true
+	t.sentIDs = append(t.sentIDs, id)
+}
+
+// hitSortTimestamp returns the first sort value of a hit, which logs queries
+// set to the timestamp in nanoseconds
+func hitSortTimestamp(hit map[string]interface{}) (int64, bool) {
+	sortValues, ok := hit["sort"].([]interface{})
+	if !ok || len(sortValues) == 0 {
+		return 0, false
+	}
+
+	switch v := sortValues[0].(type) {
+	case json.Number:
+		timestamp, err := v.Int64()
+		return timestamp, err == nil
+	case float64:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func parseLogsTailQuery(path string, queryJSON json.RawMessage) (*Query, error) {
+	refID, _, _ := strings.Cut(strings.TrimPrefix(path, logsTailPathPrefix), "/")
+	queries, err := parseQuery([]backend.DataQuery{{RefID: refID, JSON: queryJSON}})
+	if err != nil {
+		return nil, err
+	}
+	if len(queries) != 1 || !isLogsQuery(queries[0]) {
+		return nil, fmt.Errorf("live tail is only supported for logs queries")
+	}
+	return queries[0], nil
+}
+
+// SubscribeStream is called when a client wants to live tail a logs query
+func (ds *QuickwitDatasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if !strings.HasPrefix(req.Path, logsTailPathPrefix) {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	if _, err := parseLogsTailQuery(req.Path, req.Data); err != nil {
+		qwlog.Debug("Rejected live tail subscription", "path", req.Path, "err", err)
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream is called when a client sends a message to the stream, which live tail does not accept
+func (ds *QuickwitDatasource) PublishStream(ctx context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream polls Quickwit for new logs and pushes them to the subscribers until the stream is closed
+func (ds *QuickwitDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	query, err := parseLogsTailQuery(req.Path, req.Data)
+	if err != nil {
+		return err
+	}
+
+	// Ensure ds is initialized, we need timestamp infos
+	dsInfo, err := ds.initializedDatasourceInfo(ctx, req)
+	if err != nil {
+		return fmt.Errorf("Datasource initialization failed: %w", err)
+	}
+
+	tail := newLogsTail(query, time.Now())
+	ticker := time.NewTicker(logsTailPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			frame, err := pollLogsTail(ctx, tail, dsInfo, time.Now())
+			if err != nil {
+				qwlog.Warn("Failed to poll live tail", "path", req.Path, "err", err)
+				continue
+			}
+			if frame == nil || frame.Rows() == 0 {
+				continue
+			}
+			if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pollLogsTail fetches the rows indexed since the previous poll
+func pollLogsTail(ctx context.Context, tail *logsTail, dsInfo *es.DatasourceInfo, now time.Time) (*data.Frame, error) {
+	query := tail.nextQuery(now)
+	req, err := buildMSR([]*Query{query}, dsInfo.ConfiguredFields, dsInfo.ForcedQueryFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := es.NewClient(ctx, dsInfo)
+	if err != nil {
+		return nil, err
+	}
+	msr, err := client.ExecuteMultisearch(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(msr.Responses) != 1 {
+		return nil, fmt.Errorf("unexpected number of responses: %d", len(msr.Responses))
+	}
+
+	res, err := decodeLogsSearchResponse(msr.Responses[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Hits == nil {
+		return nil, nil
+	}
+	res.Hits.Hits = tail.advance(res.Hits.Hits)
+	if len(res.Hits.Hits) == 0 {
+		return nil, nil
+	}
+
+	queryRes := backend.DataResponse{}
+	if err := processLogsResponse(res, query, dsInfo.ConfiguredFields, &queryRes); err != nil {
+		return nil, err
+	}
+	frame := queryRes.Frames[0]
+	frame.RefID = query.RefID
+	return frame, nil
+}
@@ -0,0 +1,302 @@
+package quickwit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+	"github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/simplejson"
+)
+
+var _ backend.StreamHandler = (*QuickwitDatasource)(nil)
+
+func TestLogsTail(t *testing.T) {
+	logsQuery := `{ "refId": "A", "query": "service:api", "metrics": [{ "type": "logs", "id": "1", "settings": { "limit": "50" } }] }`
+	start := time.Unix(0, 1_700_000_000_000_000_000)
+
+	newHit := func(id string, timestamp int64) map[string]interface{} {
+		return map[string]interface{}{
+			"_index":  "logs",
+			"_id":     id,
+			"_source": map[string]interface{}{"line": id},
+			"sort":    []interface{}{json.Number(strconv.FormatInt(timestamp, 10)), json.Number(strconv.Itoa(int(id[0])))},
+		}
+	}
+
+	hitIDs := func(hits []map[string]interface{}) []string {
+		ids := make([]string, len(hits))
+		for i, hit := range hits {
+			ids[i] = hit["_id"].(string)
+		}
+		return ids
+	}
+
+	t.Run("Next query searches from the last row in ascending order", func(t *testing.T) {
+		query, err := parseLogsTailQuery("tail/A/1f2e", json.RawMessage(logsQuery))
+		require.NoError(t, err)
+		tail := newLogsTail(query, start)
+
+		next := tail.nextQuery(start.Add(5 * time.Second))
+		settings := next.Metrics[0].Settings
+		assert.Equal(t, "asc", settings.Get("sortDirection").MustString())
+		assert.Empty(t, settings.Get("searchAfter").MustArray())
+		assert.Equal(t, start.UnixMilli(), next.RangeFrom)
+		assert.Equal(t, start.Add(5*time.Second).UnixMilli(), next.RangeTo)
+		assert.Equal(t, "A", next.RefID)
+
+		ts := start.UnixNano()
+		tail.advance([]map[string]interface{}{
+			newHit("a", ts+10),
+			newHit("b", ts+20_000_000),
+		})
+		next = tail.nextQuery(start.Add(10 * time.Second))
+		assert.Equal(t, start.UnixMilli()+20, next.RangeFrom)
+		assert.Empty(t, next.Metrics[0].Settings.Get("searchAfter").MustArray())
+
+		// The tailed query itself is left untouched
+		assert.Empty(t, query.Metrics[0].Settings.Get("sortDirection").MustString())
+	})
+
+	t.Run("Rows already sent at the last timestamp are filtered out", func(t *testing.T) {
+		query, err := parseLogsTailQuery("tail/A", json.RawMessage(logsQuery))
+		require.NoError(t, err)
+		tail := newLogsTail(query, start)
+		ts := start.UnixNano()
+
+		sent := tail.advance([]map[string]interface{}{
+			newHit("a", ts+10),
+			newHit("b", ts+10),
+		})
+		assert.Equal(t, []string{"a", "b"}, hitIDs(sent))
+		assert.Equal(t, ts+10, tail.cursor)
+
+		// A row of a split published later shares the timestamp of the rows sent
+		sent = tail.advance([]map[string]interface{}{
+			newHit("a", ts+10),
+			newHit("c", ts+10),
+			newHit("b", ts+10),
+			newHit("d", ts+30),
+		})
+		assert.Equal(t, []string{"c", "d"}, hitIDs(sent))
+		assert.Equal(t, ts+30, tail.cursor)
+
+		sent = tail.advance([]map[string]interface{}{
+			newHit("c", ts+10),
+			newHit("d", ts+30),
+		})
+		assert.Empty(t, sent)
+		assert.Nil(t, tail.searchAfter)
+	})
+
+	t.Run("Polls move forward when a full page of rows was already sent", func(t *testing.T) {
+		query, err := parseLogsTailQuery("tail/A", json.RawMessage(`{ "refId": "A", "metrics": [{ "type": "logs", "id": "1", "settings": { "limit": "2" } }] }`))
+		require.NoError(t, err)
+		tail := newLogsTail(query, start)
+		ts := start.UnixNano()
+
+		sent := tail.advance([]map[string]interface{}{newHit("a", ts+10), newHit("b", ts+10)})
+		assert.Equal(t, []string{"a", "b"}, hitIDs(sent))
+		assert.Equal(t, json.Number("98"), tail.nextQuery(start.Add(time.Second)).Metrics[0].Settings.Get("searchAfter").GetIndex(1).Interface())
+
+		// The search after the last row is kept while the cursor does not move
+		sent = tail.advance([]map[string]interface{}{newHit("c", ts+10)})
+		assert.Equal(t, []string{"c"}, hitIDs(sent))
+		assert.Equal(t, json.Number("98"), tail.nextQuery(start.Add(time.Second)).Metrics[0].Settings.Get("searchAfter").GetIndex(1).Interface())
+
+		sent = tail.advance(nil)
+		assert.Empty(t, sent)
+		assert.NotNil(t, tail.searchAfter)
+
+		// Once the cursor moves, its timestamp is fetched again from its first row
+		sent = tail.advance([]map[string]interface{}{newHit("d", ts+20)})
+		assert.Equal(t, []string{"d"}, hitIDs(sent))
+		assert.Nil(t, tail.searchAfter)
+	})
+
+	t.Run("Each row is sent once when more rows than the limit share a timestamp", func(t *testing.T) {
+		query, err := parseLogsTailQuery("tail/A", json.RawMessage(`{ "refId": "A", "metrics": [{ "type": "logs", "id": "1", "settings": { "limit": "2" } }] }`))
+		require.NoError(t, err)
+		tail := newLogsTail(query, start)
+		ts := start.UnixNano()
+
+		// search returns the page of the indexed rows the next query fetches
+		var indexed []map[string]interface{}
+		fetched := map[string]int{}
+		search := func() []map[string]interface{} {
+			settings := tail.nextQuery(start.Add(time.Second)).Metrics[0].Settings
+			after := settings.Get("searchAfter")
+			var page []map[string]interface{}
+			for _, hit := range indexed {
+				sortValues := hit["sort"].([]interface{})
+				if len(after.MustArray()) == 2 {
+					afterTs, _ := after.GetIndex(0).Interface().(json.Number).Int64()
+					afterDoc, _ := after.GetIndex(1).Interface().(json.Number).Int64()
+					hitTs, _ := sortValues[0].(json.Number).Int64()
+					hitDoc, _ := sortValues[1].(json.Number).Int64()
+					if hitTs < afterTs || (hitTs == afterTs && hitDoc <= afterDoc) {
+						continue
+					}
+				}
+				if len(page) < 2 {
+					page = append(page, hit)
+				}
+			}
+			for _, id := range hitIDs(page) {
+				fetched[id]++
+			}
+			return page
+		}
+
+		var sent []string
+		for _, id := range []string{"a", "b", "c", "d", "e"} {
+			indexed = append(indexed, newHit(id, ts+10))
+		}
+		for i := 0; i < 4; i++ {
+			sent = append(sent, hitIDs(tail.advance(search()))...)
+		}
+		assert.Equal(t, []string{"a", "b", "c", "d", "e"}, sent)
+
+		indexed = append(indexed, newHit("f", ts+10), newHit("g", ts+10))
+		for i := 0; i < 2; i++ {
+			sent = append(sent, hitIDs(tail.advance(search()))...)
+		}
+		assert.Equal(t, []string{"a", "b", "c", "d", "e", "f", "g"}, sent)
+
+		// Only the rows of the last page are fetched again
+		assert.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1, "d": 1, "e": 3, "f": 1, "g": 1}, fetched)
+	})
+
+	t.Run("The ids of the rows sent at the last timestamp are bounded", func(t *testing.T) {
+		query, err := parseLogsTailQuery("tail/A", json.RawMessage(logsQuery))
+		require.NoError(t, err)
+		tail := newLogsTail(query, start)
+		for i := 0; i < maxLogsTailBoundaryIDs+10; i++ {
+			tail.markSent(strconv.Itoa(i))
+		}
+		assert.Len(t, tail.sent, maxLogsTailBoundaryIDs)
+		assert.False(t, tail.sent["0"])
+		assert.True(t, tail.sent[strconv.Itoa(maxLogsTailBoundaryIDs+9)])
+	})
+
+	t.Run("Poll sends the rows after the last row as a logs frame", func(t *testing.T) {
+		query, err := parseLogsTailQuery("tail/A", json.RawMessage(logsQuery))
+		require.NoError(t, err)
+		tail := newLogsTail(query, start)
+		timestamp := strconv.FormatInt(start.UnixNano()+500, 10)
+		configuredFields := es.ConfiguredFields{
+			TimeOutputFormat: Rfc3339,
+			TimeField:        "testtime",
+			LogMessageField:  "line",
+			LogLevelField:    "lvl",
+		}
+
+		// Quickwit hits have no _id, the rows of both polls share a timestamp
+		var requestBody []byte
+		poll := func(response string, now time.Time) *data.Frame {
+			dsInfo := newFlowTestDsInfo([]byte(response), 200, configuredFields, func(req *http.Request) error {
+				requestBody, err = io.ReadAll(req.Body)
+				return err
+			})
+			frame, err := pollLogsTail(context.Background(), tail, dsInfo, now)
+			require.NoError(t, err)
+			return frame
+		}
+
+		frame := poll(`{
+			"responses": [{
+				"hits": { "hits": [
+					{ "_source": { "testtime": "2023-11-14T22:13:20.5Z", "line": "first" }, "sort": [`+timestamp+`, 1] },
+					{ "_source": { "testtime": "2023-11-14T22:13:20.5Z", "line": "second" }, "sort": [`+timestamp+`, 2] }
+				] },
+				"status": 200
+			}]
+		}`, start.Add(time.Second))
+		require.NotNil(t, frame)
+		assert.Equal(t, "A", frame.RefID)
+		assert.Equal(t, 2, frame.Rows())
+
+		lines := strings.Split(string(requestBody), "\n")
+		body, err := simplejson.NewJson([]byte(lines[1]))
+		require.NoError(t, err)
+		assert.Equal(t, "asc", body.GetPath("sort").GetIndex(0).GetPath("testtime", "order").MustString())
+		assert.Equal(t, "asc", body.GetPath("sort").GetIndex(1).GetPath("_doc", "order").MustString())
+		_, ok := body.CheckGet("search_after")
+		assert.False(t, ok)
+
+		next := poll(`{
+			"responses": [{
+				"hits": { "hits": [
+					{ "_source": { "testtime": "2023-11-14T22:13:20.5Z", "line": "second" }, "sort": [`+timestamp+`, 2] },
+					{ "_source": { "testtime": "2023-11-14T22:13:20.5Z", "line": "third" }, "sort": [`+timestamp+`, 3] },
+					{ "_source": { "testtime": "2023-11-14T22:13:20.5Z", "line": "fourth" }, "sort": [`+timestamp+`, 4] }
+				] },
+				"status": 200
+			}]
+		}`, start.Add(2*time.Second))
+		require.NotNil(t, next)
+		assert.Equal(t, 2, next.Rows())
+
+		lines = strings.Split(string(requestBody), "\n")
+		body, err = simplejson.NewJson([]byte(lines[1]))
+		require.NoError(t, err)
+		_, ok = body.CheckGet("search_after")
+		assert.False(t, ok)
+
+		// The rows of successive polls have different ids
+		ids := map[string]bool{}
+		for _, f := range []*data.Frame{frame, next} {
+			idField, _ := f.FieldByName("id")
+			require.NotNil(t, idField)
+			for i := 0; i < idField.Len(); i++ {
+				id, _ := idField.ConcreteAt(i)
+				ids[id.(string)] = true
+			}
+		}
+		assert.Len(t, ids, 4)
+	})
+}
+
+func TestSubscribeStream(t *testing.T) {
+	ds := &QuickwitDatasource{}
+
+	t.Run("Accepts logs queries on the tail path", func(t *testing.T) {
+		res, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+			Path: "tail/A",
+			Data: json.RawMessage(`{ "refId": "A", "metrics": [{ "type": "logs", "id": "1" }] }`),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, backend.SubscribeStreamStatusOK, res.Status)
+	})
+
+	t.Run("Rejects non logs queries", func(t *testing.T) {
+		res, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+			Path: "tail/A",
+			Data: json.RawMessage(`{ "refId": "A", "metrics": [{ "type": "count", "id": "1" }], "bucketAggs": [{ "type": "date_histogram", "id": "2" }] }`),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, backend.SubscribeStreamStatusNotFound, res.Status)
+	})
+
+	t.Run("Rejects unknown paths", func(t *testing.T) {
+		res, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "other/A"})
+		require.NoError(t, err)
+		assert.Equal(t, backend.SubscribeStreamStatusNotFound, res.Status)
+	})
+
+	t.Run("Rejects publications", func(t *testing.T) {
+		res, err := ds.PublishStream(context.Background(), &backend.PublishStreamRequest{Path: "tail/A"})
+		require.NoError(t, err)
+		assert.Equal(t, backend.PublishStreamStatusPermissionDenied, res.Status)
+	})
+}
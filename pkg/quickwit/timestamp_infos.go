@@ -1,11 +1,13 @@
 package quickwit
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
 )
 
 type QuickwitIndexMetadata struct {
@@ -18,62 +20,50 @@ type QuickwitIndexMetadata struct {
 	} `json:"index_config"`
 }
 
-type QuickwitCreationErrorPayload struct {
-	Message    string `json:"message"`
-	StatusCode int    `json:"status"`
-}
-
-// TODO: Revamp error handling
-func NewErrorCreationPayload(statusCode int, message string) error {
-	var payload QuickwitCreationErrorPayload
-	payload.Message = message
-	payload.StatusCode = statusCode
-	json, err := json.Marshal(payload)
-	if nil != err {
-		return err
-	}
-
-	return errors.New(string(json))
-}
-
+// FilterErrorResponses returns a QuickwitError for non successful responses
 func FilterErrorResponses(r *http.Response) (*http.Response, error) {
 	if r.StatusCode < 200 || r.StatusCode >= 400 {
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			return nil, NewErrorCreationPayload(r.StatusCode, fmt.Errorf("failed to read error body: err = %w", err).Error())
+			return nil, &es.TransportError{Err: err}
 		}
-		return nil, NewErrorCreationPayload(r.StatusCode, fmt.Sprintf("error = %s", (body)))
+		return nil, es.NewQuickwitError(r.StatusCode, body, "", nil)
 	}
 	return r, nil
 }
 
-func GetTimestampFieldInfos(indexMetadataList []QuickwitIndexMetadata) (string, string, error) {
+// GetIndexTimestampInfos returns the timestamp field and output format of
+// each index, indexes matching a pattern may use different timestamp fields
+func GetIndexTimestampInfos(indexMetadataList []QuickwitIndexMetadata) (map[string]es.TimestampInfo, error) {
 	if len(indexMetadataList) == 0 {
-		return "", "", fmt.Errorf("index metadata list is empty")
+		return nil, fmt.Errorf("index metadata list is empty")
 	}
 
-	refTimestampFieldName, refTimestampOutputFormat := FindTimestampFieldInfos(indexMetadataList[0])
-	if refTimestampFieldName == "" || refTimestampOutputFormat == "" {
-		return "", "", fmt.Errorf("Invalid timestamp field infos for %s: %s, %s", indexMetadataList[0].IndexConfig.IndexID, refTimestampFieldName, refTimestampOutputFormat)
-	}
-
-	for _, indexMetadata := range indexMetadataList[1:] {
+	timestampInfos := make(map[string]es.TimestampInfo, len(indexMetadataList))
+	for _, indexMetadata := range indexMetadataList {
 		timestampFieldName, timestampOutputFormat := FindTimestampFieldInfos(indexMetadata)
-
-		if timestampFieldName != refTimestampFieldName || timestampOutputFormat != refTimestampOutputFormat {
-			return "", "", fmt.Errorf("Indexes matching pattern have incompatible timestamp fields, found: %s (%s) and %s (%s)", refTimestampFieldName, refTimestampOutputFormat, timestampFieldName, timestampOutputFormat)
+		if timestampFieldName == "" || timestampOutputFormat == "" {
+			return nil, fmt.Errorf("Invalid timestamp field infos for %s: %s, %s", indexMetadata.IndexConfig.IndexID, timestampFieldName, timestampOutputFormat)
+		}
+		timestampInfos[indexMetadata.IndexConfig.IndexID] = es.TimestampInfo{
+			Field:        timestampFieldName,
+			OutputFormat: timestampOutputFormat,
 		}
 	}
 
-	return refTimestampFieldName, refTimestampOutputFormat, nil
+	return timestampInfos, nil
 }
 
-func GetIndexesMetadata(indexPattern string, qwickwitUrl string, cli *http.Client) ([]QuickwitIndexMetadata, error) {
+func GetIndexesMetadata(ctx context.Context, indexPattern string, qwickwitUrl string, cli *http.Client) ([]QuickwitIndexMetadata, error) {
 	mappingEndpointUrl := qwickwitUrl + "/indexes?index_id_patterns=" + indexPattern
 	qwlog.Debug("Calling quickwit endpoint: " + mappingEndpointUrl)
-	r, err := cli.Get(mappingEndpointUrl)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mappingEndpointUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	r, err := cli.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("Error when calling url = %s: %w", mappingEndpointUrl, err)
+		return nil, &es.TransportError{Err: fmt.Errorf("Error when calling url = %s: %w", mappingEndpointUrl, err)}
 	}
 	defer r.Body.Close()
 
@@ -90,7 +80,7 @@ func GetIndexesMetadata(indexPattern string, qwickwitUrl string, cli *http.Clien
 	var payload []QuickwitIndexMetadata
 	err = json.Unmarshal(body, &payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response body: %w", err)
+		return nil, &es.DecodeError{Err: fmt.Errorf("failed to unmarshal response body: %w", err)}
 	}
 
 	return payload, nil
@@ -2,171 +2,39 @@ package quickwit
 
 import (
 	"encoding/json"
-	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-)
-
-func TestDecodeTimestampFieldInfos(t *testing.T) {
-	t.Run("Test decode simple fields", func(t *testing.T) {
-		// Given
-		query := []byte(`
-			[{
-				"version": "0.6",
-				"index_config": {
-				"version": "0.6",
-				"doc_mapping": {
-					"timestamp_field": "timestamp",
-					"mode": "dynamic",
-					"tokenizers": [],
-					"field_mappings": [
-						{
-							"name": "foo",
-							"type": "text",
-							"fast": false,
-							"fieldnorms": false,
-							"indexed": true,
-							"record": "basic",
-							"stored": true,
-							"tokenizer": "default"
-						},
-						{
-							"name": "timestamp",
-							"type": "datetime",
-							"fast": true,
-							"fast_precision": "seconds",
-							"indexed": true,
-							"input_formats": [
-							"rfc3339",
-							"unix_timestamp"
-							],
-							"output_format": "rfc3339",
-							"stored": true
-						}
-					]
-				},
-				"retention": null
-				},
-				"sources": []
-			}]
-		`)
-
-		// When
-		var payload []QuickwitIndexMetadata
-		err := json.Unmarshal(query, &payload)
-		timestampFieldName, timestampOutputFormat, err := GetTimestampFieldInfos(payload)
 
-		// Then
-		require.NoError(t, err)
-		require.Equal(t, timestampFieldName, "timestamp")
-		require.Equal(t, timestampOutputFormat, "rfc3339")
-	})
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+)
 
-	t.Run("Test decode from list of index config", func(t *testing.T) {
-		// Given
-		query := []byte(`
-		[
-			{
-				"version": "0.6",
-				"index_config": {
-				"doc_mapping": {
-					"timestamp_field": "timestamp",
-					"field_mappings": [
-						{
-							"name": "timestamp",
-							"type": "datetime",
-							"output_format": "rfc3339"
-						}
-					]
-				},
-				"indexing_settings": {},
-				"retention": null
-				},
-				"sources": []
-			}
-		]
-		`)
+func TestGetIndexTimestampInfos(t *testing.T) {
+	t.Run("Test indexes with different timestamp fields", func(t *testing.T) {
+		payload := []QuickwitIndexMetadata{
+			newTestIndexMetadata("logs-a", "timestamp", "rfc3339"),
+			newTestIndexMetadata("logs-b", "ts", "unix_timestamp_secs"),
+		}
 
-		// When
-		var payload []QuickwitIndexMetadata
-		err := json.Unmarshal(query, &payload)
-		require.NoError(t, err)
-		qwlog.Debug(fmt.Sprint(payload))
-		timestampFieldName, _, err := GetTimestampFieldInfos(payload)
-		// timestampFieldName, _, err := DecodeTimestampFieldFromIndexConfigs(query)
+		timestampInfos, err := GetIndexTimestampInfos(payload)
 
-		// Then
 		require.NoError(t, err)
-		require.Equal(t, timestampFieldName, "timestamp")
+		require.Equal(t, map[string]es.TimestampInfo{
+			"logs-a": {Field: "timestamp", OutputFormat: "rfc3339"},
+			"logs-b": {Field: "ts", OutputFormat: "unix_timestamp_secs"},
+		}, timestampInfos)
 	})
 
-	t.Run("Test decode from list of index config with different timestamp fields return an error", func(t *testing.T) {
-		// Given
-		query := []byte(`
-		[
-			{
-				"version": "0.6",
-				"index_config": {
-				"doc_mapping": {
-					"timestamp_field": "timestamp",
-					"field_mappings": [
-						{
-							"name": "timestamp",
-							"type": "datetime",
-							"output_format": "rfc3339"
-						}
-					]
-				},
-				"indexing_settings": {},
-				"retention": null
-				},
-				"sources": []
-			},
-			{
-				"version": "0.6",
-				"index_config": {
-					"doc_mapping": {
-					"timestamp_field": "timestamp2",
-					"field_mappings": [
-						{
-							"name": "timestamp2",
-							"type": "datetime",
-							"output_format": "rfc3339"
-						}
-					]
-					},
-					"indexing_settings": {},
-					"retention": null
-				},
-				"sources": []
-			}
-		]
-		`)
-
-		// When
-		// _, _, err := DecodeTimestampFieldFromIndexConfigs(query)
-		var payload []QuickwitIndexMetadata
-		err := json.Unmarshal(query, &payload)
-		require.NoError(t, err)
-		_, _, err = GetTimestampFieldInfos(payload)
-
-		// Then
-		require.Error(t, err)
-		require.ErrorContains(t, err, "Indexes matching pattern have incompatible timestamp fields")
-	})
-}
+	t.Run("Test index without timestamp output format return an error", func(t *testing.T) {
+		payload := []QuickwitIndexMetadata{
+			newTestIndexMetadata("logs-a", "timestamp", "rfc3339"),
+			newTestIndexMetadata("logs-b", "ts", ""),
+		}
 
-func TestNewErrorCreationPayload(t *testing.T) {
-	t.Run("Test marshall creation payload error", func(t *testing.T) {
-		// When
-		err := NewErrorCreationPayload(400, "No valid format")
+		_, err := GetIndexTimestampInfos(payload)
 
-		// Then
-		require.Error(t, err)
-		require.ErrorContains(t, err, "\"message\":\"No valid format\"")
-		require.ErrorContains(t, err, "\"status\":400")
+		require.ErrorContains(t, err, "Invalid timestamp field infos for logs-b")
 	})
 }
 
@@ -470,14 +338,4 @@ func TestDecodeTimestampFieldInfosWithIssue152DocMapping(t *testing.T) {
 	// Verify that it correctly identifies the timestamp field and format
 	assert.Equal(t, "timestamp", timestampField, "Should correctly identify the timestamp field")
 	assert.Equal(t, "unix_timestamp_nanos", outputFormat, "Should correctly identify the output format")
-
-	// Test the higher-level function too
-	timestampFieldName, timestampOutputFormat, err := GetTimestampFieldInfos([]QuickwitIndexMetadata{indexMetadata})
-	if err != nil {
-		t.Logf("GetTimestampFieldInfos error: %v", err)
-	} else {
-		require.NoError(t, err)
-		assert.Equal(t, "timestamp", timestampFieldName)
-		assert.Equal(t, "unix_timestamp_nanos", timestampOutputFormat)
-	}
 }
@@ -0,0 +1,311 @@
+package quickwit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+)
+
+// Queries of this type are template variable queries, their query holds the
+// variable definition as JSON, like {"find": "terms", "field": "host"}
+const variableQueryType = "variable"
+
+const (
+	// Terms of a field
+	findTerms = "terms"
+	// Terms of a field in the documents matching a query, the most frequent first
+	findValues = "values"
+	// Names of the aggregatable fields, optionally filtered by type
+	findFields = "fields"
+
+	variableTermsAggID = "1"
+)
+
+// fieldTypes maps the Elasticsearch field types to the types used by the
+// variable definitions
+var fieldTypes = map[string]string{
+	"date":          "date",
+	"date_nanos":    "date",
+	"keyword":       "string",
+	"text":          "string",
+	"binary":        "string",
+	"byte":          "number",
+	"long":          "number",
+	"unsigned_long": "number",
+	"double":        "number",
+	"integer":       "number",
+	"short":         "number",
+	"float":         "number",
+	"scaled_float":  "number",
+}
+
+// variableQuery is the definition of a template variable
+type variableQuery struct {
+	Find  string `json:"find"`
+	Field string `json:"field"`
+	// Query filters the documents of the terms and values variables
+	Query   string `json:"query"`
+	Size    int    `json:"size"`
+	OrderBy string `json:"orderBy"`
+	Order   string `json:"order"`
+	// Type filters the fields variables, it holds comma separated types
+	Type string `json:"type"`
+}
+
+func isVariableQuery(query *Query) bool {
+	return query.QueryType == variableQueryType
+}
+
+// splitVariableQueries separates the variable queries from the other queries
+func splitVariableQueries(queries []*Query) (variableQueries []*Query, otherQueries []*Query) {
+	for _, q := range queries {
+		if isVariableQuery(q) {
+			variableQueries = append(variableQueries, q)
+		} else {
+			otherQueries = append(otherQueries, q)
+		}
+	}
+	return variableQueries, otherQueries
+}
+
+func parseVariableQuery(q *Query) (*variableQuery, error) {
+	var vq variableQuery
+	if err := json.Unmarshal([]byte(q.RawQuery), &vq); err != nil {
+		return nil, fmt.Errorf("invalid variable query: %w", err)
+	}
+
+	switch vq.Find {
+	case findTerms, findValues:
+		if vq.Field == "" {
+			return nil, fmt.Errorf("invalid variable query: %s variables require a field", vq.Find)
+		}
+		if vq.Find == findValues && strings.TrimSpace(vq.Query) == "" {
+			return nil, fmt.Errorf("invalid variable query: values variables require a query")
+		}
+	case findFields:
+	default:
+		return nil, fmt.Errorf("invalid variable query: unknown find type %q", vq.Find)
+	}
+
+	if vq.Size <= 0 {
+		vq.Size = defaultSize
+	}
+	switch vq.OrderBy {
+	case "":
+		vq.OrderBy = "_key"
+		if vq.Find == findValues {
+			vq.OrderBy = "_count"
+		}
+	case "key", "term":
+		vq.OrderBy = "_key"
+	case "doc_count":
+		vq.OrderBy = "_count"
+	default:
+		return nil, fmt.Errorf("invalid variable query: invalid sort type %q", vq.OrderBy)
+	}
+	switch vq.Order {
+	case "":
+		vq.Order = "asc"
+		if vq.OrderBy == "_count" {
+			vq.Order = "desc"
+		}
+	case "asc", "desc":
+	default:
+		return nil, fmt.Errorf("invalid variable query: invalid sort order %q", vq.Order)
+	}
+	return &vq, nil
+}
+
+// queryVariables runs the variable queries, the terms are fetched in a single
+// multisearch and the fields from the field capabilities of the index
+func queryVariables(ctx context.Context, client es.Client, queries []*Query, dsInfo *es.DatasourceInfo) backend.Responses {
+	responses := backend.Responses{}
+
+	var termsQueries []*Query
+	var termsDefinitions []*variableQuery
+	for _, q := range queries {
+		vq, err := parseVariableQuery(q)
+		if err != nil {
+			responses[q.RefID] = backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
+			continue
+		}
+		if vq.Find == findFields {
+			responses[q.RefID] = queryFieldsVariable(ctx, q, vq, dsInfo)
+			continue
+		}
+		termsQueries = append(termsQueries, q)
+		termsDefinitions = append(termsDefinitions, vq)
+	}
+	if len(termsQueries) == 0 {
+		return responses
+	}
+
+	ms := es.NewMultiSearchRequestBuilder()
+	timeField := dsInfo.ConfiguredFields.SearchTimeField()
+	for i, q := range termsQueries {
+		vq := termsDefinitions[i]
+		b := ms.Search(q.Interval)
+		b.Size(0)
+		filters := b.Query().Bool().Filter()
+		filters.AddDateRangeFilter(timeField, q.RangeTo, q.RangeFrom)
+		filters.AddQueryStringFilter(applyForcedQueryFilter(vq.Query, dsInfo.ForcedQueryFilter), true, "AND")
+		b.Agg().Terms(variableTermsAggID, vq.Field, func(a *es.TermsAggregation, ab es.AggBuilder) {
+			a.Size = vq.Size
+			a.ShardSize = vq.Size
+			a.Order[vq.OrderBy] = vq.Order
+		})
+	}
+	requests, err := ms.Build()
+	if err != nil {
+		for _, q := range termsQueries {
+			responses[q.RefID] = errorDataResponse(err)
+		}
+		return responses
+	}
+
+	msr, err := client.ExecuteMultisearch(requests)
+	for i, q := range termsQueries {
+		switch {
+		case err != nil:
+			responses[q.RefID] = errorDataResponse(err)
+		case i >= len(msr.Responses):
+			responses[q.RefID] = errorDataResponse(&es.DecodeError{Err: fmt.Errorf("no response for query %s", q.RefID)})
+		default:
+			responses[q.RefID] = parseTermsVariableResponse(msr.Responses[i])
+		}
+	}
+	return responses
+}
+
+// parseTermsVariableResponse returns the bucket keys of the terms aggregation
+func parseTermsVariableResponse(rawRes *json.RawMessage) backend.DataResponse {
+	if rawRes == nil {
+		return errorDataResponse(&es.DecodeError{Err: fmt.Errorf("empty response")})
+	}
+	dec := json.NewDecoder(bytes.NewReader(*rawRes))
+	dec.UseNumber()
+	var res *es.SearchResponse
+	if err := dec.Decode(&res); err != nil {
+		return errorDataResponse(&es.DecodeError{Err: err})
+	}
+	if res == nil {
+		return errorDataResponse(&es.DecodeError{Err: fmt.Errorf("empty response")})
+	}
+	if res.Error != nil || res.Status >= 400 {
+		status := res.Status
+		if status < 400 {
+			status = int(backend.StatusInternal)
+		}
+		return backend.ErrDataResponseWithSource(backend.Status(status), backend.ErrorSourceFromHTTPStatus(status), getErrorFromElasticResponse(res))
+	}
+
+	agg, _ := res.Aggregations[variableTermsAggID].(map[string]interface{})
+	buckets, _ := agg["buckets"].([]interface{})
+	values := make([]string, 0, len(buckets))
+	for _, b := range buckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if key, ok := bucket["key_as_string"].(string); ok {
+			values = append(values, key)
+		} else if bucket["key"] != nil {
+			values = append(values, fmt.Sprint(bucket["key"]))
+		}
+	}
+	return backend.DataResponse{Frames: data.Frames{newVariableFrame(values)}}
+}
+
+// fieldCapabilitiesResponse is the response of the _field_caps endpoint
+type fieldCapabilitiesResponse struct {
+	Fields map[string]map[string]struct {
+		Type         string `json:"type"`
+		Aggregatable bool   `json:"aggregatable"`
+	} `json:"fields"`
+}
+
+// queryFieldsVariable returns the names of the aggregatable fields having one
+// of the requested types
+func queryFieldsVariable(ctx context.Context, q *Query, vq *variableQuery, dsInfo *es.DatasourceInfo) backend.DataResponse {
+	capabilities, err := getFieldCapabilities(ctx, dsInfo, q.RangeFrom, q.RangeTo)
+	if err != nil {
+		return errorDataResponse(err)
+	}
+
+	types := map[string]bool{}
+	for _, t := range strings.Split(vq.Type, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types[t] = true
+		}
+	}
+
+	names := make([]string, 0, len(capabilities.Fields))
+	for name, fieldCapabilities := range capabilities.Fields {
+		for _, capability := range fieldCapabilities {
+			if !capability.Aggregatable {
+				continue
+			}
+			if len(types) > 0 && !types[capability.Type] && !types[fieldTypes[capability.Type]] {
+				continue
+			}
+			names = append(names, name)
+			break
+		}
+	}
+	sort.Strings(names)
+	return backend.DataResponse{Frames: data.Frames{newVariableFrame(names)}}
+}
+
+// getFieldCapabilities fetches the field capabilities of the index for a time
+// range, in milliseconds
+func getFieldCapabilities(ctx context.Context, dsInfo *es.DatasourceInfo, from, to int64) (*fieldCapabilitiesResponse, error) {
+	query := url.Values{}
+	query.Set("start_timestamp", fmt.Sprint(from/1000))
+	query.Set("end_timestamp", fmt.Sprint((to+999)/1000))
+	fieldCapsUrl := dsInfo.URL + "/_elastic/" + url.PathEscape(dsInfo.Database) + "/_field_caps?" + query.Encode()
+	qwlog.Debug("Calling quickwit endpoint: " + fieldCapsUrl)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fieldCapsUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	r, err := dsInfo.HTTPClient.Do(req)
+	if err != nil {
+		return nil, &es.TransportError{Err: err}
+	}
+	defer r.Body.Close()
+
+	r, err = FilterErrorResponses(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, &es.TransportError{Err: err}
+	}
+
+	var capabilities fieldCapabilitiesResponse
+	if err := json.Unmarshal(body, &capabilities); err != nil {
+		return nil, &es.DecodeError{Err: err}
+	}
+	return &capabilities, nil
+}
+
+// newVariableFrame returns the text/value frame used by template variables
+func newVariableFrame(values []string) *data.Frame {
+	return data.NewFrame("",
+		data.NewField("text", nil, values),
+		data.NewField("value", nil, values),
+	)
+}
@@ -0,0 +1,161 @@
+package quickwit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/quickwit-oss/quickwit-datasource/pkg/quickwit/client"
+)
+
+func variableFrameValues(t *testing.T, frame *data.Frame) []string {
+	t.Helper()
+	require.Len(t, frame.Fields, 2)
+	assert.Equal(t, "text", frame.Fields[0].Name)
+	assert.Equal(t, "value", frame.Fields[1].Name)
+	values := make([]string, 0, frame.Rows())
+	for i := 0; i < frame.Rows(); i++ {
+		values = append(values, frame.Fields[1].At(i).(string))
+	}
+	return values
+}
+
+func TestParseVariableQuery(t *testing.T) {
+	for definition, expected := range map[string]variableQuery{
+		`{"find": "terms", "field": "host"}`:                                    {Find: findTerms, Field: "host", Size: defaultSize, OrderBy: "_key", Order: "asc"},
+		`{"find": "terms", "field": "host", "orderBy": "doc_count"}`:            {Find: findTerms, Field: "host", Size: defaultSize, OrderBy: "_count", Order: "desc"},
+		`{"find": "values", "field": "host", "query": "level:error"}`:           {Find: findValues, Field: "host", Query: "level:error", Size: defaultSize, OrderBy: "_count", Order: "desc"},
+		`{"find": "fields", "type": "keyword,number", "size": 10}`:              {Find: findFields, Type: "keyword,number", Size: 10, OrderBy: "_key", Order: "asc"},
+		`{"find": "terms", "field": "host", "orderBy": "key", "order": "desc"}`: {Find: findTerms, Field: "host", Size: defaultSize, OrderBy: "_key", Order: "desc"},
+	} {
+		vq, err := parseVariableQuery(&Query{RawQuery: definition})
+		require.NoError(t, err, definition)
+		assert.Equal(t, expected, *vq, definition)
+	}
+
+	for _, definition := range []string{
+		`not json`,
+		`{"find": "terms"}`,
+		`{"find": "values", "field": "host"}`,
+		`{"find": "unknown"}`,
+		`{"find": "terms", "field": "host", "orderBy": "random"}`,
+	} {
+		_, err := parseVariableQuery(&Query{RawQuery: definition})
+		assert.Error(t, err, definition)
+	}
+}
+
+func TestQueryVariables(t *testing.T) {
+	from := time.UnixMilli(1_000_000)
+	to := time.UnixMilli(2_000_500)
+
+	t.Run("Terms variables are fetched in a single multisearch", func(t *testing.T) {
+		c := newFakeClient()
+		require.NoError(t, json.Unmarshal([]byte(`{
+			"responses": [
+				{ "aggregations": { "1": { "buckets": [{ "key": "web-1", "doc_count": 3 }, { "key": "web-2", "doc_count": 1 }] } } },
+				{ "aggregations": { "1": { "buckets": [{ "key": 500, "doc_count": 3 }, { "key": 1700000000000, "key_as_string": "2023-11-14T22:13:20Z", "doc_count": 1 }] } } }
+			]
+		}`), c.multiSearchResponse))
+
+		dsInfo := &es.DatasourceInfo{ConfiguredFields: es.ConfiguredFields{TimeField: "timestamp"}, ForcedQueryFilter: "service:api"}
+
+		result, err := executeElasticsearchDataQueries(c, map[string]string{
+			"A": `{ "queryType": "variable", "query": "{\"find\": \"terms\", \"field\": \"host\", \"size\": 10}" }`,
+			"B": `{ "queryType": "variable", "query": "{\"find\": \"values\", \"field\": \"status\", \"query\": \"level:error\"}" }`,
+		}, from, to, dsInfo, nil)
+		require.NoError(t, err)
+		responses := result.Responses
+		require.Len(t, c.multisearchRequests, 1)
+		requests := c.multisearchRequests[0]
+		require.Len(t, requests, 2)
+
+		termsAgg := requests[0].Aggs[0].Aggregation.Aggregation.(*es.TermsAggregation)
+		assert.Equal(t, "host", termsAgg.Field)
+		assert.Equal(t, 10, termsAgg.Size)
+		assert.Equal(t, map[string]interface{}{"_key": "asc"}, termsAgg.Order)
+		assert.Equal(t, "service:api", requests[0].Query.Bool.Filters[1].(*es.QueryStringFilter).Query)
+		assert.Equal(t, "level:error AND service:api", requests[1].Query.Bool.Filters[1].(*es.QueryStringFilter).Query)
+
+		require.NoError(t, responses["A"].Error)
+		assert.Equal(t, []string{"web-1", "web-2"}, variableFrameValues(t, responses["A"].Frames[0]))
+		require.NoError(t, responses["B"].Error)
+		assert.Equal(t, []string{"500", "2023-11-14T22:13:20Z"}, variableFrameValues(t, responses["B"].Frames[0]))
+	})
+
+	t.Run("Fields variables are filtered by type", func(t *testing.T) {
+		var requestURL string
+		ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			requestURL = r.URL.String()
+			rw.Header().Set("Content-Type", "application/json")
+			_, err := rw.Write([]byte(`{
+				"fields": {
+					"host": { "keyword": { "type": "keyword", "aggregatable": true } },
+					"status": { "long": { "type": "long", "aggregatable": true } },
+					"message": { "text": { "type": "text", "aggregatable": false } },
+					"timestamp": { "date_nanos": { "type": "date_nanos", "aggregatable": true } }
+				}
+			}`))
+			require.NoError(t, err)
+		}))
+		t.Cleanup(ts.Close)
+
+		dsInfo := &es.DatasourceInfo{URL: ts.URL, HTTPClient: ts.Client(), Database: "logs"}
+		result, err := executeElasticsearchDataQueries(newFakeClient(), map[string]string{
+			"A": `{ "queryType": "variable", "query": "{\"find\": \"fields\"}" }`,
+			"B": `{ "queryType": "variable", "query": "{\"find\": \"fields\", \"type\": \"keyword, number\"}" }`,
+		}, from, to, dsInfo, nil)
+		require.NoError(t, err)
+		responses := result.Responses
+		assert.Equal(t, "/_elastic/logs/_field_caps?end_timestamp=2001&start_timestamp=1000", requestURL)
+		require.NoError(t, responses["A"].Error)
+		assert.Equal(t, []string{"host", "status", "timestamp"}, variableFrameValues(t, responses["A"].Frames[0]))
+		require.NoError(t, responses["B"].Error)
+		assert.Equal(t, []string{"host", "status"}, variableFrameValues(t, responses["B"].Frames[0]))
+	})
+
+	t.Run("Fields variables escape the index in the field capabilities path", func(t *testing.T) {
+		var requestPath string
+		ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			requestPath = r.URL.EscapedPath()
+			rw.Header().Set("Content-Type", "application/json")
+			_, err := rw.Write([]byte(`{ "fields": {} }`))
+			require.NoError(t, err)
+		}))
+		t.Cleanup(ts.Close)
+
+		dsInfo := &es.DatasourceInfo{URL: ts.URL, HTTPClient: ts.Client(), Database: "logs/raw?v=1"}
+		result, err := executeElasticsearchDataQueries(newFakeClient(), map[string]string{
+			"A": `{ "queryType": "variable", "query": "{\"find\": \"fields\"}" }`,
+		}, from, to, dsInfo, nil)
+		require.NoError(t, err)
+		require.NoError(t, result.Responses["A"].Error)
+		assert.Equal(t, "/_elastic/logs%2Fraw%3Fv=1/_field_caps", requestPath)
+	})
+
+	t.Run("Invalid definitions only fail their variable", func(t *testing.T) {
+		c := newFakeClient()
+		require.NoError(t, json.Unmarshal([]byte(`{
+			"responses": [{ "error": { "reason": "field not found" }, "status": 400 }]
+		}`), c.multiSearchResponse))
+
+		result, err := executeElasticsearchDataQueries(c, map[string]string{
+			"A": `{ "queryType": "variable", "query": "{\"find\": \"terms\", \"field\": \"unknown\"}" }`,
+			"B": `{ "queryType": "variable", "query": "{\"find\": \"terms\"}" }`,
+		}, from, to, &es.DatasourceInfo{}, nil)
+		require.NoError(t, err)
+		responses := result.Responses
+
+		require.Error(t, responses["A"].Error)
+		assert.Equal(t, backend.StatusBadRequest, responses["A"].Status)
+		require.Error(t, responses["B"].Error)
+		assert.Contains(t, responses["B"].Error.Error(), "require a field")
+	})
+}
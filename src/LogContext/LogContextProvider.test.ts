@@ -0,0 +1,27 @@
+import { FieldType, toDataFrame } from '@grafana/data';
+
+import { LogRowContextQueryDirection, sliceLogContext } from './LogContextProvider';
+
+describe('Test LogContextProvider:sliceLogContext', () => {
+  const frame = toDataFrame({
+    fields: [{ name: 'line', type: FieldType.string, values: ['before 2', 'before 1', 'row', 'after 1', 'after 2'] }],
+  });
+
+  it('Should return the rows before the row, most recent first', () => {
+    const backward = sliceLogContext(frame, 2, LogRowContextQueryDirection.Backward);
+
+    expect(backward.length).toBe(2);
+    expect(backward.fields[0].values).toEqual(['before 1', 'before 2']);
+  });
+
+  it('Should return the rows after the row, oldest first', () => {
+    const forward = sliceLogContext(frame, 2, LogRowContextQueryDirection.Forward);
+
+    expect(forward.length).toBe(2);
+    expect(forward.fields[0].values).toEqual(['after 1', 'after 2']);
+  });
+
+  it('Should return no rows when the row is not found', () => {
+    expect(sliceLogContext(frame, -1, LogRowContextQueryDirection.Forward).length).toBe(0);
+  });
+});
@@ -1,18 +1,16 @@
 import { ReactNode } from 'react';
-import { lastValueFrom, catchError } from 'rxjs';
 
 import { BaseQuickwitDataSource } from '@/datasource/base';
 
 import {
-  CoreApp,
   DataFrame,
+  DataFrameJSON,
   DataQueryError,
-  DataQueryRequest,
+  dataFrameFromJSON,
   LogRowModel,
-  rangeUtil,
 } from '@grafana/data';
 
-import { ElasticsearchQuery, Logs, LogsSortDirection} from '../types';
+import { ElasticsearchQuery } from '../types';
 
 import { LogContextUI } from './components/LogContextUI';
 import { createContextTimeRange } from './utils';
@@ -26,57 +24,60 @@ export enum LogRowContextQueryDirection {
     Forward = 'FORWARD',
 }
 
+// The log context resource returns the rows before and after the row in a
+// single frame, the index of the row being set in its custom meta
+type LogContext = {
+  frame: DataFrame;
+  anchorIndex: number;
+};
+
 export class LogContextProvider {
   datasource: BaseQuickwitDataSource;
   contextQuery: string | null;
+  // The two directions of the context of a row share one resource call
+  private pendingContext: { key: string; context: Promise<LogContext> } | null;
 
   constructor(datasource: BaseQuickwitDataSource) {
     this.datasource = datasource;
     this.contextQuery = null;
+    this.pendingContext = null;
   }
-  private makeLogContextDataRequest = (
-    row: LogRowModel,
-    options?: LogRowContextOptions,
-    origQuery?: ElasticsearchQuery
-    ) => {
-    const direction = options?.direction || LogRowContextQueryDirection.Backward;
-    const searchAfter = row.dataFrame.fields.find((f) => f.name === 'sort')?.values.get(row.rowIndex) ?? [row.timeEpochNs]
-
-    const logQuery: Logs = {
-      type: 'logs',
-      id: '1',
-      settings: {
-        limit: options?.limit ? options?.limit.toString() : '10',
-        // Sorting of results in the context query
-        sortDirection: direction === LogRowContextQueryDirection.Backward ? LogsSortDirection.DESC : LogsSortDirection.ASC,
-        // Used to get the next log lines before/after the current log line using sort field of selected log line
-        searchAfter: searchAfter,
-      },
-    };
-
-    const query: ElasticsearchQuery = {
-      refId: `log-context-${row.dataFrame.refId}-${direction}`,
-      metrics: [logQuery],
-      query: this.contextQuery == null ? origQuery?.query : this.contextQuery,
-    };
-
-    const range = createContextTimeRange(row.timeEpochMs, direction);
 
-    const interval = rangeUtil.calculateInterval(range, 1);
+  private fetchLogContext = (row: LogRowModel, limit: number, origQuery?: ElasticsearchQuery): Promise<LogContext> => {
+    const query = this.contextQuery == null ? origQuery?.query : this.contextQuery;
+    const key = JSON.stringify([row.dataFrame.refId, row.uid, limit, query]);
+    if (this.pendingContext?.key === key) {
+      return this.pendingContext.context;
+    }
 
-    const contextRequest: DataQueryRequest<ElasticsearchQuery> = {
-      requestId: `log-context-request-${row.dataFrame.refId}-${options?.direction}`,
-      targets: [query],
-      interval: interval.interval,
-      intervalMs: interval.intervalMs,
-      range,
-      scopedVars: {},
-      timezone: 'UTC',
-      app: CoreApp.Explore,
-      startTime: Date.now(),
-      hideFromInspector: true,
-    };
-    return contextRequest;
+    const range = createContextTimeRange(row.timeEpochMs);
+    // The id of the rows is made of their sort values, which keeps the
+    // nanoseconds of their timestamp
+    const rowId = row.dataFrame.fields.find((f) => f.name === 'id')?.values[row.rowIndex];
+    const context = this.datasource
+      .postResource<DataFrameJSON>('log_context', {
+        query,
+        rowId,
+        timestamp: Number(row.timeEpochNs),
+        limit,
+        from: range.from.valueOf(),
+        to: range.to.valueOf(),
+      })
+      .then((frameJSON) => {
+        const frame = dataFrameFromJSON(frameJSON);
+        return { frame: { ...frame, refId: row.dataFrame.refId }, anchorIndex: frame.meta?.custom?.anchorIndex ?? -1 };
+      })
+      .catch((err) => {
+        this.pendingContext = null;
+        const error: DataQueryError = {
+          message: 'Error during context query. Please check JS console logs.',
+          status: err.status,
+          statusText: err.data?.message ?? err.message,
+        };
+        throw error;
+      });
+    this.pendingContext = { key, context };
+    return context;
   };
 
   getLogRowContext = async (
@@ -84,20 +85,9 @@ export class LogContextProvider {
         options?: LogRowContextOptions,
         origQuery?: ElasticsearchQuery
     ): Promise<{ data: DataFrame[] }> => {
-    const contextRequest = this.makeLogContextDataRequest(row, options, origQuery);
-
-    return lastValueFrom(
-      this.datasource.query(contextRequest).pipe(
-        catchError((err) => {
-          const error: DataQueryError = {
-            message: 'Error during context query. Please check JS console logs.',
-            status: err.status,
-            statusText: err.message,
-          };
-          throw error;
-        })
-      )
-    );
+    const direction = options?.direction || LogRowContextQueryDirection.Backward;
+    const { frame, anchorIndex } = await this.fetchLogContext(row, options?.limit ?? 10, origQuery);
+    return { data: [sliceLogContext(frame, anchorIndex, direction)] };
   };
 
   getLogRowContextUi(
@@ -108,3 +98,19 @@ export class LogContextProvider {
     return ( LogContextUI({row, runContextQuery, origQuery, updateQuery: query=>{this.contextQuery=query}, datasource:this.datasource}))
   }
 }
+
+/**
+ * Returns the rows of the log context before the row, most recent first, or
+ * the rows after the row, oldest first
+ */
+export function sliceLogContext(frame: DataFrame, anchorIndex: number, direction: LogRowContextQueryDirection): DataFrame {
+  const isBackward = direction === LogRowContextQueryDirection.Backward;
+  const slice = (values: unknown[]) => {
+    if (anchorIndex < 0) {
+      return [];
+    }
+    return isBackward ? values.slice(0, anchorIndex).reverse() : values.slice(anchorIndex + 1);
+  };
+  const fields = frame.fields.map((field) => ({ ...field, values: slice(field.values) }));
+  return { ...frame, fields, length: fields[0]?.values.length ?? 0 };
+}
@@ -16,7 +16,10 @@ import { bucketAggregationConfig } from './utils';
 const QUICKWIT_SUPPORTED_BUCKET_AGGREGATIONS = [
   'date_histogram',
   'terms',
-  'histogram'
+  'histogram',
+  'range',
+  'date_range',
+  'composite',
 ];
 
 const bucketAggOptions: Array<SelectableValue<BucketAggregationType>> = Object.entries(bucketAggregationConfig).map(
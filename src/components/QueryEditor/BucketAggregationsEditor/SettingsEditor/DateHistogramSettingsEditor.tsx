@@ -6,9 +6,9 @@ import { InternalTimeZones, SelectableValue } from '@grafana/data';
 import { InlineField, Input, Select, TimeZonePicker } from '@grafana/ui';
 
 import { useDispatch } from '@/hooks/useStatelessReducer';
-import { DateHistogram } from '@/types';
+import { DateHistogram, DateHistogramIntervalType } from '@/types';
 import { useCreatableSelectPersistedBehaviour } from '@/components/hooks/useCreatableSelectPersistedBehaviour';
-import { changeBucketAggregationSetting } from '../state/actions';
+import { changeBucketAggregationSetting, changeBucketAggregationSettings } from '../state/actions';
 import { bucketAggregationConfig } from '../utils';
 
 import { inlineFieldProps } from '.';
@@ -24,6 +24,23 @@ const defaultIntervalOptions: Array<SelectableValue<string>> = [
   { label: '1d', value: '1d' },
 ];
 
+const intervalTypeOptions: Array<SelectableValue<DateHistogramIntervalType>> = [
+  { label: 'Fixed', value: 'fixed' },
+  { label: 'Calendar', value: 'calendar' },
+];
+
+// Quickwit has no calendar intervals: the backend regroups the hours of the
+// time zone into its days, weeks, months, quarters and years
+const calendarIntervalOptions: Array<SelectableValue<string>> = [
+  { label: '1m', value: '1m' },
+  { label: '1h', value: '1h' },
+  { label: '1d', value: '1d' },
+  { label: '1w', value: '1w' },
+  { label: '1M', value: '1M' },
+  { label: '1q', value: '1q' },
+  { label: '1y', value: '1y' },
+];
+
 const hasValue =
   (searchValue: string) =>
   ({ value }: SelectableValue<string>) =>
@@ -55,21 +72,57 @@ export const DateHistogramSettingsEditor = ({ bucketAgg }: Props) => {
   const handleIntervalChange = ({ value }: SelectableValue<string>) =>
     dispatch(changeBucketAggregationSetting({ bucketAgg, settingName: 'interval', newValue: value }));
 
+  const interval = bucketAgg.settings?.interval || bucketAggregationConfig.date_histogram.defaultSettings?.interval;
+  const isCalendar = bucketAgg.settings?.intervalType === 'calendar';
+
+  const handleIntervalTypeChange = ({ value }: SelectableValue<DateHistogramIntervalType>) => {
+    const settings: Record<string, any> = { intervalType: value };
+    // The calendar date histograms only support calendar intervals
+    if (value === 'calendar' && !calendarIntervalOptions.some(hasValue(interval || ''))) {
+      settings.interval = '1d';
+    }
+    dispatch(changeBucketAggregationSettings({ bucketAgg, settings }));
+  };
+
+  const fixedIntervalSelectProps = useCreatableSelectPersistedBehaviour({
+    options: defaultIntervalOptions,
+    value: interval,
+    onChange: handleIntervalChange,
+  });
+
   return (
     <>
-      <InlineField label="Interval" {...inlineFieldProps}>
+      <InlineField
+        label="Interval type"
+        {...inlineFieldProps}
+        tooltip="The calendar intervals follow the days, weeks, months, quarters and years of the time zone. Their unique counts, percentiles, rates and pipeline metrics are not supported."
+      >
         <Select
-          inputId={uniqueId('es-date_histogram-interval')}
-          isValidNewOption={isValidNewOption}
-          filterOption={optionStartsWithValue}
-          {...useCreatableSelectPersistedBehaviour({
-            options: defaultIntervalOptions,
-            value: bucketAgg.settings?.interval || bucketAggregationConfig.date_histogram.defaultSettings?.interval,
-            onChange: handleIntervalChange,
-          })}
+          inputId={`${baseId}-interval_type`}
+          options={intervalTypeOptions}
+          value={bucketAgg.settings?.intervalType || 'fixed'}
+          onChange={handleIntervalTypeChange}
         />
       </InlineField>
 
+      <InlineField label="Interval" {...inlineFieldProps}>
+        {isCalendar ? (
+          <Select
+            inputId={`${baseId}-calendar_interval`}
+            options={calendarIntervalOptions}
+            value={interval}
+            onChange={handleIntervalChange}
+          />
+        ) : (
+          <Select
+            inputId={uniqueId('es-date_histogram-interval')}
+            isValidNewOption={isValidNewOption}
+            filterOption={optionStartsWithValue}
+            {...fixedIntervalSelectProps}
+          />
+        )}
+      </InlineField>
+
       <InlineField label="Min Doc Count" {...inlineFieldProps}>
         <Input
           id={`${baseId}-min_doc_count`}
@@ -96,19 +149,22 @@ export const DateHistogramSettingsEditor = ({ bucketAgg }: Props) => {
         />
       </InlineField>
 
-      <InlineField
-        label="Offset"
-        {...inlineFieldProps}
-        tooltip="Change the start value of each bucket by the specified positive (+) or negative offset (-) duration, such as 1h for an hour, or 1d for a day"
-      >
-        <Input
-          id={`${baseId}-offset`}
-          onBlur={(e) =>
-            dispatch(changeBucketAggregationSetting({ bucketAgg, settingName: 'offset', newValue: e.target.value }))
-          }
-          defaultValue={bucketAgg.settings?.offset || bucketAggregationConfig.date_histogram.defaultSettings?.offset}
-        />
-      </InlineField>
+      {/* The calendar buckets start on the days of the time zone */}
+      {!isCalendar && (
+        <InlineField
+          label="Offset"
+          {...inlineFieldProps}
+          tooltip="Change the start value of each bucket by the specified positive (+) or negative offset (-) duration, such as 1h for an hour, or 1d for a day"
+        >
+          <Input
+            id={`${baseId}-offset`}
+            onBlur={(e) =>
+              dispatch(changeBucketAggregationSetting({ bucketAgg, settingName: 'offset', newValue: e.target.value }))
+            }
+            defaultValue={bucketAgg.settings?.offset || bucketAggregationConfig.date_histogram.defaultSettings?.offset}
+          />
+        </InlineField>
+      )}
 
       <InlineField label="Timezone" {...inlineFieldProps}>
         <TimeZonePicker
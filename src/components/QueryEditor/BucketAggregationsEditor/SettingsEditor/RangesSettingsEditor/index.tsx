@@ -0,0 +1,97 @@
+import { css } from '@emotion/css';
+import { uniqueId } from 'lodash';
+import React, { useRef } from 'react';
+
+import { InlineField, InlineSwitch, Input } from '@grafana/ui';
+
+import { useDispatch } from '@/hooks/useStatelessReducer';
+import { AggRange, DateRange, Range } from '@/types';
+import { AddRemove } from '@/components/AddRemove';
+import { changeBucketAggregationSetting } from '../../state/actions';
+import { inlineFieldProps } from '..';
+
+import { defaultRange } from './utils';
+
+interface Props {
+  bucketAgg: Range | DateRange;
+}
+
+export const RangesSettingsEditor = ({ bucketAgg }: Props) => {
+  const { current: baseId } = useRef(uniqueId('es-ranges-'));
+
+  const dispatch = useDispatch();
+
+  const ranges = bucketAgg.settings?.ranges?.length ? bucketAgg.settings.ranges : [defaultRange()];
+  const boundPlaceholder = bucketAgg.type === 'date_range' ? 'now-1d' : '0';
+
+  const changeRanges = (newValue: AggRange[]) =>
+    dispatch(changeBucketAggregationSetting({ bucketAgg, settingName: 'ranges', newValue }));
+  const changeRange = (index: number, range: AggRange) =>
+    changeRanges(ranges.map((r, i) => (i === index ? range : r)));
+
+  return (
+    <>
+      <div
+        className={css`
+          display: flex;
+          flex-direction: column;
+        `}
+      >
+        {ranges.map((range, index) => (
+          <div
+            key={index}
+            className={css`
+              display: flex;
+            `}
+          >
+            <InlineField label="From" labelWidth={8}>
+              <Input
+                width={14}
+                id={`${baseId}-from-${index}`}
+                placeholder={boundPlaceholder}
+                onBlur={(e) => changeRange(index, { ...range, from: e.target.value })}
+                defaultValue={range.from}
+              />
+            </InlineField>
+            <InlineField label="To" labelWidth={6}>
+              <Input
+                width={14}
+                id={`${baseId}-to-${index}`}
+                placeholder={boundPlaceholder}
+                onBlur={(e) => changeRange(index, { ...range, to: e.target.value })}
+                defaultValue={range.to}
+              />
+            </InlineField>
+            <InlineField label="Label" labelWidth={8}>
+              <Input
+                width={14}
+                id={`${baseId}-key-${index}`}
+                placeholder="Label"
+                onBlur={(e) => changeRange(index, { ...range, key: e.target.value })}
+                defaultValue={range.key}
+              />
+            </InlineField>
+            <AddRemove
+              index={index}
+              elements={ranges}
+              onAdd={() => changeRanges([...ranges, defaultRange()])}
+              onRemove={() => changeRanges(ranges.filter((_, i) => i !== index))}
+            />
+          </div>
+        ))}
+      </div>
+
+      <InlineField label="Keyed" {...inlineFieldProps} tooltip="Return the buckets keyed by their label">
+        <InlineSwitch
+          id={`${baseId}-keyed`}
+          value={!!bucketAgg.settings?.keyed}
+          onChange={(e) =>
+            dispatch(
+              changeBucketAggregationSetting({ bucketAgg, settingName: 'keyed', newValue: e.currentTarget.checked })
+            )
+          }
+        />
+      </InlineField>
+    </>
+  );
+};
@@ -0,0 +1,3 @@
+import { AggRange } from '@/types';
+
+export const defaultRange = (): AggRange => ({ from: '', to: '', key: '' });
@@ -2,15 +2,15 @@ import { uniqueId } from 'lodash';
 import React, { useRef } from 'react';
 
 import { SelectableValue } from '@grafana/data';
-import { InlineField, Select, Input } from '@grafana/ui';
+import { InlineField, InlineSwitch, Select, Input } from '@grafana/ui';
 
 import { useDispatch } from '@/hooks/useStatelessReducer';
 import { MetricAggregation, Percentiles, ExtendedStatMetaType, ExtendedStats, Terms } from '@/types';
 import { describeMetric } from '@/utils';
 import { useCreatableSelectPersistedBehaviour } from '@/components/hooks/useCreatableSelectPersistedBehaviour';
-import { useQuery } from '@/components/QueryEditor/ElasticsearchQueryContext';
+import { useDatasource, useQuery } from '@/components/QueryEditor/ElasticsearchQueryContext';
 import { isPipelineAggregation } from '@/components/QueryEditor/MetricAggregationsEditor/aggregations';
-import { changeBucketAggregationSetting } from '../state/actions';
+import { changeBucketAggregationSetting, changeBucketAggregationSettings } from '../state/actions';
 import { bucketAggregationConfig, orderByOptions, orderOptions, sizeOptions } from '../utils';
 
 import { inlineFieldProps } from '.';
@@ -19,8 +19,13 @@ interface Props {
   bucketAgg: Terms;
 }
 
+// The key of the bucket of the documents without value of a text field
+const missingBucketKey = '__missing__';
+const stringFieldTypes = ['text', 'keyword'];
+
 export const TermsSettingsEditor = ({ bucketAgg }: Props) => {
   const { metrics } = useQuery();
+  const datasource = useDatasource();
   const orderBy = createOrderByOptions(metrics);
   const { current: baseId } = useRef(uniqueId('es-terms-'));
 
@@ -78,8 +83,15 @@ export const TermsSettingsEditor = ({ bucketAgg }: Props) => {
         />
       </InlineField>
 
-      <InlineField label="Missing" {...inlineFieldProps}>
+      <InlineField
+        label="Missing"
+        {...inlineFieldProps}
+        invalid={!!bucketAgg.settings?.missingBucket && !bucketAgg.settings?.missing}
+        error="The missing bucket requires a missing value of the type of the field"
+      >
         <Input
+          // Remount when the missing bucket sets the missing value
+          key={bucketAgg.settings?.missing}
           id={`${baseId}-missing`}
           onBlur={(e) =>
             dispatch(changeBucketAggregationSetting({ bucketAgg, settingName: 'missing', newValue: e.target.value }))
@@ -87,6 +99,59 @@ export const TermsSettingsEditor = ({ bucketAgg }: Props) => {
           defaultValue={bucketAgg.settings?.missing || bucketAggregationConfig.terms.defaultSettings?.missing}
         />
       </InlineField>
+
+      <InlineField
+        label="Missing bucket"
+        {...inlineFieldProps}
+        tooltip="Label the documents without value as (missing). Fields other than text need a missing value of their type."
+      >
+        <InlineSwitch
+          id={`${baseId}-missing_bucket`}
+          value={!!bucketAgg.settings?.missingBucket}
+          onChange={(e) => {
+            const settings: Record<string, any> = { missingBucket: e.currentTarget.checked };
+            const fieldType = bucketAgg.field ? datasource.getFieldType(bucketAgg.field) : undefined;
+            // Only the text fields can group their documents without value under
+            // the default key, the others need a missing value of their type
+            const isStringField = !!fieldType && stringFieldTypes.includes(fieldType);
+            if (settings.missingBucket && !bucketAgg.settings?.missing && isStringField) {
+              settings.missing = missingBucketKey;
+            }
+            dispatch(changeBucketAggregationSettings({ bucketAgg, settings }));
+          }}
+        />
+      </InlineField>
+
+      <InlineField
+        label="Other bucket"
+        {...inlineFieldProps}
+        tooltip="Count the documents of the terms outside of the top terms. The other bucket is count only, its metrics are not computed."
+      >
+        <InlineSwitch
+          id={`${baseId}-other_bucket`}
+          value={!!bucketAgg.settings?.otherBucket}
+          onChange={(e) =>
+            dispatch(
+              changeBucketAggregationSetting({ bucketAgg, settingName: 'otherBucket', newValue: e.currentTarget.checked })
+            )
+          }
+        />
+      </InlineField>
+
+      {bucketAgg.settings?.otherBucket && (
+        <InlineField label="Other label" {...inlineFieldProps}>
+          <Input
+            id={`${baseId}-other_bucket_label`}
+            placeholder="Other"
+            onBlur={(e) =>
+              dispatch(
+                changeBucketAggregationSetting({ bucketAgg, settingName: 'otherBucketLabel', newValue: e.target.value })
+              )
+            }
+            defaultValue={bucketAgg.settings?.otherBucketLabel}
+          />
+        </InlineField>
+      )}
     </>
   );
 };
@@ -11,6 +11,7 @@ import { bucketAggregationConfig } from '../utils';
 
 import { DateHistogramSettingsEditor } from './DateHistogramSettingsEditor';
 import { FiltersSettingsEditor } from './FiltersSettingsEditor';
+import { RangesSettingsEditor } from './RangesSettingsEditor';
 import { TermsSettingsEditor } from './TermsSettingsEditor';
 import { useDescription } from './useDescription';
 
@@ -34,6 +35,42 @@ export const SettingsEditor = ({ bucketAgg }: Props) => {
       {bucketAgg.type === 'terms' && <TermsSettingsEditor bucketAgg={bucketAgg} />}
       {bucketAgg.type === 'date_histogram' && <DateHistogramSettingsEditor bucketAgg={bucketAgg} />}
       {bucketAgg.type === 'filters' && <FiltersSettingsEditor bucketAgg={bucketAgg} />}
+      {(bucketAgg.type === 'range' || bucketAgg.type === 'date_range') && (
+        <RangesSettingsEditor bucketAgg={bucketAgg} />
+      )}
+
+      {bucketAgg.type === 'composite' && (
+        <>
+          <InlineField label="Fields" tooltip="Comma separated list of the fields to group by" {...inlineFieldProps}>
+            <Input
+              id={`${baseId}-composite-fields`}
+              onBlur={(e) =>
+                dispatch(
+                  changeBucketAggregationSetting({
+                    bucketAgg,
+                    settingName: 'fields',
+                    newValue: e.target.value
+                      .split(',')
+                      .map((field) => field.trim())
+                      .filter((field) => field !== ''),
+                  })
+                )
+              }
+              defaultValue={(bucketAgg.settings?.fields || []).join(', ')}
+            />
+          </InlineField>
+
+          <InlineField label="Page size" {...inlineFieldProps}>
+            <Input
+              id={`${baseId}-composite-size`}
+              onBlur={(e) =>
+                dispatch(changeBucketAggregationSetting({ bucketAgg, settingName: 'size', newValue: e.target.value }))
+              }
+              defaultValue={bucketAgg.settings?.size || bucketAggregationConfig[bucketAgg.type].defaultSettings?.size}
+            />
+          </InlineField>
+        </>
+      )}
 
       {bucketAgg.type === 'geohash_grid' && (
         <InlineField label="Precision" {...inlineFieldProps}>
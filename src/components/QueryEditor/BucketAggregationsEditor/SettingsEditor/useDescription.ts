@@ -60,6 +60,17 @@ export const useDescription = (bucketAgg: BucketAggregation): string => {
       return `Filter Queries (${filters!.length})`;
     }
 
+    case 'range':
+    case 'date_range': {
+      const ranges = bucketAgg.settings?.ranges || [];
+      return `Ranges (${ranges.length})`;
+    }
+
+    case 'composite': {
+      const fields = bucketAgg.settings?.fields || [];
+      return fields.length > 0 ? `Fields: ${fields.join(', ')}` : 'No fields';
+    }
+
     case 'geohash_grid': {
       const precision = Math.max(Math.min(parseInt(bucketAgg.settings?.precision || '5', 10), 12), 1);
       return `Precision: ${precision}`;
@@ -72,6 +83,10 @@ export const useDescription = (bucketAgg: BucketAggregation): string => {
 
       let description = `Interval: ${interval}`;
 
+      if (bucketAgg.settings?.intervalType === 'calendar') {
+        description += ' (calendar)';
+      }
+
       if (typeof minDocCount === 'number' &&  minDocCount > 0) {
         description += `, Min Doc Count: ${minDocCount}`;
       }
@@ -13,6 +13,9 @@ export const BUCKET_AGGREGATION_TYPES: BucketAggregationType[] = [
   'filters',
   'geohash_grid',
   'nested',
+  'range',
+  'date_range',
+  'composite',
 ];
 
 export const isBucketAggregationType = (s: BucketAggregationType | string): s is BucketAggregationType =>
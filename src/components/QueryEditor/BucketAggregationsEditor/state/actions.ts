@@ -17,3 +17,7 @@ export const changeBucketAggregationSetting = createAction<{
   settingName: string;
   newValue: any;
 }>('@bucketAggs/change_setting');
+export const changeBucketAggregationSettings = createAction<{
+  bucketAgg: BucketAggregation;
+  settings: Record<string, any>;
+}>('@bucketAggs/change_settings');
@@ -1,5 +1,5 @@
 import { reducerTester } from '@/dependencies/reducerTester';
-import { BucketAggregation, DateHistogram, ElasticsearchQuery } from '@/types';
+import { BucketAggregation, DateHistogram, ElasticsearchQuery, Terms } from '@/types';
 import { changeMetricType } from '../../MetricAggregationsEditor/state/actions';
 import { initQuery } from '../../state';
 import { bucketAggregationConfig } from '../utils';
@@ -8,6 +8,7 @@ import {
   addBucketAggregation,
   changeBucketAggregationField,
   changeBucketAggregationSetting,
+  changeBucketAggregationSettings,
   changeBucketAggregationType,
   removeBucketAggregation,
 } from './actions';
@@ -152,6 +153,29 @@ describe('Bucket Aggregations Reducer', () => {
       .thenStateShouldEqual([{ ...firstAggregation, settings: expectedSettings }, secondAggregation]);
   });
 
+  it("Should change several of the aggregation's settings at once", () => {
+    const aggregation: Terms = {
+      id: '1',
+      type: 'terms',
+      field: 'host',
+      settings: {
+        size: '10',
+      },
+    };
+
+    reducerTester<ElasticsearchQuery['bucketAggs']>()
+      .givenReducer(createReducer('@timestamp'), [aggregation])
+      .whenActionIsDispatched(
+        changeBucketAggregationSettings({
+          bucketAgg: aggregation,
+          settings: { missing: '__missing__', missingBucket: true },
+        })
+      )
+      .thenStateShouldEqual([
+        { ...aggregation, settings: { size: '10', missing: '__missing__', missingBucket: true } },
+      ]);
+  });
+
   describe('Initialization', () => {
     it('Correctly adds a default Date Histogram if there is no aggregation', () => {
       const defaultTimeField = '@timestamp';
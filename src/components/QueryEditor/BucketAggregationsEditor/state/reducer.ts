@@ -12,6 +12,7 @@ import {
   addBucketAggregation,
   changeBucketAggregationField,
   changeBucketAggregationSetting,
+  changeBucketAggregationSettings,
   changeBucketAggregationType,
   removeBucketAggregation,
 } from './actions';
@@ -106,6 +107,22 @@ export const createReducer =
       });
     }
 
+    if (changeBucketAggregationSettings.match(action)) {
+      return state!.map((bucketAgg) => {
+        if (bucketAgg.id !== action.payload.bucketAgg.id) {
+          return bucketAgg;
+        }
+
+        return {
+          ...bucketAgg,
+          settings: removeEmpty({
+            ...bucketAgg.settings,
+            ...action.payload.settings,
+          }),
+        };
+      });
+    }
+
     if (initQuery.match(action) || initExploreQuery.match(action)) {
       if (state && state.length > 0) {
         return state;
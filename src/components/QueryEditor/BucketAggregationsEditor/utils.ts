@@ -3,6 +3,7 @@ import { InternalTimeZones, SelectableValue } from '@grafana/data';
 import { BucketsConfiguration } from '@/types';
 
 import { defaultFilter } from './SettingsEditor/FiltersSettingsEditor/utils';
+import { defaultRange } from './SettingsEditor/RangesSettingsEditor/utils';
 
 export const bucketAggregationConfig: BucketsConfiguration = {
   terms: {
@@ -52,6 +53,31 @@ export const bucketAggregationConfig: BucketsConfiguration = {
     requiresField: true,
     defaultSettings: {},
   },
+  range: {
+    label: 'Range',
+    requiresField: true,
+    defaultSettings: {
+      ranges: [defaultRange()],
+      keyed: false,
+    },
+  },
+  date_range: {
+    label: 'Date Range',
+    requiresField: true,
+    defaultSettings: {
+      ranges: [defaultRange()],
+      keyed: false,
+      timeZone: InternalTimeZones.utc,
+    },
+  },
+  composite: {
+    label: 'Multi Terms',
+    requiresField: false,
+    defaultSettings: {
+      fields: [],
+      size: '1000',
+    },
+  },
 };
 
 export const orderByOptions: Array<SelectableValue<string>> = [
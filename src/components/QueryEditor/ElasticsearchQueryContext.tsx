@@ -10,7 +10,15 @@ import { stripQueryType } from '@/queryModel';
 import { createReducer as createBucketAggsReducer } from './BucketAggregationsEditor/state/reducer';
 import { reducer as metricsReducer } from './MetricAggregationsEditor/state/reducer';
 import { reducer as filtersReducer } from './FilterEditor/state/reducer';
-import { aliasPatternReducer, queryReducer, initQuery, initExploreQuery } from './state';
+import {
+  aliasPatternReducer,
+  disableCacheReducer,
+  outputFormatReducer,
+  queryReducer,
+  timeoutReducer,
+  initQuery,
+  initExploreQuery,
+} from './state';
 import { getHook } from '@/utils/context';
 import { Provider, useDispatch } from "react-redux";
 import { initDefaults } from '@/store/defaults';
@@ -66,9 +74,17 @@ export const ElasticsearchProvider = withStore(({
     [onChange]
   );
 
-  const reducer = combineReducers<Pick<ElasticsearchQuery, 'query' | 'alias' | 'metrics' | 'filters' | 'bucketAggs'>>({
+  const reducer = combineReducers<
+    Pick<
+      ElasticsearchQuery,
+      'query' | 'alias' | 'outputFormat' | 'disableCache' | 'timeout' | 'metrics' | 'filters' | 'bucketAggs'
+    >
+  >({
     query: queryReducer,
     alias: aliasPatternReducer,
+    outputFormat: outputFormatReducer,
+    disableCache: disableCacheReducer,
+    timeout: timeoutReducer,
     metrics: metricsReducer,
     filters: filtersReducer,
     bucketAggs: createBucketAggsReducer(datasource.timeField),
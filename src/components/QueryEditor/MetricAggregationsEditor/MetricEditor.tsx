@@ -32,7 +32,19 @@ interface Props {
   value: MetricAggregation;
 }
 
-const QUICKWIT_SUPPORTED_METRICS = ['count', 'avg', 'sum', 'min', 'max', 'percentiles', 'raw_data', 'logs'];
+const QUICKWIT_SUPPORTED_METRICS = [
+  'count',
+  'avg',
+  'sum',
+  'min',
+  'max',
+  'stats',
+  'percentiles',
+  'percentile_ranks',
+  'value_count',
+  'raw_data',
+  'logs',
+];
 
 const getTypeOptions = (
   _: MetricAggregation[],
@@ -7,7 +7,7 @@ import { extendedStats } from '@/queryDef';
 import { MetricAggregation, ExtendedStat } from '@/types';
 import { useQuery } from '../../ElasticsearchQueryContext';
 import { SettingsEditorContainer } from '../../SettingsEditorContainer';
-import { isMetricAggregationWithMissingSupport } from '../aggregations';
+import { isMetricAggregationWithMissingSupport, isMetricAggregationWithUnit } from '../aggregations';
 import { changeMetricMeta, changeMetricSetting } from '../state/actions';
 import { metricAggregationConfig } from '../utils';
 
@@ -166,6 +166,25 @@ export const SettingsEditor = ({ metric, previousMetrics }: Props) => {
         </InlineField>
       )}
 
+      {metric.type === 'percentile_ranks' && (
+        <InlineField label="Values" {...inlineFieldProps}>
+          <Input
+            id={`${baseId}-percentile_ranks-values`}
+            onBlur={(e) =>
+              dispatch(
+                changeMetricSetting({
+                  metric,
+                  settingName: 'values',
+                  newValue: e.target.value.split(',').filter(Boolean),
+                })
+              )
+            }
+            defaultValue={metric.settings?.values}
+            placeholder="100,500,1000"
+          />
+        </InlineField>
+      )}
+
       {metric.type === 'rate' && (
         <>
           <InlineField label="Unit" {...inlineFieldProps} data-testid="unit-select">
@@ -197,6 +216,16 @@ export const SettingsEditor = ({ metric, previousMetrics }: Props) => {
             they will be ignored but it is also possible to treat them as if they had a value"
         />
       )}
+
+      {isMetricAggregationWithUnit(metric) && (
+        <SettingField
+          label="Unit"
+          metric={metric}
+          settingName="unit"
+          placeholder="ms"
+          tooltip="Unit of the values, used to display them"
+        />
+      )}
     </SettingsEditorContainer>
   );
 };
@@ -21,6 +21,13 @@ export const useDescription = (metric: MetricAggregation): string => {
 
       return 'Percents: Default';
 
+    case 'percentile_ranks':
+      if (metric.settings?.values && metric.settings?.values?.length >= 1) {
+        return `Values: ${metric.settings?.values}`;
+      }
+
+      return 'Values: None';
+
     case 'extended_stats': {
       const selectedStats = Object.entries(metric.meta || {})
         .map(([key, value]) => value && extendedStats.find(hasValue(key))?.label)
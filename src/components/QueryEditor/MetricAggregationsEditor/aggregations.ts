@@ -8,6 +8,7 @@ import {
   MetricAggregationWithMissingSupport,
   PipelineMetricAggregation,
   MetricAggregationWithSettings,
+  MetricAggregationWithUnit,
 } from '@/types';
 
 import { metricAggregationConfig } from './utils';
@@ -61,6 +62,22 @@ export const isMetricAggregationWithMeta = (
   metric: BaseMetricAggregation | MetricAggregationWithMeta
 ): metric is MetricAggregationWithMeta => metricAggregationConfig[metric.type].hasMeta;
 
+const METRIC_AGGREGATION_TYPES_WITH_UNIT: MetricAggregationType[] = [
+  'avg',
+  'sum',
+  'max',
+  'min',
+  'extended_stats',
+  'stats',
+  'percentiles',
+  'percentile_ranks',
+  'cardinality',
+];
+
+export const isMetricAggregationWithUnit = (
+  metric: BaseMetricAggregation | MetricAggregationWithUnit
+): metric is MetricAggregationWithUnit => METRIC_AGGREGATION_TYPES_WITH_UNIT.includes(metric.type);
+
 export const METRIC_AGGREGATION_TYPES: MetricAggregationType[] = [
   'count',
   'avg',
@@ -68,8 +85,11 @@ export const METRIC_AGGREGATION_TYPES: MetricAggregationType[] = [
   'min',
   'max',
   'extended_stats',
+  'stats',
   'percentiles',
+  'percentile_ranks',
   'cardinality',
+  'value_count',
   'raw_document',
   'raw_data',
   'logs',
@@ -100,6 +100,46 @@ export const metricAggregationConfig: MetricsConfiguration = {
       },
     },
   },
+  stats: {
+    label: 'Stats',
+    impliedQueryType: 'metrics',
+    requiresField: true,
+    supportsMissing: true,
+    supportsInlineScript: true,
+    isPipelineAgg: false,
+    supportsMultipleBucketPaths: false,
+    hasSettings: true,
+    hasMeta: false,
+    defaults: {},
+  },
+  percentile_ranks: {
+    label: 'Percentile Ranks',
+    impliedQueryType: 'metrics',
+    requiresField: true,
+    supportsMissing: true,
+    supportsInlineScript: false,
+    isPipelineAgg: false,
+    supportsMultipleBucketPaths: false,
+    hasSettings: true,
+    hasMeta: false,
+    defaults: {
+      settings: {
+        values: [],
+      },
+    },
+  },
+  value_count: {
+    label: 'Value Count',
+    impliedQueryType: 'metrics',
+    requiresField: true,
+    supportsMissing: false,
+    supportsInlineScript: false,
+    isPipelineAgg: false,
+    supportsMultipleBucketPaths: false,
+    hasSettings: false,
+    hasMeta: false,
+    defaults: {},
+  },
   cardinality: {
     label: 'Unique Count',
     impliedQueryType: 'metrics',
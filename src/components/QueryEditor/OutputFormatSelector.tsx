@@ -0,0 +1,32 @@
+import React from 'react';
+
+import { SelectableValue } from '@grafana/data';
+import { RadioButtonGroup } from '@grafana/ui';
+
+import { useDispatch } from '@/hooks/useStatelessReducer';
+import { ElasticsearchQuery } from '@/types';
+
+import { useQuery } from './ElasticsearchQueryContext';
+import { changeOutputFormat } from './state';
+
+type OutputFormat = NonNullable<ElasticsearchQuery['outputFormat']>;
+
+const OPTIONS: Array<SelectableValue<OutputFormat>> = [
+  { value: 'table', label: 'Table', description: 'Buckets without a date histogram are returned as a table' },
+  { value: 'numeric', label: 'Numeric', description: 'One labelled number per bucket, suitable for alert rules' },
+  { value: 'time_series', label: 'Time series', description: 'One labelled time series per bucket' },
+];
+
+export const OutputFormatSelector = () => {
+  const query = useQuery();
+  const dispatch = useDispatch();
+
+  return (
+    <RadioButtonGroup<OutputFormat>
+      fullWidth={false}
+      options={OPTIONS}
+      value={query.outputFormat ?? 'table'}
+      onChange={(outputFormat) => dispatch(changeOutputFormat(outputFormat))}
+    />
+  );
+};
@@ -5,7 +5,7 @@ import React, { createContext, useEffect, useRef } from 'react';
 import { useEventListener } from 'usehooks-ts'
 
 import { CoreApp, Field, getDefaultTimeRange, GrafanaTheme2, QueryEditorProps } from '@grafana/data';
-import { InlineLabel, useStyles2 } from '@grafana/ui';
+import { Input, InlineLabel, InlineSwitch, useStyles2 } from '@grafana/ui';
 
 import { ElasticDatasource } from '@/datasource';
 import { useNextId } from '@/hooks/useNextId';
@@ -16,9 +16,10 @@ import { BucketAggregationsEditor } from './BucketAggregationsEditor';
 import { ElasticsearchProvider, useDatasource, useRange } from './ElasticsearchQueryContext';
 import { MetricAggregationsEditor } from './MetricAggregationsEditor';
 import { metricAggregationConfig } from './MetricAggregationsEditor/utils';
-import { changeQuery } from './state';
+import { changeDisableCache, changeQuery, changeTimeout } from './state';
 import { QuickwitOptions } from '../../quickwit';
 import { QueryTypeSelector } from './QueryTypeSelector';
+import { OutputFormatSelector } from './OutputFormatSelector';
 
 import { getHook } from '@/utils/context';
 import { LuceneQueryEditor } from '@/components/LuceneQueryEditor';
@@ -136,9 +137,35 @@ const QueryEditorForm = ({ value, onRunQuery }: Props) => {
           onSubmit={onSubmit}/>
       </div>
       <FilterEditor onSubmit={onRunQuery} />
+      <div className={styles.root}>
+        <InlineLabel width={17} tooltip="Always fetch fresh results, even when the datasource caches the responses">
+          Bypass cache
+        </InlineLabel>
+        <InlineSwitch
+          value={value.disableCache ?? false}
+          onChange={(event) => dispatch(changeDisableCache(event.currentTarget.checked))}
+        />
+        <InlineLabel width={17} tooltip="Time Quickwit searches before returning partial results, like 30s. Leave empty to use the timeout of the datasource.">
+          Timeout
+        </InlineLabel>
+        <Input
+          width={12}
+          defaultValue={value.timeout}
+          placeholder="30s"
+          onBlur={(event) => dispatch(changeTimeout(event.currentTarget.value || undefined))}
+        />
+      </div>
 
       <MetricAggregationsEditor nextId={nextId} />
       {showBucketAggregationsEditor && <BucketAggregationsEditor nextId={nextId} />}
+      {showBucketAggregationsEditor && (
+        <div className={styles.root}>
+          <InlineLabel width={17}>Format</InlineLabel>
+          <div className={styles.queryItem}>
+            <OutputFormatSelector />
+          </div>
+        </div>
+      )}
     </div>
   );
 };
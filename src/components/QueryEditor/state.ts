@@ -13,6 +13,12 @@ export const changeQuery = createAction<ElasticsearchQuery['query']>('change_que
 
 export const changeAliasPattern = createAction<ElasticsearchQuery['alias']>('change_alias_pattern');
 
+export const changeOutputFormat = createAction<ElasticsearchQuery['outputFormat']>('change_output_format');
+
+export const changeDisableCache = createAction<ElasticsearchQuery['disableCache']>('change_disable_cache');
+
+export const changeTimeout = createAction<ElasticsearchQuery['timeout']>('change_timeout');
+
 export const queryReducer = (prevQuery: ElasticsearchQuery['query'], action: Action) => {
   if (changeQuery.match(action)) {
     return action.payload;
@@ -36,3 +42,27 @@ export const aliasPatternReducer = (prevAliasPattern: ElasticsearchQuery['alias'
 
   return prevAliasPattern;
 };
+
+export const outputFormatReducer = (prevOutputFormat: ElasticsearchQuery['outputFormat'], action: Action) => {
+  if (changeOutputFormat.match(action)) {
+    return action.payload;
+  }
+
+  return prevOutputFormat;
+};
+
+export const disableCacheReducer = (prevDisableCache: ElasticsearchQuery['disableCache'], action: Action) => {
+  if (changeDisableCache.match(action)) {
+    return action.payload;
+  }
+
+  return prevDisableCache;
+};
+
+export const timeoutReducer = (prevTimeout: ElasticsearchQuery['timeout'], action: Action) => {
+  if (changeTimeout.match(action)) {
+    return action.payload;
+  }
+
+  return prevTimeout;
+};
@@ -1,8 +1,8 @@
 import React, { useCallback } from 'react';
-import { DataSourceHttpSettings, Input, InlineField, FieldSet, RadioButtonGroup } from '@grafana/ui';
+import { DataSourceHttpSettings, Input, InlineField, InlineSwitch, FieldSet, RadioButtonGroup } from '@grafana/ui';
 import { DataSourcePluginOptionsEditorProps, DataSourceSettings, SelectableValue } from '@grafana/data';
 import { DataSourcePicker } from '@grafana/runtime';
-import { FilterAutocompleteChainMode, QuickwitOptions } from '../quickwit';
+import { FilterAutocompleteChainMode, QuickwitOptions, SearchApi } from '../quickwit';
 import { coerceOptions } from './utils';
 import { Divider } from '../components/Divider';
 import { DataLinks } from './DataLinks';
@@ -16,6 +16,11 @@ const filterChainModeOptions: Array<SelectableValue<FilterAutocompleteChainMode>
   { label: 'Full', value: 'full' },
 ];
 
+const searchApiOptions: Array<SelectableValue<SearchApi>> = [
+  { label: 'Elasticsearch compatible', value: 'elastic' },
+  { label: 'Native', value: 'native' },
+];
+
 export const ConfigEditor = (props: Props) => {
   const { options: originalOptions, onOptionsChange } = props;
   const options = coerceOptions(originalOptions);
@@ -124,6 +129,210 @@ export const QuickwitDetails = ({ value, onChange }: DetailsProps) => {
               width={40}
             />
           </InlineField>
+          <InlineField
+            label="Search API"
+            labelWidth={26}
+            tooltip="Quickwit endpoint used to run queries. The native search API prunes splits with the query time range, but does not support the live tail and the log context."
+          >
+            <RadioButtonGroup
+              id="quickwit_search_api"
+              options={searchApiOptions}
+              value={value.jsonData.searchApi}
+              onChange={(searchApi) => onChange({ ...value, jsonData: { ...value.jsonData, searchApi } })}
+            />
+          </InlineField>
+          <InlineField
+            label="Response cache TTL"
+            labelWidth={26}
+            tooltip="Duration the search responses are cached by the backend, like 30s. Leave empty to disable the cache."
+          >
+            <Input
+              id="quickwit_response_cache_ttl"
+              value={value.jsonData.responseCacheTTL}
+              onChange={(event) =>
+                onChange({ ...value, jsonData: { ...value.jsonData, responseCacheTTL: event.currentTarget.value } })
+              }
+              placeholder="30s"
+              width={40}
+            />
+          </InlineField>
+          <InlineField label="Response cache size" labelWidth={26} tooltip="Maximum size of the cached responses, in bytes">
+            <Input
+              id="quickwit_response_cache_max_bytes"
+              type="number"
+              min={0}
+              value={value.jsonData.responseCacheMaxBytes}
+              onChange={(event) =>
+                onChange({ ...value, jsonData: { ...value.jsonData, responseCacheMaxBytes: event.currentTarget.value } })
+              }
+              placeholder="67108864"
+              width={40}
+            />
+          </InlineField>
+          <InlineField
+            label="Incremental querying"
+            labelWidth={26}
+            tooltip="Remember the time series of the date histogram queries and only fetch their most recent buckets on refresh"
+          >
+            <InlineSwitch
+              id="quickwit_incremental_querying"
+              value={value.jsonData.incrementalQuerying ?? false}
+              onChange={(event) =>
+                onChange({ ...value, jsonData: { ...value.jsonData, incrementalQuerying: event.currentTarget.checked } })
+              }
+            />
+          </InlineField>
+          {value.jsonData.incrementalQuerying && (
+            <InlineField
+              label="Query overlap window"
+              labelWidth={26}
+              tooltip="Duration fetched again before the end of the remembered series, to include the documents indexed late"
+            >
+              <Input
+                id="quickwit_incremental_query_overlap_window"
+                value={value.jsonData.incrementalQueryOverlapWindow}
+                onChange={(event) =>
+                  onChange({
+                    ...value,
+                    jsonData: { ...value.jsonData, incrementalQueryOverlapWindow: event.currentTarget.value },
+                  })
+                }
+                placeholder="10m"
+                width={40}
+              />
+            </InlineField>
+          )}
+          <InlineField
+            label="Range shards"
+            labelWidth={26}
+            tooltip="Split the time range of the logs and date histogram queries into this number of searches run in parallel. Leave empty to search the whole range at once."
+          >
+            <Input
+              id="quickwit_range_shards"
+              type="number"
+              min={0}
+              value={value.jsonData.rangeShards}
+              onChange={(event) =>
+                onChange({ ...value, jsonData: { ...value.jsonData, rangeShards: event.currentTarget.value } })
+              }
+              placeholder="1"
+              width={40}
+            />
+          </InlineField>
+          <InlineField
+            label="Search timeout"
+            labelWidth={26}
+            tooltip="Time Quickwit searches before returning partial results, like 30s. Queries may override it. Leave empty for no timeout."
+          >
+            <Input
+              id="quickwit_search_timeout"
+              value={value.jsonData.searchTimeout}
+              onChange={(event) =>
+                onChange({ ...value, jsonData: { ...value.jsonData, searchTimeout: event.currentTarget.value } })
+              }
+              placeholder="30s"
+              width={40}
+            />
+          </InlineField>
+          <InlineField
+            label="Max concurrent queries"
+            labelWidth={26}
+            tooltip="Number of searches sent to Quickwit at once, the others wait for a free slot. Leave empty for no limit."
+          >
+            <Input
+              id="quickwit_max_concurrent_queries"
+              type="number"
+              min={0}
+              value={value.jsonData.maxConcurrentQueries}
+              onChange={(event) =>
+                onChange({ ...value, jsonData: { ...value.jsonData, maxConcurrentQueries: event.currentTarget.value } })
+              }
+              width={40}
+            />
+          </InlineField>
+          {!!value.jsonData.maxConcurrentQueries && (
+            <InlineField
+              label="Query queue timeout"
+              labelWidth={26}
+              tooltip="Duration a search waits for a free slot before failing, like 30s"
+            >
+              <Input
+                id="quickwit_query_queue_timeout"
+                value={value.jsonData.queryQueueTimeout}
+                onChange={(event) =>
+                  onChange({ ...value, jsonData: { ...value.jsonData, queryQueueTimeout: event.currentTarget.value } })
+                }
+                placeholder="30s"
+                width={40}
+              />
+            </InlineField>
+          )}
+          <InlineField
+            label="Retry attempts"
+            labelWidth={26}
+            tooltip="Number of times a search failing with a transient error is sent, including the first one. Leave empty to disable the retries."
+          >
+            <Input
+              id="quickwit_retry_max_attempts"
+              type="number"
+              min={0}
+              value={value.jsonData.retryMaxAttempts}
+              onChange={(event) =>
+                onChange({ ...value, jsonData: { ...value.jsonData, retryMaxAttempts: event.currentTarget.value } })
+              }
+              placeholder="1"
+              width={40}
+            />
+          </InlineField>
+          {Number(value.jsonData.retryMaxAttempts) > 1 && (
+            <>
+              <InlineField
+                label="Retried status codes"
+                labelWidth={26}
+                tooltip="Comma separated status codes retried, the connection failures are always retried"
+              >
+                <Input
+                  id="quickwit_retry_status_codes"
+                  value={value.jsonData.retryStatusCodes}
+                  onChange={(event) =>
+                    onChange({ ...value, jsonData: { ...value.jsonData, retryStatusCodes: event.currentTarget.value } })
+                  }
+                  placeholder="429,502,503,504"
+                  width={40}
+                />
+              </InlineField>
+              <InlineField
+                label="Retry initial backoff"
+                labelWidth={26}
+                tooltip="Maximum wait before the first retry, it doubles with each retry"
+              >
+                <Input
+                  id="quickwit_retry_initial_backoff"
+                  value={value.jsonData.retryInitialBackoff}
+                  onChange={(event) =>
+                    onChange({ ...value, jsonData: { ...value.jsonData, retryInitialBackoff: event.currentTarget.value } })
+                  }
+                  placeholder="100ms"
+                  width={40}
+                />
+              </InlineField>
+              <InlineField
+                label="Retry max backoff"
+                labelWidth={26}
+                tooltip="Maximum wait between two attempts, the searches asked to wait longer by Quickwit are not retried"
+              >
+                <Input
+                  id="quickwit_retry_max_backoff"
+                  value={value.jsonData.retryMaxBackoff}
+                  onChange={(event) =>
+                    onChange({ ...value, jsonData: { ...value.jsonData, retryMaxBackoff: event.currentTarget.value } })
+                  }
+                  placeholder="5s"
+                  width={40}
+                />
+              </InlineField>
+            </>
+          )}
         </FieldSet>
         <FieldSet label="Editor settings">
           <InlineField label="Default logs limit" labelWidth={26} tooltip="The log level field must be a fast field">
@@ -15,6 +15,7 @@ export const coerceOptions = (
       logMessageField: options.jsonData.logMessageField || '',
       logLevelField: options.jsonData.logLevelField || '',
       forcedQueryFilter: options.jsonData.forcedQueryFilter || '',
+      searchApi: options.jsonData.searchApi || 'elastic',
       filterAutocompleteLimit: options.jsonData.filterAutocompleteLimit ?? '1000',
       filterAutocompleteChainMode,
       filterAutocompleteUseFilterChains: filterAutocompleteChainMode !== 'none',
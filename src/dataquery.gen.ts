@@ -13,11 +13,11 @@ import { AdHocVariableFilter } from '@grafana/data';
 
 export const DataQueryModelVersion = Object.freeze([0, 0]);
 
-export type BucketAggregation = (DateHistogram | Histogram | Terms | Filters | GeoHashGrid | Nested);
+export type BucketAggregation = (DateHistogram | Histogram | Terms | Filters | GeoHashGrid | Nested | Range | DateRange | Composite);
 
-export type MetricAggregation = (Count | PipelineMetricAggregation | MetricAggregationWithSettings);
+export type MetricAggregation = (Count | ValueCount | PipelineMetricAggregation | MetricAggregationWithSettings);
 
-export type BucketAggregationType = ('terms' | 'filters' | 'geohash_grid' | 'date_histogram' | 'histogram' | 'nested');
+export type BucketAggregationType = ('terms' | 'filters' | 'geohash_grid' | 'date_histogram' | 'histogram' | 'nested' | 'range' | 'date_range' | 'composite');
 
 export interface BaseBucketAggregation {
   id: string;
@@ -29,9 +29,15 @@ export interface BucketAggregationWithField extends BaseBucketAggregation {
   field?: string;
 }
 
+/**
+ * The buckets of the calendar intervals follow the days, weeks, months, quarters and years of the time zone
+ */
+export type DateHistogramIntervalType = ('fixed' | 'calendar');
+
 export interface DateHistogram extends BucketAggregationWithField {
   settings?: {
     interval?: string;
+    intervalType?: DateHistogramIntervalType;
     min_doc_count?: string;
     trimEdges?: string;
     offset?: string;
@@ -42,6 +48,7 @@ export interface DateHistogram extends BucketAggregationWithField {
 
 export interface DateHistogramSettings {
   interval?: string;
+  intervalType?: DateHistogramIntervalType;
   min_doc_count?: string;
   offset?: string;
   timeZone?: string;
@@ -76,6 +83,9 @@ export interface Terms extends BucketAggregationWithField {
     min_doc_count?: string;
     orderBy?: string;
     missing?: string;
+    missingBucket?: boolean;
+    otherBucket?: boolean;
+    otherBucketLabel?: string;
   };
   type: 'terms';
 }
@@ -83,8 +93,11 @@ export interface Terms extends BucketAggregationWithField {
 export interface TermsSettings {
   min_doc_count?: string;
   missing?: string;
+  missingBucket?: boolean;
   order?: TermsOrder;
   orderBy?: string;
+  otherBucket?: boolean;
+  otherBucketLabel?: string;
   size?: string;
 }
 
@@ -119,9 +132,48 @@ export interface GeoHashGridSettings {
   precision?: string;
 }
 
+/**
+ * Range of a range or date range aggregation, from is included and to is excluded
+ */
+export type AggRange = {
+  from?: string,
+  to?: string,
+  key?: string,
+};
+
+export interface Range extends BucketAggregationWithField {
+  settings?: {
+    ranges?: AggRange[];
+    keyed?: boolean;
+  };
+  type: 'range';
+}
+
+export interface DateRange extends BucketAggregationWithField {
+  settings?: {
+    ranges?: AggRange[];
+    keyed?: boolean;
+    format?: string;
+    timeZone?: string;
+  };
+  type: 'date_range';
+}
+
+/**
+ * Groups the documents by the combinations of the values of several fields,
+ * fetched by pages of size buckets
+ */
+export interface Composite extends BaseBucketAggregation {
+  settings?: {
+    fields?: string[];
+    size?: string;
+  };
+  type: 'composite';
+}
+
 export type PipelineMetricAggregationType = ('moving_avg' | 'moving_fn' | 'derivative' | 'serial_diff' | 'cumulative_sum' | 'bucket_script');
 
-export type MetricAggregationType = ('count' | 'avg' | 'sum' | 'min' | 'max' | 'extended_stats' | 'percentiles' | 'cardinality' | 'raw_document' | 'raw_data' | 'logs' | 'traces' | 'trace_search' | 'rate' | 'top_metrics' | PipelineMetricAggregationType);
+export type MetricAggregationType = ('count' | 'avg' | 'sum' | 'min' | 'max' | 'extended_stats' | 'stats' | 'percentiles' | 'percentile_ranks' | 'cardinality' | 'value_count' | 'raw_document' | 'raw_data' | 'logs' | 'traces' | 'trace_search' | 'rate' | 'top_metrics' | PipelineMetricAggregationType);
 
 export interface BaseMetricAggregation {
   hide?: boolean;
@@ -169,6 +221,7 @@ export interface Average extends MetricAggregationWithField, MetricAggregationWi
   settings?: {
     script?: InlineScript;
     missing?: string;
+    unit?: string;
   };
   type: 'avg';
 }
@@ -178,6 +231,7 @@ export interface Sum extends MetricAggregationWithField, MetricAggregationWithIn
   settings?: {
     script?: InlineScript;
     missing?: string;
+    unit?: string;
   };
   type: 'sum';
 }
@@ -187,6 +241,7 @@ export interface Max extends MetricAggregationWithField, MetricAggregationWithIn
   settings?: {
     script?: InlineScript;
     missing?: string;
+    unit?: string;
   };
   type: 'max';
 }
@@ -196,6 +251,7 @@ export interface Min extends MetricAggregationWithField, MetricAggregationWithIn
   settings?: {
     script?: InlineScript;
     missing?: string;
+    unit?: string;
   };
   type: 'min';
 }
@@ -214,6 +270,7 @@ export interface ExtendedStats extends MetricAggregationWithField, MetricAggrega
     script?: InlineScript;
     missing?: string;
     sigma?: string;
+    unit?: string;
   };
   type: 'extended_stats';
 }
@@ -224,14 +281,41 @@ export interface Percentiles extends MetricAggregationWithField, MetricAggregati
     script?: InlineScript;
     missing?: string;
     percents?: string[];
+    unit?: string;
   };
   type: 'percentiles';
 }
 
+export interface PercentileRanks extends MetricAggregationWithField {
+  field?: string;
+  settings?: {
+    missing?: string;
+    values?: string[];
+    unit?: string;
+  };
+  type: 'percentile_ranks';
+}
+
+export interface Stats extends MetricAggregationWithField, MetricAggregationWithInlineScript {
+  field?: string;
+  settings?: {
+    script?: InlineScript;
+    missing?: string;
+    unit?: string;
+  };
+  type: 'stats';
+}
+
+export interface ValueCount extends MetricAggregationWithField {
+  field?: string;
+  type: 'value_count';
+}
+
 export interface UniqueCount extends MetricAggregationWithField {
   settings?: {
     precision_threshold?: string;
     missing?: string;
+    unit?: string;
   };
   type: 'cardinality';
 }
@@ -399,7 +483,12 @@ export interface TopMetrics extends BaseMetricAggregation {
 
 export type PipelineMetricAggregation = (MovingAverage | Derivative | CumulativeSum | BucketScript);
 
-export type MetricAggregationWithSettings = (BucketScript | CumulativeSum | Derivative | SerialDiff | RawData | RawDocument | UniqueCount | Percentiles | ExtendedStats | Min | Max | Sum | Average | MovingAverage | MovingFunction | Logs | Traces | TraceSearch | Rate | TopMetrics);
+/**
+ * Metrics with the unit of their values in a unit setting
+ */
+export type MetricAggregationWithUnit = (Average | Sum | Max | Min | ExtendedStats | Stats | Percentiles | PercentileRanks | UniqueCount);
+
+export type MetricAggregationWithSettings = (BucketScript | CumulativeSum | Derivative | SerialDiff | RawData | RawDocument | UniqueCount | Percentiles | PercentileRanks | ExtendedStats | Stats | Min | Max | Sum | Average | MovingAverage | MovingFunction | Logs | Traces | TraceSearch | Rate | TopMetrics);
 
 export interface Elasticsearch extends DataQuery {
   /**
@@ -414,6 +503,18 @@ export interface Elasticsearch extends DataQuery {
    * List of metric aggregations
    */
   metrics?: MetricAggregation[];
+  /**
+   * Bypass the response cache of the datasource
+   */
+  disableCache?: boolean;
+  /**
+   * Search timeout overriding the one of the datasource, like 30s
+   */
+  timeout?: string;
+  /**
+   * Output format of the metric queries: table, numeric or time_series
+   */
+  outputFormat?: 'table' | 'numeric' | 'time_series';
   /**
    * List of filters
    */
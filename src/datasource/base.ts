@@ -1,8 +1,9 @@
-import { Observable, lastValueFrom, from, of, map, mergeMap } from 'rxjs';
+import { Observable, lastValueFrom, from, merge, of, map, mergeMap } from 'rxjs';
 
 import {
   AbstractQuery,
   AdHocVariableFilter,
+  AnnotationQuery,
   CoreApp,
   DataFrame,
   DataQueryRequest,
@@ -10,6 +11,7 @@ import {
   DataSourceInstanceSettings,
   DataSourceWithQueryImportSupport,
   getDefaultTimeRange,
+  LiveChannelScope,
   MetricFindValue,
   QueryFixAction,
   ScopedVars,
@@ -19,6 +21,7 @@ import {
 import { BucketAggregation, DataLinkConfig, ElasticsearchQuery, TermsQuery, FieldCapabilitiesResponse } from '@/types';
 import {
   DataSourceWithBackend,
+  getGrafanaLiveSrv,
   getTemplateSrv,
   TemplateSrv } from '@grafana/runtime';
 import { FilterAutocompleteChainMode, QuickwitOptions } from 'quickwit';
@@ -33,6 +36,7 @@ import { fieldTypeMap, hasWhiteSpace, isSimpleToken } from 'utils';
 import { addAddHocFilter } from 'modifyQuery';
 import { getQueryResponseProcessor } from 'datasource/processResponse';
 import { normalizeInternalLinkQuery } from '@/queryModel';
+import { ElasticsearchAnnotationsQueryEditor } from 'components/QueryEditor/AnnotationQueryEditor';
 
 import { SECOND } from 'utils/time';
 import { GConstructor } from 'utils/mixins';
@@ -40,6 +44,24 @@ import { newFilterId, uidMaker } from '@/utils/uid';
 import { DefaultsConfigOverrides } from 'store/defaults/conf';
 import { isSet } from '@/utils';
 
+/**
+ * Annotations are executed by the backend as `annotations` queries, the field
+ * mappings of the annotation are sent along with its query. The time field is
+ * sent as `annotationTimeField`, `timeField` is a legacy key of the queries.
+ */
+export function prepareAnnotationQuery(annotation: AnnotationQuery<ElasticsearchQuery>): ElasticsearchQuery {
+  return {
+    ...annotation.target,
+    refId: annotation.target?.refId ?? 'annotation_query',
+    query: annotation.target?.query ?? '',
+    queryType: 'annotations',
+    annotationTimeField: annotation.timeField,
+    timeEndField: annotation.timeEndField,
+    textField: annotation.textField,
+    tagsField: annotation.tagsField,
+  } as ElasticsearchQuery;
+}
+
 export type BaseQuickwitDataSourceConstructor = GConstructor<BaseQuickwitDataSource>;
 
 const getQueryUid = uidMaker('query');
@@ -132,7 +154,10 @@ export class BaseQuickwitDataSource
       settingsData.filterAutocompleteUseFilterChains
     );
     this.languageProvider = new ElasticsearchLanguageProvider(this);
-    this.annotations = {};
+    this.annotations = {
+      QueryEditor: ElasticsearchAnnotationsQueryEditor,
+      prepareQuery: prepareAnnotationQuery,
+    };
 
     // Warm the schema cache so modifyQuery can pick the right operator even when
     // the query editor hasn't mounted (e.g. dashboard view mode). Fire-and-forget.
@@ -147,9 +172,31 @@ export class BaseQuickwitDataSource
       targets: request.targets.map(normalizeInternalLinkQuery),
     };
     const queryProcessor = getQueryResponseProcessor(this, normalizedRequest);
+    // Explore live mode tails the logs queries through the backend streams
+    if (request.liveStreaming && normalizedRequest.targets.every(isLogsQuery)) {
+      const streams = normalizedRequest.targets.map((target) => this.tailLogs(target, request.scopedVars));
+      return merge(...streams).pipe(map(queryProcessor.processResponse));
+    }
     return super.query(normalizedRequest).pipe(map(queryProcessor.processResponse));
   }
 
+  /**
+   * Subscribes to the live tail stream of a logs query, which pushes the new rows
+   * as they are indexed. The channel path holds a key of the query so that the
+   * queries sharing a refId do not share their stream.
+   */
+  tailLogs(target: ElasticsearchQuery, scopedVars: ScopedVars): Observable<DataQueryResponse> {
+    const query = this.applyTemplateVariables(target, scopedVars);
+    return getGrafanaLiveSrv().getDataStream({
+      addr: {
+        scope: LiveChannelScope.DataSource,
+        namespace: this.uid,
+        path: `tail/${target.refId}/${liveTailKey(query)}`,
+        data: query,
+      },
+    });
+  }
+
   /**
    * Checks the plugin health
    * see public/app/features/datasources/state/actions.ts for what needs to be returned here
@@ -594,29 +641,41 @@ export class BaseQuickwitDataSource
     query: string,
     options?: { range: TimeRange; variable?: { name: string } }
   ): Promise<MetricFindValue[]> {
-    const range = options?.range;
-    const parsedQuery = JSON.parse(query);
-    if (query) {
-      if (parsedQuery.find === 'fields') {
-        parsedQuery.type = this.interpolateLuceneQuery(parsedQuery.type);
-        return lastValueFrom(this.getFields({ aggregatable: true, type: parsedQuery.type, range: range }), {
-          defaultValue: [],
-        });
-      }
-      if (parsedQuery.find === 'terms') {
-        parsedQuery.field = this.interpolateLuceneQuery(parsedQuery.field);
-        parsedQuery.query = this.interpolateLuceneQuery(parsedQuery.query);
-        return lastValueFrom(
-          this.getTerms(
-            parsedQuery,
-            range,
-            options?.variable?.name ? `getVariableTerms-${options?.variable?.name}` : undefined
-          ),
-          { defaultValue: [] }
-        );
+    if (!query) {
+      return Promise.resolve([]);
+    }
+    // The variable definition is interpolated here and run by the backend as a variable query
+    const definition = JSON.parse(query);
+    for (const key of ['field', 'query', 'type']) {
+      if (typeof definition[key] === 'string') {
+        definition[key] = this.interpolateLuceneQuery(definition[key]);
       }
     }
-    return Promise.resolve([]);
+    const target: ElasticsearchQuery = {
+      refId: 'variable',
+      queryType: 'variable',
+      query: JSON.stringify(definition),
+      metrics: [],
+      bucketAggs: [],
+    };
+    const request = this.getRequestForQuery(
+      target,
+      options?.range || getDefaultTimeRange(),
+      options?.variable?.name ? `getVariableTerms-${options.variable.name}` : undefined
+    );
+    return lastValueFrom(
+      super.query(request).pipe(
+        map((res) => {
+          if (res.error) {
+            throw new Error(res.error.message);
+          }
+          return res.data.flatMap((df: DataFrame) =>
+            df.fields.length === 0 ? [] : df.fields[0].values.map((value) => ({ text: value, value }))
+          );
+        })
+      ),
+      { defaultValue: [] }
+    );
   }
 
   interpolateLuceneQuery(queryString: string, scopedVars?: ScopedVars) {
@@ -639,6 +698,11 @@ export class BaseQuickwitDataSource
     scopedVars: ScopedVars,
     filters?: AdHocVariableFilter[]
   ): ElasticsearchQuery {
+    // The variable queries are interpolated by metricFindQuery, their query is a JSON definition
+    if (query.queryType === 'variable') {
+      return { ...query, datasource: this.getRef() };
+    }
+
     // We need a separate interpolation format for lucene queries, therefore we first interpolate any
     // lucene query string and then everything else
     const interpolateBucketAgg = (bucketAgg: BucketAggregation): BucketAggregation => {
@@ -724,6 +788,20 @@ export class BaseQuickwitDataSource
   }
 }
 
+function isLogsQuery(query: ElasticsearchQuery): boolean {
+  return query.metrics?.length === 1 && query.metrics[0].type === 'logs';
+}
+
+// liveTailKey hashes a query model into the key of its live tail channel
+function liveTailKey(query: ElasticsearchQuery): string {
+  const json = JSON.stringify(query);
+  let hash = 5381;
+  for (let i = 0; i < json.length; i++) {
+    hash = ((hash << 5) + hash + json.charCodeAt(i)) | 0;
+  }
+  return (hash >>> 0).toString(16);
+}
+
 function isRecord(value: unknown): value is Record<string, unknown> {
   return typeof value === 'object' && value !== null && !Array.isArray(value);
 }
@@ -14,6 +14,7 @@ const getFilter = (type: AggregationType) => {
   if (isMetricAggregationType(type)) {
     switch (type) {
       case 'cardinality':
+      case 'value_count':
         return [];
       case 'top_metrics':
         // top_metrics was introduced in 7.7 where `metrics` only supported number:
@@ -33,7 +34,10 @@ const getFilter = (type: AggregationType) => {
       case 'geohash_grid':
         return ['geo_point'];
       case 'histogram':
+      case 'range':
         return ['number'];
+      case 'date_range':
+        return ['date'];
       default:
         return [];
     }
@@ -4,12 +4,35 @@ import { DefaultsConfigOverrides } from 'store/defaults/conf';
 
 export type FilterAutocompleteChainMode = 'none' | 'sample' | 'full';
 
+export type SearchApi = 'elastic' | 'native';
+
 export interface QuickwitOptions extends DataSourceJsonData {
     timeField: string;
     interval?: string;
     logMessageField?: string;
     logLevelField?: string;
     forcedQueryFilter?: string;
+    searchApi?: SearchApi;
+    // Duration of the backend response cache, like 30s, empty to disable it
+    responseCacheTTL?: string;
+    responseCacheMaxBytes?: string;
+    incrementalQuerying?: boolean;
+    // Duration fetched again before the end of the cached series, like 10m
+    incrementalQueryOverlapWindow?: string;
+    // Number of searches the time range of the logs and date histogram queries is split into
+    rangeShards?: string;
+    // Default timeout of the searches, like 30s, empty for no timeout
+    searchTimeout?: string;
+    // Number of searches sent to Quickwit at once by a datasource instance, empty for no limit
+    maxConcurrentQueries?: string;
+    // Duration a search waits for a free slot, like 30s
+    queryQueueTimeout?: string;
+    // Number of times a failing search is sent, including the first one
+    retryMaxAttempts?: string;
+    // Comma separated status codes retried, like 429,502,503,504
+    retryStatusCodes?: string;
+    retryInitialBackoff?: string;
+    retryMaxBackoff?: string;
     logsDatasourceUid?: string;
     logsDatasourceName?: string;
     tracesDatasourceUid?: string;